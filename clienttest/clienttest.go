@@ -0,0 +1,134 @@
+// Package clienttest provides a Replicache client for SDK test suites. Clients dispatch to
+// the real repm implementation, so SDK tests exercise the same Go logic as apps do, and add
+// helpers to seed data, force root changes, and pull from a fake server.
+//
+// repm's storage is directory-based, so databases live in a temporary directory that is
+// shared by all clients in the process. Each client has its own database, which Close drops.
+package clienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+
+	"github.com/attic-labs/noms/go/spec"
+
+	servetypes "roci.dev/diff-server/serve/types"
+	"roci.dev/diff-server/util/chk"
+	jsnoms "roci.dev/diff-server/util/noms/json"
+	"roci.dev/replicache-client/repm"
+)
+
+var (
+	initOnce sync.Once
+	initErr  error
+	nextID   int32
+)
+
+// Client is an open database in repm.
+type Client struct {
+	// Name is the name of the client's database, for use with repm.Dispatch.
+	Name string
+}
+
+// New opens a new, empty database. opts is an optional repm.OpenRequest.
+func New(opts *repm.OpenRequest) (*Client, error) {
+	initOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "clienttest")
+		if err != nil {
+			initErr = err
+			return
+		}
+		repm.Init(dir, "", nil)
+	})
+	if initErr != nil {
+		return nil, initErr
+	}
+	c := &Client{Name: fmt.Sprintf("clienttest-%d", atomic.AddInt32(&nextID, 1))}
+	var data []byte
+	if opts != nil {
+		data = mustMarshal(opts)
+	}
+	if _, err := repm.Dispatch(c.Name, "open", data); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close drops the client's database.
+func (c *Client) Close() error {
+	_, err := repm.Dispatch(c.Name, "drop", nil)
+	return err
+}
+
+// Dispatch sends rpc to the client's database, as repm.Dispatch does.
+func (c *Client) Dispatch(rpc string, data []byte) ([]byte, error) {
+	return repm.Dispatch(c.Name, rpc, data)
+}
+
+// Seed puts each of kvs, whose values are JSON, into the database.
+func (c *Client) Seed(kvs map[string]string) error {
+	for k, v := range kvs {
+		_, err := c.Dispatch("put", mustMarshal(repm.PutRequest{ID: k, Value: json.RawMessage(v)}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForceRootChange changes the root of the database without changing its data, e.g. to test
+// that SDKs notice root changes.
+func (c *Client) ForceRootChange() error {
+	const key = "clienttest/force-root-change"
+	_, err := c.Dispatch("put", mustMarshal(repm.PutRequest{ID: key, Value: json.RawMessage(`true`)}))
+	if err != nil {
+		return err
+	}
+	_, err = c.Dispatch("del", mustMarshal(repm.DelRequest{ID: key}))
+	return err
+}
+
+// Root returns the current root of the database.
+func (c *Client) Root() (string, error) {
+	buf, err := c.Dispatch("getRoot", []byte(`{}`))
+	if err != nil {
+		return "", err
+	}
+	var res repm.GetRootResponse
+	if err := json.Unmarshal(buf, &res); err != nil {
+		return "", err
+	}
+	return res.Root.String(), nil
+}
+
+// FakePull pulls from a fake server that responds with resp. resp.Checksum must be the
+// checksum of the data after applying resp.Patch.
+func (c *Client) FakePull(resp servetypes.PullResponse) (repm.PullResponse, error) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(mustMarshal(resp))
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	if err != nil {
+		return repm.PullResponse{}, err
+	}
+	buf, err := c.Dispatch("pull", mustMarshal(repm.PullRequest{Remote: jsnoms.Spec{Spec: sp}}))
+	if err != nil {
+		return repm.PullResponse{}, err
+	}
+	var res repm.PullResponse
+	err = json.Unmarshal(buf, &res)
+	return res, err
+}
+
+func mustMarshal(thing interface{}) []byte {
+	data, err := json.Marshal(thing)
+	chk.NoError(err)
+	return data
+}
@@ -0,0 +1,55 @@
+package clienttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/attic-labs/noms/go/types"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+)
+
+func TestClient(t *testing.T) {
+	assert := assert.New(t)
+	c, err := New(nil)
+	assert.NoError(err)
+	other, err := New(nil)
+	assert.NoError(err)
+	assert.NotEqual(c.Name, other.Name)
+	assert.NoError(other.Close())
+
+	assert.NoError(c.Seed(map[string]string{"foo": `"bar"`}))
+	buf, err := c.Dispatch("get", []byte(`{"id":"foo"}`))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":"bar"}`, string(buf))
+
+	r1, err := c.Root()
+	assert.NoError(err)
+	assert.NoError(c.ForceRootChange())
+	r2, err := c.Root()
+	assert.NoError(err)
+	assert.NotEqual(r1, r2)
+	buf, err = c.Dispatch("get", []byte(`{"id":"clienttest/force-root-change"}`))
+	assert.NoError(err)
+	assert.Equal(`{"has":false}`, string(buf))
+
+	// The pull acknowledges the first two local mutations, so the pulled state replaces the
+	// seeded state.
+	m := kv.NewMapForTest(types.NewTestValueStore(), "hot", `"dog"`)
+	var pr servetypes.PullResponse
+	assert.NoError(json.Unmarshal([]byte(fmt.Sprintf(`{"patch":[{"op":"remove","path":"/"},{"op":"add","path":"/hot","value":"dog"}],"stateID":"11111111111111111111111111111111","checksum":"%s","lastMutationID":2}`, m.Checksum())), &pr))
+	res, err := c.FakePull(pr)
+	assert.NoError(err)
+	assert.Nil(res.Error)
+	buf, err = c.Dispatch("get", []byte(`{"id":"hot"}`))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":"dog"}`, string(buf))
+	buf, err = c.Dispatch("get", []byte(`{"id":"foo"}`))
+	assert.NoError(err)
+	assert.Equal(`{"has":false}`, string(buf))
+
+	assert.NoError(c.Close())
+}
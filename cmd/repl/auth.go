@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// authSource holds the ways the auth token can be specified, in order of precedence.
+type authSource struct {
+	// Token is the token itself, from --auth.
+	Token string
+	// Env is the name of an environment variable containing the token, from --auth-env.
+	Env string
+	// Keychain is the service name the token is stored under in the OS keychain, from
+	// --auth-keychain.
+	Keychain string
+}
+
+// keychainLookup returns the secret stored under service in the OS keychain. It is a
+// variable so that tests can replace it.
+var keychainLookup = lookupKeychain
+
+// resolve returns the auth token, or fallback if no source is specified.
+func (a authSource) resolve(fallback string) (string, error) {
+	if a.Token != "" {
+		return a.Token, nil
+	}
+	if a.Env != "" {
+		t, ok := os.LookupEnv(a.Env)
+		if !ok || t == "" {
+			return "", fmt.Errorf("environment variable %s is not set", a.Env)
+		}
+		return t, nil
+	}
+	if a.Keychain != "" {
+		t, err := keychainLookup(a.Keychain)
+		if err != nil {
+			return "", fmt.Errorf("could not read %s from keychain: %s", a.Keychain, err)
+		}
+		return t, nil
+	}
+	return fallback, nil
+}
+
+// lookupKeychain reads the secret for service using the macOS Keychain or, elsewhere, the
+// freedesktop Secret Service (GNOME Keyring, KWallet) via secret-tool.
+func lookupKeychain(service string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", service)
+	default:
+		return "", fmt.Errorf("keychain not supported on %s", runtime.GOOS)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	t := strings.TrimRight(string(out), "\r\n")
+	if t == "" {
+		return "", errors.New("no such item")
+	}
+	return t, nil
+}
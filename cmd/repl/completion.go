@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	shellBash = "bash"
+	shellZsh  = "zsh"
+	shellFish = "fish"
+)
+
+func completion(parent *kingpin.Application, out io.Writer) {
+	kc := parent.Command("completion", "Prints a shell completion script. For example, add 'source <(repl completion bash)' to ~/.bashrc.")
+	shell := kc.Arg("shell", "Shell to print the completion script for.").Required().Enum(shellBash, shellZsh, shellFish)
+	kc.Action(func(_ *kingpin.ParseContext) error {
+		m := parent.Model()
+		switch *shell {
+		case shellBash:
+			writeBashCompletion(out, m)
+		case shellZsh:
+			// zsh can run bash completion functions, which keeps the two in sync.
+			fmt.Fprintln(out, "autoload -U +X bashcompinit && bashcompinit")
+			writeBashCompletion(out, m)
+		case shellFish:
+			writeFishCompletion(out, m)
+		}
+		return nil
+	})
+}
+
+// visibleFlags returns the flags in fs that are shown in help.
+func visibleFlags(fs *kingpin.FlagGroupModel) []*kingpin.FlagModel {
+	r := []*kingpin.FlagModel{}
+	for _, f := range fs.Flags {
+		if !f.Hidden {
+			r = append(r, f)
+		}
+	}
+	return r
+}
+
+// visibleCommands returns the top-level commands of m that are shown in help.
+func visibleCommands(m *kingpin.ApplicationModel) []*kingpin.CmdModel {
+	r := []*kingpin.CmdModel{}
+	for _, c := range m.Commands {
+		if !c.Hidden {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+func flagWords(fs []*kingpin.FlagModel) []string {
+	r := make([]string, 0, len(fs))
+	for _, f := range fs {
+		r = append(r, "--"+f.Name)
+	}
+	return r
+}
+
+func writeBashCompletion(w io.Writer, m *kingpin.ApplicationModel) {
+	cmds := visibleCommands(m)
+	names := make([]string, 0, len(cmds))
+	for _, c := range cmds {
+		names = append(names, c.Name)
+	}
+	global := flagWords(visibleFlags(m.FlagGroupModel))
+
+	fmt.Fprintf(w, `_%[1]s() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" cmd="" opts i
+    for ((i=1; i<COMP_CWORD; i++)); do
+        case " %[2]s " in
+            *" ${COMP_WORDS[i]} "*) cmd="${COMP_WORDS[i]}"; break ;;
+        esac
+    done
+    case "$cmd" in
+`, m.Name, strings.Join(names, " "))
+	fmt.Fprintf(w, "        \"\") opts=%q ;;\n", strings.Join(append(global, names...), " "))
+	for _, c := range cmds {
+		fmt.Fprintf(w, "        %s) opts=%q ;;\n", c.Name, strings.Join(append(global, flagWords(visibleFlags(c.FlagGroupModel))...), " "))
+	}
+	fmt.Fprintf(w, `    esac
+    COMPREPLY=($(compgen -W "$opts" -- "$cur"))
+}
+complete -o default -F _%[1]s %[1]s
+`, m.Name)
+}
+
+func writeFishCompletion(w io.Writer, m *kingpin.ApplicationModel) {
+	writeFlag := func(cond string, f *kingpin.FlagModel) {
+		fmt.Fprintf(w, "complete -c %s", m.Name)
+		if cond != "" {
+			fmt.Fprintf(w, " -n %s", fishQuote(cond))
+		}
+		fmt.Fprintf(w, " -l %s", f.Name)
+		if f.Short != 0 {
+			fmt.Fprintf(w, " -s %c", f.Short)
+		}
+		if !f.IsBoolFlag() {
+			fmt.Fprint(w, " -r")
+		}
+		fmt.Fprintf(w, " -d %s\n", fishQuote(f.Help))
+	}
+
+	for _, f := range visibleFlags(m.FlagGroupModel) {
+		writeFlag("", f)
+	}
+	for _, c := range visibleCommands(m) {
+		fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand -a %s -d %s\n", m.Name, c.Name, fishQuote(c.Help))
+		for _, f := range visibleFlags(c.FlagGroupModel) {
+			writeFlag("__fish_seen_subcommand_from "+c.Name, f)
+		}
+	}
+}
+
+// fishQuote quotes s as a single-quoted fish string.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultProfile is the profile used when --profile is not specified.
+const defaultProfile = "default"
+
+// profile holds the settings for a named profile in the config file. Flags take precedence
+// over profile settings.
+type profile struct {
+	DB             string
+	Remote         string
+	Auth           string
+	ClientViewAuth string
+}
+
+// config maps profile names to profiles.
+type config map[string]profile
+
+// defaultConfigPath returns the path of the config file, which is config.toml in the
+// replicant directory under $XDG_CONFIG_HOME, or ~/.config if that isn't set.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "replicant", "config.toml")
+}
+
+// loadConfig reads the config file at path. A missing file is an empty config.
+func loadConfig(path string) (config, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// parseConfig parses the subset of TOML used by the config file: a table per profile,
+// containing string values for the keys db, remote, auth, and client-view-auth. For example:
+//
+//	[default]
+//	db = "/path/to/db"
+//
+//	[work]
+//	db = "/path/to/work"
+//	remote = "https://serve.replicache.dev/work"
+//	auth = "secret"
+//	client-view-auth = "user-secret"
+func parseConfig(r io.Reader) (config, error) {
+	c := config{}
+	name := ""
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: invalid table header: %s", n, line)
+			}
+			name = strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("line %d: empty profile name", n)
+			}
+			if _, ok := c[name]; !ok {
+				c[name] = profile{}
+			}
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("line %d: settings must be in a profile table, e.g. [%s]", n, defaultProfile)
+		}
+		key := strings.TrimSpace(line[:i])
+		value, err := parseString(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		p := c[name]
+		switch key {
+		case "db":
+			p.DB = value
+		case "remote":
+			p.Remote = value
+		case "auth":
+			p.Auth = value
+		case "client-view-auth":
+			p.ClientViewAuth = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown key: %s", n, key)
+		}
+		c[name] = p
+	}
+	return c, s.Err()
+}
+
+// parseString parses a TOML basic ("...") or literal ('...') string, which may be followed
+// by a comment.
+func parseString(s string) (string, error) {
+	if strings.HasPrefix(s, "'") {
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string: %s", s)
+		}
+		return s[1 : end+1], checkTrailing(s[end+2:])
+	}
+	if strings.HasPrefix(s, `"`) {
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", fmt.Errorf("invalid string: %s", s[:i+1])
+				}
+				return v, checkTrailing(s[i+1:])
+			}
+		}
+		return "", fmt.Errorf("unterminated string: %s", s)
+	}
+	return "", fmt.Errorf("values must be strings: %s", s)
+}
+
+func checkTrailing(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "#") {
+		return fmt.Errorf("unexpected text after value: %s", s)
+	}
+	return nil
+}
@@ -3,12 +3,17 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"runtime/trace"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -20,13 +25,15 @@ import (
 	"github.com/mgutz/ansi"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
+	servetypes "roci.dev/diff-server/serve/types"
 	"roci.dev/diff-server/util/chk"
-	"roci.dev/diff-server/util/kp"
 	rlog "roci.dev/diff-server/util/log"
+	nomsjson "roci.dev/diff-server/util/noms/json"
 	"roci.dev/diff-server/util/tbl"
 	rtime "roci.dev/diff-server/util/time"
 	"roci.dev/diff-server/util/version"
 	"roci.dev/replicache-client/db"
+	"roci.dev/replicache-client/vectors"
 )
 
 const (
@@ -49,21 +56,59 @@ func impl(args []string, in io.Reader, out, errs io.Writer, exit func(int)) {
 	app.Terminate(exit)
 
 	v := app.Flag("version", "Prints the version of this client - same as the 'version' command.").Short('v').Bool()
-	auth := app.Flag("auth", "The authorization token to pass to db when connecting.").String()
-	sps := app.Flag("db", "The database to connect to. Both local and remote databases are supported. For local databases, specify a directory path to store the database in. For remote databases, specify the http(s) URL to the database (usually https://serve.replicache.dev/<mydb>).").PlaceHolder("/path/to/db").Required().String()
+	var auth authSource
+	app.Flag("auth", "The authorization token to pass to db when connecting.").StringVar(&auth.Token)
+	app.Flag("auth-env", "Environment variable to read the authorization token from, if --auth is not specified.").PlaceHolder("VAR").StringVar(&auth.Env)
+	app.Flag("auth-keychain", "Service name to read the authorization token from in the OS keychain (macOS Keychain, or Secret Service via secret-tool), if --auth and --auth-env are not specified.").PlaceHolder("SERVICE").StringVar(&auth.Keychain)
+	sps := app.Flag("db", "The database to connect to. Both local and remote databases are supported. For local databases, specify a directory path to store the database in. For remote databases, specify the http(s) URL to the database (usually https://serve.replicache.dev/<mydb>).").PlaceHolder("/path/to/db").String()
 	tf := app.Flag("trace", "Name of a file to write a trace to").OpenFile(os.O_RDWR|os.O_CREATE, 0644)
 	cpu := app.Flag("cpu", "Name of file to write CPU profile to").OpenFile(os.O_RDWR|os.O_CREATE, 0644)
+	cfgPath := app.Flag("config", "Config file containing named profiles of settings.").Default(defaultConfigPath()).String()
+	prof := app.Flag("profile", "Profile in the config file to take --db, --auth, and pull's --remote and --client-view-auth from, if they are not specified.").Default(defaultProfile).String()
+	of := app.Flag("output", "Output format. 'json' emits one JSON object per result (NDJSON for commands returning many results).").Default(outputText).Enum(outputText, outputJSON)
+
+	var cfg config
+	getProfile := func() (profile, error) {
+		if cfg == nil {
+			c, err := loadConfig(*cfgPath)
+			if err != nil {
+				return profile{}, err
+			}
+			cfg = c
+		}
+		p, ok := cfg[*prof]
+		if !ok && *prof != defaultProfile {
+			return profile{}, fmt.Errorf("No such profile: %s", *prof)
+		}
+		return p, nil
+	}
 
 	var sp *spec.Spec
 	getSpec := func() (spec.Spec, error) {
 		if sp != nil {
 			return *sp, nil
 		}
-		s, err := spec.ForDatabase(*sps)
+		p, err := getProfile()
+		if err != nil {
+			return spec.Spec{}, err
+		}
+		// --db isn't a required flag because it can come from the profile, and some commands,
+		// like gen-vectors, don't use it.
+		dbSpec := *sps
+		if dbSpec == "" {
+			dbSpec = p.DB
+		}
+		if dbSpec == "" {
+			return spec.Spec{}, errors.New("required flag --db not provided")
+		}
+		s, err := spec.ForDatabase(dbSpec)
+		if err != nil {
+			return spec.Spec{}, err
+		}
+		s.Options.Authorization, err = auth.resolve(p.Auth)
 		if err != nil {
 			return spec.Spec{}, err
 		}
-		s.Options.Authorization = *auth
 		return s, nil
 	}
 
@@ -137,14 +182,21 @@ func impl(args []string, in io.Reader, out, errs io.Writer, exit func(int)) {
 		return nil
 	})
 
-	has(app, getDB, out)
-	get(app, getDB, out)
-	scan(app, getDB, out, errs)
+	has(app, getDB, of, out)
+	get(app, getDB, of, out)
+	scan(app, getDB, of, out, errs)
 	put(app, getDB, in)
-	del(app, getDB, out)
-	sync(app, getDB)
+	del(app, getDB, of, out)
+	pull(app, getDB, getProfile, of, out, errs)
+	previewPatch(app, getDB, of, in, out)
+	diffCmd(app, getDB, of, out)
+	statsCmd(app, getDB, of, out)
+	du(app, getDB, of, out)
 	drop(app, getSpec, in, out)
-	logCmd(app, getDB, out)
+	doctor(app, getSpec, of, out)
+	logCmd(app, getDB, of, out)
+	genVectors(app, out)
+	completion(app, out)
 
 	if len(args) == 0 {
 		app.Usage(args)
@@ -160,8 +212,19 @@ func impl(args []string, in io.Reader, out, errs io.Writer, exit func(int)) {
 
 type gdb func() (db.DB, error)
 type gsp func() (spec.Spec, error)
+type gprof func() (profile, error)
 
-func has(parent *kingpin.Application, gdb gdb, out io.Writer) {
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// writeJSON writes v to out as a single line of JSON.
+func writeJSON(out io.Writer, v interface{}) error {
+	return json.NewEncoder(out).Encode(v)
+}
+
+func has(parent *kingpin.Application, gdb gdb, of *string, out io.Writer) {
 	kc := parent.Command("has", "Check whether a value exists in the database.")
 	id := kc.Arg("id", "id of the value to check for").Required().String()
 	kc.Action(func(_ *kingpin.ParseContext) error {
@@ -173,6 +236,11 @@ func has(parent *kingpin.Application, gdb gdb, out io.Writer) {
 		if err != nil {
 			return err
 		}
+		if *of == outputJSON {
+			return writeJSON(out, struct {
+				Has bool `json:"has"`
+			}{ok})
+		}
 		if ok {
 			out.Write([]byte("true\n"))
 		} else {
@@ -182,18 +250,25 @@ func has(parent *kingpin.Application, gdb gdb, out io.Writer) {
 	})
 }
 
-func get(parent *kingpin.Application, gdb gdb, out io.Writer) {
+func get(parent *kingpin.Application, gdb gdb, of *string, out io.Writer) {
 	kc := parent.Command("get", "Reads a value from the database.")
 	id := kc.Arg("id", "id of the value to get").Required().String()
+	path := kc.Arg("path", "JSON Pointer to a field within the value to get, e.g. /user/name").String()
 	kc.Action(func(_ *kingpin.ParseContext) error {
 		db, err := gdb()
 		if err != nil {
 			return err
 		}
-		v, err := db.Get(*id)
+		v, err := db.GetPath(*id, *path)
 		if err != nil {
 			return err
 		}
+		if *of == outputJSON {
+			return writeJSON(out, struct {
+				Has   bool            `json:"has"`
+				Value json.RawMessage `json:"value,omitempty"`
+			}{v != nil, v})
+		}
 		if v == nil {
 			return nil
 		}
@@ -202,7 +277,7 @@ func get(parent *kingpin.Application, gdb gdb, out io.Writer) {
 	})
 }
 
-func scan(parent *kingpin.Application, gdb gdb, out, errs io.Writer) {
+func scan(parent *kingpin.Application, gdb gdb, of *string, out, errs io.Writer) {
 	kc := parent.Command("scan", "Scans values in-order from the database.")
 	opts := db.ScanOptions{
 		Start: &db.ScanBound{
@@ -211,10 +286,12 @@ func scan(parent *kingpin.Application, gdb gdb, out, errs io.Writer) {
 		},
 	}
 	kc.Flag("prefix", "prefix of values to return").StringVar(&opts.Prefix)
-	kc.Flag("start-id", "id of the value to start scanning at").StringVar(&opts.Start.ID.Value)
-	kc.Flag("start-id-exclusive", "id of the value to start scanning at").BoolVar(&opts.Start.ID.Exclusive)
-	kc.Flag("start-index", "id of the value to start scanning at").Uint64Var(opts.Start.Index)
-	kc.Flag("limit", "maximum number of items to return").IntVar(&opts.Limit)
+	kc.Flag("start-id", "id of the value to start scanning at. Must start with --prefix.").StringVar(&opts.Start.ID.Value)
+	kc.Flag("start-id-exclusive", "start scanning after --start-id rather than at it. Requires --start-id.").BoolVar(&opts.Start.ID.Exclusive)
+	kc.Flag("start-index", "index of the value to start scanning at, among all values rather than those with --prefix").Uint64Var(opts.Start.Index)
+	kc.Flag("limit", "maximum number of items to return. Must not be negative.").IntVar(&opts.Limit)
+	kc.Flag("keys-only", "only return the ids of values").BoolVar(&opts.KeysOnly)
+	kc.Flag("delimiter", "list one level of path-structured ids: ids containing the delimiter after --prefix are returned once per common prefix, like directories").StringVar(&opts.Delimiter)
 	kc.Action(func(_ *kingpin.ParseContext) error {
 		db, err := gdb()
 		if err != nil {
@@ -226,6 +303,16 @@ func scan(parent *kingpin.Application, gdb gdb, out, errs io.Writer) {
 			return nil
 		}
 		for _, it := range items {
+			if *of == outputJSON {
+				if err := writeJSON(out, it); err != nil {
+					return err
+				}
+				continue
+			}
+			if it.Value == nil {
+				fmt.Fprintln(out, it.ID)
+				continue
+			}
 			fmt.Fprintf(out, "%s: %s\n", it.ID, types.EncodedValue(it.Value.Value))
 		}
 		return nil
@@ -233,33 +320,49 @@ func scan(parent *kingpin.Application, gdb gdb, out, errs io.Writer) {
 }
 
 func put(parent *kingpin.Application, gdb gdb, in io.Reader) {
-	kc := parent.Command("put", "Reads a JSON-formated value from stdin and puts it into the database.")
+	kc := parent.Command("put", "Reads a JSON-formated value from stdin, or the value argument, and puts it into the database or into a field of an existing value.")
 	id := kc.Arg("id", "id of the value to put").Required().String()
+	path := kc.Arg("path", "JSON Pointer to a field within the value to set, rather than replacing the whole value, e.g. /user/name").String()
+	value := kc.Arg("value", "JSON value to put. Read from stdin if not specified.").String()
+	tags := kc.Flag("tags", "JSON metadata to record with the commit.").String()
 	kc.Action(func(_ *kingpin.ParseContext) error {
+		ctx := db.WithTags(context.Background(), []byte(*tags))
 		db, err := gdb()
 		if err != nil {
 			return err
 		}
 		var v bytes.Buffer
-		if _, err := v.ReadFrom(in); err != nil {
+		if *value != "" {
+			v.WriteString(*value)
+		} else if _, err := v.ReadFrom(in); err != nil {
 			return err
 		}
-		return db.Put(*id, v.Bytes())
+		if *path != "" {
+			return db.PutPathCtx(ctx, *id, *path, v.Bytes())
+		}
+		return db.PutCtx(ctx, *id, v.Bytes())
 	})
 }
 
-func del(parent *kingpin.Application, gdb gdb, out io.Writer) {
+func del(parent *kingpin.Application, gdb gdb, of *string, out io.Writer) {
 	kc := parent.Command("del", "Deletes an item from the database.")
 	id := kc.Arg("id", "id of the value to delete").Required().String()
+	tags := kc.Flag("tags", "JSON metadata to record with the commit.").String()
 	kc.Action(func(_ *kingpin.ParseContext) error {
+		ctx := db.WithTags(context.Background(), []byte(*tags))
 		db, err := gdb()
 		if err != nil {
 			return err
 		}
-		ok, err := db.Del(*id)
+		ok, err := db.DelCtx(ctx, *id)
 		if err != nil {
 			return err
 		}
+		if *of == outputJSON {
+			return writeJSON(out, struct {
+				Ok bool `json:"ok"`
+			}{ok})
+		}
 		if !ok {
 			out.Write([]byte("No such id.\n"))
 		}
@@ -267,38 +370,263 @@ func del(parent *kingpin.Application, gdb gdb, out io.Writer) {
 	})
 }
 
-func sync(parent *kingpin.Application, gdb gdb) {
-	kc := parent.Command("sync", "Sync with a this client server.")
-	remoteSpec := kp.DatabaseSpec(kc.Arg("remote", "Server to sync with. See https://github.com/attic-labs/noms/blob/master/doc/spelling.md#spelling-databases.").Required())
-	clientViewAuth := kc.Arg("client-view-auth", "Client view authorization sent to the data layer.").Default("").String()
+func pull(parent *kingpin.Application, gdb gdb, gprof gprof, of *string, out, errs io.Writer) {
+	kc := parent.Command("pull", "Pulls the latest state from a diff-server, or syncs with a shared folder.")
+	remote := kc.Flag("remote", "Server to pull from, or a shared folder to sync with, e.g. file:///path/to/folder. Defaults to the profile's remote. See https://github.com/attic-labs/noms/blob/master/doc/spelling.md#spelling-databases.").String()
+	var clientViewAuth authSource
+	kc.Flag("client-view-auth", "Client view authorization sent to the data layer. Defaults to the profile's client-view-auth.").StringVar(&clientViewAuth.Token)
+	kc.Flag("client-view-auth-env", "Environment variable to read the client view authorization from, if --client-view-auth is not specified.").PlaceHolder("VAR").StringVar(&clientViewAuth.Env)
+	kc.Flag("client-view-auth-keychain", "Service name to read the client view authorization from in the OS keychain, if --client-view-auth and --client-view-auth-env are not specified.").PlaceHolder("SERVICE").StringVar(&clientViewAuth.Keychain)
 
 	kc.Action(func(_ *kingpin.ParseContext) error {
-		db, err := gdb()
+		p, err := gprof()
+		if err != nil {
+			return err
+		}
+		r := *remote
+		if r == "" {
+			r = p.Remote
+		}
+		if r == "" {
+			return errors.New("required flag --remote not provided")
+		}
+		if dir, ok := db.FileRemoteDir(r); ok {
+			d, err := gdb()
+			if err != nil {
+				return err
+			}
+			if err := d.SyncFile(context.Background(), dir); err != nil {
+				return err
+			}
+			if *of == outputJSON {
+				return writeJSON(out, struct {
+					Root string `json:"root"`
+				}{d.Hash().String()})
+			}
+			return nil
+		}
+		remoteSpec, err := spec.ForDatabase(r)
+		if err != nil {
+			return err
+		}
+		cvAuth, err := clientViewAuth.resolve(p.ClientViewAuth)
+		if err != nil {
+			return err
+		}
+		d, err := gdb()
 		if err != nil {
 			return err
 		}
 
-		// TODO: progress
-		_, err = db.Pull(*remoteSpec, *clientViewAuth, nil)
-		return err
+		var progress db.Progress
+		if f, ok := errs.(*os.File); ok && isTerminal(f) {
+			progress = func(p db.PullProgress) {
+				var msg string
+				switch p.Phase {
+				case db.PullPhaseDownloading:
+					msg = fmt.Sprintf("Downloading: %d/%d bytes", p.BytesReceived, p.BytesExpected)
+				case db.PullPhaseApplying:
+					msg = fmt.Sprintf("Applying: %d/%d ops", p.OpsApplied, p.OpsExpected)
+				case db.PullPhaseCommitting:
+					msg = "Committing"
+				}
+				// Pad so that a shorter message fully overwrites the previous one.
+				fmt.Fprintf(errs, "\r%-40s", msg)
+			}
+			defer fmt.Fprintln(errs)
+		}
+
+		cvi, err := d.Pull(remoteSpec, cvAuth, progress)
+		if err != nil {
+			return err
+		}
+		if cvi.HTTPStatusCode != 0 && cvi.HTTPStatusCode != http.StatusOK {
+			fmt.Fprintf(errs, "Warning: client view request returned %d: %s\n", cvi.HTTPStatusCode, cvi.ErrorMessage)
+		}
+		if *of == outputJSON {
+			return writeJSON(out, struct {
+				Root string `json:"root"`
+			}{d.Hash().String()})
+		}
+		return nil
+	})
+}
+
+func previewPatch(parent *kingpin.Application, gdb gdb, of *string, in io.Reader, out io.Writer) {
+	kc := parent.Command("preview-patch", "Reads a pull response from stdin and prints the changes its patch would make, without applying it.")
+	kc.Action(func(_ *kingpin.ParseContext) error {
+		d, err := gdb()
+		if err != nil {
+			return err
+		}
+		var resp servetypes.PullResponse
+		err = json.NewDecoder(in).Decode(&resp)
+		if err != nil {
+			return err
+		}
+		p, err := d.PreviewPatch(resp)
+		if err != nil {
+			return err
+		}
+		if *of == outputJSON {
+			return writeJSON(out, p)
+		}
+		for _, c := range p.Changes {
+			fmt.Fprintf(out, "%s %s\n", c.Op, c.ID)
+		}
+		if !p.ChecksumMatches {
+			fmt.Fprintf(out, "Checksum mismatch! Expected %s, got %s\n", resp.Checksum, p.Checksum)
+		}
+		return nil
+	})
+}
+
+func diffCmd(parent *kingpin.Application, gdb gdb, of *string, out io.Writer) {
+	kc := parent.Command("diff", "Prints the keys whose values differ between this database and another.")
+	other := kc.Flag("other", "The database to compare with. Takes the same forms as --db.").Required().String()
+	prefix := kc.Flag("prefix", "Only compare keys starting with this prefix.").String()
+	kc.Action(func(_ *kingpin.ParseContext) error {
+		d, err := gdb()
+		if err != nil {
+			return err
+		}
+		sp, err := spec.ForDatabase(*other)
+		if err != nil {
+			return err
+		}
+		o, err := db.Load(sp)
+		if err != nil {
+			return err
+		}
+		diffs := d.Diff(o, *prefix)
+		for _, kd := range diffs {
+			if *of == outputJSON {
+				if err := writeJSON(out, kd); err != nil {
+					return err
+				}
+				continue
+			}
+			switch kd.Op {
+			case "add":
+				fmt.Fprintf(out, "+ %s: %s\n", kd.ID, types.EncodedValue(kd.New.Value))
+			case "remove":
+				fmt.Fprintf(out, "- %s: %s\n", kd.ID, types.EncodedValue(kd.Old.Value))
+			default:
+				fmt.Fprintf(out, "~ %s: %s -> %s\n", kd.ID, types.EncodedValue(kd.Old.Value), types.EncodedValue(kd.New.Value))
+			}
+		}
+		if len(diffs) == 0 && *of != outputJSON {
+			out.Write([]byte("No differences.\n"))
+		}
+		return nil
+	})
+}
+
+func statsCmd(parent *kingpin.Application, gdb gdb, of *string, out io.Writer) {
+	kc := parent.Command("stats", "Prints the number of keys, approximate size, and pending commits of the database.")
+	verbose := kc.Flag("verbose", "Also print how many values are equal to those of other keys, and so stored once, by key prefix.").Short('v').Bool()
+	delimiter := kc.Flag("delimiter", "Delimiter ending key prefixes for --verbose.").Default("/").String()
+	kc.Action(func(_ *kingpin.ParseContext) error {
+		d, err := gdb()
+		if err != nil {
+			return err
+		}
+		s, err := d.Stats()
+		if err != nil {
+			return err
+		}
+		var total db.SharingStats
+		var byPrefix []db.SharingStats
+		if *verbose {
+			total, byPrefix, err = d.Sharing(*delimiter)
+			if err != nil {
+				return err
+			}
+			sort.SliceStable(byPrefix, func(i, j int) bool { return byPrefix[i].SharedBytes() > byPrefix[j].SharedBytes() })
+		}
+		if *of == outputJSON {
+			if !*verbose {
+				return writeJSON(out, s)
+			}
+			return writeJSON(out, struct {
+				db.Stats
+				Sharing         db.SharingStats   `json:"sharing"`
+				SharingByPrefix []db.SharingStats `json:"sharingByPrefix"`
+			}{s, total, byPrefix})
+		}
+		t := (&tbl.Table{}).
+			Add("Keys: ", fmt.Sprint(s.Keys)).
+			Add("Bytes: ", fmt.Sprint(s.Bytes)).
+			Add("Local-only keys: ", fmt.Sprint(s.LocalOnlyKeys)).
+			Add("Local-only bytes: ", fmt.Sprint(s.LocalOnlyBytes)).
+			Add("Pending commits: ", fmt.Sprint(s.PendingCommits))
+		if *verbose {
+			t.Add("Distinct values: ", fmt.Sprintf("%d of %d", total.DistinctValues, total.Keys)).
+				Add("Shared value bytes: ", fmt.Sprintf("%d of %d", total.SharedBytes(), total.ValueBytes))
+		}
+		if _, err = t.WriteTo(out); err != nil || !*verbose {
+			return err
+		}
+		fmt.Fprintf(out, "\nShared\tBytes\tDistinct\tKeys\tPrefix\n")
+		for _, ps := range byPrefix {
+			fmt.Fprintf(out, "%d\t%d\t%d\t%d\t%s\n", ps.SharedBytes(), ps.ValueBytes, ps.DistinctValues, ps.Keys, ps.Prefix)
+		}
+		return nil
+	})
+}
+
+func du(parent *kingpin.Application, gdb gdb, of *string, out io.Writer) {
+	kc := parent.Command("du", "Prints the approximate size in bytes and number of keys of each key, or key prefix, largest first.")
+	byPrefix := kc.Flag("by-prefix", "Group keys by prefix: the key up to and including the first --delimiter.").Bool()
+	delimiter := kc.Flag("delimiter", "Delimiter ending key prefixes for --by-prefix.").Default("/").String()
+	kc.Action(func(_ *kingpin.ParseContext) error {
+		d, err := gdb()
+		if err != nil {
+			return err
+		}
+		delim := ""
+		if *byPrefix {
+			delim = *delimiter
+		}
+		stats, err := d.StatsByPrefix(delim)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].Bytes > stats[j].Bytes })
+		for _, ps := range stats {
+			if *of == outputJSON {
+				if err := writeJSON(out, ps); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintf(out, "%d\t%d\t%s\n", ps.Bytes, ps.Keys, ps.Prefix)
+		}
+		return nil
 	})
 }
 
 func drop(parent *kingpin.Application, gsp gsp, in io.Reader, out io.Writer) {
 	kc := parent.Command("drop", "Deletes a this client database and its history.")
+	force := kc.Flag("force", "Drop without prompting for confirmation. Required when stdin is not a terminal.").Short('y').Bool()
 
 	r := bufio.NewReader(in)
 	w := bufio.NewWriter(out)
 	kc.Action(func(_ *kingpin.ParseContext) error {
-		w.WriteString(dropWarning)
-		w.Flush()
-		answer, err := r.ReadString('\n')
-		if err != nil {
-			return err
-		}
-		answer = strings.TrimSpace(answer)
-		if answer != "y" {
-			return nil
+		if !*force {
+			if !isInteractive(in) {
+				return errors.New("Refusing to drop without confirmation because stdin is not a terminal - pass --force to drop anyway")
+			}
+			w.WriteString(dropWarning)
+			w.Flush()
+			answer, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			answer = strings.TrimSpace(answer)
+			if answer != "y" {
+				return nil
+			}
 		}
 		sp, err := gsp()
 		if err != nil {
@@ -310,11 +638,101 @@ func drop(parent *kingpin.Application, gsp gsp, in io.Reader, out io.Writer) {
 	})
 }
 
-func logCmd(parent *kingpin.Application, gdb gdb, out io.Writer) {
+func doctor(parent *kingpin.Application, gsp gsp, of *string, out io.Writer) {
+	kc := parent.Command("doctor", "Diagnoses problems that prevent a database from loading, such as a local head that isn't a Replicache commit.")
+	repair := kc.Flag("repair", "Repair the problems found.").Bool()
+	kc.Action(func(_ *kingpin.ParseContext) error {
+		sp, err := gsp()
+		if err != nil {
+			return err
+		}
+		noms := sp.GetDatabase()
+		var problems []db.Problem
+		if *repair {
+			problems, err = db.Repair(noms)
+			if err != nil {
+				return err
+			}
+		} else {
+			problems = db.Diagnose(noms)
+		}
+		if *of == outputJSON {
+			return writeJSON(out, struct {
+				Problems []db.Problem `json:"problems"`
+				Repaired bool         `json:"repaired,omitempty"`
+			}{problems, *repair})
+		}
+		if len(problems) == 0 {
+			fmt.Fprintln(out, "No problems found.")
+			return nil
+		}
+		for _, p := range problems {
+			fmt.Fprintf(out, "%s: %s\n", p.Kind, p.Description)
+			if *repair {
+				fmt.Fprintf(out, "  Repaired: %s\n", p.Repair)
+			} else {
+				fmt.Fprintf(out, "  Repair: %s\n", p.Repair)
+			}
+		}
+		if !*repair {
+			fmt.Fprintln(out, "Run with --repair to repair.")
+		}
+		return nil
+	})
+}
+
+// isInteractive returns false if in is a file that is not a terminal, e.g. when stdin is
+// redirected from /dev/null or a pipe in a script. Other readers are assumed to be interactive.
+func isInteractive(in io.Reader) bool {
+	f, ok := in.(*os.File)
+	if !ok {
+		return true
+	}
+	return isTerminal(f)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// logEntry is the JSON representation of a commit emitted by `log --output=json`.
+type logEntry struct {
+	Hash         string          `json:"hash"`
+	Created      time.Time       `json:"created"`
+	Status       string          `json:"status"`
+	Merged       time.Time       `json:"merged"`
+	InitialBasis string          `json:"initialBasis,omitempty"`
+	Name         string          `json:"name"`
+	Args         []string        `json:"args"`
+	Tags         json.RawMessage `json:"tags,omitempty"`
+}
+
+func logCmd(parent *kingpin.Application, gdb gdb, of *string, out io.Writer) {
 	kc := parent.Command("log", "Displays the history of a this client database.")
 	np := kc.Flag("no-pager", "supress paging functionality").Bool()
+	sinceFlag := kc.Flag("since", "Only show commits created since this time: a duration ago, like 24h, or a date, like 2006-01-02 or 2006-01-02T15:04:05Z.").String()
+	maxCount := kc.Flag("max-count", "Show at most this many commits.").Short('n').Int()
+	key := kc.Flag("key", "Only show commits that change keys starting with this prefix.").PlaceHolder("PREFIX").String()
+	format := kc.Flag("format", "Output format. Defaults to --output.").Enum(outputText, outputJSON)
+	noDiff := kc.Flag("no-diff", "Don't print the changes made by each commit.").Bool()
 
 	kc.Action(func(_ *kingpin.ParseContext) error {
+		var since time.Time
+		if *sinceFlag != "" {
+			var err error
+			since, err = parseSince(*sinceFlag, rtime.Now())
+			if err != nil {
+				return err
+			}
+		}
+		of := of
+		if *format != "" {
+			of = format
+		}
 		d, err := gdb()
 		if err != nil {
 			return err
@@ -325,8 +743,9 @@ func logCmd(parent *kingpin.Application, gdb gdb, out io.Writer) {
 			return err
 		}
 		inRemote := false
+		shown := 0
 
-		if !*np {
+		if !*np && *of != outputJSON {
 			pgr := outputpager.Start()
 			defer pgr.Stop()
 			out = pgr.Writer
@@ -336,6 +755,9 @@ func logCmd(parent *kingpin.Application, gdb gdb, out io.Writer) {
 			if c.Type() == db.CommitTypeGenesis {
 				break
 			}
+			if *maxCount > 0 && shown >= *maxCount {
+				break
+			}
 
 			if c.Original.Equals(r.Original) {
 				inRemote = true
@@ -363,7 +785,7 @@ func logCmd(parent *kingpin.Application, gdb gdb, out io.Writer) {
 				return
 			}
 
-			getTx := func() string {
+			getArgs := func() []string {
 				args := []string{}
 				it := initialCommit.Meta.Tx.Args.Iterator()
 				for {
@@ -377,7 +799,54 @@ func logCmd(parent *kingpin.Application, gdb gdb, out io.Writer) {
 						args = append(args, types.EncodedValue(v))
 					}
 				}
-				return fmt.Sprintf("%s(%s)", initialCommit.Meta.Tx.Name, strings.Join(args, ", "))
+				return args
+			}
+
+			var tags bytes.Buffer
+			if initialCommit.Meta.Tx.Tags != nil {
+				err = nomsjson.ToJSON(initialCommit.Meta.Tx.Tags, &tags)
+				if err != nil {
+					return err
+				}
+			}
+
+			basis, err := c.Basis(d.Noms())
+			if err != nil {
+				return err
+			}
+
+			if (!since.IsZero() && initialCommit.Meta.Tx.Date.Time.Before(since)) ||
+				(*key != "" && !touchesPrefix(basis.Data(d.Noms()).NomsMap(), c.Data(d.Noms()).NomsMap(), *key)) {
+				c = basis
+				continue
+			}
+			shown++
+
+			if *of == outputJSON {
+				status, t := getStatus()
+				e := logEntry{
+					Hash:    c.Original.Hash().String(),
+					Created: initialCommit.Meta.Tx.Date.Time,
+					Status:  status,
+					Merged:  t,
+					Name:    initialCommit.Meta.Tx.Name,
+					Args:    getArgs(),
+				}
+				if tags.Len() > 0 {
+					e.Tags = tags.Bytes()
+				}
+				if !initialCommit.Original.Equals(c.Original) {
+					initialBasis, err := initialCommit.Basis(d.Noms())
+					if err != nil {
+						return err
+					}
+					e.InitialBasis = initialBasis.Original.Hash().String()
+				}
+				if err := writeJSON(out, e); err != nil {
+					return err
+				}
+				c = basis
+				continue
 			}
 
 			fmt.Fprintln(out, color("commit "+c.Original.Hash().String(), "red+h"))
@@ -397,21 +866,21 @@ func logCmd(parent *kingpin.Application, gdb gdb, out io.Writer) {
 				}
 				table.Add("Initial Basis: ", initialBasis.Original.Hash().String())
 			}
-			table.Add("Transaction: ", getTx())
-
-			_, err = table.WriteTo(out)
-			if err != nil {
-				return err
+			table.Add("Transaction: ", fmt.Sprintf("%s(%s)", initialCommit.Meta.Tx.Name, strings.Join(getArgs(), ", ")))
+			if tags.Len() > 0 {
+				table.Add("Tags: ", tags.String())
 			}
 
-			basis, err := c.Basis(d.Noms())
+			_, err = table.WriteTo(out)
 			if err != nil {
 				return err
 			}
 
-			err = diff.PrintDiff(out, basis.Data(d.Noms()).NomsMap(), c.Data(d.Noms()).NomsMap(), false)
-			if err != nil {
-				return err
+			if !*noDiff {
+				err = diff.PrintDiff(out, basis.Data(d.Noms()).NomsMap(), c.Data(d.Noms()).NomsMap(), false)
+				if err != nil {
+					return err
+				}
 			}
 
 			fmt.Fprintln(out, "")
@@ -422,9 +891,54 @@ func logCmd(parent *kingpin.Application, gdb gdb, out io.Writer) {
 	})
 }
 
+// parseSince parses the --since flag of log, which is either a duration before now or an
+// absolute time.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since: %s", s)
+}
+
+// touchesPrefix returns whether any key starting with prefix differs between from and to.
+func touchesPrefix(from, to types.Map, prefix string) bool {
+	changes := make(chan types.ValueChanged)
+	stop := make(chan struct{})
+	go func() {
+		to.Diff(from, changes, stop)
+		close(changes)
+	}()
+	found := false
+	for c := range changes {
+		if !found && strings.HasPrefix(string(c.Key.(types.String)), prefix) {
+			found = true
+			close(stop)
+		}
+	}
+	return found
+}
+
 func color(text, color string) string {
 	if outputpager.IsStdoutTty() {
 		return ansi.Color(text, color)
 	}
 	return text
 }
+
+func genVectors(parent *kingpin.Application, out io.Writer) {
+	kc := parent.Command("gen-vectors", "Prints golden protocol test vectors as JSON, for validating other client implementations and the diff server.")
+	kc.Action(func(_ *kingpin.ParseContext) error {
+		v, err := vectors.Generate()
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	})
+}
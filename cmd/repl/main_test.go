@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"regexp"
 	"strings"
 	"testing"
@@ -10,8 +15,10 @@ import (
 	"github.com/attic-labs/noms/go/spec"
 	"github.com/stretchr/testify/assert"
 
+	servetypes "roci.dev/diff-server/serve/types"
 	"roci.dev/diff-server/util/time"
 	"roci.dev/replicache-client/db"
+	"roci.dev/replicache-client/vectors"
 )
 
 func TestCommands(t *testing.T) {
@@ -174,6 +181,86 @@ func TestCommands(t *testing.T) {
 			"",
 			"",
 		},
+		{
+			"scan prefix start-id good",
+			"",
+			"scan --prefix=f --start-id=fo",
+			0,
+			"foo: \"bar\"\n",
+			"",
+		},
+		{
+			"scan prefix start-id contradictory",
+			"",
+			"scan --prefix=f --start-id=g",
+			0,
+			"",
+			"invalid argument: start.id 'g' is not within prefix 'f'\n",
+		},
+		{
+			"scan start-id-exclusive missing start-id",
+			"",
+			"scan --start-id-exclusive",
+			0,
+			"",
+			"invalid argument: start.id.exclusive requires start.id.value\n",
+		},
+		{
+			"scan limit negative",
+			"",
+			"scan --limit=-1",
+			0,
+			"",
+			"invalid argument: limit must not be negative: -1\n",
+		},
+		{
+			"has json",
+			"",
+			"--output=json has foo",
+			0,
+			"{\"has\":true}\n",
+			"",
+		},
+		{
+			"has json missing",
+			"",
+			"--output=json has monkey",
+			0,
+			"{\"has\":false}\n",
+			"",
+		},
+		{
+			"get json",
+			"",
+			"--output=json get foo",
+			0,
+			"{\"has\":true,\"value\":\"bar\"}\n",
+			"",
+		},
+		{
+			"get json missing",
+			"",
+			"--output=json get monkey",
+			0,
+			"{\"has\":false}\n",
+			"",
+		},
+		{
+			"scan json",
+			"",
+			"--output=json scan",
+			0,
+			"{\"id\":\"foo\",\"value\":\"bar\"}\n",
+			"",
+		},
+		{
+			"output bad",
+			"",
+			"--output=xml has foo",
+			1,
+			"",
+			"enum value must be one of text,json, got 'xml'\n",
+		},
 		{
 			"del bad missing-arg",
 			"",
@@ -190,6 +277,14 @@ func TestCommands(t *testing.T) {
 			"No such id.\n",
 			"",
 		},
+		{
+			"del json no-op",
+			"",
+			"--output=json del monkey",
+			0,
+			"{\"ok\":false}\n",
+			"",
+		},
 		{
 			"del good",
 			"",
@@ -263,6 +358,96 @@ func TestDrop(t *testing.T) {
 	}
 }
 
+func TestDropNonInteractive(t *testing.T) {
+	assert := assert.New(t)
+	tc := []struct {
+		force   bool
+		code    int
+		errs    string
+		deleted bool
+	}{
+		{false, 1, "Refusing to drop without confirmation because stdin is not a terminal - pass --force to drop anyway\n", false},
+		{true, 0, "", true},
+	}
+
+	for i, t := range tc {
+		d, dir := db.LoadTempDB(assert)
+		d.Put("foo", []byte(`"bar"`))
+
+		desc := fmt.Sprintf("test case %d, force: %t", i, t.force)
+		args := []string{"--db=" + dir, "drop"}
+		if t.force {
+			args = append(args, "-y")
+		}
+		in, err := os.Open(os.DevNull)
+		assert.NoError(err)
+		out := strings.Builder{}
+		errs := strings.Builder{}
+		code := 0
+		impl(args, in, &out, &errs, func(c int) { code = c })
+		in.Close()
+
+		assert.Equal("", out.String(), desc)
+		assert.Equal(t.errs, errs.String(), desc)
+		assert.Equal(t.code, code, desc)
+		sp, err := spec.ForDatabase(dir)
+		assert.NoError(err)
+		noms := sp.GetDatabase()
+		ds := noms.GetDataset(db.LOCAL_DATASET)
+		assert.Equal(!t.deleted, ds.HasHead(), desc)
+	}
+}
+
+func TestPull(t *testing.T) {
+	assert := assert.New(t)
+	_, dir := db.LoadTempDB(assert)
+
+	var reqBody servetypes.PullRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/pull", r.URL.Path)
+		assert.NoError(json.NewDecoder(r.Body).Decode(&reqBody))
+		w.Write([]byte(`{"patch":[{"op":"add","path":"/foo","value":"bar"}],"stateID":"11111111111111111111111111111111","checksum":"c4e7090d","lastMutationID":2,"clientViewInfo":{"httpStatusCode":200,"errorMessage":""}}`))
+	}))
+	defer server.Close()
+
+	run := func(args ...string) (string, string, int) {
+		out := strings.Builder{}
+		errs := strings.Builder{}
+		code := 0
+		impl(append([]string{"--db=" + dir}, args...), strings.NewReader(""), &out, &errs, func(c int) { code = c })
+		return out.String(), errs.String(), code
+	}
+
+	_, errs, code := run("pull")
+	assert.Equal(1, code)
+	assert.Equal("required flag --remote not provided\n", errs)
+
+	out, _, code := run("--output=json", "pull", "--remote="+server.URL, "--client-view-auth=t123")
+	assert.Equal(0, code)
+	assert.Regexp(`^{"root":"[0-9a-v]{32}"}\n$`, out)
+	assert.Equal("t123", reqBody.ClientViewAuth)
+	assert.Equal("", reqBody.BaseStateID)
+
+	// The client view auth can come from the environment or a profile, like --auth.
+	os.Setenv("REPL_TEST_CLIENT_VIEW_AUTH", "t456")
+	defer os.Unsetenv("REPL_TEST_CLIENT_VIEW_AUTH")
+	_, _, code = run("pull", "--remote="+server.URL, "--client-view-auth-env=REPL_TEST_CLIENT_VIEW_AUTH")
+	assert.Equal(0, code)
+	assert.Equal("t456", reqBody.ClientViewAuth)
+	_, errs, code = run("pull", "--remote="+server.URL, "--client-view-auth-env=REPL_TEST_CLIENT_VIEW_AUTH_UNSET")
+	assert.Equal(1, code)
+	assert.Equal("environment variable REPL_TEST_CLIENT_VIEW_AUTH_UNSET is not set\n", errs)
+	cfg := dir + "/config.toml"
+	assert.NoError(ioutil.WriteFile(cfg, []byte("[default]\nclient-view-auth = \"t789\"\n"), 0644))
+	_, _, code = run("--config="+cfg, "pull", "--remote="+server.URL)
+	assert.Equal(0, code)
+	assert.Equal("t789", reqBody.ClientViewAuth)
+
+	out, _, code = run("get", "foo")
+	assert.Equal(0, code)
+	assert.Equal(`"bar"`, out)
+}
+
 func TestEmptyInput(t *testing.T) {
 	assert := assert.New(t)
 	db.LoadTempDB(assert)
@@ -274,3 +459,342 @@ func TestEmptyInput(t *testing.T) {
 	args = []string{"--db=/tmp/foo"}
 	impl(args, strings.NewReader(""), ioutil.Discard, ioutil.Discard, func(_ int) {})
 }
+
+func TestPreviewPatch(t *testing.T) {
+	assert := assert.New(t)
+	_, dir := db.LoadTempDB(assert)
+
+	run := func(in string, args ...string) (string, string, int) {
+		out := strings.Builder{}
+		errs := strings.Builder{}
+		code := 0
+		impl(append([]string{"--db=" + dir}, args...), strings.NewReader(in), &out, &errs, func(c int) { code = c })
+		return out.String(), errs.String(), code
+	}
+
+	resp := `{"patch":[{"op":"add","path":"/foo","value":"bar"}],"stateID":"11111111111111111111111111111111","checksum":"c4e7090d","lastMutationID":2}`
+	out, _, code := run(resp, "preview-patch")
+	assert.Equal(0, code)
+	assert.Equal("add foo\n", out)
+
+	out, _, code = run(strings.Replace(resp, "c4e7090d", "00000000", 1), "--output=json", "preview-patch")
+	assert.Equal(0, code)
+	assert.Equal(`{"checksum":"c4e7090d","checksumMatches":false,"changes":[{"id":"foo","op":"add"}]}`+"\n", out)
+
+	// Nothing was applied.
+	out, _, code = run("", "has", "foo")
+	assert.Equal(0, code)
+	assert.Equal("false\n", out)
+}
+
+func TestDiff(t *testing.T) {
+	assert := assert.New(t)
+	a, dirA := db.LoadTempDB(assert)
+	b, dirB := db.LoadTempDB(assert)
+	assert.NoError(a.Put("foo", []byte(`"bar"`)))
+	assert.NoError(a.Put("hot", []byte(`"dog"`)))
+	assert.NoError(b.Put("foo", []byte(`"baz"`)))
+	assert.NoError(b.Put("new", []byte(`1`)))
+
+	run := func(args ...string) (string, int) {
+		out := strings.Builder{}
+		code := 0
+		impl(append([]string{"--db=" + dirA}, args...), strings.NewReader(""), &out, &strings.Builder{}, func(c int) { code = c })
+		return out.String(), code
+	}
+
+	out, code := run("diff", "--other="+dirB)
+	assert.Equal(0, code)
+	assert.Equal("~ foo: \"bar\" -> \"baz\"\n- hot: \"dog\"\n+ new: 1\n", out)
+
+	out, code = run("--output=json", "diff", "--other="+dirB, "--prefix=h")
+	assert.Equal(0, code)
+	assert.Equal(`{"id":"hot","op":"remove","old":"dog"}`+"\n", out)
+
+	out, code = run("diff", "--other="+dirA)
+	assert.Equal(0, code)
+	assert.Equal("No differences.\n", out)
+}
+
+func TestGenVectors(t *testing.T) {
+	assert := assert.New(t)
+	out := strings.Builder{}
+	code := 0
+	impl([]string{"gen-vectors"}, strings.NewReader(""), &out, &strings.Builder{}, func(c int) { code = c })
+	assert.Equal(0, code)
+	var v vectors.Vectors
+	assert.NoError(json.Unmarshal([]byte(out.String()), &v))
+	assert.NotEmpty(v.Checksums)
+	assert.NotEmpty(v.Pulls)
+	assert.NotEmpty(v.Dispatch)
+
+	// Other commands still require --db.
+	errs := strings.Builder{}
+	impl([]string{"--config=/not/existent/config.toml", "has", "foo"}, strings.NewReader(""), &out, &errs, func(c int) { code = c })
+	assert.Equal(1, code)
+	assert.Contains(errs.String(), "required flag --db not provided")
+}
+
+func TestParseConfig(t *testing.T) {
+	assert := assert.New(t)
+	tc := []struct {
+		in            string
+		expected      config
+		expectedError string
+	}{
+		{"", config{}, ""},
+		{"# comment\n\n[default]\ndb = \"/tmp/db\"\n", config{"default": {DB: "/tmp/db"}}, ""},
+		{"[work]\ndb='C:\\db' # literal\nremote = \"https://example.com\"\nauth = \"s\\\"ecret\"\nclient-view-auth = 'cv'\n[empty]", config{
+			"work":  {DB: `C:\db`, Remote: "https://example.com", Auth: `s"ecret`, ClientViewAuth: "cv"},
+			"empty": {},
+		}, ""},
+		{"db = \"/tmp/db\"", nil, "line 1: settings must be in a profile table, e.g. [default]"},
+		{"[default\n", nil, "line 1: invalid table header: [default"},
+		{"[]", nil, "line 1: empty profile name"},
+		{"[default]\ndb", nil, "line 2: expected key = value"},
+		{"[default]\ndb = /tmp/db", nil, "line 2: values must be strings: /tmp/db"},
+		{"[default]\ndb = \"/tmp/db", nil, "line 2: unterminated string: \"/tmp/db"},
+		{"[default]\ndb = \"/tmp/db\" x", nil, "line 2: unexpected text after value: x"},
+		{"[default]\nport = \"1\"", nil, "line 2: unknown key: port"},
+	}
+	for i, t := range tc {
+		c, err := parseConfig(strings.NewReader(t.in))
+		if t.expectedError != "" {
+			assert.EqualError(err, t.expectedError, "case %d", i)
+			continue
+		}
+		assert.NoError(err, "case %d", i)
+		assert.Equal(t.expected, c, "case %d", i)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	cfg := dir + "/config.toml"
+	assert.NoError(ioutil.WriteFile(cfg, []byte(fmt.Sprintf("[default]\ndb = %q\n\n[other]\ndb = %q\n", dir+"/default", dir+"/other")), 0644))
+
+	run := func(in string, args ...string) (string, string, int) {
+		out := strings.Builder{}
+		errs := strings.Builder{}
+		code := 0
+		impl(append([]string{"--config=" + cfg}, args...), strings.NewReader(in), &out, &errs, func(c int) { code = c })
+		return out.String(), errs.String(), code
+	}
+
+	_, _, code := run(`"default"`, "put", "foo")
+	assert.Equal(0, code)
+	_, _, code = run(`"other"`, "--profile=other", "put", "foo")
+	assert.Equal(0, code)
+
+	out, _, _ := run("", "get", "foo")
+	assert.Equal(`"default"`, out)
+	out, _, _ = run("", "--profile=other", "get", "foo")
+	assert.Equal(`"other"`, out)
+	// Flags take precedence over the profile.
+	out, _, _ = run("", "--profile=other", "--db="+dir+"/default", "get", "foo")
+	assert.Equal(`"default"`, out)
+
+	_, errs, code := run("", "--profile=nope", "get", "foo")
+	assert.Equal(1, code)
+	assert.Equal("No such profile: nope\n", errs)
+}
+
+func TestAuthSource(t *testing.T) {
+	assert := assert.New(t)
+	defer func(f func(string) (string, error)) { keychainLookup = f }(keychainLookup)
+	keychainLookup = func(service string) (string, error) {
+		if service == "replicache" {
+			return "keychain-token", nil
+		}
+		return "", errors.New("no such item")
+	}
+	os.Setenv("REPL_TEST_AUTH", "env-token")
+	defer os.Unsetenv("REPL_TEST_AUTH")
+
+	tc := []struct {
+		src           authSource
+		expected      string
+		expectedError string
+	}{
+		{authSource{}, "profile-token", ""},
+		{authSource{Token: "flag-token", Env: "REPL_TEST_AUTH", Keychain: "replicache"}, "flag-token", ""},
+		{authSource{Env: "REPL_TEST_AUTH", Keychain: "replicache"}, "env-token", ""},
+		{authSource{Keychain: "replicache"}, "keychain-token", ""},
+		{authSource{Env: "REPL_TEST_AUTH_UNSET"}, "", "environment variable REPL_TEST_AUTH_UNSET is not set"},
+		{authSource{Keychain: "other"}, "", "could not read other from keychain: no such item"},
+	}
+	for i, t := range tc {
+		tok, err := t.src.resolve("profile-token")
+		if t.expectedError != "" {
+			assert.EqualError(err, t.expectedError, "case %d", i)
+			continue
+		}
+		assert.NoError(err, "case %d", i)
+		assert.Equal(t.expected, tok, "case %d", i)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	assert := assert.New(t)
+	run := func(shell string) (string, int) {
+		out := strings.Builder{}
+		code := 0
+		impl([]string{"completion", shell}, strings.NewReader(""), &out, &strings.Builder{}, func(c int) { code = c })
+		return out.String(), code
+	}
+
+	out, code := run("bash")
+	assert.Equal(0, code)
+	assert.Contains(out, "complete -o default -F _repl repl\n")
+	assert.Regexp(`"" opts=".*--db .*\bpull\b.*"`, out)
+	assert.Regexp(`scan\) opts=".*--db .*--prefix.*"`, out)
+	assert.NotContains(out, "--completion-script-bash")
+
+	out, code = run("zsh")
+	assert.Equal(0, code)
+	assert.True(strings.HasPrefix(out, "autoload -U +X bashcompinit && bashcompinit\n"))
+
+	out, code = run("fish")
+	assert.Equal(0, code)
+	assert.Contains(out, "complete -c repl -l db -r -d ")
+	assert.Contains(out, "complete -c repl -f -n __fish_use_subcommand -a pull -d 'Pulls the latest state from a diff-server.'\n")
+	assert.Contains(out, "complete -c repl -n '__fish_seen_subcommand_from scan' -l prefix -r -d ")
+
+	_, code = run("tcsh")
+	assert.Equal(1, code)
+}
+
+func TestLog(t *testing.T) {
+	assert := assert.New(t)
+	_, dir := db.LoadTempDB(assert)
+
+	run := func(in string, args ...string) (string, string, int) {
+		out := strings.Builder{}
+		errs := strings.Builder{}
+		code := 0
+		impl(append([]string{"--db=" + dir}, args...), strings.NewReader(in), &out, &errs, func(c int) { code = c })
+		return out.String(), errs.String(), code
+	}
+	for _, kv := range [][2]string{{"foo", `"bar"`}, {"user/1", `"abby"`}, {"foo", `"baz"`}} {
+		_, _, code := run(kv[1], "put", kv[0])
+		assert.Equal(0, code)
+	}
+
+	entries := func(args ...string) []logEntry {
+		out, errs, code := run("", append([]string{"log", "--no-pager", "--format=json"}, args...)...)
+		assert.Equal(0, code, errs)
+		r := []logEntry{}
+		dec := json.NewDecoder(strings.NewReader(out))
+		for dec.More() {
+			var e logEntry
+			assert.NoError(dec.Decode(&e))
+			r = append(r, e)
+		}
+		return r
+	}
+
+	all := entries()
+	assert.Equal(3, len(all))
+	assert.Equal(all[:2], entries("--max-count=2"))
+	assert.Equal(all[1:2], entries("--key=user/"))
+	assert.Equal([]logEntry{all[0], all[2]}, entries("--key=foo"))
+	assert.Equal(all, entries("--since=2000-01-01"))
+	assert.Equal(all, entries("--since=24h"))
+	assert.Equal([]logEntry{}, entries("--since=2999-01-01T00:00:00Z"))
+
+	out, _, code := run("", "log", "--no-pager")
+	assert.Equal(0, code)
+	assert.Regexp(`(?m)^\+`, out)
+	out, _, code = run("", "log", "--no-pager", "--no-diff")
+	assert.Equal(0, code)
+	assert.Equal(3, strings.Count(out, "commit "))
+	assert.NotRegexp(`(?m)^\+`, out)
+
+	_, errs, code := run("", "log", "--since=yesterday")
+	assert.Equal(1, code)
+	assert.Equal("invalid --since: yesterday\n", errs)
+}
+
+func TestGetPutPath(t *testing.T) {
+	assert := assert.New(t)
+	_, dir := db.LoadTempDB(assert)
+
+	run := func(in string, args ...string) (string, string, int) {
+		out := strings.Builder{}
+		errs := strings.Builder{}
+		code := 0
+		impl(append([]string{"--db=" + dir}, args...), strings.NewReader(in), &out, &errs, func(c int) { code = c })
+		return out.String(), errs.String(), code
+	}
+
+	_, _, code := run(`{"user":{"name":"abby","tags":[]}}`, "put", "doc")
+	assert.Equal(0, code)
+	_, _, code = run("", "put", "doc", "/user/name", `"aaron"`)
+	assert.Equal(0, code)
+	_, _, code = run(`"admin"`, "put", "doc", "/user/tags/-")
+	assert.Equal(0, code)
+
+	out, _, code := run("", "get", "doc")
+	assert.Equal(0, code)
+	assert.Equal(`{"user":{"name":"aaron","tags":["admin"]}}`, out)
+	out, _, code = run("", "get", "doc", "/user/tags/0")
+	assert.Equal(0, code)
+	assert.Equal(`"admin"`, out)
+	out, _, code = run("", "--output=json", "get", "doc", "/user/age")
+	assert.Equal(0, code)
+	assert.Equal("{\"has\":false}\n", out)
+
+	_, errs, code := run("", "put", "doc", "/group/name", `"x"`)
+	assert.Equal(1, code)
+	assert.Equal("could not Put 'doc' at '/group/name': no such field: group\n", errs)
+}
+
+func TestStatsAndDu(t *testing.T) {
+	assert := assert.New(t)
+	_, dir := db.LoadTempDB(assert)
+
+	run := func(in string, args ...string) (string, string, int) {
+		out := strings.Builder{}
+		errs := strings.Builder{}
+		code := 0
+		impl(append([]string{"--db=" + dir}, args...), strings.NewReader(in), &out, &errs, func(c int) { code = c })
+		return out.String(), errs.String(), code
+	}
+	for _, kv := range [][2]string{{"user/1", `"abby"`}, {"user/2", `{"name":"aaron"}`}, {"todo/1", `true`}} {
+		_, _, code := run(kv[1], "put", kv[0])
+		assert.Equal(0, code)
+	}
+
+	out, _, code := run("", "--output=json", "stats")
+	assert.Equal(0, code)
+	assert.Equal(`{"keys":3,"bytes":44,"localOnlyKeys":0,"localOnlyBytes":0,"pendingCommits":3}`+"\n", out)
+	out, _, code = run("", "stats")
+	assert.Equal(0, code)
+	assert.Regexp(`(?m)^Keys: +3$`, out)
+	assert.Regexp(`(?m)^Pending commits: +3$`, out)
+
+	_, _, code = run(`"abby"`, "put", "user/3")
+	assert.Equal(0, code)
+	out, _, code = run("", "stats", "--verbose")
+	assert.Equal(0, code)
+	assert.Regexp(`(?m)^Distinct values: +3 of 4$`, out)
+	assert.Regexp(`(?m)^Shared value bytes: +6 of 32$`, out)
+	assert.Contains(out, "\nShared\tBytes\tDistinct\tKeys\tPrefix\n6\t28\t2\t3\tuser/\n0\t4\t1\t1\ttodo/\n")
+	out, _, code = run("", "--output=json", "stats", "-v", "--delimiter=/2")
+	assert.Equal(0, code)
+	assert.Contains(out, `"sharing":{"prefix":"","keys":4,"distinctValues":3,"valueBytes":32,"distinctValueBytes":26}`)
+	_, _, code = run("", "del", "user/3")
+	assert.Equal(0, code)
+
+	out, _, code = run("", "du")
+	assert.Equal(0, code)
+	assert.Equal("22\t1\tuser/2\n12\t1\tuser/1\n10\t1\ttodo/1\n", out)
+	out, _, code = run("", "du", "--by-prefix")
+	assert.Equal(0, code)
+	assert.Equal("34\t2\tuser/\n10\t1\ttodo/\n", out)
+	out, _, code = run("", "--output=json", "du", "--by-prefix", "--delimiter=/1")
+	assert.Equal(0, code)
+	assert.Equal(`{"prefix":"user/2","keys":1,"bytes":22}`+"\n"+`{"prefix":"user/1","keys":1,"bytes":12}`+"\n"+`{"prefix":"todo/1","keys":1,"bytes":10}`+"\n", out)
+}
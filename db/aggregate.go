@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/attic-labs/noms/go/types"
+)
+
+type AggregateOptions struct {
+	// Prefix restricts the aggregate to keys starting with it.
+	Prefix string `json:"prefix,omitempty"`
+	// Path is a JSON Pointer to the numeric field to compute min, max, and sum over. If empty,
+	// only count is computed.
+	Path string `json:"path,omitempty"`
+	// Filter restricts the aggregate to values matching it.
+	Filter *ScanFilter `json:"filter,omitempty"`
+}
+
+type AggregateResult struct {
+	// Count is the number of values in range that match the filter.
+	Count uint64 `json:"count"`
+	// NumericCount is the number of those values that have a number at Path. Min, Max, and Sum
+	// are computed over these.
+	NumericCount uint64   `json:"numericCount"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	Sum          float64  `json:"sum"`
+}
+
+// Aggregate computes summary statistics over a range of keys without returning their values.
+func (db *DB) Aggregate(ctx context.Context, opts AggregateOptions) (AggregateResult, error) {
+	var r AggregateResult
+	if err := ctx.Err(); err != nil {
+		return r, err
+	}
+	path, err := parsePointer(opts.Path)
+	if err != nil {
+		return r, err
+	}
+	var filter *compiledFilter
+	if opts.Filter != nil {
+		filter, err = compileFilter(*opts.Filter)
+		if err != nil {
+			return r, err
+		}
+	}
+
+	data := db.snapshot().Data(db.noms).NomsMap()
+	for it := data.IteratorFrom(types.String(opts.Prefix)); it.Valid(); it.Next() {
+		k, v := it.Entry()
+		if !strings.HasPrefix(string(k.(types.String)), opts.Prefix) {
+			break
+		}
+		if filter != nil {
+			ok, err := filter.matches(v)
+			if err != nil {
+				return AggregateResult{}, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		r.Count++
+		if opts.Path == "" {
+			continue
+		}
+		doc, err := decodeValue(v)
+		if err != nil {
+			return AggregateResult{}, err
+		}
+		f, ok := resolvePointer(doc, path)
+		if !ok {
+			continue
+		}
+		n, ok := f.(float64)
+		if !ok {
+			continue
+		}
+		r.NumericCount++
+		r.Sum += n
+		if r.Min == nil || n < *r.Min {
+			min := n
+			r.Min = &min
+		}
+		if r.Max == nil || n > *r.Max {
+			max := n
+			r.Max = &max
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	d, err := Load(sp)
+	assert.NoError(err)
+
+	for k, v := range map[string]string{
+		"o/1": `{"total":10,"paid":true}`,
+		"o/2": `{"total":2.5,"paid":false}`,
+		"o/3": `{"total":"n/a","paid":true}`,
+		"o/4": `{"paid":true}`,
+		"p/1": `{"total":1000}`,
+	} {
+		assert.NoError(d.Put(k, []byte(v)))
+	}
+
+	f := func(v float64) *float64 {
+		return &v
+	}
+
+	tc := []struct {
+		opts          AggregateOptions
+		expected      AggregateResult
+		expectedError string
+	}{
+		{AggregateOptions{}, AggregateResult{Count: 5}, ""},
+		{AggregateOptions{Prefix: "o/"}, AggregateResult{Count: 4}, ""},
+		{AggregateOptions{Prefix: "o/", Path: "/total"}, AggregateResult{Count: 4, NumericCount: 2, Min: f(2.5), Max: f(10), Sum: 12.5}, ""},
+		{AggregateOptions{Path: "/total"}, AggregateResult{Count: 5, NumericCount: 3, Min: f(2.5), Max: f(1000), Sum: 1012.5}, ""},
+		{AggregateOptions{Prefix: "o/", Path: "/total", Filter: &ScanFilter{Path: "/paid", Op: "eq", Value: json.RawMessage(`true`)}},
+			AggregateResult{Count: 3, NumericCount: 1, Min: f(10), Max: f(10), Sum: 10}, ""},
+		{AggregateOptions{Prefix: "q/", Path: "/total"}, AggregateResult{}, ""},
+		{AggregateOptions{Path: "total"}, AggregateResult{}, "Invalid path: 'total' - must be empty or start with '/'"},
+	}
+
+	for i, t := range tc {
+		res, err := d.Aggregate(context.Background(), t.opts)
+		if t.expectedError != "" {
+			assert.EqualError(err, t.expectedError, "case %d", i)
+			continue
+		}
+		assert.NoError(err, "case %d", i)
+		assert.Equal(t.expected, res, "case %d", i)
+	}
+}
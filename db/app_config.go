@@ -0,0 +1,63 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/attic-labs/noms/go/types"
+
+	nomsjson "roci.dev/diff-server/util/noms/json"
+)
+
+// APP_CONFIG_DATASET holds configuration stored by the host application, such as the remote
+// URL or sync interval, as a map from key to JSON value. It is separate from user data and is
+// not synced. It is kept by Reset.
+const APP_CONFIG_DATASET = "appconfig"
+
+func (db *DB) appConfig() types.Map {
+	ds := db.noms.GetDataset(APP_CONFIG_DATASET)
+	if !ds.HasHead() {
+		return types.NewMap(db.noms)
+	}
+	return ds.HeadValue().(types.Map)
+}
+
+// SetConfig sets the configuration value for key to JSON. If JSON is empty the key is removed.
+func (db *DB) SetConfig(key string, JSON []byte) error {
+	if key == "" {
+		return errors.New("config key must be non-empty")
+	}
+	defer db.lock()()
+	ed := db.appConfig().Edit()
+	if len(JSON) == 0 {
+		ed.Remove(types.String(key))
+	} else {
+		canonicalJSON, err := nomsjson.Canonicalize(JSON)
+		if err != nil {
+			return fmt.Errorf("could not set config '%s'='%s': %w", key, JSON, err)
+		}
+		value, err := nomsjson.FromJSON(bytes.NewReader(canonicalJSON), db.noms)
+		if err != nil {
+			return fmt.Errorf("could not set config '%s'='%s': %w", key, JSON, err)
+		}
+		ed.Set(types.String(key), value)
+	}
+	_, err := db.noms.CommitValue(db.noms.GetDataset(APP_CONFIG_DATASET), ed.Map())
+	return err
+}
+
+// GetConfig returns the configuration value for key as JSON, or nil if it isn't set.
+func (db *DB) GetConfig(key string) ([]byte, error) {
+	defer db.rlock()()
+	value, ok := db.appConfig().MaybeGet(types.String(key))
+	if !ok {
+		return nil, nil
+	}
+	var b bytes.Buffer
+	err := nomsjson.ToJSON(value, &b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode config '%s': %s", ErrValueCorrupt, key, err)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppConfig(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	v, err := db.GetConfig("remote")
+	assert.NoError(err)
+	assert.Nil(v)
+
+	assert.EqualError(db.SetConfig("", []byte(`1`)), "config key must be non-empty")
+	assert.Error(db.SetConfig("remote", []byte(`{`)))
+
+	h := db.Hash()
+	assert.NoError(db.SetConfig("remote", []byte(`{"url": "https://example.com"}`)))
+	assert.NoError(db.SetConfig("interval", []byte(`60`)))
+	// Configuration is not part of the data.
+	assert.Equal(h, db.Hash())
+
+	v, err = db.GetConfig("remote")
+	assert.NoError(err)
+	assert.Equal(`{"url":"https://example.com"}`, string(v))
+
+	// Configuration is durable and survives reset.
+	assert.NoError(db.Reset())
+	db, err = New(db.noms)
+	assert.NoError(err)
+	v, err = db.GetConfig("interval")
+	assert.NoError(err)
+	assert.Equal(`60`, string(v))
+
+	assert.NoError(db.SetConfig("interval", nil))
+	v, err = db.GetConfig("interval")
+	assert.NoError(err)
+	assert.Nil(v)
+}
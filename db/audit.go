@@ -0,0 +1,126 @@
+package db
+
+import (
+	"log"
+
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+	"github.com/attic-labs/noms/go/util/datetime"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// AUDIT_DATASET holds the audit log, a list of AuditEntry, when auditing is enabled. Like
+// KEYMETA_DATASET it is kept outside of commits so that it does not affect sync.
+const AUDIT_DATASET = "audit"
+
+// AuditOptions configures the audit log.
+type AuditOptions struct {
+	// Enabled causes every local mutation to be appended to the audit log.
+	Enabled bool `json:"enabled"`
+	// Principal identifies the authenticated user on whose behalf mutations are made. It is
+	// provided by the host application and recorded verbatim.
+	Principal string `json:"principal,omitempty"`
+}
+
+// AuditEntry records a single local mutation.
+type AuditEntry struct {
+	ClientID string            `json:"clientID"`
+	Date     datetime.DateTime `json:"date"`
+	// Commit is the hash of the local commit produced by the mutation.
+	Commit   string `json:"commit"`
+	Function string `json:"function"`
+	// Keys are the keys whose values were changed by the mutation, in key order.
+	Keys      []string `json:"keys"`
+	Principal string   `json:"principal,omitempty" noms:",omitempty"`
+}
+
+type ExportAuditOptions struct {
+	// Since limits results to entries recorded at or after this time.
+	Since *datetime.DateTime `json:"since,omitempty"`
+	// Limit is the maximum number of entries to return. Zero means no limit.
+	Limit int `json:"limit,omitempty"`
+}
+
+// SetAudit configures the audit log. Entries already recorded are retained when auditing is
+// disabled.
+func (db *DB) SetAudit(opts AuditOptions) {
+	defer db.lock()()
+	db.audit = opts
+}
+
+// recordAudit appends an entry for the mutation function that moved the local head from
+// from to to, if auditing is enabled. Callers must hold the lock.
+func (db *DB) recordAudit(function string, from, to Commit) {
+	if !db.audit.Enabled {
+		return
+	}
+	// As with key metadata, the head has already been committed so failing to record the
+	// entry should not fail the write.
+	err := db.appendAudit(function, from, to)
+	if err != nil {
+		log.Printf("Could not record audit entry for %s: %s", to.Original.Hash(), err)
+	}
+}
+
+func (db *DB) appendAudit(function string, from, to Commit) error {
+	entry := AuditEntry{
+		ClientID:  db.clientID,
+		Date:      time.DateTime(),
+		Commit:    to.Original.Hash().String(),
+		Function:  function,
+		Keys:      changedKeys(from.Data(db.noms).NomsMap(), to.Data(db.noms).NomsMap()),
+		Principal: db.audit.Principal,
+	}
+	ds := db.noms.GetDataset(AUDIT_DATASET)
+	l := types.NewList(db.noms)
+	if ds.HasHead() {
+		l = ds.HeadValue().(types.List)
+	}
+	l = l.Edit().Append(marshal.MustMarshal(db.noms, entry)).List()
+	_, err := db.noms.CommitValue(ds, l)
+	return err
+}
+
+// changedKeys returns the keys that differ between from and to.
+func changedKeys(from, to types.Map) []string {
+	changes := make(chan types.ValueChanged)
+	go func() {
+		to.Diff(from, changes, nil)
+		close(changes)
+	}()
+	keys := []string{}
+	for c := range changes {
+		keys = append(keys, string(c.Key.(types.String)))
+	}
+	return keys
+}
+
+// ExportAudit returns entries from the audit log, oldest first.
+func (db *DB) ExportAudit(opts ExportAuditOptions) ([]AuditEntry, error) {
+	defer db.rlock()()
+	res := []AuditEntry{}
+	ds := db.noms.GetDataset(AUDIT_DATASET)
+	if !ds.HasHead() {
+		return res, nil
+	}
+	for it := ds.HeadValue().(types.List).Iterator(); opts.Limit == 0 || len(res) < opts.Limit; {
+		v := it.Next()
+		if v == nil {
+			break
+		}
+		var e AuditEntry
+		err := marshal.Unmarshal(v, &e)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Since != nil && e.Date.Before(opts.Since.Time) {
+			continue
+		}
+		if e.Keys == nil {
+			e.Keys = []string{}
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/time"
+)
+
+func TestAudit(t *testing.T) {
+	assert := assert.New(t)
+	defer time.SetFake()()
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	// Nothing is recorded until auditing is enabled.
+	assert.NoError(db.Put("a", []byte(`1`)))
+	entries, err := db.ExportAudit(ExportAuditOptions{})
+	assert.NoError(err)
+	assert.Equal([]AuditEntry{}, entries)
+
+	db.SetAudit(AuditOptions{Enabled: true, Principal: "alice"})
+	assert.NoError(db.Put("b", []byte(`2`)))
+	putHash := db.Hash().String()
+	_, err = db.Clear("")
+	assert.NoError(err)
+	clearHash := db.Hash().String()
+
+	db.SetAudit(AuditOptions{})
+	assert.NoError(db.Put("c", []byte(`3`)))
+
+	entries, err = db.ExportAudit(ExportAuditOptions{})
+	assert.NoError(err)
+	assert.Equal(2, len(entries))
+	assert.Equal(db.clientID, entries[0].ClientID)
+	assert.Equal(putHash, entries[0].Commit)
+	assert.Equal(".putValue", entries[0].Function)
+	assert.Equal([]string{"b"}, entries[0].Keys)
+	assert.Equal("alice", entries[0].Principal)
+	assert.True(time.Now().Equal(entries[0].Date.Time))
+	assert.Equal(clearHash, entries[1].Commit)
+	assert.Equal(".clearPrefix", entries[1].Function)
+	assert.Equal([]string{"a", "b"}, entries[1].Keys)
+
+	entries, err = db.ExportAudit(ExportAuditOptions{Limit: 1})
+	assert.NoError(err)
+	assert.Equal(1, len(entries))
+	assert.Equal(putHash, entries[0].Commit)
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	gtime "time"
+)
+
+// ErrQuotaExceeded is returned by Pull when the bandwidth quota has been used up. It is a
+// soft limit: the pull that crosses the quota completes, and later pulls fail until enough
+// of the window has passed.
+var ErrQuotaExceeded = errors.New("bandwidth quota exceeded")
+
+// BandwidthStats is the cumulative number of bytes exchanged with a remote.
+type BandwidthStats struct {
+	Remote        string `json:"remote"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	// LastBytesReceived is the number of bytes received by the most recent pull, which is a
+	// reasonable estimate of the size of the next one.
+	LastBytesReceived uint64 `json:"lastBytesReceived"`
+}
+
+// BandwidthQuota limits the bytes sent and received across all remotes within a trailing
+// window, so that apps can respect users' metered connection settings.
+type BandwidthQuota struct {
+	// Bytes is the number of bytes allowed per window. Zero means no quota.
+	Bytes    uint64 `json:"bytes"`
+	WindowMs uint64 `json:"windowMs"`
+}
+
+// Bandwidth accounts for the bytes exchanged with remotes. It has its own lock because pulls
+// do most of their work without holding the database lock, and is separate from DB so that
+// the accounting can outlive a DB that is closed and reopened.
+type Bandwidth struct {
+	mu      sync.Mutex
+	quota   BandwidthQuota
+	remotes map[string]*BandwidthStats
+	// usage is the transfers within the quota window, oldest first.
+	usage []bandwidthUsage
+}
+
+type bandwidthUsage struct {
+	date  gtime.Time
+	bytes uint64
+}
+
+func NewBandwidth() *Bandwidth {
+	return &Bandwidth{remotes: map[string]*BandwidthStats{}}
+}
+
+// Stats returns the bytes exchanged with each remote, ordered by remote.
+func (b *Bandwidth) Stats() []BandwidthStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := make([]BandwidthStats, 0, len(b.remotes))
+	for _, s := range b.remotes {
+		r = append(r, *s)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Remote < r[j].Remote
+	})
+	return r
+}
+
+// SetQuota replaces the quota. Usage is only tracked while there is a quota, so transfers made
+// before the first quota is set do not count against it.
+func (b *Bandwidth) SetQuota(q BandwidthQuota) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.quota = q
+}
+
+func (b *Bandwidth) window() gtime.Duration {
+	return gtime.Duration(b.quota.WindowMs) * gtime.Millisecond
+}
+
+// prune drops usage that has fallen out of the quota window. Callers must hold b.mu.
+func (b *Bandwidth) prune(now gtime.Time) {
+	i := 0
+	for i < len(b.usage) && now.Sub(b.usage[i].date) >= b.window() {
+		i++
+	}
+	b.usage = b.usage[i:]
+}
+
+// check returns an error matching ErrQuotaExceeded if the quota has been used up.
+func (b *Bandwidth) check(now gtime.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.quota.Bytes == 0 {
+		return nil
+	}
+	b.prune(now)
+	var used uint64
+	for _, u := range b.usage {
+		used += u.bytes
+	}
+	if used >= b.quota.Bytes {
+		return fmt.Errorf("%w: %d bytes used in the last %s, quota is %d", ErrQuotaExceeded, used, b.window(), b.quota.Bytes)
+	}
+	return nil
+}
+
+func (b *Bandwidth) record(remote string, sent, received uint64, now gtime.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.remotes[remote]
+	if s == nil {
+		s = &BandwidthStats{Remote: remote}
+		b.remotes[remote] = s
+	}
+	s.BytesSent += sent
+	s.BytesReceived += received
+	s.LastBytesReceived = received
+	b.usage = append(b.usage, bandwidthUsage{now, sent + received})
+	b.prune(now)
+}
+
+// Bandwidth returns the bandwidth accounting for the database.
+func (db *DB) Bandwidth() *Bandwidth {
+	defer db.rlock()()
+	return db.bandwidth
+}
+
+// SetBandwidth replaces the bandwidth accounting for the database, e.g. with that of a
+// previous DB for the same database.
+func (db *DB) SetBandwidth(b *Bandwidth) {
+	defer db.lock()()
+	db.bandwidth = b
+}
+
+// byteCounter counts the bytes read through it.
+type byteCounter struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
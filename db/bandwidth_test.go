@@ -0,0 +1,74 @@
+package db
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/time"
+)
+
+func TestBandwidth(t *testing.T) {
+	assert := assert.New(t)
+	defer time.SetFake()()
+	db, _ := LoadTempDB(assert)
+
+	body := "nope"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(body))
+	}))
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	assert.Equal([]BandwidthStats{}, db.Bandwidth().Stats())
+	_, err = db.Pull(sp, "", nil)
+	assert.Error(err)
+	_, err = db.Pull(sp, "", nil)
+	assert.Error(err)
+	stats := db.Bandwidth().Stats()
+	assert.Equal(1, len(stats))
+	assert.Equal(sp.String(), stats[0].Remote)
+	assert.True(stats[0].BytesSent > 0)
+	assert.Equal(uint64(2*len(body)), stats[0].BytesReceived)
+
+	// Usage is only tracked once there is a quota.
+	perPull := stats[0].BytesSent/2 + uint64(len(body))
+	db.Bandwidth().SetQuota(BandwidthQuota{Bytes: perPull + 1, WindowMs: 60000})
+	_, err = db.Pull(sp, "", nil)
+	assert.False(errors.Is(err, ErrQuotaExceeded))
+	_, err = db.Pull(sp, "", nil)
+	assert.False(errors.Is(err, ErrQuotaExceeded))
+	_, err = db.Pull(sp, "", nil)
+	assert.True(errors.Is(err, ErrQuotaExceeded))
+	assert.Equal(uint64(4*len(body)), db.Bandwidth().Stats()[0].BytesReceived)
+
+	db.Bandwidth().SetQuota(BandwidthQuota{})
+	_, err = db.Pull(sp, "", nil)
+	assert.False(errors.Is(err, ErrQuotaExceeded))
+}
+
+func TestBandwidthWindow(t *testing.T) {
+	assert := assert.New(t)
+	b := NewBandwidth()
+	b.SetQuota(BandwidthQuota{Bytes: 100, WindowMs: 60000})
+	now := gtime.Date(2020, 1, 1, 0, 0, 0, 0, gtime.UTC)
+
+	b.record("a", 10, 50, now)
+	assert.NoError(b.check(now))
+	b.record("b", 10, 30, now.Add(30*gtime.Second))
+	assert.True(errors.Is(b.check(now.Add(30*gtime.Second)), ErrQuotaExceeded))
+	assert.EqualError(b.check(now.Add(59*gtime.Second)), "bandwidth quota exceeded: 100 bytes used in the last 1m0s, quota is 100")
+	// The first transfer falls out of the window.
+	assert.NoError(b.check(now.Add(60 * gtime.Second)))
+
+	assert.Equal([]BandwidthStats{
+		{Remote: "a", BytesSent: 10, BytesReceived: 50, LastBytesReceived: 50},
+		{Remote: "b", BytesSent: 10, BytesReceived: 30, LastBytesReceived: 30},
+	}, b.Stats())
+}
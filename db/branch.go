@@ -0,0 +1,202 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+
+	nomsjson "roci.dev/diff-server/util/noms/json"
+	"roci.dev/diff-server/util/time"
+)
+
+// Branches are stored as datasets whose head is the branch's latest commit.
+const branchDatasetPrefix = "branch/"
+
+// Branch is a named line of local commits forked from the local head. Writes to a branch are
+// not visible in the local head until the branch is merged, which makes branches useful for
+// speculative edits such as drafts that may later be abandoned.
+type Branch struct {
+	db   *DB
+	name string
+	id   string
+}
+
+func branchDatasetID(name string) (string, error) {
+	// Branch names follow the same rules as checkpoint names.
+	if !checkpointNameRe.MatchString(name) {
+		return "", fmt.Errorf("Invalid branch name: '%s' - must match %s", name, checkpointNameRe)
+	}
+	return branchDatasetPrefix + name, nil
+}
+
+// CreateBranch creates a new branch from the current local head.
+func (db *DB) CreateBranch(name string) (Branch, error) {
+	id, err := branchDatasetID(name)
+	if err != nil {
+		return Branch{}, err
+	}
+	defer db.lock()()
+	ds := db.noms.GetDataset(id)
+	if ds.HasHead() {
+		return Branch{}, fmt.Errorf("Branch already exists: %s", name)
+	}
+	_, err = db.noms.SetHead(ds, db.head.Ref())
+	if err != nil {
+		return Branch{}, err
+	}
+	return Branch{db: db, name: name, id: id}, nil
+}
+
+// Branch returns an existing branch.
+func (db *DB) Branch(name string) (Branch, error) {
+	id, err := branchDatasetID(name)
+	if err != nil {
+		return Branch{}, err
+	}
+	defer db.lock()()
+	if !db.noms.GetDataset(id).HasHead() {
+		return Branch{}, notFound("No such branch: %s", name)
+	}
+	return Branch{db: db, name: name, id: id}, nil
+}
+
+func (b Branch) Name() string {
+	return b.name
+}
+
+// head returns the branch's latest commit. Callers must hold the lock.
+func (b Branch) head() (Commit, error) {
+	ds := b.db.noms.GetDataset(b.id)
+	if !ds.HasHead() {
+		return Commit{}, notFound("No such branch: %s", b.name)
+	}
+	var c Commit
+	err := marshal.Unmarshal(ds.Head(), &c)
+	return c, err
+}
+
+func (b Branch) Head() (Commit, error) {
+	defer b.db.lock()()
+	return b.head()
+}
+
+func (b Branch) data() (types.Map, error) {
+	defer b.db.lock()()
+	head, err := b.head()
+	if err != nil {
+		return types.Map{}, err
+	}
+	return head.Data(b.db.noms).NomsMap(), nil
+}
+
+func (b Branch) Has(id string) (bool, error) {
+	m, err := b.data()
+	if err != nil {
+		return false, err
+	}
+	return m.Has(types.String(id)), nil
+}
+
+func (b Branch) Get(id string) ([]byte, error) {
+	m, err := b.data()
+	if err != nil {
+		return nil, err
+	}
+	value := m.Get(types.String(id))
+	if value == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	err = nomsjson.ToJSON(value, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode '%s': %s", ErrValueCorrupt, id, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (b Branch) Scan(opts ScanOptions) ([]ScanItem, error) {
+	m, err := b.data()
+	if err != nil {
+		return nil, err
+	}
+	return scan(b.db.noms, m, opts)
+}
+
+func (b Branch) Put(path string, JSON []byte) error {
+	if isLocalOnlyKey(path) {
+		return fmt.Errorf("could not Put '%s': local-only keys cannot be written to branches", path)
+	}
+	value, err := b.db.putValue(path, JSON)
+	if err != nil {
+		return err
+	}
+	_, err = b.exec(".putValue", types.NewList(b.db.noms, types.String(path), value))
+	return err
+}
+
+func (b Branch) Del(path string) (bool, error) {
+	v, err := b.exec(".delValue", types.NewList(b.db.noms, types.String(path)))
+	if err != nil {
+		return false, err
+	}
+	return bool(v.(types.Bool)), nil
+}
+
+func (b Branch) exec(function string, args types.List) (types.Value, error) {
+	defer b.db.lock()()
+	head, err := b.head()
+	if err != nil {
+		return nil, err
+	}
+	newData, newDataChecksum, output, isWrite, err := b.db.execImpl(b.db.noms, head.Ref(), function, args)
+	if err != nil {
+		return nil, err
+	}
+	if !isWrite {
+		return output, nil
+	}
+	commit := makeTx(b.db.noms, head.Ref(), time.DateTime(), function, args, nil, newData, newDataChecksum)
+	_, err = b.db.noms.FastForward(b.db.noms.GetDataset(b.id), b.db.noms.WriteValue(commit.Original))
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Merge applies the branch's commits to the local head and deletes the branch. If the local
+// head has not moved since the branch was created this is a fast-forward, otherwise the
+// branch's commits are rebased onto the local head.
+func (b Branch) Merge() error {
+	defer b.db.lock()()
+	head, err := b.head()
+	if err != nil {
+		return err
+	}
+	newHead, err := rebase(b.db, b.db.head.Ref(), time.DateTime(), head, types.Ref{})
+	if err != nil {
+		return err
+	}
+	_, err = b.db.noms.FastForward(b.db.noms.GetDataset(LOCAL_DATASET), b.db.noms.WriteValue(newHead.Original))
+	if err != nil {
+		return err
+	}
+	old := b.db.head
+	b.db.head = newHead
+	b.db.headChanged()
+	b.db.recordAudit(".merge", old, newHead)
+	_, err = b.db.noms.Delete(b.db.noms.GetDataset(b.id))
+	return err
+}
+
+// Discard deletes the branch without applying its commits.
+func (b Branch) Discard() error {
+	defer b.db.lock()()
+	ds := b.db.noms.GetDataset(b.id)
+	if !ds.HasHead() {
+		return notFound("No such branch: %s", b.name)
+	}
+	_, err := b.db.noms.Delete(ds)
+	return err
+}
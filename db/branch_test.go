@@ -0,0 +1,84 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBranch(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	_, err = db.CreateBranch("a b")
+	assert.EqualError(err, "Invalid branch name: 'a b' - must match ^[a-zA-Z0-9\\-_]+$")
+	_, err = db.Branch("draft")
+	assert.EqualError(err, "No such branch: draft")
+	assert.True(errors.Is(err, ErrNotFound))
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+
+	// Fast-forward merge.
+	b, err := db.CreateBranch("draft")
+	assert.NoError(err)
+	_, err = db.CreateBranch("draft")
+	assert.EqualError(err, "Branch already exists: draft")
+
+	assert.NoError(b.Put("foo", []byte(`"baz"`)))
+	assert.NoError(b.Put("hot", []byte(`"dog"`)))
+	ok, err := b.Del("hot")
+	assert.NoError(err)
+	assert.True(ok)
+	v, err := b.Get("foo")
+	assert.NoError(err)
+	assert.Equal(`"baz"`, string(v))
+	v, err = db.Get("foo")
+	assert.NoError(err)
+	assert.Equal(`"bar"`, string(v))
+
+	bh, err := b.Head()
+	assert.NoError(err)
+	assert.NoError(b.Merge())
+	assert.Equal(bh.Original.Hash(), db.Hash())
+	v, err = db.Get("foo")
+	assert.NoError(err)
+	assert.Equal(`"baz"`, string(v))
+	_, err = db.Branch("draft")
+	assert.EqualError(err, "No such branch: draft")
+
+	// Rebase merge.
+	b, err = db.CreateBranch("draft")
+	assert.NoError(err)
+	assert.NoError(b.Put("b", []byte(`1`)))
+	assert.NoError(db.Put("l", []byte(`2`)))
+	b, err = db.Branch("draft")
+	assert.NoError(err)
+	items, err := b.Scan(ScanOptions{})
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+	assert.NoError(b.Merge())
+	assert.Equal(CommitTypeReorder, db.Head().Type())
+	for _, k := range []string{"b", "foo", "l"} {
+		ok, err = db.Has(k)
+		assert.NoError(err)
+		assert.True(ok, k)
+	}
+
+	// Discard.
+	h := db.Hash()
+	b, err = db.CreateBranch("draft")
+	assert.NoError(err)
+	assert.NoError(b.Put("gone", []byte(`true`)))
+	assert.NoError(b.Discard())
+	assert.Equal(h, db.Hash())
+	ok, err = db.Has("gone")
+	assert.NoError(err)
+	assert.False(ok)
+	_, err = b.Get("gone")
+	assert.EqualError(err, "No such branch: draft")
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"github.com/attic-labs/noms/go/hash"
+	"github.com/attic-labs/noms/go/types"
+)
+
+const defaultRebaseCacheSize = 1 << 12
+
+// CacheStats reports the effectiveness of a value cache.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+func (s *CacheStats) add(o CacheStats) {
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+}
+
+// valueCache is a read-through cache of decoded values in front of a ValueReadWriter. It is
+// meant to be scoped to a single operation such as a rebase, which replays transactions one
+// by one and so repeatedly reads the same commits and map chunks. Values written through the
+// cache are cached too, since they typically become the basis for the next transaction.
+//
+// When the cache reaches its size limit it is emptied rather than evicting selectively, which
+// is cheap and adequate for the mostly-sequential access pattern of a rebase.
+type valueCache struct {
+	noms   types.ValueReadWriter
+	size   int
+	values map[hash.Hash]types.Value
+	stats  CacheStats
+}
+
+func newValueCache(noms types.ValueReadWriter, size int) *valueCache {
+	return &valueCache{
+		noms:   noms,
+		size:   size,
+		values: map[hash.Hash]types.Value{},
+	}
+}
+
+func (c *valueCache) put(h hash.Hash, v types.Value) {
+	if c.size <= 0 {
+		return
+	}
+	if len(c.values) >= c.size {
+		c.values = map[hash.Hash]types.Value{}
+	}
+	c.values[h] = v
+}
+
+func (c *valueCache) ReadValue(h hash.Hash) types.Value {
+	if v, ok := c.values[h]; ok {
+		c.stats.Hits++
+		return v
+	}
+	c.stats.Misses++
+	v := c.noms.ReadValue(h)
+	if v != nil {
+		c.put(h, v)
+	}
+	return v
+}
+
+func (c *valueCache) ReadManyValues(hashes hash.HashSlice) types.ValueSlice {
+	r := make(types.ValueSlice, len(hashes))
+	for i, h := range hashes {
+		r[i] = c.ReadValue(h)
+	}
+	return r
+}
+
+func (c *valueCache) WriteValue(v types.Value) types.Ref {
+	r := c.noms.WriteValue(v)
+	c.put(v.Hash(), v)
+	return r
+}
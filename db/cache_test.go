@@ -0,0 +1,69 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/attic-labs/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueCache(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	noms := sp.GetDatabase()
+
+	a := types.String("a")
+	b := types.String("b")
+	noms.WriteValue(b)
+
+	c := newValueCache(noms, 2)
+
+	// Written values are cached.
+	c.WriteValue(a)
+	assert.True(a.Equals(c.ReadValue(a.Hash())))
+	assert.Equal(CacheStats{Hits: 1}, c.stats)
+
+	// Reads go through to the underlying store once.
+	assert.True(b.Equals(c.ReadValue(b.Hash())))
+	assert.True(b.Equals(c.ReadValue(b.Hash())))
+	assert.Equal(CacheStats{Hits: 2, Misses: 1}, c.stats)
+
+	// The cache is emptied when full.
+	c.WriteValue(types.String("c"))
+	assert.Equal(1, len(c.values))
+	assert.True(a.Equals(c.ReadValue(a.Hash())))
+	assert.Equal(CacheStats{Hits: 2, Misses: 2}, c.stats)
+
+	// Missing values are not cached.
+	assert.Nil(c.ReadValue(types.String("d").Hash()))
+
+	// A zero-sized cache passes everything through.
+	c = newValueCache(noms, 0)
+	c.WriteValue(a)
+	assert.True(a.Equals(c.ReadValue(a.Hash())))
+	assert.Equal(CacheStats{Misses: 1}, c.stats)
+	assert.Equal(0, len(c.values))
+}
+
+func TestRebaseCacheStats(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	assert.Equal(CacheStats{}, db.RebaseCacheStats())
+	b, err := db.CreateBranch("b")
+	assert.NoError(err)
+	for _, k := range []string{"a", "b", "c"} {
+		assert.NoError(b.Put(k, []byte(`true`)))
+	}
+	assert.NoError(db.Put("d", []byte(`true`)))
+	assert.NoError(b.Merge())
+
+	s := db.RebaseCacheStats()
+	assert.True(s.Hits > 0)
+	assert.True(s.Misses > 0)
+}
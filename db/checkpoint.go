@@ -0,0 +1,60 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/attic-labs/noms/go/marshal"
+)
+
+// Checkpoints are stored as datasets whose head is the tagged local commit.
+const checkpointDatasetPrefix = "checkpoint/"
+
+var checkpointNameRe = regexp.MustCompile(`^[a-zA-Z0-9\-_]+$`)
+
+func checkpointDatasetID(name string) (string, error) {
+	if !checkpointNameRe.MatchString(name) {
+		return "", fmt.Errorf("Invalid checkpoint name: '%s' - must match %s", name, checkpointNameRe)
+	}
+	return checkpointDatasetPrefix + name, nil
+}
+
+// Checkpoint tags the current head with name, replacing any existing checkpoint with that name.
+func (db *DB) Checkpoint(name string) error {
+	id, err := checkpointDatasetID(name)
+	if err != nil {
+		return err
+	}
+	defer db.lock()()
+	_, err = db.noms.SetHead(db.noms.GetDataset(id), db.head.Ref())
+	return err
+}
+
+// Restore resets the local head to the commit tagged by Checkpoint(name). Commits made since the
+// checkpoint are no longer reachable from the head but are not otherwise removed, and the
+// checkpoint remains so that it can be restored again.
+func (db *DB) Restore(name string) error {
+	id, err := checkpointDatasetID(name)
+	if err != nil {
+		return err
+	}
+	defer db.lock()()
+	ds := db.noms.GetDataset(id)
+	if !ds.HasHead() {
+		return notFound("No such checkpoint: %s", name)
+	}
+	var c Commit
+	err = marshal.Unmarshal(ds.Head(), &c)
+	if err != nil {
+		return err
+	}
+	_, err = db.noms.SetHead(db.noms.GetDataset(LOCAL_DATASET), c.Ref())
+	if err != nil {
+		return err
+	}
+	old := db.head
+	db.head = c
+	db.headChanged()
+	db.recordAudit(".restore", old, c)
+	return nil
+}
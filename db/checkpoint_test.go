@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	assert.EqualError(db.Checkpoint("a b"), "Invalid checkpoint name: 'a b' - must match ^[a-zA-Z0-9\\-_]+$")
+	assert.EqualError(db.Restore("nope"), "No such checkpoint: nope")
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	assert.NoError(db.Checkpoint("cp1"))
+	cp1 := db.Hash()
+
+	assert.NoError(db.Put("foo", []byte(`"baz"`)))
+	assert.NoError(db.Put("hot", []byte(`"dog"`)))
+
+	assert.NoError(db.Restore("cp1"))
+	assert.Equal(cp1, db.Hash())
+	v, err := db.Get("foo")
+	assert.NoError(err)
+	assert.Equal(`"bar"`, string(v))
+	ok, err := db.Has("hot")
+	assert.NoError(err)
+	assert.False(ok)
+
+	// The restored head is durable.
+	db, err = New(db.noms)
+	assert.NoError(err)
+	assert.Equal(cp1, db.Hash())
+
+	// Checkpoints can be restored repeatedly.
+	assert.NoError(db.Put("foo", []byte(`"quux"`)))
+	assert.NoError(db.Restore("cp1"))
+	assert.Equal(cp1, db.Hash())
+
+	// Checkpoints can be replaced.
+	assert.NoError(db.Put("foo", []byte(`"quux"`)))
+	assert.NoError(db.Checkpoint("cp1"))
+	cp2 := db.Hash()
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	assert.NoError(db.Restore("cp1"))
+	assert.Equal(cp2, db.Hash())
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/attic-labs/noms/go/types"
+)
+
+// CollectionSeparator separates a collection's name from the IDs of its members in the
+// underlying keys, e.g., the key of "1" in collection "todos" is "todos/1".
+const CollectionSeparator = "/"
+
+// Collection is a view of the keys in a DB that share the prefix <name>/. IDs passed to and
+// returned from Collection methods are relative to the collection.
+type Collection struct {
+	db   *DB
+	name string
+}
+
+// Collection returns a view of the named collection. Collections need not be created before
+// use, and are empty until something is put into them.
+func (db *DB) Collection(name string) (Collection, error) {
+	if name == "" {
+		return Collection{}, errors.New("collection name must not be empty")
+	}
+	if strings.Contains(name, CollectionSeparator) {
+		return Collection{}, fmt.Errorf("collection name must not contain '%s'", CollectionSeparator)
+	}
+	return Collection{db: db, name: name}, nil
+}
+
+func (c Collection) Name() string {
+	return c.name
+}
+
+func (c Collection) prefix() string {
+	return c.name + CollectionSeparator
+}
+
+func (c Collection) key(id string) string {
+	return c.prefix() + id
+}
+
+func (c Collection) Has(ctx context.Context, id string) (bool, error) {
+	return c.db.HasCtx(ctx, c.key(id))
+}
+
+func (c Collection) Get(ctx context.Context, id string) ([]byte, error) {
+	return c.db.GetCtx(ctx, c.key(id))
+}
+
+func (c Collection) Put(ctx context.Context, id string, JSON []byte) error {
+	return c.db.PutCtx(ctx, c.key(id), JSON)
+}
+
+func (c Collection) Del(ctx context.Context, id string) (bool, error) {
+	return c.db.DelCtx(ctx, c.key(id))
+}
+
+// Scan is like DB.ScanCtx but restricted to the collection. Prefix and Start.ID are relative to
+// the collection. Start.Index is not supported.
+func (c Collection) Scan(ctx context.Context, opts ScanOptions) ([]ScanItem, error) {
+	if opts.Start != nil && opts.Start.Index != nil {
+		return nil, errors.New("start index is not supported when scanning a collection")
+	}
+	opts.Prefix = c.prefix() + opts.Prefix
+	if opts.Start != nil && opts.Start.ID != nil {
+		id := *opts.Start.ID
+		id.Value = c.key(id.Value)
+		opts.Start = &ScanBound{ID: &id}
+	}
+	items, err := c.db.ScanCtx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].ID = strings.TrimPrefix(items[i].ID, c.prefix())
+	}
+	return items, nil
+}
+
+// Count returns the number of members of the collection.
+func (c Collection) Count(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	prefix := c.prefix()
+	var n uint64
+	for it := c.db.snapshot().Data(c.db.noms).NomsMap().IteratorFrom(types.String(prefix)); it.Valid(); it.Next() {
+		if !strings.HasPrefix(string(it.Key().(types.String)), prefix) {
+			break
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Clear removes all members of the collection in a single commit and returns how many were
+// removed.
+func (c Collection) Clear(ctx context.Context) (uint64, error) {
+	return c.db.ClearCtx(ctx, c.prefix())
+}
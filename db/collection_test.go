@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollection(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	_, err = db.Collection("")
+	assert.EqualError(err, "collection name must not be empty")
+	_, err = db.Collection("a/b")
+	assert.EqualError(err, "collection name must not contain '/'")
+
+	todos, err := db.Collection("todos")
+	assert.NoError(err)
+	assert.Equal("todos", todos.Name())
+
+	assert.NoError(todos.Put(ctx, "1", []byte(`"a"`)))
+	assert.NoError(todos.Put(ctx, "2", []byte(`"b"`)))
+	assert.NoError(todos.Put(ctx, "3", []byte(`"c"`)))
+	assert.NoError(db.Put("todosx", []byte(`"not a todo"`)))
+	assert.NoError(db.Put("users/1", []byte(`"u"`)))
+
+	v, err := db.Get("todos/1")
+	assert.NoError(err)
+	assert.Equal(`"a"`, string(v))
+	v, err = todos.Get(ctx, "2")
+	assert.NoError(err)
+	assert.Equal(`"b"`, string(v))
+	ok, err := todos.Has(ctx, "todosx")
+	assert.NoError(err)
+	assert.False(ok)
+
+	n, err := todos.Count(ctx)
+	assert.NoError(err)
+	assert.Equal(uint64(3), n)
+
+	items, err := todos.Scan(ctx, ScanOptions{})
+	assert.NoError(err)
+	ids := []string{}
+	for _, it := range items {
+		ids = append(ids, it.ID)
+	}
+	assert.Equal([]string{"1", "2", "3"}, ids)
+
+	items, err = todos.Scan(ctx, ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "1", Exclusive: true}}, Limit: 1})
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+	assert.Equal("2", items[0].ID)
+
+	idx := uint64(1)
+	_, err = todos.Scan(ctx, ScanOptions{Start: &ScanBound{Index: &idx}})
+	assert.EqualError(err, "start index is not supported when scanning a collection")
+
+	ok, err = todos.Del(ctx, "3")
+	assert.NoError(err)
+	assert.True(ok)
+
+	// Clear is a single commit.
+	basis := db.Hash()
+	n, err = todos.Clear(ctx)
+	assert.NoError(err)
+	assert.Equal(uint64(2), n)
+	basisCommit, err := db.Head().Basis(db.Noms())
+	assert.NoError(err)
+	assert.Equal(basis, basisCommit.Original.Hash())
+
+	n, err = todos.Count(ctx)
+	assert.NoError(err)
+	assert.Equal(uint64(0), n)
+	ok, err = db.Has("todosx")
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = db.Has("users/1")
+	assert.NoError(err)
+	assert.True(ok)
+}
@@ -29,6 +29,7 @@ Struct Commit {
 		},
 		name: String,
 		args: List<Value>,
+		tags?: Value,
 	} |
 	Struct Reorder {
 		date:   Struct DateTime {
@@ -48,6 +49,8 @@ type Tx struct {
 	Date datetime.DateTime
 	Name string
 	Args types.List
+	// Tags is optional app-supplied metadata describing the origin of the transaction.
+	Tags types.Value `noms:",omitempty"`
 }
 
 type Reorder struct {
@@ -223,12 +226,13 @@ func makeGenesis(noms types.ValueReadWriter, serverStateID string, dataRef types
 	return c
 }
 
-func makeTx(noms types.ValueReadWriter, basis types.Ref, d datetime.DateTime, f string, args types.List, newData types.Ref, checksum types.String) Commit {
+func makeTx(noms types.ValueReadWriter, basis types.Ref, d datetime.DateTime, f string, args types.List, tags types.Value, newData types.Ref, checksum types.String) Commit {
 	c := Commit{}
 	c.Parents = []types.Ref{basis}
 	c.Meta.Tx.Date = d
 	c.Meta.Tx.Name = f
 	c.Meta.Tx.Args = args
+	c.Meta.Tx.Tags = tags
 	c.Value.Data = newData
 	c.Value.Checksum = checksum
 	c.Original = marshal.MustMarshal(noms, c).(types.Struct)
@@ -29,7 +29,7 @@ func TestMarshal(t *testing.T) {
 	drRef := noms.WriteValue(dr.NomsMap())
 	args := types.NewList(noms, types.Bool(true), types.String("monkey"))
 	g := makeGenesis(noms, "", emRef, emChecksum, emLTID)
-	tx := makeTx(noms, types.NewRef(g.Original), d, "func", args, drRef, drChecksum)
+	tx := makeTx(noms, types.NewRef(g.Original), d, "func", args, nil, drRef, drChecksum)
 	noms.WriteValue(g.Original)
 	noms.WriteValue(tx.Original)
 
@@ -78,7 +78,7 @@ func TestMarshal(t *testing.T) {
 			}),
 		},
 		{
-			makeTx(noms, types.NewRef(g.Original), d, "func", args, drRef, drChecksum),
+			makeTx(noms, types.NewRef(g.Original), d, "func", args, nil, drRef, drChecksum),
 			types.NewStruct("Commit", types.StructData{
 				"parents": types.NewSet(noms, types.NewRef(g.Original)),
 				"meta": types.NewStruct("Tx", types.StructData{
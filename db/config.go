@@ -9,8 +9,11 @@ import (
 	"github.com/lithammer/shortuuid"
 )
 
+// CONFIG_DATASET holds the ClientConfig.
+const CONFIG_DATASET = "config"
+
 func initClientID(noms datas.Database) (string, error) {
-	ds := noms.GetDataset("config")
+	ds := noms.GetDataset(CONFIG_DATASET)
 	var cc ClientConfig
 	if ds.HasHead() {
 		err := marshal.Unmarshal(ds.HeadValue(), &cc)
@@ -3,11 +3,13 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"sync"
+	gtime "time"
 
 	"github.com/attic-labs/noms/go/d"
 	"github.com/attic-labs/noms/go/datas"
@@ -24,15 +26,86 @@ import (
 const (
 	LOCAL_DATASET  = "local"
 	REMOTE_DATASET = "remote"
+
+	defaultMaxWriteAttempts = 3
+
+	defaultTombstoneRetention = 30 * 24 * gtime.Hour
+	// tombstonePruneInterval is how often ScanDeleted removes expired tombstones from storage.
+	// Expired tombstones are never reported, whether or not they have been removed.
+	tombstonePruneInterval = gtime.Hour
 )
 
+// ErrWriteConflict is returned when a write could not be committed because the database was
+// repeatedly modified concurrently (e.g., by another process) while the write was in progress.
+var ErrWriteConflict = errors.New("write conflict")
+
+// ErrNotFound is returned when a named object such as a branch, checkpoint, or view does not
+// exist. Missing keys are not errors: Get returns nil and Has returns false.
+var ErrNotFound = errors.New("not found")
+
+// ErrValueCorrupt is returned when a stored value cannot be decoded as JSON.
+var ErrValueCorrupt = errors.New("value corrupt")
+
+// ErrInvalidArgument is returned when options are malformed or contradict each other.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// notFoundError is an error with a descriptive message that matches ErrNotFound.
+type notFoundError string
+
+func notFound(format string, args ...interface{}) error {
+	return notFoundError(fmt.Sprintf(format, args...))
+}
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
 type DB struct {
-	noms     datas.Database
-	head     Commit
-	clientID string
-	mu       sync.Mutex
+	noms             datas.Database
+	head             Commit
+	clientID         string
+	onConflict       ConflictHandler
+	maxWriteAttempts int
+	// tombstoneRetention is how long deleted keys are reported by ScanDeleted.
+	tombstoneRetention gtime.Duration
+	// keyMetaMu serializes updates of KEYMETA_DATASET, which happen on reads, and guards
+	// tombstonesPruned.
+	keyMetaMu sync.Mutex
+	// tombstonesPruned is when ScanDeleted last pruned expired tombstones.
+	tombstonesPruned gtime.Time
+	// rebaseCacheSize bounds the number of values cached while rebasing or replaying commits.
+	rebaseCacheSize  int
+	rebaseCacheStats CacheStats
+	audit            AuditOptions
+	wireLog          wireLog
+	bandwidth        *Bandwidth
+	events           *EventLog
+	// pullHints are the scheduling hints from the most recent pull response.
+	pullHints    pullHints
+	pendingLimit pendingLimit
+	// hotPrefixes are the prefixes set with SetHotPrefixes.
+	hotPrefixes []string
+	// Hooks registered by BeforeCommit and OnCommit.
+	beforeCommitHooks []BeforeCommitHook
+	commitHooks       []CommitHook
+	views             map[string]registeredView
+	// headWaiters is closed when the head changes, to wake WaitForChange.
+	headWaiters chan struct{}
+	// mu is held exclusively by writes, which are serialized, and shared by reads, which run
+	// concurrently. Reads of the data use a snapshot of the head, so they only hold mu while
+	// taking it.
+	mu sync.RWMutex
 }
 
+// ConflictHandler is called when a pending local commit cannot be replayed on top of newly
+// pulled server state. Returning nil drops the commit and continues the pull. Returning an
+// error aborts the pull, leaving the local head unchanged.
+type ConflictHandler func(c Commit, err error) error
+
 func Load(sp spec.Spec) (*DB, error) {
 	if !sp.Path.IsEmpty() {
 		return nil, errors.New("Invalid spec - must not specify a path")
@@ -51,7 +124,12 @@ func Load(sp spec.Spec) (*DB, error) {
 
 func New(noms datas.Database) (*DB, error) {
 	r := DB{
-		noms: noms,
+		noms:               noms,
+		maxWriteAttempts:   defaultMaxWriteAttempts,
+		tombstoneRetention: defaultTombstoneRetention,
+		rebaseCacheSize:    defaultRebaseCacheSize,
+		bandwidth:          NewBandwidth(),
+		events:             NewEventLog(),
 	}
 	defer r.lock()()
 	err := r.init()
@@ -88,6 +166,13 @@ func (db *DB) init() error {
 		return nil
 	}
 
+	if f := commitFormatOf(ds.Head()); f >= 0 && f < len(commitFormats)-1 {
+		if err := upgradeCommitFormat(db.noms); err != nil {
+			return err
+		}
+		ds = db.noms.GetDataset(LOCAL_DATASET)
+	}
+
 	headType := types.TypeOf(ds.Head())
 	if !types.IsSubtype(schema, headType) {
 		return fmt.Errorf("Cannot load database. Specified head has non-Replicache data of type: %s", headType.Describe())
@@ -104,12 +189,54 @@ func (db *DB) init() error {
 	return nil
 }
 
+// SetConflictHandler sets the handler consulted when pending commits fail to replay after a
+// pull. If no handler is set, such failures abort the pull.
+func (db *DB) SetConflictHandler(h ConflictHandler) {
+	defer db.lock()()
+	db.onConflict = h
+}
+
+// SetMaxWriteAttempts sets how many times a write is attempted when it conflicts with a
+// concurrent change to the underlying database before giving up with ErrWriteConflict.
+func (db *DB) SetMaxWriteAttempts(n int) {
+	defer db.lock()()
+	if n < 1 {
+		n = 1
+	}
+	db.maxWriteAttempts = n
+}
+
+// SetRebaseCacheSize sets how many values are cached while rebasing or replaying commits.
+// Zero disables the cache.
+func (db *DB) SetRebaseCacheSize(n int) {
+	defer db.lock()()
+	db.rebaseCacheSize = n
+}
+
+// RebaseCacheStats returns the cumulative effectiveness of the cache used while rebasing or
+// replaying commits.
+func (db *DB) RebaseCacheStats() CacheStats {
+	defer db.rlock()()
+	return db.rebaseCacheStats
+}
+
+// SetTombstoneRetention sets how long deleted keys continue to be reported by ScanDeleted.
+func (db *DB) SetTombstoneRetention(d gtime.Duration) {
+	unlock := db.lock()
+	db.tombstoneRetention = d
+	unlock()
+	// The window may have shrunk, so prune on the next ScanDeleted.
+	db.keyMetaMu.Lock()
+	db.tombstonesPruned = gtime.Time{}
+	db.keyMetaMu.Unlock()
+}
+
 func (db *DB) Noms() types.ValueReadWriter {
 	return db.noms
 }
 
 func (db *DB) Head() Commit {
-	return db.head
+	return db.snapshot()
 }
 
 func (db *DB) RemoteHead() (c Commit, err error) {
@@ -125,42 +252,138 @@ func (db *DB) RemoteHead() (c Commit, err error) {
 }
 
 func (db *DB) Hash() hash.Hash {
-	return db.head.Original.Hash()
+	return db.snapshot().Original.Hash()
 }
 
 func (db *DB) Has(id string) (bool, error) {
-	return db.head.Data(db.noms).Has(types.String(id)), nil
+	return db.HasCtx(context.Background(), id)
+}
+
+// HasCtx is like Has but fails early if ctx is done.
+func (db *DB) HasCtx(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if isViewKey(id) {
+		v, err := db.getView(id)
+		return v != nil, err
+	}
+	if isLocalOnlyKey(id) {
+		return db.getLocalOnly(id) != nil, nil
+	}
+	return db.snapshot().Data(db.noms).Has(types.String(id)), nil
 }
 
 func (db *DB) Get(id string) ([]byte, error) {
-	value := db.head.Data(db.noms).Get(types.String(id))
+	return db.GetCtx(context.Background(), id)
+}
+
+// GetCtx is like Get but fails early if ctx is done.
+func (db *DB) GetCtx(ctx context.Context, id string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var value types.Value
+	if isViewKey(id) {
+		var err error
+		value, err = db.getView(id)
+		if err != nil {
+			return nil, err
+		}
+	} else if isLocalOnlyKey(id) {
+		value = db.getLocalOnly(id)
+	} else {
+		value = db.snapshot().Data(db.noms).Get(types.String(id))
+	}
 	if value == nil {
 		return nil, nil
 	}
 	var b bytes.Buffer
 	err := nomsjson.ToJSON(value, &b)
-	return b.Bytes(), err
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode '%s': %s", ErrValueCorrupt, id, err)
+	}
+	return b.Bytes(), nil
 }
 
 func (db *DB) Put(path string, JSON []byte) error {
+	return db.PutCtx(context.Background(), path, JSON)
+}
+
+// PutCtx is like Put but gives up if ctx is done before the write is committed.
+func (db *DB) PutCtx(ctx context.Context, path string, JSON []byte) error {
+	value, err := db.putValue(path, JSON)
+	if err != nil {
+		return err
+	}
+	if isLocalOnlyKey(path) {
+		return db.putLocalOnly(path, value)
+	}
+
+	defer db.lock()()
+	_, err = db.execInternal(ctx, ".putValue", types.NewList(db.Noms(), types.String(path), value))
+	return err
+}
+
+// putValue converts JSON to the Noms value to be stored at path.
+func (db *DB) putValue(path string, JSON []byte) (types.Value, error) {
+	if isViewKey(path) {
+		return nil, fmt.Errorf("could not Put '%s': keys starting with '%s' are reserved for views", path, ViewPrefix)
+	}
 	canonicalJSON, err := nomsjson.Canonicalize(JSON)
 	if err != nil {
-		return fmt.Errorf("could not Put '%s'='%s': %w", path, JSON, err)
+		return nil, fmt.Errorf("could not Put '%s'='%s': %w", path, JSON, err)
 	}
 	value, err := nomsjson.FromJSON(bytes.NewReader(canonicalJSON), db.Noms())
 	if err != nil {
-		return fmt.Errorf("could not Put '%s'='%s': %w", path, JSON, err)
+		return nil, fmt.Errorf("could not Put '%s'='%s': %w", path, JSON, err)
 	}
+	return value, nil
+}
+
+func (db *DB) Del(path string) (ok bool, err error) {
+	return db.DelCtx(context.Background(), path)
+}
 
+// DelCtx is like Del but gives up if ctx is done before the write is committed.
+func (db *DB) DelCtx(ctx context.Context, path string) (ok bool, err error) {
+	if isViewKey(path) {
+		return false, fmt.Errorf("could not Del '%s': keys starting with '%s' are reserved for views", path, ViewPrefix)
+	}
+	if isLocalOnlyKey(path) {
+		return db.delLocalOnly(path)
+	}
 	defer db.lock()()
-	_, err = db.execInternal(".putValue", types.NewList(db.Noms(), types.String(path), value))
-	return err
+	v, err := db.execInternal(ctx, ".delValue", types.NewList(db.Noms(), types.String(path)))
+	if err != nil {
+		return false, err
+	}
+	return bool(v.(types.Bool)), nil
 }
 
-func (db *DB) Del(path string) (ok bool, err error) {
+func (db *DB) Clear(prefix string) (n uint64, err error) {
+	return db.ClearCtx(context.Background(), prefix)
+}
+
+// ClearCtx removes all keys starting with prefix, or all keys if prefix is empty, in a single
+// commit. It returns the number of keys removed. Local-only keys are only removed if prefix is
+// a local-only key.
+func (db *DB) ClearCtx(ctx context.Context, prefix string) (n uint64, err error) {
+	if isLocalOnlyKey(prefix) {
+		return db.clearLocalOnly(prefix)
+	}
+	defer db.lock()()
+	v, err := db.execInternal(ctx, ".clearPrefix", types.NewList(db.Noms(), types.String(prefix)))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(v.(types.Number)), nil
+}
+
+// Close releases the underlying Noms database. The DB must not be used afterward.
+func (db *DB) Close() error {
 	defer db.lock()()
-	v, err := db.execInternal(".delValue", types.NewList(db.Noms(), types.String(path)))
-	return bool(v.(types.Bool)), err
+	return db.noms.Close()
 }
 
 func (db *DB) Reload() error {
@@ -169,9 +392,34 @@ func (db *DB) Reload() error {
 	return db.init()
 }
 
-func (db *DB) execInternal(function string, args types.List) (types.Value, error) {
+func (db *DB) execInternal(ctx context.Context, function string, args types.List) (types.Value, error) {
+	tags, err := db.tagsFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		output, err := db.tryExecInternal(function, args, tags)
+		if err != datas.ErrMergeNeeded && err != datas.ErrOptimisticLockFailed {
+			return output, err
+		}
+		if attempt >= db.maxWriteAttempts {
+			return nil, fmt.Errorf("%w: could not commit %s after %d attempts", ErrWriteConflict, function, attempt)
+		}
+		// Someone else moved the head out from under us. Reload and re-run against the new head.
+		log.Printf("Retrying %s after concurrent change: %s", function, err)
+		db.noms.Rebase()
+		if err := db.init(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (db *DB) tryExecInternal(function string, args types.List, tags types.Value) (types.Value, error) {
 	basis := types.NewRef(db.head.Original)
-	newData, newDataChecksum, output, isWrite, err := db.execImpl(basis, function, args)
+	newData, newDataChecksum, output, isWrite, err := db.execImpl(db.noms, basis, function, args)
 	if err != nil {
 		return nil, err
 	}
@@ -181,7 +429,11 @@ func (db *DB) execInternal(function string, args types.List) (types.Value, error
 		return output, nil
 	}
 
-	commit := makeTx(db.noms, basis, time.DateTime(), function, args, newData, newDataChecksum)
+	commit := makeTx(db.noms, basis, time.DateTime(), function, args, tags, newData, newDataChecksum)
+	err = db.runBeforeCommitHooks(ProposedCommit{Basis: db.head, Commit: commit})
+	if err != nil {
+		return nil, err
+	}
 	commitRef := db.noms.WriteValue(commit.Original)
 
 	// FastForward not strictly needed here because we should have already ensured that we were
@@ -190,26 +442,42 @@ func (db *DB) execInternal(function string, args types.List) (types.Value, error
 	if err != nil {
 		return nil, err
 	}
+	old := db.head
 	db.head = commit
+	db.headChanged()
+	db.recordAudit(function, old, commit)
+	db.checkPendingLimit(old, commit)
 	return output, nil
 }
 
 // TODO: add date and random source to this so that sync can set it up correctly when replaying.
-func (db *DB) execImpl(basis types.Ref, function string, args types.List) (newDataRef types.Ref, newDataChecksum types.String, output types.Value, isWrite bool, err error) {
+func (db *DB) execImpl(noms types.ValueReadWriter, basis types.Ref, function string, args types.List) (newDataRef types.Ref, newDataChecksum types.String, output types.Value, isWrite bool, err error) {
 	var basisCommit Commit
-	err = marshal.Unmarshal(basis.TargetValue(db.noms), &basisCommit)
+	err = marshal.Unmarshal(basis.TargetValue(noms), &basisCommit)
 	if err != nil {
 		return types.Ref{}, types.String(""), nil, false, err
 	}
+	return db.execOn(noms, basisCommit, function, args)
+}
 
+// execOn is execImpl with the basis commit already read. Only the data of basisCommit is used.
+func (db *DB) execOn(noms types.ValueReadWriter, basisCommit Commit, function string, args types.List) (newDataRef types.Ref, newDataChecksum types.String, output types.Value, isWrite bool, err error) {
 	newData := basisCommit.Value.Data
 
 	if strings.HasPrefix(function, ".") {
 		switch function {
+		case squashFunction:
+			isWrite = true
+			newData, newDataChecksum, err = db.execSquash(noms, basisCommit, args)
+			if err != nil {
+				return
+			}
+			break
+
 		case ".putValue":
 			k := args.Get(0).(types.String)
 			v := args.Get(1)
-			ed := basisCommit.Data(db.noms).Edit()
+			ed := basisCommit.Data(noms).Edit()
 			isWrite = true
 			err = ed.Set(k, v)
 			if err != nil {
@@ -218,12 +486,12 @@ func (db *DB) execImpl(basis types.Ref, function string, args types.List) (newDa
 			}
 			newMap := ed.Build()
 			newDataChecksum = newMap.NomsChecksum()
-			newData = db.noms.WriteValue(newMap.NomsMap())
+			newData = noms.WriteValue(newMap.NomsMap())
 			break
 
 		case ".delValue":
 			k := args.Get(0).(types.String)
-			m := basisCommit.Data(db.noms)
+			m := basisCommit.Data(noms)
 			ed := m.Edit()
 			isWrite = true
 			ok := ed.Has(k)
@@ -233,10 +501,34 @@ func (db *DB) execImpl(basis types.Ref, function string, args types.List) (newDa
 				return
 			}
 			newMap := ed.Build()
-			newData = db.noms.WriteValue(newMap.NomsMap())
+			newData = noms.WriteValue(newMap.NomsMap())
 			newDataChecksum = newMap.NomsChecksum()
 			output = types.Bool(ok)
 			break
+
+		case ".clearPrefix":
+			prefix := string(args.Get(0).(types.String))
+			m := basisCommit.Data(noms)
+			ed := m.Edit()
+			isWrite = true
+			n := 0
+			for it := m.NomsMap().IteratorFrom(types.String(prefix)); it.Valid(); it.Next() {
+				k := it.Key().(types.String)
+				if !strings.HasPrefix(string(k), prefix) {
+					break
+				}
+				err = ed.Remove(k)
+				if err != nil {
+					err = fmt.Errorf("could not Del '%s': %w", k, err)
+					return
+				}
+				n++
+			}
+			newMap := ed.Build()
+			newData = noms.WriteValue(newMap.NomsMap())
+			newDataChecksum = newMap.NomsChecksum()
+			output = types.Number(n)
+			break
 		}
 	} else {
 		d.Panic("NON-INTERNAL TRANSACTIONS DISABLED FOR NOW")
@@ -251,3 +543,19 @@ func (db *DB) lock() func() {
 		db.mu.Unlock()
 	}
 }
+
+// rlock is like lock but shares the lock with other readers. It must only be held by
+// functions that don't modify the DB.
+func (db *DB) rlock() func() {
+	db.mu.RLock()
+	return func() {
+		db.mu.RUnlock()
+	}
+}
+
+// snapshot returns the current head. The data it refers to is immutable, so it can be read
+// without holding the lock.
+func (db *DB) snapshot() Commit {
+	defer db.rlock()()
+	return db.head
+}
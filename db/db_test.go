@@ -1,8 +1,12 @@
 package db
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/attic-labs/noms/go/spec"
@@ -96,6 +100,157 @@ func TestDel(t *testing.T) {
 	assert.False(ok)
 }
 
+func TestClear(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	for _, k := range []string{"a", "b/1", "b/2", "c"} {
+		assert.NoError(db.Put(k, []byte(`true`)))
+	}
+
+	n, err := db.Clear("b/")
+	assert.NoError(err)
+	assert.Equal(uint64(2), n)
+	items, err := db.Scan(ScanOptions{})
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+	assert.Equal("a", items[0].ID)
+	assert.Equal("c", items[1].ID)
+
+	n, err = db.Clear("x")
+	assert.NoError(err)
+	assert.Equal(uint64(0), n)
+
+	n, err = db.Clear("")
+	assert.NoError(err)
+	assert.Equal(uint64(2), n)
+	items, err = db.Scan(ScanOptions{})
+	assert.NoError(err)
+	assert.Equal(0, len(items))
+	assert.Equal(CommitTypeTx, db.Head().Type())
+	assert.Equal(".clearPrefix", db.Head().Meta.Tx.Name)
+}
+
+func TestCanceledContext(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	err = db.Put("foo", []byte(`"bar"`))
+	assert.NoError(err)
+	h := db.Hash()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = db.HasCtx(ctx, "foo")
+	assert.Equal(context.Canceled, err)
+	_, err = db.GetCtx(ctx, "foo")
+	assert.Equal(context.Canceled, err)
+	_, err = db.ScanCtx(ctx, ScanOptions{})
+	assert.Equal(context.Canceled, err)
+	err = db.PutCtx(ctx, "foo", []byte(`"baz"`))
+	assert.Equal(context.Canceled, err)
+	_, err = db.DelCtx(ctx, "foo")
+	assert.Equal(context.Canceled, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Fail("unexpected request")
+	}))
+	defer server.Close()
+	remote, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	_, err = db.PullCtx(ctx, remote, "", nil)
+	assert.True(errors.Is(err, context.Canceled), "%v", err)
+
+	assert.Equal(h, db.Hash())
+	v, err := db.Get("foo")
+	assert.NoError(err)
+	assert.Equal(`"bar"`, string(v))
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	noms := sp.GetDatabase()
+
+	// Two writers sharing the same underlying store, as with two connections or processes.
+	db1, err := New(noms)
+	assert.NoError(err)
+	db2, err := New(noms)
+	assert.NoError(err)
+
+	assert.NoError(db1.Put("foo", []byte(`"foo"`)))
+	assert.NoError(db2.Put("bar", []byte(`"bar"`)))
+	ok, err := db2.Del("baz")
+	assert.NoError(err)
+	assert.False(ok)
+	assert.NoError(db1.Put("baz", []byte(`"baz"`)))
+
+	assert.NoError(db1.Reload())
+	assert.NoError(db2.Reload())
+	assert.True(db1.Head().Original.Equals(db2.Head().Original))
+	for _, k := range []string{"foo", "bar", "baz"} {
+		ok, err := db1.Has(k)
+		assert.NoError(err)
+		assert.True(ok, k)
+	}
+
+	db2.SetMaxWriteAttempts(1)
+	assert.NoError(db1.Put("foo", []byte(`"foo2"`)))
+	err = db2.Put("bar", []byte(`"bar2"`))
+	assert.True(errors.Is(err, ErrWriteConflict))
+	assert.EqualError(err, "write conflict: could not commit .putValue after 1 attempts")
+
+	// The failed write must not have changed anything.
+	assert.NoError(db2.Reload())
+	v, err := db2.Get("bar")
+	assert.NoError(err)
+	assert.Equal(`"bar"`, string(v))
+}
+
+func TestPullConcurrentWriter(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	noms := sp.GetDatabase()
+	db1, err := New(noms)
+	assert.NoError(err)
+	db2, err := New(noms)
+	assert.NoError(err)
+
+	m := kv.NewMapForTest(noms, "s", `"s"`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"patch":[{"op":"add","path":"/s","value":"s"}],"stateID":"11111111111111111111111111111111","checksum":"%s"}`, m.Checksum())))
+	}))
+	defer server.Close()
+	remote, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	// db2's head is out of date, so the pull's rebase must pick up db1's commit rather than
+	// overwrite it.
+	assert.NoError(db1.Put("foo", []byte(`"foo"`)))
+	_, err = db2.Pull(remote, "", nil)
+	assert.NoError(err)
+	assert.NoError(db1.Reload())
+	for _, k := range []string{"foo", "s"} {
+		ok, err := db1.Has(k)
+		assert.NoError(err)
+		assert.True(ok, k)
+	}
+
+	db2.SetMaxWriteAttempts(1)
+	assert.NoError(db1.Put("bar", []byte(`"bar"`)))
+	_, err = db2.Pull(remote, "", nil)
+	assert.True(errors.Is(err, ErrWriteConflict))
+	assert.EqualError(err, "write conflict: could not rebase after 1 attempts")
+}
+
 func TestLoadBadSpec(t *testing.T) {
 	assert := assert.New(t)
 
@@ -111,4 +266,47 @@ func TestLoadBadSpec(t *testing.T) {
 	db, err = Load(sp)
 	assert.Nil(db)
 	assert.EqualError(err, "Unexpected response: Not Found: 404 page not found")
-}
\ No newline at end of file
+}
+
+// TestConcurrentReadsAndWrites is most useful with the race detector: go test -race.
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+	c, err := db.Collection("c")
+	assert.NoError(err)
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(db.Put(fmt.Sprintf("k%d", i), []byte(fmt.Sprintf("%d", i))))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			_, err := db.Get(fmt.Sprintf("k%d", i))
+			assert.NoError(err)
+			_, err = db.Scan(ScanOptions{})
+			assert.NoError(err)
+			_, err = db.SyncInfo()
+			assert.NoError(err)
+			_, err = db.Aggregate(context.Background(), AggregateOptions{Prefix: "k"})
+			assert.NoError(err)
+			_, err = c.Count(context.Background())
+			assert.NoError(err)
+			_, _, err = db.GetMeta(fmt.Sprintf("k%d", i))
+			assert.NoError(err)
+			db.Hash()
+			db.Fingerprint()
+		}(i)
+	}
+	wg.Wait()
+
+	items, err := db.Scan(ScanOptions{})
+	assert.NoError(err)
+	assert.Equal(n, len(items))
+	info, err := db.SyncInfo()
+	assert.NoError(err)
+	assert.Equal(n, info.PendingMutations)
+}
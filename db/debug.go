@@ -0,0 +1,96 @@
+package db
+
+import (
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/util/datetime"
+)
+
+// CommitInfo summarizes a commit for diagnostics.
+type CommitInfo struct {
+	Hash string `json:"hash"`
+	Type string `json:"type"`
+	// Name is the name of the transaction, for Tx and Reorder commits. Transaction arguments
+	// are omitted since they contain user data.
+	Name string             `json:"name,omitempty"`
+	Date *datetime.DateTime `json:"date,omitempty"`
+}
+
+// DebugConfig is the configuration of a DB.
+type DebugConfig struct {
+	MaxWriteAttempts   int            `json:"maxWriteAttempts"`
+	RebaseCacheSize    int            `json:"rebaseCacheSize"`
+	TombstoneRetention gtime.Duration `json:"tombstoneRetention"`
+	Audit              AuditOptions   `json:"audit"`
+	WireLogSize        int            `json:"wireLogSize"`
+}
+
+// DebugInfo describes the state of a DB, for attaching to bug reports.
+type DebugInfo struct {
+	Head     CommitInfo `json:"head"`
+	SyncInfo SyncInfo   `json:"syncInfo"`
+	// Pending are the local commits since the last pull, oldest first.
+	Pending          []CommitInfo   `json:"pending"`
+	Config           DebugConfig    `json:"config"`
+	RebaseCacheStats CacheStats     `json:"rebaseCacheStats"`
+	WireLog          []WireLogEntry `json:"wireLog"`
+}
+
+// DebugInfo returns diagnostic information about the database.
+func (db *DB) DebugInfo() (DebugInfo, error) {
+	defer db.rlock()()
+	si, err := db.syncInfo()
+	if err != nil {
+		return DebugInfo{}, err
+	}
+	head, err := db.commitInfo(db.head)
+	if err != nil {
+		return DebugInfo{}, err
+	}
+	_, pending, err := pendingCommits(db.noms, db.head)
+	if err != nil {
+		return DebugInfo{}, err
+	}
+	r := DebugInfo{
+		Head:     head,
+		SyncInfo: si,
+		Pending:  make([]CommitInfo, 0, len(pending)),
+		Config: DebugConfig{
+			MaxWriteAttempts:   db.maxWriteAttempts,
+			RebaseCacheSize:    db.rebaseCacheSize,
+			TombstoneRetention: db.tombstoneRetention,
+			Audit:              db.audit,
+			WireLogSize:        db.wireLog.getSize(),
+		},
+		RebaseCacheStats: db.rebaseCacheStats,
+		WireLog:          db.WireLog(),
+	}
+	for _, c := range pending {
+		ci, err := db.commitInfo(c)
+		if err != nil {
+			return DebugInfo{}, err
+		}
+		r.Pending = append(r.Pending, ci)
+	}
+	return r, nil
+}
+
+func (db *DB) commitInfo(c Commit) (CommitInfo, error) {
+	ci := CommitInfo{
+		Hash: c.Original.Hash().String(),
+		Type: c.Type().String(),
+	}
+	switch c.Type() {
+	case CommitTypeTx:
+		ci.Name = c.Meta.Tx.Name
+		ci.Date = &c.Meta.Tx.Date
+	case CommitTypeReorder:
+		initial, err := c.InitalCommit(db.noms)
+		if err != nil {
+			return CommitInfo{}, err
+		}
+		ci.Name = initial.Meta.Tx.Name
+		ci.Date = &c.Meta.Reorder.Date
+	}
+	return ci, nil
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/time"
+)
+
+func TestDebugInfo(t *testing.T) {
+	assert := assert.New(t)
+	defer time.SetFake()()
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	info, err := db.DebugInfo()
+	assert.NoError(err)
+	assert.Equal(CommitInfo{Hash: db.Hash().String(), Type: "CommitTypeGenesis"}, info.Head)
+	assert.Equal([]CommitInfo{}, info.Pending)
+	assert.Equal(DebugConfig{
+		MaxWriteAttempts:   defaultMaxWriteAttempts,
+		RebaseCacheSize:    defaultRebaseCacheSize,
+		TombstoneRetention: defaultTombstoneRetention,
+	}, info.Config)
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	put := db.Hash().String()
+	_, err = db.Del("foo")
+	assert.NoError(err)
+	db.SetWireLogSize(3)
+
+	info, err = db.DebugInfo()
+	assert.NoError(err)
+	assert.Equal(db.Hash().String(), info.Head.Hash)
+	assert.Equal("CommitTypeTx", info.Head.Type)
+	assert.Equal(".delValue", info.Head.Name)
+	assert.True(time.Now().Equal(info.Head.Date.Time))
+	assert.Equal(2, len(info.Pending))
+	assert.Equal(put, info.Pending[0].Hash)
+	assert.Equal(".putValue", info.Pending[0].Name)
+	assert.Equal(info.Head, info.Pending[1])
+	assert.Equal(2, info.SyncInfo.PendingMutations)
+	assert.Equal(3, info.Config.WireLogSize)
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"strings"
+
+	jsnoms "roci.dev/diff-server/util/noms/json"
+)
+
+// KeyDiff describes how the value of a key differs between two replicas.
+type KeyDiff struct {
+	ID string `json:"id"`
+	// Op is one of "add", "replace", or "remove", describing the change that turns the first
+	// replica into the second.
+	Op  string        `json:"op"`
+	Old *jsnoms.Value `json:"old,omitempty"`
+	New *jsnoms.Value `json:"new,omitempty"`
+}
+
+// Diff returns the keys starting with prefix whose values differ between db and other, in key
+// order. Only the current data is compared, not history. Local-only keys are not compared.
+func (db *DB) Diff(other *DB, prefix string) []KeyDiff {
+	from := db.snapshot().Data(db.noms).NomsMap()
+	to := other.snapshot().Data(other.noms).NomsMap()
+
+	r := []KeyDiff{}
+	for _, c := range diffKeys(from, to) {
+		if !strings.HasPrefix(c.ID, prefix) {
+			continue
+		}
+		d := KeyDiff{ID: c.ID, Op: changeOp(c)}
+		if c.Old != nil {
+			v := jsnoms.Make(nil, c.Old)
+			d.Old = &v
+		}
+		if c.New != nil {
+			v := jsnoms.Make(nil, c.New)
+			d.New = &v
+		}
+		r = append(r, d)
+	}
+	return r
+}
+
+// changeOp returns the JSON Patch operation corresponding to c.
+func changeOp(c KeyChange) string {
+	switch {
+	case c.Old == nil:
+		return "add"
+	case c.New == nil:
+		return "remove"
+	default:
+		return "replace"
+	}
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	assert := assert.New(t)
+	load := func() *DB {
+		sp, err := spec.ForDatabase("mem")
+		assert.NoError(err)
+		db, err := Load(sp)
+		assert.NoError(err)
+		return db
+	}
+
+	a := load()
+	b := load()
+	assert.Equal([]KeyDiff{}, a.Diff(b, ""))
+
+	assert.NoError(a.Put("a/same", []byte(`1`)))
+	assert.NoError(b.Put("a/same", []byte(`1`)))
+	assert.NoError(a.Put("a/changed", []byte(`1`)))
+	assert.NoError(b.Put("a/changed", []byte(`2`)))
+	assert.NoError(a.Put("a/removed", []byte(`1`)))
+	assert.NoError(b.Put("a/added", []byte(`1`)))
+	assert.NoError(b.Put("b/added", []byte(`1`)))
+
+	diff := func(prefix string) string {
+		buf, err := json.Marshal(a.Diff(b, prefix))
+		assert.NoError(err)
+		return string(buf)
+	}
+	assert.Equal(`[{"id":"a/added","op":"add","new":1},{"id":"a/changed","op":"replace","old":1,"new":2},{"id":"a/removed","op":"remove","old":1},{"id":"b/added","op":"add","new":1}]`, diff(""))
+	assert.Equal(`[{"id":"b/added","op":"add","new":1}]`, diff("b/"))
+}
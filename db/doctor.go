@@ -0,0 +1,188 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/attic-labs/noms/go/datas"
+	"github.com/attic-labs/noms/go/hash"
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+)
+
+// Kinds of Problem.
+const (
+	// ProblemLocalNotCommit is a local head that isn't a Replicache commit, e.g. because an
+	// external process committed to the local dataset. The database can't be loaded.
+	ProblemLocalNotCommit = "localNotCommit"
+	// ProblemBrokenHistory is a local head whose history or data is missing. The database
+	// can't be used.
+	ProblemBrokenHistory = "brokenHistory"
+	// ProblemOrphanedRemote is a remote head that isn't in the history of the local head. It
+	// makes the log misreport which commits are merged.
+	ProblemOrphanedRemote = "orphanedRemote"
+)
+
+// Problem is an inconsistency in the datasets of a database found by Diagnose.
+type Problem struct {
+	// Kind is one of the Problem constants.
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	// Repair describes what Repair does about the problem.
+	Repair string `json:"repair"`
+
+	// resetTo is the commit Repair resets the local head to, if any.
+	resetTo types.Ref
+}
+
+// Diagnose checks the datasets of noms for problems. It is for databases that fail to load, or
+// behave oddly, after being modified by an external process or an older build, so noms need not
+// be loadable with New.
+func Diagnose(noms datas.Database) []Problem {
+	r := []Problem{}
+	local, localOK := noms.GetDataset(LOCAL_DATASET).MaybeHead()
+	var head Commit
+	// Commits in older formats are upgraded when the database is loaded, and diagnosed after.
+	current := false
+	if localOK {
+		f := commitFormatOf(local)
+		if f < 0 {
+			p := Problem{
+				Kind:        ProblemLocalNotCommit,
+				Description: fmt.Sprintf("The local head %s is not a Replicache commit: %s", local.Hash(), types.TypeOf(local).Describe()),
+				Repair:      "Reset the database to an empty state. Pending changes are lost, and data will be pulled again.",
+			}
+			if ref, ok := lastCommit(noms, local); ok {
+				p.Repair = fmt.Sprintf("Reset the local head to %s, its most recent Replicache ancestor. Later changes are lost.", ref.TargetHash())
+				p.resetTo = ref
+			}
+			return append(r, p)
+		}
+		if f < len(commitFormats)-1 {
+			return r
+		}
+		current = true
+		err := marshal.Unmarshal(local, &head)
+		if err == nil {
+			err = checkHistory(noms, head)
+		}
+		if err != nil {
+			return append(r, Problem{
+				Kind:        ProblemBrokenHistory,
+				Description: fmt.Sprintf("The history of the local head %s is broken: %s", local.Hash(), err),
+				Repair:      "Reset the database to an empty state. Pending changes are lost, and data will be pulled again.",
+			})
+		}
+	}
+
+	if remote, ok := noms.GetDataset(REMOTE_DATASET).MaybeHead(); ok {
+		if !current || !isAncestor(noms, remote, head) {
+			r = append(r, Problem{
+				Kind:        ProblemOrphanedRemote,
+				Description: fmt.Sprintf("The remote head %s is not in the history of the local head.", remote.Hash()),
+				Repair:      "Delete the remote dataset.",
+			})
+		}
+	}
+	return r
+}
+
+// Repair fixes the problems Diagnose finds in noms and returns them. The database must not be
+// loaded while it is repaired.
+func Repair(noms datas.Database) ([]Problem, error) {
+	problems := Diagnose(noms)
+	for _, p := range problems {
+		var err error
+		switch p.Kind {
+		case ProblemLocalNotCommit, ProblemBrokenHistory:
+			ds := noms.GetDataset(LOCAL_DATASET)
+			if p.resetTo.IsZeroValue() {
+				// New writes a fresh genesis commit to an empty local dataset.
+				_, err = noms.Delete(ds)
+			} else {
+				_, err = noms.SetHead(ds, p.resetTo)
+			}
+		case ProblemOrphanedRemote:
+			_, err = noms.Delete(noms.GetDataset(REMOTE_DATASET))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not repair %s: %w", p.Kind, err)
+		}
+	}
+	return problems, nil
+}
+
+// lastCommit returns the most recent ancestor of v that is a Replicache commit, searching
+// breadth first through the parents of non-Replicache commits.
+func lastCommit(noms types.ValueReader, v types.Struct) (types.Ref, bool) {
+	queue := []types.Struct{v}
+	seen := map[hash.Hash]bool{}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		parents, ok := s.MaybeGet("parents")
+		if !ok {
+			continue
+		}
+		set, ok := parents.(types.Set)
+		if !ok {
+			continue
+		}
+		var found types.Ref
+		set.IterAll(func(pv types.Value) {
+			ref, ok := pv.(types.Ref)
+			if !found.IsZeroValue() || !ok || seen[ref.TargetHash()] {
+				return
+			}
+			seen[ref.TargetHash()] = true
+			p, ok := ref.TargetValue(noms).(types.Struct)
+			if !ok {
+				return
+			}
+			if commitFormatOf(p) >= 0 {
+				found = ref
+				return
+			}
+			queue = append(queue, p)
+		})
+		if !found.IsZeroValue() {
+			return found, true
+		}
+	}
+	return types.Ref{}, false
+}
+
+// checkHistory checks that the data of head and its history back to the genesis commit can be
+// read.
+func checkHistory(noms types.ValueReader, head Commit) error {
+	if noms.ReadValue(head.Value.Data.TargetHash()) == nil {
+		return fmt.Errorf("data %s is missing", head.Value.Data.TargetHash())
+	}
+	for c := head; c.Type() != CommitTypeGenesis; {
+		if c.BasisValue(noms) == nil {
+			return fmt.Errorf("commit %s is missing", c.BasisRef().TargetHash())
+		}
+		var err error
+		c, err = c.Basis(noms)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAncestor returns whether v is head or a commit in its history.
+func isAncestor(noms types.ValueReader, v types.Struct, head Commit) bool {
+	for c := head; ; {
+		if c.Original.Equals(v) {
+			return true
+		}
+		if c.Type() == CommitTypeGenesis || c.BasisValue(noms) == nil {
+			return false
+		}
+		var err error
+		c, err = c.Basis(noms)
+		if err != nil {
+			return false
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoctor(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+	noms := db.noms
+
+	kinds := func(problems []Problem) []string {
+		r := []string{}
+		for _, p := range problems {
+			r = append(r, p.Kind)
+		}
+		return r
+	}
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	assert.Equal([]string{}, kinds(Diagnose(noms)))
+
+	// A remote head in the history of the local head is fine, one elsewhere is not.
+	_, err := noms.SetHead(noms.GetDataset(REMOTE_DATASET), db.head.Ref())
+	assert.NoError(err)
+	assert.Equal([]string{}, kinds(Diagnose(noms)))
+	old := makeGenesis(noms, "old", noms.WriteValue(types.NewMap(noms)), "00000000", 0)
+	_, err = noms.SetHead(noms.GetDataset(REMOTE_DATASET), noms.WriteValue(old.Original))
+	assert.NoError(err)
+	assert.Equal([]string{ProblemOrphanedRemote}, kinds(Diagnose(noms)))
+	problems, err := Repair(noms)
+	assert.NoError(err)
+	assert.Equal([]string{ProblemOrphanedRemote}, kinds(problems))
+	assert.False(noms.GetDataset(REMOTE_DATASET).HasHead())
+
+	// A foreign commit on top of a Replicache commit is reset to it.
+	good := db.head
+	_, err = noms.CommitValue(noms.GetDataset(LOCAL_DATASET), types.String("foreign"))
+	assert.NoError(err)
+	_, err = New(noms)
+	assert.Error(err)
+	problems = Diagnose(noms)
+	assert.Equal([]string{ProblemLocalNotCommit}, kinds(problems))
+	assert.Contains(problems[0].Repair, good.Original.Hash().String())
+	_, err = Repair(noms)
+	assert.NoError(err)
+	db, err = New(noms)
+	assert.NoError(err)
+	assert.True(good.Original.Equals(db.head.Original))
+	v, err := db.Get("foo")
+	assert.NoError(err)
+	assert.Equal(`"bar"`, string(v))
+
+	// Without one, the database is reset.
+	_, err = noms.Delete(noms.GetDataset(LOCAL_DATASET))
+	assert.NoError(err)
+	_, err = noms.CommitValue(noms.GetDataset(LOCAL_DATASET), types.String("foreign"))
+	assert.NoError(err)
+	problems, err = Repair(noms)
+	assert.NoError(err)
+	assert.Equal([]string{ProblemLocalNotCommit}, kinds(problems))
+	db, err = New(noms)
+	assert.NoError(err)
+	assert.Equal([]string{}, kinds(Diagnose(noms)))
+	v, err = db.Get("foo")
+	assert.NoError(err)
+	assert.Nil(v)
+}
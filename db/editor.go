@@ -34,7 +34,7 @@ func (ed editor) Scan(opts ScanOptions) (r []ScanItem, err error) {
 	// Blech, this sucks. We need to build the map because Noms MapEditor doesn't support scans.
 	// Implementing them is more effort than I have avaiable right now.
 	m := ed.data.Map()
-	r, err = scan(m, opts)
+	r, err = scan(ed.noms, m, opts)
 	ed.data = m.Edit()
 	return
 }
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"sync"
+	gtime "time"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// Types of Event.
+const (
+	// EventCommit is recorded whenever the local head changes.
+	EventCommit = "commit"
+	// EventPullStart, EventPullFinish, and EventPullError are recorded as pulls progress.
+	EventPullStart  = "pullStart"
+	EventPullFinish = "pullFinish"
+	EventPullError  = "pullError"
+	// EventRebase is recorded when pending commits are rebased onto new server state.
+	EventRebase = "rebase"
+)
+
+// eventLogSize is the number of recent events kept in an EventLog.
+const eventLogSize = 256
+
+// Event is an entry in the EventLog. Fields that don't apply to the event's type are omitted.
+type Event struct {
+	// Seq numbers events in the order they were recorded, starting at one.
+	Seq  uint64     `json:"seq"`
+	Date gtime.Time `json:"date"`
+	Type string     `json:"type"`
+	// Head is the local head after commit and rebase events.
+	Head string `json:"head,omitempty"`
+	// Name is the name of the transaction that made a commit event, if any.
+	Name string `json:"name,omitempty"`
+	// Remote is the remote of pull events.
+	Remote string `json:"remote,omitempty"`
+	// Error is the error of pullError events.
+	Error string `json:"error,omitempty"`
+	// Pending is the number of pending commits replayed by rebase events.
+	Pending int `json:"pending,omitempty"`
+}
+
+// EventLog is a ring buffer of the most recent events of a database, so that apps can show
+// activity without parsing logs. Like Bandwidth, it has its own lock and can outlive a DB
+// that is closed and reopened.
+type EventLog struct {
+	mu     sync.Mutex
+	seq    uint64
+	events []Event
+}
+
+func NewEventLog() *EventLog {
+	return &EventLog{}
+}
+
+// Since returns the events after seq, oldest first. dropped is true if some of them are no
+// longer in the log.
+func (l *EventLog) Since(seq uint64) (events []Event, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events = []Event{}
+	for _, e := range l.events {
+		if e.Seq > seq {
+			events = append(events, e)
+		}
+	}
+	if seq < l.seq && (len(events) == 0 || events[0].Seq > seq+1) {
+		dropped = true
+	}
+	return events, dropped
+}
+
+func (l *EventLog) add(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e.Seq = l.seq
+	e.Date = time.Now()
+	if len(l.events) == eventLogSize {
+		l.events = l.events[1:]
+	}
+	l.events = append(l.events, e)
+}
+
+// EventLog returns the event log of the database.
+func (db *DB) EventLog() *EventLog {
+	defer db.rlock()()
+	return db.events
+}
+
+// SetEventLog replaces the event log of the database, e.g. with that of a previous DB for
+// the same database.
+func (db *DB) SetEventLog(l *EventLog) {
+	defer db.lock()()
+	db.events = l
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+)
+
+func TestEventLog(t *testing.T) {
+	assert := assert.New(t)
+	l := NewEventLog()
+
+	events, dropped := l.Since(0)
+	assert.Equal([]Event{}, events)
+	assert.False(dropped)
+
+	for i := 0; i < eventLogSize+10; i++ {
+		l.add(Event{Type: EventCommit})
+	}
+	events, dropped = l.Since(0)
+	assert.True(dropped)
+	assert.Equal(eventLogSize, len(events))
+	assert.Equal(uint64(11), events[0].Seq)
+
+	events, dropped = l.Since(10)
+	assert.False(dropped)
+	assert.Equal(eventLogSize, len(events))
+
+	events, dropped = l.Since(eventLogSize + 8)
+	assert.False(dropped)
+	assert.Equal(2, len(events))
+	assert.Equal(uint64(eventLogSize+10), events[1].Seq)
+
+	events, dropped = l.Since(eventLogSize + 10)
+	assert.False(dropped)
+	assert.Equal([]Event{}, events)
+}
+
+func TestEvents(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	m := kv.NewMapForTest(db.noms, "a", `"a"`)
+	body := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	types := func(events []Event) []string {
+		r := []string{}
+		for _, e := range events {
+			r = append(r, e.Type)
+		}
+		return r
+	}
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	events, _ := db.EventLog().Since(0)
+	assert.Equal([]string{EventCommit}, types(events))
+	assert.Equal(".putValue", events[0].Name)
+	assert.Equal(db.Hash().String(), events[0].Head)
+
+	body = fmt.Sprintf(`{"patch":[{"op":"add","path":"/a","value":"a"}],"stateID":"11111111111111111111111111111111","checksum":"%s","lastMutationID":0}`, m.Checksum())
+	_, err = db.Pull(sp, "", nil)
+	assert.NoError(err)
+	events, _ = db.EventLog().Since(events[0].Seq)
+	assert.Equal([]string{EventPullStart, EventRebase, EventCommit, EventPullFinish}, types(events))
+	assert.Equal(sp.String(), events[0].Remote)
+	assert.Equal(1, events[1].Pending)
+	assert.Equal(db.Hash().String(), events[1].Head)
+
+	body = `{`
+	_, err = db.Pull(sp, "", nil)
+	assert.Error(err)
+	events, _ = db.EventLog().Since(events[3].Seq)
+	assert.Equal([]string{EventPullStart, EventPullError}, types(events))
+	assert.Equal(err.Error(), events[1].Error)
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	servetypes "roci.dev/diff-server/serve/types"
+	"roci.dev/diff-server/util/time"
+)
+
+// FileRemotePrefix is the prefix of remotes that sync through a shared folder rather than a
+// diff-server, e.g. "file:///Users/me/Dropbox/todos". See SyncFile.
+const FileRemotePrefix = "file:"
+
+// FileRemoteDir returns the folder of a file remote, and whether remote is one.
+func FileRemoteDir(remote string) (string, bool) {
+	if !strings.HasPrefix(remote, FileRemotePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(remote, FileRemotePrefix), "//"), true
+}
+
+// fileStateName matches the names of the states in a shared folder. Names are the time the
+// state was written, zero-padded so that they sort in time order, and the ID of the client that
+// wrote it, so that clients never write the same file and sync services never see a conflict.
+var fileStateName = regexp.MustCompile(`^[0-9]{20}-[A-Za-z0-9_-]+\.json$`)
+
+// fileState is a state in a shared folder. It is a pull response whose patch builds the state
+// from empty. Since there is no server to track them, it also records the last mutation ID of
+// each client that has synced with the folder.
+type fileState struct {
+	servetypes.PullResponse
+	LastMutationIDs map[string]uint64 `json:"lastMutationIDs"`
+}
+
+// SyncFile syncs with a shared folder, such as one kept in sync across devices by Dropbox,
+// for users without a diff-server. The latest state in the folder is pulled, the pending local
+// commits are replayed on top of it as with Pull, and the result is written to the folder as
+// a new state, which acknowledges the pending commits the next time any client pulls it.
+//
+// Concurrent syncs from different clients are last writer wins: a state written without
+// pulling another client's concurrent state loses that client's changes. The latest state is
+// determined by the writers' clocks.
+func (db *DB) SyncFile(ctx context.Context, dir string) error {
+	latest, err := readLatestFileState(dir)
+	if err != nil {
+		return err
+	}
+	if latest != nil {
+		if err := db.pullFileState(ctx, *latest); err != nil {
+			return err
+		}
+	}
+	return db.pushFileState(ctx, dir, latest)
+}
+
+// readLatestFileState returns the latest state in dir, or nil if there isn't one.
+func readLatestFileState(dir string) (*fileState, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	name := ""
+	for _, fi := range infos {
+		if fileStateName.MatchString(fi.Name()) && fi.Name() > name {
+			name = fi.Name()
+		}
+	}
+	if name == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var s fileState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("%s is not valid JSON: %s", name, err.Error())
+	}
+	return &s, nil
+}
+
+func (db *DB) pullFileState(ctx context.Context, s fileState) error {
+	unlock := db.lock()
+	head := db.head
+	unlock()
+	genesis, err := findGenesis(db.noms, head)
+	if err != nil {
+		return err
+	}
+	if s.StateID == genesis.Meta.Genesis.ServerStateID {
+		return nil
+	}
+
+	patchedMap, err := db.applySnapshot(s.PullResponse)
+	if err != nil {
+		return err
+	}
+
+	defer db.lock()()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	genesis, err = findGenesis(db.noms, db.head)
+	if err != nil {
+		return err
+	}
+	// The state may have been written by a client that hadn't pulled our latest mutations.
+	// Those are lost, so there's nothing left to replay for them.
+	lastMutationID := s.LastMutationIDs[db.clientID]
+	if lastMutationID < genesis.Meta.Genesis.LastMutationID {
+		lastMutationID = genesis.Meta.Genesis.LastMutationID
+	}
+	newGenesis := makeGenesis(db.noms, s.StateID, db.noms.WriteValue(patchedMap.NomsMap()), patchedMap.NomsChecksum(), lastMutationID)
+	_, err = db.rebaseOnto(ctx, newGenesis, RebaseOptions{})
+	return err
+}
+
+// pushFileState writes the local head to dir as a new state, if it has pending commits or dir
+// has no state yet. pulled is the state the head is based on, if any.
+func (db *DB) pushFileState(ctx context.Context, dir string, pulled *fileState) error {
+	unlock := db.lock()
+	head := db.head
+	unlock()
+	genesis, pending, err := pendingCommits(db.noms, head)
+	if err != nil {
+		return err
+	}
+	if pulled != nil && len(pending) == 0 {
+		return nil
+	}
+
+	data := head.Data(db.noms)
+	patch, err := SnapshotPatch(data.NomsMap())
+	if err != nil {
+		return err
+	}
+
+	lastMutationIDs := map[string]uint64{}
+	if pulled != nil {
+		for id, lmid := range pulled.LastMutationIDs {
+			lastMutationIDs[id] = lmid
+		}
+	}
+	lastMutationID := genesis.Meta.Genesis.LastMutationID + uint64(len(pending))
+	lastMutationIDs[db.clientID] = lastMutationID
+	s := fileState{
+		PullResponse: servetypes.PullResponse{
+			Patch:          patch,
+			StateID:        fmt.Sprintf("%020d-%s", time.Now().UnixNano(), db.clientID),
+			LastMutationID: lastMutationID,
+			Checksum:       data.Checksum(),
+		},
+		LastMutationIDs: lastMutationIDs,
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The state is written under a name that doesn't match fileStateName and then renamed, so
+	// that other clients never read a partially written state.
+	name := filepath.Join(dir, s.StateID+".json")
+	tmp := filepath.Join(dir, "."+s.StateID+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
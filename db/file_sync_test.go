@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRemoteDir(t *testing.T) {
+	assert := assert.New(t)
+	tc := []struct {
+		remote string
+		dir    string
+		ok     bool
+	}{
+		{"file:///tmp/shared", "/tmp/shared", true},
+		{"file:/tmp/shared", "/tmp/shared", true},
+		{"file:shared", "shared", true},
+		{"https://serve.replicache.dev", "", false},
+		{"/tmp/shared", "", false},
+	}
+	for _, t := range tc {
+		dir, ok := FileRemoteDir(t.remote)
+		assert.Equal(t.dir, dir, t.remote)
+		assert.Equal(t.ok, ok, t.remote)
+	}
+}
+
+func TestSyncFile(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	a, _ := LoadTempDB(assert)
+	b, _ := LoadTempDB(assert)
+	get := func(db *DB, id string) string {
+		v, err := db.Get(id)
+		assert.NoError(err)
+		return string(v)
+	}
+	pending := func(db *DB) int {
+		_, p, err := pendingCommits(db.noms, db.head)
+		assert.NoError(err)
+		return len(p)
+	}
+	states := func() int {
+		infos, err := ioutil.ReadDir(dir)
+		assert.NoError(err)
+		n := 0
+		for _, fi := range infos {
+			if fileStateName.MatchString(fi.Name()) {
+				n++
+			}
+		}
+		return n
+	}
+	ctx := context.Background()
+
+	// The first sync seeds the folder.
+	assert.NoError(a.Put("foo", []byte(`"a"`)))
+	assert.NoError(a.SyncFile(ctx, dir))
+	assert.Equal(1, states())
+	assert.Equal(1, pending(a))
+
+	// Syncing without pending commits doesn't write a state.
+	assert.NoError(b.SyncFile(ctx, dir))
+	assert.Equal(`"a"`, get(b, "foo"))
+	assert.Equal(0, pending(b))
+	assert.Equal(1, states())
+
+	assert.NoError(b.Put("bar/baz~", []byte(`{"b":true}`)))
+	assert.NoError(b.SyncFile(ctx, dir))
+	assert.Equal(2, states())
+
+	// a's commit is acknowledged by b's state, which carries forward a's last mutation ID.
+	assert.NoError(a.SyncFile(ctx, dir))
+	assert.Equal(`"a"`, get(a, "foo"))
+	assert.Equal(`{"b":true}`, get(a, "bar/baz~"))
+	assert.Equal(0, pending(a))
+	assert.Equal(2, states())
+
+	// Files that aren't states are ignored.
+	assert.NoError(ioutil.WriteFile(dir+"/notes.json", []byte("not a state"), 0644))
+	assert.NoError(b.SyncFile(ctx, dir))
+	assert.Equal(0, pending(b))
+	assert.Equal(2, states())
+
+	// An unreadable folder is an error.
+	assert.Error(a.SyncFile(ctx, dir+"/nonexistent"))
+}
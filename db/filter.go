@@ -0,0 +1,165 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/attic-labs/noms/go/types"
+
+	"roci.dev/diff-server/util/chk"
+	jsnoms "roci.dev/diff-server/util/noms/json"
+)
+
+// pointerUnescaper decodes a JSON Pointer reference token.
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// pointerEscaper encodes a JSON Pointer reference token.
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// ScanFilter restricts scan results to values where the field at Path compares to Value
+// according to Op, e.g., {"path":"/done","op":"eq","value":false}.
+//
+// Path is a JSON Pointer (RFC 6901). Op is one of "eq", "ne", "lt", "lte", "gt", or "gte".
+// The ordering ops only match numbers compared to numbers and strings compared to strings.
+// Values that do not have a field at Path never match.
+type ScanFilter struct {
+	Path  string          `json:"path"`
+	Op    string          `json:"op"`
+	Value json.RawMessage `json:"value"`
+}
+
+// compiledFilter is a ScanFilter that has been validated and parsed for evaluation.
+type compiledFilter struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+func compileFilter(f ScanFilter) (*compiledFilter, error) {
+	switch f.Op {
+	case "eq", "ne", "lt", "lte", "gt", "gte":
+	default:
+		return nil, fmt.Errorf("Invalid filter op: '%s'", f.Op)
+	}
+	path, err := parsePointer(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	cf := &compiledFilter{path: path, op: f.Op}
+	if len(f.Value) == 0 {
+		return nil, fmt.Errorf("Filter value is required")
+	}
+	err = json.Unmarshal(f.Value, &cf.value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter value: %s", err)
+	}
+	return cf, nil
+}
+
+// parsePointer splits a JSON Pointer into its unescaped reference tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("Invalid path: '%s' - must be empty or start with '/'", p)
+	}
+	var r []string
+	for _, tok := range strings.Split(p[1:], "/") {
+		r = append(r, pointerUnescaper.Replace(tok))
+	}
+	return r, nil
+}
+
+// decodeValue converts v to the generic Go representation of its JSON form.
+func decodeValue(v types.Value) (interface{}, error) {
+	var buf bytes.Buffer
+	err := jsnoms.ToJSON(v, &buf)
+	if err == nil {
+		var doc interface{}
+		err = json.Unmarshal(buf.Bytes(), &doc)
+		if err == nil {
+			return doc, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrValueCorrupt, err)
+}
+
+// resolvePointer returns the field of doc at path, if any.
+func resolvePointer(doc interface{}, path []string) (interface{}, bool) {
+	for _, tok := range path {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			doc, ok = d[tok]
+			if !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, false
+			}
+			doc = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func (cf *compiledFilter) matches(v types.Value) (bool, error) {
+	doc, err := decodeValue(v)
+	if err != nil {
+		return false, err
+	}
+	doc, ok := resolvePointer(doc, cf.path)
+	if !ok {
+		return false, nil
+	}
+
+	switch cf.op {
+	case "eq":
+		return reflect.DeepEqual(doc, cf.value), nil
+	case "ne":
+		return !reflect.DeepEqual(doc, cf.value), nil
+	}
+
+	var c int
+	switch a := doc.(type) {
+	case float64:
+		b, ok := cf.value.(float64)
+		if !ok {
+			return false, nil
+		}
+		if a < b {
+			c = -1
+		} else if a > b {
+			c = 1
+		}
+	case string:
+		b, ok := cf.value.(string)
+		if !ok {
+			return false, nil
+		}
+		c = strings.Compare(a, b)
+	default:
+		return false, nil
+	}
+	switch cf.op {
+	case "lt":
+		return c < 0, nil
+	case "lte":
+		return c <= 0, nil
+	case "gt":
+		return c > 0, nil
+	case "gte":
+		return c >= 0, nil
+	}
+	chk.Fail("NOTREACHED")
+	return false, nil
+}
@@ -0,0 +1,13 @@
+package db
+
+import (
+	"github.com/attic-labs/noms/go/hash"
+)
+
+// Fingerprint returns a hash of the keys and values in the database. It depends only on the
+// content, not on the history that produced it, so two replicas with equal content have equal
+// fingerprints. Local-only keys are not included.
+func (db *DB) Fingerprint() hash.Hash {
+	defer db.rlock()()
+	return db.head.Data(db.noms).NomsMap().Hash()
+}
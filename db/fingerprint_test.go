@@ -0,0 +1,38 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	assert := assert.New(t)
+	load := func() *DB {
+		sp, err := spec.ForDatabase("mem")
+		assert.NoError(err)
+		db, err := Load(sp)
+		assert.NoError(err)
+		return db
+	}
+
+	a := load()
+	assert.NoError(a.Put("foo", []byte(`"bar"`)))
+	assert.NoError(a.Put("hot", []byte(`"dog"`)))
+
+	// Same content reached by a different history.
+	b := load()
+	assert.NoError(b.Put("hot", []byte(`"cat"`)))
+	assert.NoError(b.Put("foo", []byte(`"bar"`)))
+	assert.NoError(b.Put("hot", []byte(`"dog"`)))
+	assert.NotEqual(a.Hash(), b.Hash())
+	assert.Equal(a.Fingerprint(), b.Fingerprint())
+
+	// Local-only keys don't count.
+	assert.NoError(b.Put("_local/x", []byte(`1`)))
+	assert.Equal(a.Fingerprint(), b.Fingerprint())
+
+	assert.NoError(b.Put("hot", []byte(`"cat"`)))
+	assert.NotEqual(a.Fingerprint(), b.Fingerprint())
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/attic-labs/noms/go/datas"
+	"github.com/attic-labs/noms/go/hash"
+	"github.com/attic-labs/noms/go/nomdl"
+	"github.com/attic-labs/noms/go/types"
+
+	"roci.dev/diff-server/kv"
+)
+
+// commitFormat is a version of the layout of commits. Because loading checks the head against
+// schema, databases written by older builds couldn't be read after the layout changed, so they
+// are upgraded to the current format when they are loaded.
+//
+// To change the layout, add the new schema as the last format, and give the previous format an
+// upgrade function.
+type commitFormat struct {
+	version int
+	schema  *types.Type
+	// upgrade converts c, a commit in this format whose parents and subject are already in the
+	// next format, to the next format.
+	upgrade func(noms types.ValueReadWriter, c types.Struct) (types.Struct, error)
+}
+
+var commitFormats = []commitFormat{
+	// Version 1 commits predate data checksums.
+	{
+		version: 1,
+		schema: nomdl.MustParseType(`
+Struct Commit {
+	parents: Set<Ref<Cycle<Commit>>>,
+	meta: Struct Genesis {
+		lastMutationID?: Number,
+		serverStateID?: String,
+	} |
+	Struct Tx {
+		date:   Struct DateTime {
+			secSinceEpoch: Number,
+		},
+		name: String,
+		args: List<Value>,
+	} |
+	Struct Reorder {
+		date:   Struct DateTime {
+			secSinceEpoch: Number,
+		},
+		subject: Ref<Cycle<Commit>>,
+	},
+	value: Struct {
+		data: Ref<Map<String, Value>>,
+	},
+}`),
+		upgrade: addChecksum,
+	},
+	{version: 2, schema: schema},
+}
+
+// CommitFormatVersion is the version of the layout of commits written by this build.
+var CommitFormatVersion = commitFormats[len(commitFormats)-1].version
+
+// commitFormatOf returns the index in commitFormats of the newest format v is in, or -1 if it
+// is not a commit.
+func commitFormatOf(v types.Value) int {
+	t := types.TypeOf(v)
+	for i := len(commitFormats) - 1; i >= 0; i-- {
+		if types.IsSubtype(commitFormats[i].schema, t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// upgradeCommitFormat upgrades the heads of the datasets of noms that are commits in older
+// formats, such as the local head and checkpoints, to the current format.
+func upgradeCommitFormat(noms datas.Database) error {
+	var ids []string
+	noms.Datasets().IterAll(func(k, v types.Value) {
+		ids = append(ids, string(k.(types.String)))
+	})
+	memo := map[hash.Hash]types.Ref{}
+	for _, id := range ids {
+		ds := noms.GetDataset(id)
+		head, ok := ds.MaybeHead()
+		if !ok {
+			continue
+		}
+		f := commitFormatOf(head)
+		if f < 0 || f == len(commitFormats)-1 {
+			continue
+		}
+		log.Printf("Upgrading dataset '%s' from commit format %d to %d", id, commitFormats[f].version, CommitFormatVersion)
+		ref, err := upgradeCommit(noms, head, f, memo)
+		if err != nil {
+			return fmt.Errorf("could not upgrade dataset '%s': %w", id, err)
+		}
+		if _, err := noms.SetHead(ds, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeCommit upgrades c, and the commits it refers to, from commitFormats[from] to the
+// current format and returns a ref to the upgraded commit. memo maps the hashes of commits
+// already upgraded to their upgraded refs, so that shared history is upgraded once.
+func upgradeCommit(noms types.ValueReadWriter, c types.Struct, from int, memo map[hash.Hash]types.Ref) (types.Ref, error) {
+	if r, ok := memo[c.Hash()]; ok {
+		return r, nil
+	}
+	orig := c.Hash()
+
+	// Upgrading a commit changes its hash, so the commits it refers to are upgraded first.
+	upgradeRef := func(v types.Value) (types.Ref, error) {
+		ref := v.(types.Ref)
+		target, ok := ref.TargetValue(noms).(types.Struct)
+		if !ok {
+			return types.Ref{}, fmt.Errorf("commit %s is missing", ref.TargetHash())
+		}
+		return upgradeCommit(noms, target, from, memo)
+	}
+	var parents []types.Value
+	var err error
+	c.Get("parents").(types.Set).IterAll(func(v types.Value) {
+		if err != nil {
+			return
+		}
+		var r types.Ref
+		r, err = upgradeRef(v)
+		parents = append(parents, r)
+	})
+	if err != nil {
+		return types.Ref{}, err
+	}
+	c = c.Set("parents", types.NewSet(noms, parents...))
+	meta := c.Get("meta").(types.Struct)
+	if subject, ok := meta.MaybeGet("subject"); ok {
+		r, err := upgradeRef(subject)
+		if err != nil {
+			return types.Ref{}, err
+		}
+		c = c.Set("meta", meta.Set("subject", r))
+	}
+
+	for _, f := range commitFormats[from : len(commitFormats)-1] {
+		c, err = f.upgrade(noms, c)
+		if err != nil {
+			return types.Ref{}, err
+		}
+	}
+	r := noms.WriteValue(c)
+	memo[orig] = r
+	return r, nil
+}
+
+// addChecksum upgrades a version 1 commit by adding the checksum of its data.
+func addChecksum(noms types.ValueReadWriter, c types.Struct) (types.Struct, error) {
+	value := c.Get("value").(types.Struct)
+	ref := value.Get("data").(types.Ref)
+	data, ok := ref.TargetValue(noms).(types.Map)
+	if !ok {
+		return types.Struct{}, fmt.Errorf("data %s is missing", ref.TargetHash())
+	}
+	return c.Set("value", value.Set("checksum", types.String(kv.ComputeChecksum(data).String()))), nil
+}
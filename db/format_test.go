@@ -0,0 +1,112 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/types"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+)
+
+// The corpus of historical commit formats. Each builder writes commits laid out as the builds
+// of its format did.
+
+func v1Genesis(noms types.ValueReadWriter, serverStateID string, lastMutationID uint64, data types.Map) types.Struct {
+	return types.NewStruct("Commit", types.StructData{
+		"parents": types.NewSet(noms),
+		"meta": types.NewStruct("Genesis", types.StructData{
+			"serverStateID":  types.String(serverStateID),
+			"lastMutationID": types.Number(lastMutationID),
+		}),
+		"value": types.NewStruct("", types.StructData{"data": noms.WriteValue(data)}),
+	})
+}
+
+func v1Tx(noms types.ValueReadWriter, basis types.Struct, name string, data types.Map) types.Struct {
+	return types.NewStruct("Commit", types.StructData{
+		"parents": types.NewSet(noms, noms.WriteValue(basis)),
+		"meta": types.NewStruct("Tx", types.StructData{
+			"date": types.NewStruct("DateTime", types.StructData{"secSinceEpoch": types.Number(1)}),
+			"name": types.String(name),
+			"args": types.NewList(noms),
+		}),
+		"value": types.NewStruct("", types.StructData{"data": noms.WriteValue(data)}),
+	})
+}
+
+func v1Reorder(noms types.ValueReadWriter, basis, subject types.Struct, data types.Map) types.Struct {
+	return types.NewStruct("Commit", types.StructData{
+		"parents": types.NewSet(noms, noms.WriteValue(basis), noms.WriteValue(subject)),
+		"meta": types.NewStruct("Reorder", types.StructData{
+			"date":    types.NewStruct("DateTime", types.StructData{"secSinceEpoch": types.Number(2)}),
+			"subject": noms.WriteValue(subject),
+		}),
+		"value": types.NewStruct("", types.StructData{"data": noms.WriteValue(data)}),
+	})
+}
+
+func TestCommitFormatUpgrade(t *testing.T) {
+	assert := assert.New(t)
+
+	data := func(noms types.ValueReadWriter, kvs ...string) types.Map {
+		return kv.NewMapForTest(noms, kvs...).NomsMap()
+	}
+	tc := []struct {
+		name    string
+		build   func(noms types.ValueReadWriter) types.Struct
+		format  int
+		value   string
+		pending int
+	}{
+		{"v1 genesis", func(noms types.ValueReadWriter) types.Struct {
+			return v1Genesis(noms, "s1", 1, data(noms, "foo", `"bar"`))
+		}, 1, `"bar"`, 0},
+		{"v1 tx", func(noms types.ValueReadWriter) types.Struct {
+			g := v1Genesis(noms, "s1", 1, data(noms, "foo", `"bar"`))
+			return v1Tx(noms, g, "put", data(noms, "foo", `"baz"`))
+		}, 1, `"baz"`, 1},
+		{"v1 reorder", func(noms types.ValueReadWriter) types.Struct {
+			g1 := v1Genesis(noms, "s1", 1, data(noms, "foo", `"bar"`))
+			tx := v1Tx(noms, g1, "put", data(noms, "foo", `"baz"`))
+			g2 := v1Genesis(noms, "s2", 1, data(noms, "foo", `"bar"`, "other", `1`))
+			return v1Reorder(noms, g2, tx, data(noms, "foo", `"baz"`, "other", `1`))
+		}, 1, `"baz"`, 1},
+		{"v2 without tags", func(noms types.ValueReadWriter) types.Struct {
+			m := kv.NewMapForTest(noms, "foo", `"bar"`)
+			return makeGenesis(noms, "s1", noms.WriteValue(m.NomsMap()), m.NomsChecksum(), 1).Original
+		}, 2, `"bar"`, 0},
+	}
+
+	for _, t := range tc {
+		db, _ := LoadTempDB(assert)
+		noms := db.noms
+		head := t.build(noms)
+		assert.Equal(t.format, commitFormats[commitFormatOf(head)].version, t.name)
+		ref := noms.WriteValue(head)
+		for _, id := range []string{LOCAL_DATASET, checkpointDatasetPrefix + "cp"} {
+			_, err := noms.SetHead(noms.GetDataset(id), ref)
+			assert.NoError(err, t.name)
+		}
+
+		db, err := New(noms)
+		assert.NoError(err, t.name)
+		assert.Equal(len(commitFormats)-1, commitFormatOf(noms.GetDataset(LOCAL_DATASET).Head()), t.name)
+		assert.Equal(len(commitFormats)-1, commitFormatOf(noms.GetDataset(checkpointDatasetPrefix+"cp").Head()), t.name)
+		assert.Equal([]Problem{}, Diagnose(noms), t.name)
+
+		v, err := db.Get("foo")
+		assert.NoError(err, t.name)
+		assert.Equal(t.value, string(v), t.name)
+		assert.Equal(kv.ComputeChecksum(db.head.Data(noms).NomsMap()).String(), string(db.head.Value.Checksum), t.name)
+		_, pending, err := pendingCommits(noms, db.head)
+		assert.NoError(err, t.name)
+		assert.Equal(t.pending, len(pending), t.name)
+
+		assert.NoError(db.Put("foo", []byte(`"new"`)), t.name)
+		assert.NoError(db.Restore("cp"), t.name)
+		v, err = db.Get("foo")
+		assert.NoError(err, t.name)
+		assert.Equal(t.value, string(v), t.name)
+	}
+}
@@ -0,0 +1,42 @@
+package db
+
+// ProposedCommit describes a local transaction that is about to be committed.
+type ProposedCommit struct {
+	// Basis is the current head, which Commit is based on.
+	Basis Commit
+	// Commit is the new commit. It has not yet been written.
+	Commit Commit
+}
+
+// BeforeCommitHook is called before a local transaction is committed. Returning an error
+// aborts the transaction, and the error is returned to the caller.
+type BeforeCommitHook func(c ProposedCommit) error
+
+// CommitHook is called after the local head changes.
+type CommitHook func(c Commit)
+
+// BeforeCommit registers h to be called before each local transaction (e.g., Put or Del) is
+// committed. Hooks are called in the order registered with the database lock held, so they
+// must not call back into the DB, except for Noms() to read data.
+func (db *DB) BeforeCommit(h BeforeCommitHook) {
+	defer db.lock()()
+	db.beforeCommitHooks = append(db.beforeCommitHooks, h)
+}
+
+// OnCommit registers h to be called after the local head changes for any reason: a local
+// transaction, a pull, merging a branch, or restoring a checkpoint. Hooks are called in the
+// order registered with the database lock held, so they must not call back into the DB,
+// except for Noms() to read data.
+func (db *DB) OnCommit(h CommitHook) {
+	defer db.lock()()
+	db.commitHooks = append(db.commitHooks, h)
+}
+
+func (db *DB) runBeforeCommitHooks(c ProposedCommit) error {
+	for _, h := range db.beforeCommitHooks {
+		if err := h(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/attic-labs/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHooks(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	committed := []Commit{}
+	db.OnCommit(func(c Commit) {
+		committed = append(committed, c)
+	})
+	proposed := []ProposedCommit{}
+	db.BeforeCommit(func(c ProposedCommit) error {
+		proposed = append(proposed, c)
+		return nil
+	})
+	// Reject values that aren't strings.
+	db.BeforeCommit(func(c ProposedCommit) error {
+		if c.Commit.Meta.Tx.Name == ".putValue" && c.Commit.Meta.Tx.Args.Get(1).Kind() != types.StringKind {
+			return errors.New("values must be strings")
+		}
+		return nil
+	})
+
+	basis := db.Head()
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	assert.Equal(1, len(proposed))
+	assert.True(basis.Original.Equals(proposed[0].Basis.Original))
+	assert.True(db.Head().Original.Equals(proposed[0].Commit.Original))
+	assert.Equal(1, len(committed))
+	assert.True(db.Head().Original.Equals(committed[0].Original))
+
+	h := db.Hash()
+	assert.EqualError(db.Put("foo", []byte(`42`)), "values must be strings")
+	assert.Equal(h, db.Hash())
+	assert.Equal(2, len(proposed))
+	assert.Equal(1, len(committed))
+
+	// OnCommit hooks also see head changes other than transactions.
+	assert.NoError(db.Checkpoint("cp"))
+	_, err = db.Del("foo")
+	assert.NoError(err)
+	assert.NoError(db.Restore("cp"))
+	assert.Equal(3, len(committed))
+	assert.Equal(h, committed[2].Original.Hash())
+}
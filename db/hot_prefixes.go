@@ -0,0 +1,88 @@
+package db
+
+import (
+	"strings"
+
+	"roci.dev/diff-server/util/jsonpatch"
+)
+
+// PrefixProgress is how many patch operations for keys with Prefix a pull has applied.
+type PrefixProgress struct {
+	Prefix     string `json:"prefix"`
+	OpsApplied uint64 `json:"opsApplied"`
+}
+
+// SetHotPrefixes registers the key prefixes the app most needs, typically those shown on the
+// current screen. They are sent with pull requests so that servers can send operations for
+// them first, and the progress of each is reported by pulls. Replacing the prefixes doesn't
+// affect pulls in progress.
+func (db *DB) SetHotPrefixes(prefixes []string) {
+	defer db.lock()()
+	db.hotPrefixes = append([]string{}, prefixes...)
+}
+
+// HotPrefixes returns the prefixes set by SetHotPrefixes.
+func (db *DB) HotPrefixes() []string {
+	defer db.rlock()()
+	return append([]string{}, db.hotPrefixes...)
+}
+
+// hotProgress tracks the patch operations applied for each hot prefix during a pull.
+type hotProgress struct {
+	prefixes []PrefixProgress
+	// done is set once an operation outside all the hot prefixes has been applied.
+	done bool
+}
+
+func newHotProgress(prefixes []string) *hotProgress {
+	hp := &hotProgress{}
+	for _, p := range prefixes {
+		hp.prefixes = append(hp.prefixes, PrefixProgress{Prefix: p})
+	}
+	return hp
+}
+
+// prefixNames returns the hot prefixes.
+func (hp *hotProgress) prefixNames() []string {
+	var r []string
+	for _, p := range hp.prefixes {
+		r = append(r, p.Prefix)
+	}
+	return r
+}
+
+// add counts the operations of batch, which has been applied.
+func (hp *hotProgress) add(batch []jsonpatch.Operation) {
+	for _, op := range batch {
+		id := opKey(op)
+		hot := false
+		for i := range hp.prefixes {
+			// Removing the root, as snapshot patches start by doing, affects every prefix.
+			if op.Path == "/" || strings.HasPrefix(id, hp.prefixes[i].Prefix) {
+				hp.prefixes[i].OpsApplied++
+				hot = true
+			}
+		}
+		if !hot {
+			hp.done = true
+		}
+	}
+}
+
+// report copies the progress to pp, if there are hot prefixes.
+func (hp *hotProgress) report(pp *PullProgress) {
+	if len(hp.prefixes) == 0 {
+		return
+	}
+	pp.HotPrefixes = append([]PrefixProgress{}, hp.prefixes...)
+	pp.HotPrefixesApplied = hp.done
+}
+
+// opKey returns the key modified by op.
+func opKey(op jsonpatch.Operation) string {
+	p := strings.TrimPrefix(op.Path, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		p = p[:i]
+	}
+	return pointerUnescaper.Replace(p)
+}
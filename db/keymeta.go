@@ -0,0 +1,207 @@
+package db
+
+import (
+	"log"
+
+	"github.com/attic-labs/noms/go/hash"
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+	"github.com/attic-labs/noms/go/util/datetime"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// KEYMETA_DATASET holds per-key modification metadata. It is kept alongside the local dataset
+// rather than inside commits so that it does not affect commit hashes or sync. It is brought
+// up to date when it is read rather than on every commit, so that it doesn't add to the cost
+// of writes.
+const KEYMETA_DATASET = "keymeta"
+
+// KeyMeta describes the most recent modification of a key.
+type KeyMeta struct {
+	// Commit is the hash of the local commit that last modified the key.
+	Commit string `json:"commit"`
+	// Date is the date of the transaction that last modified the key. It is zero if the key was
+	// last modified by the server, since the dates of server changes are not known.
+	Date datetime.DateTime `json:"date"`
+}
+
+// keyMetaIndex is the head value of KEYMETA_DATASET.
+type keyMetaIndex struct {
+	// Basis is the local commit that Keys and Tombstones are up to date with.
+	Basis types.Ref
+	Keys  types.Map
+	// Tombstones maps deleted keys to the KeyMeta of their deletion.
+	Tombstones types.Map
+}
+
+// keyMeta returns the local head and the key metadata, brought up to date with it. It doesn't
+// hold the lock, so it doesn't hold up writes or other reads.
+func (db *DB) keyMeta() (Commit, keyMetaIndex, error) {
+	db.keyMetaMu.Lock()
+	defer db.keyMetaMu.Unlock()
+	head := db.snapshot()
+	idx, err := db.updateKeyMeta(head)
+	return head, idx, err
+}
+
+// updateKeyMeta brings the key metadata up to date with head, attributing each key that
+// changed since the commit the metadata was last computed against to the commit that changed
+// it. Callers must hold keyMetaMu.
+func (db *DB) updateKeyMeta(head Commit) (keyMetaIndex, error) {
+	ds := db.noms.GetDataset(KEYMETA_DATASET)
+	empty := keyMetaIndex{Keys: types.NewMap(db.noms), Tombstones: types.NewMap(db.noms)}
+	idx := empty
+	last := types.NewMap(db.noms)
+	var lastHash hash.Hash
+	if ds.HasHead() {
+		err := marshal.Unmarshal(ds.HeadValue(), &idx)
+		if err != nil {
+			// The index is derived data, so if it is unreadable (e.g., was written by an older
+			// version) just rebuild it.
+			log.Printf("Could not unmarshal key metadata, rebuilding: %s", err.Error())
+			idx = empty
+		} else if idx.Basis.TargetHash() == head.Original.Hash() {
+			return idx, nil
+		} else {
+			var basis Commit
+			err = marshal.Unmarshal(idx.Basis.TargetValue(db.noms), &basis)
+			if err != nil {
+				return keyMetaIndex{}, err
+			}
+			last = basis.Data(db.noms).NomsMap()
+			lastHash = basis.Original.Hash()
+		}
+	}
+
+	commits, err := commitsSince(db.noms, head, lastHash)
+	if err != nil {
+		return keyMetaIndex{}, err
+	}
+	// Server changes have no date, so their tombstones are dated when they are first seen,
+	// so that they are kept for the retention window like any other.
+	seen := time.DateTime()
+	ed := idx.Keys.Edit()
+	ted := idx.Tombstones.Edit()
+	for _, c := range commits {
+		date, err := modifiedDate(db.noms, c)
+		if err != nil {
+			return keyMetaIndex{}, err
+		}
+		km := marshal.MustMarshal(db.noms, KeyMeta{Commit: c.Original.Hash().String(), Date: date})
+		tm := km
+		if c.Type() == CommitTypeGenesis {
+			tm = marshal.MustMarshal(db.noms, KeyMeta{Commit: c.Original.Hash().String(), Date: seen})
+		}
+
+		data := c.Data(db.noms).NomsMap()
+		changes := make(chan types.ValueChanged)
+		go func() {
+			data.Diff(last, changes, nil)
+			close(changes)
+		}()
+		for ch := range changes {
+			if ch.ChangeType == types.DiffChangeRemoved {
+				ed.Remove(ch.Key)
+				ted.Set(ch.Key, tm)
+			} else {
+				ed.Set(ch.Key, km)
+				ted.Remove(ch.Key)
+			}
+		}
+		last = data
+	}
+
+	idx.Basis = head.Ref()
+	idx.Keys = ed.Map()
+	idx.Tombstones = ted.Map()
+	db.storeKeyMeta(idx)
+	return idx, nil
+}
+
+// storeKeyMeta commits idx to KEYMETA_DATASET. The index can be recomputed, so a failure to
+// store it is logged rather than failing the read. Callers must hold keyMetaMu.
+func (db *DB) storeKeyMeta(idx keyMetaIndex) {
+	_, err := db.noms.CommitValue(db.noms.GetDataset(KEYMETA_DATASET), marshal.MustMarshal(db.noms, idx))
+	if err != nil {
+		log.Printf("Could not store key metadata: %s", err)
+	}
+}
+
+// commitsSince returns the commits after the one with hash basis up to head, oldest first.
+// If basis is not an ancestor of head, for example because a pull rebased head onto new
+// server state, the commits from head's genesis are returned.
+func commitsSince(noms types.ValueReader, head Commit, basis hash.Hash) ([]Commit, error) {
+	commits := []Commit{}
+	c := head
+	for c.Original.Hash() != basis {
+		commits = append(commits, c)
+		if c.Type() == CommitTypeGenesis {
+			break
+		}
+		var err error
+		c, err = c.Basis(noms)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+// modifiedDate returns the date of the change made by c: the date of its transaction, which
+// for a reorder is that of the transaction it replays. Genesis commits hold server state,
+// whose dates are not known, so their date is zero.
+func modifiedDate(noms types.ValueReader, c Commit) (datetime.DateTime, error) {
+	switch c.Type() {
+	case CommitTypeTx:
+		return c.Meta.Tx.Date, nil
+	case CommitTypeReorder:
+		tx, err := c.InitalCommit(noms)
+		if err != nil {
+			return datetime.DateTime{}, err
+		}
+		return tx.Meta.Tx.Date, nil
+	}
+	return datetime.DateTime{}, nil
+}
+
+// headChanged is called with the lock held after the local head moves.
+func (db *DB) headChanged() {
+	// The head has already been committed at this point, so failing to update views should
+	// not fail the write. They are brought up to date on the next read.
+	err := db.updateViews(db.head)
+	if err != nil {
+		log.Printf("Could not update views: %s", err)
+	}
+	e := Event{Type: EventCommit, Head: db.head.Original.Hash().String()}
+	if db.head.Type() == CommitTypeTx {
+		e.Name = db.head.Meta.Tx.Name
+	}
+	db.events.add(e)
+	for _, h := range db.commitHooks {
+		h(db.head)
+	}
+	db.notifyHeadWaiters()
+}
+
+func (idx keyMetaIndex) get(id string) (KeyMeta, bool, error) {
+	v, ok := idx.Keys.MaybeGet(types.String(id))
+	if !ok {
+		return KeyMeta{}, false, nil
+	}
+	var km KeyMeta
+	err := marshal.Unmarshal(v, &km)
+	return km, err == nil, err
+}
+
+// GetMeta returns metadata about the last modification of id. ok is false if id is not present.
+func (db *DB) GetMeta(id string) (meta KeyMeta, ok bool, err error) {
+	_, idx, err := db.keyMeta()
+	if err != nil {
+		return KeyMeta{}, false, err
+	}
+	return idx.get(id)
+}
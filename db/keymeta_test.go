@@ -0,0 +1,128 @@
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+	"roci.dev/diff-server/util/time"
+)
+
+func TestKeyMeta(t *testing.T) {
+	assert := assert.New(t)
+	defer time.SetFake()()
+
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	_, ok, err := db.GetMeta("foo")
+	assert.NoError(err)
+	assert.False(ok)
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	fooHash := db.Hash().String()
+	assert.NoError(db.Put("baz", []byte(`"quux"`)))
+	bazHash := db.Hash().String()
+
+	km, ok, err := db.GetMeta("foo")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(fooHash, km.Commit)
+	assert.True(time.DateTime().Equal(km.Date.Time))
+
+	km, ok, err = db.GetMeta("baz")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(bazHash, km.Commit)
+
+	// Rewriting the same value is not a modification.
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	km, ok, err = db.GetMeta("foo")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(fooHash, km.Commit)
+
+	items, err := db.Scan(ScanOptions{IncludeMeta: true})
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+	assert.Equal("baz", items[0].ID)
+	assert.Equal(bazHash, items[0].Meta.Commit)
+	assert.Equal("foo", items[1].ID)
+	assert.Equal(fooHash, items[1].Meta.Commit)
+
+	items, err = db.Scan(ScanOptions{})
+	assert.NoError(err)
+	assert.Nil(items[0].Meta)
+
+	ok, err = db.Del("foo")
+	assert.NoError(err)
+	assert.True(ok)
+	_, ok, err = db.GetMeta("foo")
+	assert.NoError(err)
+	assert.False(ok)
+
+	// Metadata survives reopening the database.
+	db, err = New(db.noms)
+	assert.NoError(err)
+	km, ok, err = db.GetMeta("baz")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(bazHash, km.Commit)
+}
+
+func TestKeyMetaAttribution(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	// Metadata is computed when read, so several commits are indexed at once. Each key is
+	// attributed to the commit that changed it, with that commit's date.
+	assert.NoError(db.Put("foo", []byte(`1`)))
+	foo := db.Head()
+	gtime.Sleep(2 * gtime.Millisecond)
+	assert.NoError(db.Put("bar", []byte(`2`)))
+	bar := db.Head()
+
+	km, ok, err := db.GetMeta("foo")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(foo.Original.Hash().String(), km.Commit)
+	assert.True(foo.Meta.Tx.Date.Equal(km.Date.Time))
+	km, ok, err = db.GetMeta("bar")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(bar.Original.Hash().String(), km.Commit)
+	assert.True(bar.Meta.Tx.Date.Equal(km.Date.Time))
+
+	// After a pull, keys changed by the server are attributed to the new genesis with no date,
+	// and pending keys keep the dates of their transactions.
+	m := kv.NewMapForTest(db.noms, "s", `"s"`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"patch":[{"op":"add","path":"/s","value":"s"}],"stateID":"11111111111111111111111111111111","checksum":"%s"}`, m.Checksum())))
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	_, err = db.Pull(sp, "", nil)
+	assert.NoError(err)
+	genesis, err := findGenesis(db.noms, db.Head())
+	assert.NoError(err)
+
+	km, ok, err = db.GetMeta("s")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(genesis.Original.Hash().String(), km.Commit)
+	assert.True(km.Date.IsZero())
+	km, ok, err = db.GetMeta("foo")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.NotEqual(foo.Original.Hash().String(), km.Commit)
+	assert.True(foo.Meta.Tx.Date.Equal(km.Date.Time))
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/attic-labs/noms/go/types"
+)
+
+// LOCAL_ONLY_DATASET holds the values of local-only keys. Like KEYMETA_DATASET it is kept
+// outside of commits, so local-only values are not included in checksums, not overwritten by
+// pulls, and not synced.
+const LOCAL_ONLY_DATASET = "localonly"
+
+// LocalOnlyPrefix is the reserved key prefix for local-only keys, which are useful for UI
+// state, drafts, and device-specific settings. They can be read and written with Has, Get,
+// Put, Del, Clear, and Scan, but as they are not part of commits they have no history and are
+// not affected by Restore or pulls.
+const LocalOnlyPrefix = "_local/"
+
+// isLocalOnlyKey returns whether id is in the reserved local-only key space.
+func isLocalOnlyKey(id string) bool {
+	return strings.HasPrefix(id, LocalOnlyPrefix)
+}
+
+// localOnlyData returns the local-only values, keyed by their full key. Callers must hold the
+// lock.
+func (db *DB) localOnlyData() types.Map {
+	ds := db.noms.GetDataset(LOCAL_ONLY_DATASET)
+	if !ds.HasHead() {
+		return types.NewMap(db.noms)
+	}
+	return ds.HeadValue().(types.Map)
+}
+
+func (db *DB) getLocalOnly(id string) types.Value {
+	defer db.rlock()()
+	return db.localOnlyData().Get(types.String(id))
+}
+
+func (db *DB) putLocalOnly(id string, value types.Value) error {
+	defer db.lock()()
+	m := db.localOnlyData().Edit().Set(types.String(id), value).Map()
+	_, err := db.noms.CommitValue(db.noms.GetDataset(LOCAL_ONLY_DATASET), m)
+	return err
+}
+
+func (db *DB) delLocalOnly(id string) (bool, error) {
+	defer db.lock()()
+	m := db.localOnlyData()
+	if !m.Has(types.String(id)) {
+		return false, nil
+	}
+	_, err := db.noms.CommitValue(db.noms.GetDataset(LOCAL_ONLY_DATASET), m.Edit().Remove(types.String(id)).Map())
+	return err == nil, err
+}
+
+func (db *DB) clearLocalOnly(prefix string) (uint64, error) {
+	defer db.lock()()
+	m := db.localOnlyData()
+	ed := m.Edit()
+	n := uint64(0)
+	for it := m.IteratorFrom(types.String(prefix)); it.Valid(); it.Next() {
+		k := it.Key()
+		if !strings.HasPrefix(string(k.(types.String)), prefix) {
+			break
+		}
+		ed.Remove(k)
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	_, err := db.noms.CommitValue(db.noms.GetDataset(LOCAL_ONLY_DATASET), ed.Map())
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (db *DB) scanLocalOnly(opts ScanOptions) ([]ScanItem, error) {
+	if opts.IncludeMeta {
+		return nil, errors.New("includeMeta is not supported for local-only keys")
+	}
+	unlock := db.lock()
+	m := db.localOnlyData()
+	unlock()
+	return scan(db.noms, m, opts)
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalOnly(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	h := db.Hash()
+	checksum := db.head.Value.Checksum
+
+	assert.NoError(db.Put("_local/draft", []byte(`{"text":"hi"}`)))
+	assert.NoError(db.Put("_local/tab", []byte(`2`)))
+
+	// Local-only values don't affect the head or its checksum.
+	assert.Equal(h, db.Hash())
+	assert.Equal(checksum, db.head.Value.Checksum)
+
+	ok, err := db.Has("_local/draft")
+	assert.NoError(err)
+	assert.True(ok)
+	v, err := db.Get("_local/draft")
+	assert.NoError(err)
+	assert.Equal(`{"text":"hi"}`, string(v))
+
+	items, err := db.Scan(ScanOptions{Prefix: "_local/"})
+	assert.NoError(err)
+	ids := []string{}
+	for _, it := range items {
+		ids = append(ids, it.ID)
+	}
+	assert.Equal([]string{"_local/draft", "_local/tab"}, ids)
+
+	// Local-only keys are not included in scans of synced data.
+	items, err = db.Scan(ScanOptions{})
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+
+	// Local-only keys are not affected by restoring a checkpoint.
+	assert.NoError(db.Checkpoint("cp"))
+	assert.NoError(db.Put("_local/tab", []byte(`3`)))
+	assert.NoError(db.Restore("cp"))
+	v, err = db.Get("_local/tab")
+	assert.NoError(err)
+	assert.Equal(`3`, string(v))
+
+	ok, err = db.Del("_local/tab")
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = db.Del("_local/tab")
+	assert.NoError(err)
+	assert.False(ok)
+
+	n, err := db.Clear("_local/")
+	assert.NoError(err)
+	assert.Equal(uint64(1), n)
+	ok, err = db.Has("_local/draft")
+	assert.NoError(err)
+	assert.False(ok)
+
+	b, err := db.CreateBranch("draft")
+	assert.NoError(err)
+	assert.EqualError(b.Put("_local/draft", []byte(`1`)), "could not Put '_local/draft': local-only keys cannot be written to branches")
+}
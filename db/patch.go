@@ -0,0 +1,58 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/attic-labs/noms/go/types"
+
+	"roci.dev/diff-server/util/jsonpatch"
+	jsnoms "roci.dev/diff-server/util/noms/json"
+)
+
+// SnapshotPatch returns a patch, in the format of a pull response, that replaces all the data
+// with data, such as a diff-server sends to a client with no base state.
+func SnapshotPatch(data types.Map) ([]jsonpatch.Operation, error) {
+	r := []jsonpatch.Operation{{Op: "remove", Path: "/"}}
+	var err error
+	data.IterAll(func(k, v types.Value) {
+		if err != nil {
+			return
+		}
+		var op jsonpatch.Operation
+		op, err = patchOp("add", string(k.(types.String)), v)
+		r = append(r, op)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// DiffPatch returns a patch, in the format of a pull response, that turns from into to.
+func DiffPatch(from, to types.Map) ([]jsonpatch.Operation, error) {
+	r := []jsonpatch.Operation{}
+	for _, c := range diffKeys(from, to) {
+		op, err := patchOp(changeOp(c), c.ID, c.New)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, op)
+	}
+	return r, nil
+}
+
+// patchOp returns the patch operation op on the key id. v is the new value, if any.
+func patchOp(op, id string, v types.Value) (jsonpatch.Operation, error) {
+	r := jsonpatch.Operation{Op: op, Path: "/" + pointerEscaper.Replace(id)}
+	if v == nil {
+		return r, nil
+	}
+	var buf bytes.Buffer
+	if err := jsnoms.ToJSON(v, &buf); err != nil {
+		return jsonpatch.Operation{}, fmt.Errorf("%w: %s", ErrValueCorrupt, err)
+	}
+	r.Value = json.RawMessage(bytes.TrimSpace(buf.Bytes()))
+	return r, nil
+}
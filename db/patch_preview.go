@@ -0,0 +1,59 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+)
+
+// PatchChange describes the effect of a patch on a single key.
+type PatchChange struct {
+	ID string `json:"id"`
+	// Op is one of "add", "replace", or "remove".
+	Op string `json:"op"`
+}
+
+// PatchPreview describes the result of applying a pull response's patch.
+type PatchPreview struct {
+	// Checksum is the checksum of the data after applying the patch.
+	Checksum string `json:"checksum"`
+	// ChecksumMatches is whether Checksum is the checksum in the pull response. A pull would
+	// fail if it isn't.
+	ChecksumMatches bool          `json:"checksumMatches"`
+	Changes         []PatchChange `json:"changes"`
+}
+
+// PreviewPatch applies the patch in resp, which is in the format returned by the diff-server
+// for a pull, to the server state the local head is based on and returns the changes it makes,
+// without committing anything. It is useful for validating patches against a real client.
+func (db *DB) PreviewPatch(resp servetypes.PullResponse) (PatchPreview, error) {
+	unlock := db.lock()
+	genesis, _, err := pendingCommits(db.noms, db.head)
+	unlock()
+	if err != nil {
+		return PatchPreview{}, err
+	}
+	if resp.LastMutationID < genesis.Meta.Genesis.LastMutationID {
+		return PatchPreview{}, fmt.Errorf("Client view lastMutationID %d is < previous lastMutationID %d", resp.LastMutationID, genesis.Meta.Genesis.LastMutationID)
+	}
+
+	original := genesis.Data(db.noms)
+	patched, err := kv.ApplyPatch(db.noms, original, resp.Patch)
+	if err != nil {
+		return PatchPreview{}, errors.Wrap(err, "couldnt apply patch")
+	}
+	res := PatchPreview{
+		Checksum: patched.Checksum(),
+		Changes:  []PatchChange{},
+	}
+	if expected, err := kv.ChecksumFromString(resp.Checksum); err == nil {
+		res.ChecksumMatches = expected.String() == res.Checksum
+	}
+	for _, c := range diffKeys(original.NomsMap(), patched.NomsMap()) {
+		res.Changes = append(res.Changes, PatchChange{ID: c.ID, Op: changeOp(c)})
+	}
+	return res, nil
+}
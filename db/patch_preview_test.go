@@ -0,0 +1,62 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+)
+
+func TestPreviewPatch(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	m := kv.NewMapForTest(db.noms, "a", `"a"`, "b", `"b"`)
+	g := makeGenesis(db.noms, "s1", db.noms.WriteValue(m.NomsMap()), m.NomsChecksum(), 1)
+	_, err = db.noms.SetHead(db.noms.GetDataset(LOCAL_DATASET), db.noms.WriteValue(g.Original))
+	assert.NoError(err)
+	db, err = New(db.noms)
+	assert.NoError(err)
+	// Pending commits don't affect the preview.
+	assert.NoError(db.Put("d", []byte(`"d"`)))
+	h := db.Hash()
+
+	patch := `[{"op":"replace","path":"/a","value":"x"},{"op":"remove","path":"/b"},{"op":"add","path":"/c","value":"c"}]`
+	expected := kv.NewMapForTest(db.noms, "a", `"x"`, "c", `"c"`).Checksum()
+	resp := func(lmid uint64, checksum string) servetypes.PullResponse {
+		var r servetypes.PullResponse
+		assert.NoError(json.Unmarshal([]byte(fmt.Sprintf(`{"patch":%s,"stateID":"s2","checksum":"%s","lastMutationID":%d}`, patch, checksum, lmid)), &r))
+		return r
+	}
+
+	p, err := db.PreviewPatch(resp(1, expected))
+	assert.NoError(err)
+	assert.Equal(PatchPreview{
+		Checksum:        expected,
+		ChecksumMatches: true,
+		Changes: []PatchChange{
+			{ID: "a", Op: "replace"},
+			{ID: "b", Op: "remove"},
+			{ID: "c", Op: "add"},
+		},
+	}, p)
+
+	p, err = db.PreviewPatch(resp(1, "00000000"))
+	assert.NoError(err)
+	assert.Equal(expected, p.Checksum)
+	assert.False(p.ChecksumMatches)
+
+	_, err = db.PreviewPatch(resp(0, expected))
+	assert.EqualError(err, "Client view lastMutationID 0 is < previous lastMutationID 1")
+
+	// Nothing was committed.
+	assert.Equal(h, db.Hash())
+}
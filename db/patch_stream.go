@@ -0,0 +1,83 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+
+	"roci.dev/diff-server/util/jsonpatch"
+)
+
+// streamingPatchContentType is the content type of streaming pull responses. The first line
+// of a streaming response is a pull response without its patch, and each following line is a
+// single patch operation. This lets servers send operations as they are computed and lets
+// the client apply them as they arrive, so neither side buffers the whole patch.
+const streamingPatchContentType = "application/x-ndjson"
+
+func isStreamingPatch(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == streamingPatchContentType
+}
+
+// patchSource yields a pull response's patch operations in batches.
+type patchSource interface {
+	// next returns up to n operations. It returns no operations once the patch is exhausted.
+	next(n int) ([]jsonpatch.Operation, error)
+	// checkpoint returns the checkpoint that ended the batch last returned by next, if any.
+	checkpoint() *patchCheckpoint
+}
+
+// bufferedPatch is the patch of a pull response that was decoded in full.
+type bufferedPatch struct {
+	ops []jsonpatch.Operation
+}
+
+func (p *bufferedPatch) next(n int) ([]jsonpatch.Operation, error) {
+	if n > len(p.ops) {
+		n = len(p.ops)
+	}
+	r := p.ops[:n]
+	p.ops = p.ops[n:]
+	return r, nil
+}
+
+func (p *bufferedPatch) checkpoint() *patchCheckpoint {
+	return nil
+}
+
+// streamingPatch decodes the patch operations of a streaming pull response as they arrive.
+// A line may instead be a checkpoint, {"checkpoint":{"ops":...,"checksum":...}}, which ends
+// the current batch.
+type streamingPatch struct {
+	dec *json.Decoder
+	cp  *patchCheckpoint
+}
+
+func (p *streamingPatch) next(n int) ([]jsonpatch.Operation, error) {
+	p.cp = nil
+	var r []jsonpatch.Operation
+	for len(r) < n {
+		var line struct {
+			jsonpatch.Operation
+			Checkpoint *patchCheckpoint `json:"checkpoint"`
+		}
+		err := p.dec.Decode(&line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid patch operation %d: %s", len(r), err)
+		}
+		if line.Checkpoint != nil {
+			p.cp = line.Checkpoint
+			break
+		}
+		r = append(r, line.Operation)
+	}
+	return r, nil
+}
+
+func (p *streamingPatch) checkpoint() *patchCheckpoint {
+	return p.cp
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+)
+
+func TestPatches(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+	noms := db.Noms()
+
+	from := kv.NewMapForTest(noms, "a", `1`, "b/c~", `{"x":true}`, "d", `"old"`)
+	to := kv.NewMapForTest(noms, "a", `1`, "d", `"new"`, "e", `[]`)
+
+	toJSON := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		assert.NoError(err)
+		return string(b)
+	}
+
+	patch, err := SnapshotPatch(from.NomsMap())
+	assert.NoError(err)
+	assert.Equal(`[{"op":"remove","path":"/"},{"op":"add","path":"/a","value":1},{"op":"add","path":"/b~1c~0","value":{"x":true}},{"op":"add","path":"/d","value":"old"}]`, toJSON(patch))
+	patched, err := kv.ApplyPatch(noms, to, patch)
+	assert.NoError(err)
+	assert.Equal(from.Checksum(), patched.Checksum())
+
+	patch, err = DiffPatch(from.NomsMap(), to.NomsMap())
+	assert.NoError(err)
+	assert.Equal(`[{"op":"remove","path":"/b~1c~0"},{"op":"replace","path":"/d","value":"new"},{"op":"add","path":"/e","value":[]}]`, toJSON(patch))
+	patched, err = kv.ApplyPatch(noms, from, patch)
+	assert.NoError(err)
+	assert.Equal(to.Checksum(), patched.Checksum())
+
+	patch, err = DiffPatch(to.NomsMap(), to.NomsMap())
+	assert.NoError(err)
+	assert.Equal(`[]`, toJSON(patch))
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/attic-labs/noms/go/types"
+)
+
+// GetPath returns the JSON of the field at pointer, a JSON Pointer (RFC 6901), within the value
+// of id. It returns nil if there is no such key or field.
+func (db *DB) GetPath(id, pointer string) ([]byte, error) {
+	path, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	v, err := db.Get(id)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(v, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrValueCorrupt, err)
+	}
+	f, ok := resolvePointer(doc, path)
+	if !ok {
+		return nil, nil
+	}
+	return json.Marshal(f)
+}
+
+// PutPath sets the field at pointer, a JSON Pointer (RFC 6901), within the value of id to
+// JSON, leaving the rest of the value unchanged.
+func (db *DB) PutPath(id, pointer string, JSON []byte) error {
+	return db.PutPathCtx(context.Background(), id, pointer, JSON)
+}
+
+// PutPathCtx is like PutPath but gives up if ctx is done before the write is committed.
+//
+// The parent of the field must exist. Object fields are added or replaced. Array elements are
+// replaced, or appended if the last reference token is "-" or the length of the array.
+func (db *DB) PutPathCtx(ctx context.Context, id, pointer string, JSON []byte) error {
+	path, err := parsePointer(pointer)
+	if err != nil {
+		return err
+	}
+	var field interface{}
+	if err := json.Unmarshal(JSON, &field); err != nil {
+		return fmt.Errorf("could not Put '%s'='%s': %w", id, JSON, err)
+	}
+
+	if isLocalOnlyKey(id) {
+		value, err := db.replaceField(db.getLocalOnly(id), id, pointer, path, field)
+		if err != nil {
+			return err
+		}
+		return db.putLocalOnly(id, value)
+	}
+
+	defer db.lock()()
+	value, err := db.replaceField(db.head.Data(db.noms).Get(types.String(id)), id, pointer, path, field)
+	if err != nil {
+		return err
+	}
+	_, err = db.execInternal(ctx, ".putValue", types.NewList(db.Noms(), types.String(id), value))
+	return err
+}
+
+// replaceField returns current, the value of id, with the field at path set to field.
+func (db *DB) replaceField(current types.Value, id, pointer string, path []string, field interface{}) (types.Value, error) {
+	var doc interface{}
+	if current != nil {
+		var err error
+		doc, err = decodeValue(current)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(path) > 0 {
+		return nil, fmt.Errorf("could not Put '%s' at '%s': no such key", id, pointer)
+	}
+	doc, err := replacePointer(doc, path, field)
+	if err != nil {
+		return nil, fmt.Errorf("could not Put '%s' at '%s': %s", id, pointer, err)
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+		return nil, err
+	}
+	return db.putValue(id, buf.Bytes())
+}
+
+// replacePointer returns doc with the field at path set to v. See PutPathCtx.
+func replacePointer(doc interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	tok := path[0]
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		f, ok := d[tok]
+		if !ok && len(path) > 1 {
+			return nil, fmt.Errorf("no such field: %s", tok)
+		}
+		f, err := replacePointer(f, path[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		d[tok] = f
+		return d, nil
+	case []interface{}:
+		i := len(d)
+		if tok != "-" {
+			var err error
+			i, err = strconv.Atoi(tok)
+			if err != nil || i < 0 || i > len(d) {
+				return nil, fmt.Errorf("invalid array index: %s", tok)
+			}
+		}
+		if i == len(d) {
+			if len(path) > 1 {
+				return nil, fmt.Errorf("no such field: %s", tok)
+			}
+			return append(d, v), nil
+		}
+		f, err := replacePointer(d[i], path[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = f
+		return d, nil
+	default:
+		return nil, fmt.Errorf("cannot set field %s of a non-container value", tok)
+	}
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPutPath(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	assert.NoError(db.Put("doc", []byte(`{"a":{"b":1,"c/d":2},"list":[1,2]}`)))
+	assert.NoError(db.Put("_local/doc", []byte(`{"n":1}`)))
+
+	tc := []struct {
+		id, pointer, value string
+		expected           string
+		expectedError      string
+	}{
+		{"doc", "/a/b", "true", `{"a":{"b":true,"c/d":2},"list":[1,2]}`, ""},
+		{"doc", "/a/c~1d", `"x"`, `{"a":{"b":true,"c/d":"x"},"list":[1,2]}`, ""},
+		{"doc", "/a/e", `{"f":null}`, `{"a":{"b":true,"c/d":"x","e":{"f":null}},"list":[1,2]}`, ""},
+		{"doc", "/list/0", "3", `{"a":{"b":true,"c/d":"x","e":{"f":null}},"list":[3,2]}`, ""},
+		{"doc", "/list/-", "4", `{"a":{"b":true,"c/d":"x","e":{"f":null}},"list":[3,2,4]}`, ""},
+		{"doc", "/list/3", "5", `{"a":{"b":true,"c/d":"x","e":{"f":null}},"list":[3,2,4,5]}`, ""},
+		{"doc", "", `{"g":1}`, `{"g":1}`, ""},
+		{"doc", "/x/y", "1", "", "could not Put 'doc' at '/x/y': no such field: x"},
+		{"doc", "/g/h", "1", "", "could not Put 'doc' at '/g/h': cannot set field h of a non-container value"},
+		{"doc", "g", "1", "", "Invalid path: 'g' - must be empty or start with '/'"},
+		{"doc", "/g", "{", "", "could not Put 'doc'='{': unexpected end of JSON input"},
+		{"missing", "/a", "1", "", "could not Put 'missing' at '/a': no such key"},
+		{"missing", "", "1", "1", ""},
+		{"_local/doc", "/m", "2", `{"m":2,"n":1}`, ""},
+	}
+	for i, t := range tc {
+		err := db.PutPath(t.id, t.pointer, []byte(t.value))
+		if t.expectedError != "" {
+			assert.EqualError(err, t.expectedError, "case %d", i)
+			continue
+		}
+		assert.NoError(err, "case %d", i)
+		v, err := db.Get(t.id)
+		assert.NoError(err, "case %d", i)
+		assert.Equal(t.expected, string(v), "case %d", i)
+	}
+
+	assert.NoError(db.Put("doc", []byte(`{"a":{"b":[1,{"c":"d"}]}}`)))
+	for i, t := range []struct {
+		id, pointer string
+		expected    string
+	}{
+		{"doc", "", `{"a":{"b":[1,{"c":"d"}]}}`},
+		{"doc", "/a/b/1", `{"c":"d"}`},
+		{"doc", "/a/b/1/c", `"d"`},
+		{"doc", "/a/x", ""},
+		{"missing2", "/a", ""},
+		{"_local/doc", "/m", "2"},
+	} {
+		v, err := db.GetPath(t.id, t.pointer)
+		assert.NoError(err, "case %d", i)
+		assert.Equal(t.expected, string(v), "case %d", i)
+	}
+	_, err = db.GetPath("doc", "a")
+	assert.EqualError(err, "Invalid path: 'a' - must be empty or start with '/'")
+}
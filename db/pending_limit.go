@@ -0,0 +1,70 @@
+package db
+
+import (
+	"log"
+
+	"github.com/attic-labs/noms/go/hash"
+)
+
+// PendingLimitHandler is called when a local commit takes the number of pending commits past
+// the limit set with SetPendingLimit. pending is the new number of pending commits.
+type PendingLimitHandler func(pending int)
+
+type pendingLimit struct {
+	max     int
+	handler PendingLimitHandler
+	// count is the number of pending commits as of the commit head, so that it needn't be
+	// recounted on every commit.
+	count int
+	head  hash.Hash
+}
+
+// SetPendingLimit sets the number of pending commits, i.e. local commits not yet reflected in
+// a pull, past which each local commit calls h. Every pending commit is replayed by each pull,
+// so clients that are offline for a long time make pulls increasingly slow; the handler lets
+// apps warn the user or stop writing. If h is nil a warning is logged instead. A limit of
+// zero, the default, disables the check.
+//
+// Pending commits are not coalesced, since the server acknowledges mutations by counting them.
+//
+// h is called with the database lock held, so it must not call back into the DB.
+func (db *DB) SetPendingLimit(n int, h PendingLimitHandler) {
+	defer db.lock()()
+	if n < 0 {
+		n = 0
+	}
+	db.pendingLimit = pendingLimit{max: n, handler: h}
+}
+
+// PendingLimit returns the limit set by SetPendingLimit.
+func (db *DB) PendingLimit() int {
+	defer db.rlock()()
+	return db.pendingLimit.max
+}
+
+// checkPendingLimit is called after the local commit c, based on basis, and calls the pending
+// limit handler if c is past the limit. Callers must hold the lock.
+func (db *DB) checkPendingLimit(basis, c Commit) {
+	pl := &db.pendingLimit
+	if pl.max == 0 {
+		return
+	}
+	if pl.head != basis.Original.Hash() {
+		_, pending, err := pendingCommits(db.noms, basis)
+		if err != nil {
+			log.Printf("Could not count pending commits: %s", err)
+			return
+		}
+		pl.count = len(pending)
+	}
+	pl.count++
+	pl.head = c.Original.Hash()
+	if pl.count <= pl.max {
+		return
+	}
+	if pl.handler == nil {
+		log.Printf("Warning: %d pending commits exceeds limit of %d - pull to reduce the cost of replaying them", pl.count, pl.max)
+		return
+	}
+	pl.handler(pl.count)
+}
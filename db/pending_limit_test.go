@@ -0,0 +1,48 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingLimit(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	var calls []int
+	db.SetPendingLimit(2, func(pending int) {
+		calls = append(calls, pending)
+	})
+	assert.Equal(2, db.PendingLimit())
+
+	assert.NoError(db.Put("a", []byte(`"1"`)))
+	assert.NoError(db.Put("b", []byte(`"2"`)))
+	assert.Equal([]int(nil), calls)
+	assert.NoError(db.Put("c", []byte(`"3"`)))
+	_, err := db.Del("a")
+	assert.NoError(err)
+	assert.Equal([]int{3, 4}, calls)
+	si, err := db.SyncInfo()
+	assert.NoError(err)
+	assert.Equal(4, si.PendingMutations)
+	assert.Equal(2, si.PendingLimit)
+
+	// Pulled mutations no longer count.
+	m := db.head.Data(db.noms)
+	g := NewGenesis(db.noms, "s1", m, 4)
+	assert.NoError(db.SetHead(g))
+	calls = nil
+	assert.NoError(db.Put("d", []byte(`"4"`)))
+	assert.NoError(db.Put("e", []byte(`"5"`)))
+	assert.Equal([]int(nil), calls)
+	assert.NoError(db.Put("f", []byte(`"6"`)))
+	assert.Equal([]int{3}, calls)
+
+	// Zero disables the limit.
+	db.SetPendingLimit(0, func(pending int) {
+		calls = append(calls, pending)
+	})
+	assert.NoError(db.Put("g", []byte(`"7"`)))
+	assert.Equal([]int{3}, calls)
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/attic-labs/noms/go/types"
+
+	jsnoms "roci.dev/diff-server/util/noms/json"
+)
+
+// projection selects fields of values, as parsed JSON Pointers.
+type projection [][]string
+
+func compileProjection(fields []string) (projection, error) {
+	var p projection
+	for _, f := range fields {
+		path, err := parsePointer(f)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, path)
+	}
+	return p, nil
+}
+
+// apply returns an object containing just the selected fields of v, at the same paths as in
+// v. Intermediate arrays become objects keyed by index. Fields that v does not have are
+// omitted.
+func (p projection) apply(noms types.ValueReadWriter, v types.Value) (types.Value, error) {
+	doc, err := decodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	for _, path := range p {
+		if len(path) == 0 {
+			// The empty pointer selects the whole value.
+			return v, nil
+		}
+		f, ok := resolvePointer(doc, path)
+		if ok {
+			setPointer(out, path, f)
+		}
+	}
+	buf, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return jsnoms.FromJSON(bytes.NewReader(buf), noms)
+}
+
+// setPointer sets the field of doc at path to v, creating intermediate objects as needed.
+func setPointer(doc map[string]interface{}, path []string, v interface{}) {
+	for _, tok := range path[:len(path)-1] {
+		next, ok := doc[tok]
+		if !ok {
+			next = map[string]interface{}{}
+			doc[tok] = next
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			// An enclosing field was already selected in its entirety.
+			return
+		}
+		doc = m
+	}
+	doc[path[len(path)-1]] = v
+}
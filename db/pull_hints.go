@@ -0,0 +1,50 @@
+package db
+
+import (
+	"net/http"
+	"strconv"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/util/verbose"
+)
+
+// pollIntervalHeader is set on pull responses by servers that want clients to pull at a
+// particular interval. Its value is a number of seconds.
+const pollIntervalHeader = "X-Replicache-Poll-Interval"
+
+// pullHints are the scheduling hints sent by the server with the most recent pull response.
+type pullHints struct {
+	// retryAt is when the server asked to be pulled again, from the Retry-After header. Servers
+	// typically send it with 429 and 503 responses during incidents.
+	retryAt gtime.Time
+	// pollInterval is how often the server asked to be pulled.
+	pollInterval gtime.Duration
+}
+
+// parsePullHints reads scheduling hints from pull response headers. Malformed hints are ignored.
+func parsePullHints(h http.Header, now gtime.Time) pullHints {
+	var ph pullHints
+	if s := h.Get("Retry-After"); s != "" {
+		// Retry-After is either a number of seconds or an HTTP date.
+		if secs, err := strconv.ParseUint(s, 10, 32); err == nil {
+			ph.retryAt = now.Add(gtime.Duration(secs) * gtime.Second)
+		} else if t, err := http.ParseTime(s); err == nil {
+			ph.retryAt = t
+		} else {
+			verbose.Log("Ignoring malformed Retry-After header: %s", s)
+		}
+	}
+	if s := h.Get(pollIntervalHeader); s != "" {
+		if secs, err := strconv.ParseUint(s, 10, 32); err == nil {
+			ph.pollInterval = gtime.Duration(secs) * gtime.Second
+		} else {
+			verbose.Log("Ignoring malformed %s header: %s", pollIntervalHeader, s)
+		}
+	}
+	return ph
+}
+
+func (db *DB) setPullHints(ph pullHints) {
+	defer db.lock()()
+	db.pullHints = ph
+}
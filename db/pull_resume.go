@@ -0,0 +1,102 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+)
+
+// PULL_RESUME_DATASET holds the progress of an interrupted pull, so that the next pull can
+// resume it rather than downloading the whole patch again. It is removed when a pull
+// completes, and is not synced.
+const PULL_RESUME_DATASET = "pullresume"
+
+// patchCheckpoint is a checkpoint line of a streaming pull response, sent by servers between
+// operations of large patches. It says that after the first Ops operations of the patch, the
+// patched data has Checksum. Ops counts from the start of the whole patch, including the
+// operations skipped by a resumed pull.
+type patchCheckpoint struct {
+	Ops      uint64 `json:"ops"`
+	Checksum string `json:"checksum"`
+}
+
+// pullResume is the progress of a pull as of its last verified checkpoint.
+type pullResume struct {
+	// BaseStateID is the state the patch applies to, and StateID the state it produces.
+	BaseStateID string
+	StateID     string
+	// Ops is the number of operations of the patch applied to Patched.
+	Ops      uint64
+	Patched  types.Ref
+	Checksum string
+}
+
+// pullRequest is the body of a pull request.
+type pullRequest struct {
+	servetypes.PullRequest
+	// HotPrefixes are the prefixes set with SetHotPrefixes, whose operations the server should
+	// send first. Servers that don't know the field ignore it.
+	HotPrefixes []string `json:"hotPrefixes,omitempty"`
+	// Resume asks the server to continue an interrupted pull: to send the patch to the state
+	// StateID, skipping its first Ops operations. Servers that can't resume send the whole patch.
+	Resume *resumeRequest `json:"resume,omitempty"`
+}
+
+type resumeRequest struct {
+	StateID string `json:"stateID"`
+	Ops     uint64 `json:"ops"`
+}
+
+// pullResponse is a pull response, or the first line of a streaming one.
+type pullResponse struct {
+	servetypes.PullResponse
+	// ResumedFrom is the number of operations the server skipped to resume a pull.
+	ResumedFrom uint64 `json:"resumedFrom,omitempty"`
+}
+
+// loadPullResume returns the progress of an interrupted pull from baseStateID, if any.
+func (db *DB) loadPullResume(baseStateID string) (pullResume, bool) {
+	v, ok := db.noms.GetDataset(PULL_RESUME_DATASET).MaybeHeadValue()
+	if !ok {
+		return pullResume{}, false
+	}
+	var r pullResume
+	if err := marshal.Unmarshal(v, &r); err != nil || r.BaseStateID != baseStateID {
+		return pullResume{}, false
+	}
+	return r, true
+}
+
+func (db *DB) savePullResume(r pullResume) error {
+	_, err := db.noms.CommitValue(db.noms.GetDataset(PULL_RESUME_DATASET), marshal.MustMarshal(db.noms, r))
+	return err
+}
+
+func (db *DB) clearPullResume() error {
+	ds := db.noms.GetDataset(PULL_RESUME_DATASET)
+	if !ds.HasHead() {
+		return nil
+	}
+	_, err := db.noms.Delete(ds)
+	return err
+}
+
+// resumedData returns the patched data of r, after checking that resp resumes it.
+func (db *DB) resumedData(r pullResume, ok bool, resp pullResponse) (kv.Map, error) {
+	if !ok || resp.StateID != r.StateID || resp.ResumedFrom != r.Ops {
+		return kv.Map{}, fmt.Errorf("Server resumed pull to %s from operation %d, but no such pull was interrupted", resp.StateID, resp.ResumedFrom)
+	}
+	m, isMap := r.Patched.TargetValue(db.noms).(types.Map)
+	if !isMap {
+		return kv.Map{}, fmt.Errorf("Data of interrupted pull to %s is missing", r.StateID)
+	}
+	checksum, err := kv.ChecksumFromString(r.Checksum)
+	if err != nil {
+		return kv.Map{}, err
+	}
+	return kv.FromNoms(db.noms, m, checksum), nil
+}
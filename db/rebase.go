@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/attic-labs/noms/go/datas"
@@ -19,8 +20,16 @@ import (
 // history is still preserved in the database (e.g. for later debugging). But the
 // effect on the data and from user's point of view is the same as `git rebase`.
 func rebase(db *DB, onto types.Ref, date datetime.DateTime, commit Commit, forkPoint types.Ref) (rebased Commit, err error) {
+	cache := newValueCache(db.noms, db.rebaseCacheSize)
+	defer func() {
+		db.rebaseCacheStats.add(cache.stats)
+	}()
+	return rebaseImpl(db, cache, onto, date, commit, forkPoint)
+}
+
+func rebaseImpl(db *DB, noms types.ValueReadWriter, onto types.Ref, date datetime.DateTime, commit Commit, forkPoint types.Ref) (rebased Commit, err error) {
 	if forkPoint.IsZeroValue() {
-		forkPoint, err = commonAncestor(onto, commit.Ref(), db.Noms())
+		forkPoint, err = commonAncestor(onto, commit.Ref(), noms)
 		if err != nil {
 			return rebased, err
 		}
@@ -29,7 +38,7 @@ func rebase(db *DB, onto types.Ref, date datetime.DateTime, commit Commit, forkP
 	// If we've reached out forkpoint then by definition `onto` is the result.
 	if commit.Ref().Equals(forkPoint) {
 		var r Commit
-		err = marshal.Unmarshal(onto.TargetValue(db.noms), &r)
+		err = marshal.Unmarshal(onto.TargetValue(noms), &r)
 		if err != nil {
 			return Commit{}, err
 		}
@@ -37,11 +46,11 @@ func rebase(db *DB, onto types.Ref, date datetime.DateTime, commit Commit, forkP
 	}
 
 	// Otherwise, we recurse on this commit's basis.
-	oldBasis, err := commit.Basis(db.noms)
+	oldBasis, err := commit.Basis(noms)
 	if err != nil {
 		return Commit{}, err
 	}
-	newBasis, err := rebase(db, onto, date, oldBasis, forkPoint)
+	newBasis, err := rebaseImpl(db, noms, onto, date, oldBasis, forkPoint)
 	if err != nil {
 		return Commit{}, err
 	}
@@ -58,7 +67,7 @@ func rebase(db *DB, onto types.Ref, date datetime.DateTime, commit Commit, forkP
 	switch commit.Type() {
 	case CommitTypeTx:
 		// For Tx transactions, just re-run the tx with the new basis.
-		newData, newDataChecksum, _, _, err = db.execImpl(types.NewRef(newBasis.Original), commit.Meta.Tx.Name, commit.Meta.Tx.Args)
+		newData, newDataChecksum, _, _, err = db.execImpl(noms, types.NewRef(newBasis.Original), commit.Meta.Tx.Name, commit.Meta.Tx.Args)
 		if err != nil {
 			return Commit{}, err
 		}
@@ -67,11 +76,11 @@ func rebase(db *DB, onto types.Ref, date datetime.DateTime, commit Commit, forkP
 	case CommitTypeReorder:
 		// Reorder transactions can be recursive. But at the end of the chain there will eventually be an original Tx function.
 		// Find it and re-run it against the new basis.
-		target, err := commit.InitalCommit(db.noms)
+		target, err := commit.InitalCommit(noms)
 		if err != nil {
 			return Commit{}, err
 		}
-		newData, newDataChecksum, _, _, err = db.execImpl(types.NewRef(newBasis.Original), target.Meta.Tx.Name, target.Meta.Tx.Args)
+		newData, newDataChecksum, _, _, err = db.execImpl(noms, types.NewRef(newBasis.Original), target.Meta.Tx.Name, target.Meta.Tx.Args)
 		if err != nil {
 			return Commit{}, err
 		}
@@ -79,8 +88,8 @@ func rebase(db *DB, onto types.Ref, date datetime.DateTime, commit Commit, forkP
 	}
 
 	// Create and return the reorder commit, which will become the basis for the prev frame of the recursive call.
-	newCommit := makeReorder(db.noms, types.NewRef(newBasis.Original), date, types.NewRef(commit.Original), newData, newDataChecksum)
-	db.noms.WriteValue(newCommit.Original)
+	newCommit := makeReorder(noms, types.NewRef(newBasis.Original), date, types.NewRef(commit.Original), newData, newDataChecksum)
+	noms.WriteValue(newCommit.Original)
 	return newCommit, nil
 }
 
@@ -91,3 +100,82 @@ func commonAncestor(r1, r2 types.Ref, noms types.ValueReader) (a types.Ref, err
 	}
 	return fp, nil
 }
+
+// pendingCommits returns the genesis commit that head is based on, along with the commits
+// after it, oldest first. Each of these commits represents one local mutation that has not
+// yet been reflected in a pull from the server. Squash commits are replaced by the commits
+// they squash.
+func pendingCommits(noms types.ValueReader, head Commit) (genesis Commit, pending []Commit, err error) {
+	for c := head; ; {
+		if c.Type() == CommitTypeGenesis {
+			for i, j := 0, len(pending)-1; i < j; i, j = i+1, j-1 {
+				pending[i], pending[j] = pending[j], pending[i]
+			}
+			return c, pending, nil
+		}
+		if isSquash(c) {
+			squashed, err := squashedCommits(noms, c.Meta.Tx.Args)
+			if err != nil {
+				return Commit{}, nil, err
+			}
+			for i := len(squashed) - 1; i >= 0; i-- {
+				pending = append(pending, squashed[i])
+			}
+		} else {
+			pending = append(pending, c)
+		}
+		c, err = c.Basis(noms)
+		if err != nil {
+			return Commit{}, nil, err
+		}
+	}
+}
+
+// replay re-executes pending local commits on top of onto, which is typically a new genesis
+// commit produced by a pull. Commits whose mutation was already applied by the server (as
+// indicated by onto's lastMutationID) are dropped. The replayed history is recorded with
+// Reorder commits, same as rebase.
+//
+// If re-executing a commit fails, onConflict is consulted. If it returns nil the commit is
+// dropped and replay continues, otherwise replay stops and the error is returned. Replay also
+// stops if ctx is done. progress, if non-nil, is called before each commit and once at the end.
+func replay(ctx context.Context, db *DB, onto Commit, date datetime.DateTime, firstMutationID uint64, pending []Commit, onConflict ConflictHandler, progress func(RebaseProgress)) (Commit, error) {
+	cache := newValueCache(db.noms, db.rebaseCacheSize)
+	defer func() {
+		db.rebaseCacheStats.add(cache.stats)
+	}()
+	report := func(i int) {
+		if progress != nil {
+			progress(RebaseProgress{Replayed: i, Total: len(pending)})
+		}
+	}
+	head := onto
+	for i, c := range pending {
+		report(i)
+		if err := ctx.Err(); err != nil {
+			return Commit{}, err
+		}
+		if firstMutationID+uint64(i) <= onto.Meta.Genesis.LastMutationID {
+			continue
+		}
+		initial, err := c.InitalCommit(cache)
+		if err == nil {
+			var newData types.Ref
+			var newDataChecksum types.String
+			newData, newDataChecksum, _, _, err = db.execImpl(cache, head.Ref(), initial.Meta.Tx.Name, initial.Meta.Tx.Args)
+			if err == nil {
+				head = makeReorder(cache, head.Ref(), date, c.Ref(), newData, newDataChecksum)
+				cache.WriteValue(head.Original)
+				continue
+			}
+		}
+		if onConflict == nil {
+			return Commit{}, fmt.Errorf("Could not replay commit %s: %w", c.Original.Hash(), err)
+		}
+		if err := onConflict(c, err); err != nil {
+			return Commit{}, err
+		}
+	}
+	report(len(pending))
+	return head, nil
+}
@@ -1,9 +1,15 @@
 package db
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/attic-labs/noms/go/spec"
 	"github.com/attic-labs/noms/go/types"
 	"github.com/attic-labs/noms/go/util/datetime"
 	"github.com/stretchr/testify/assert"
@@ -59,6 +65,7 @@ func TestRebase(t *testing.T) {
 			epoch,
 			".putValue",                          // function
 			list("foo", arg),                     // args
+			nil,                                  // tags
 			write(m.NomsMap()), m.NomsChecksum()) // result data
 		write(r.Original)
 		return r
@@ -163,3 +170,182 @@ func TestRebase(t *testing.T) {
 		test(b, roc, expected, "")
 	})()
 }
+
+// rebaseModel is a simple model of the data a client should see as local commits, pulls, and
+// branch merges are interleaved.
+type rebaseModel struct {
+	server map[string]string
+	lmid   uint64
+	// pending are the local mutations the server has not yet applied.
+	pending []modelOp
+	// branch is the open branch's data and mutations, if any.
+	branch     map[string]string
+	branchOps  []modelOp
+	branchOpen bool
+}
+
+// modelOp puts value at key, or deletes key if value is empty.
+type modelOp struct {
+	key, value string
+}
+
+func (op modelOp) apply(data map[string]string) {
+	if op.value == "" {
+		delete(data, op.key)
+	} else {
+		data[op.key] = op.value
+	}
+}
+
+func applyOps(base map[string]string, ops []modelOp) map[string]string {
+	r := map[string]string{}
+	for k, v := range base {
+		r[k] = v
+	}
+	for _, op := range ops {
+		op.apply(r)
+	}
+	return r
+}
+
+func (m *rebaseModel) local() map[string]string {
+	return applyOps(m.server, m.pending)
+}
+
+// TestRebaseModel performs random interleavings of local mutations, pulls, and branch merges,
+// which rebase and replay local commits, and checks the resulting data against rebaseModel.
+func TestRebaseModel(t *testing.T) {
+	assert := assert.New(t)
+	keys := []string{"a", "b", "c", "d"}
+
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	scanData := func(items []ScanItem, err error) map[string]string {
+		assert.NoError(err)
+		r := map[string]string{}
+		for _, it := range items {
+			b, err := json.Marshal(it.Value)
+			assert.NoError(err)
+			r[it.ID] = string(b)
+		}
+		return r
+	}
+
+	for seed := int64(1); seed <= 20; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		db, _ := LoadTempDB(assert)
+		m := &rebaseModel{server: map[string]string{}}
+		var b Branch
+		steps := []string{}
+
+		// randomOp returns a put, or a delete of a key that exists in data. Deletes of missing
+		// keys are not modeled because they don't produce a commit.
+		randomOp := func(data map[string]string) modelOp {
+			k := keys[rnd.Intn(len(keys))]
+			if _, ok := data[k]; ok && rnd.Intn(3) == 0 {
+				return modelOp{key: k}
+			}
+			return modelOp{key: k, value: fmt.Sprintf(`"v%d"`, rnd.Intn(100))}
+		}
+
+		for i := 0; i < 40; i++ {
+			var label string
+			switch n := rnd.Intn(10); {
+			case n < 4:
+				op := randomOp(m.local())
+				label = fmt.Sprintf("local %v", op)
+				if op.value == "" {
+					ok, err := db.Del(op.key)
+					assert.NoError(err)
+					assert.True(ok)
+				} else {
+					assert.NoError(db.Put(op.key, []byte(op.value)))
+				}
+				m.pending = append(m.pending, op)
+
+			case n < 6:
+				// The model doesn't cover branches that span pulls.
+				if m.branchOpen {
+					continue
+				}
+				// The server applies some of the pending mutations and changes some data itself.
+				acked := rnd.Intn(len(m.pending) + 1)
+				m.server = applyOps(m.server, m.pending[:acked])
+				m.pending = m.pending[acked:]
+				m.lmid += uint64(acked)
+				if rnd.Intn(2) == 0 {
+					randomOp(m.server).apply(m.server)
+				}
+				label = fmt.Sprintf("pull acking %d", acked)
+
+				kvs := []string{}
+				patch := []string{`{"op":"remove","path":"/"}`}
+				for k, v := range m.server {
+					kvs = append(kvs, k, v)
+					patch = append(patch, fmt.Sprintf(`{"op":"add","path":"/%s","value":%s}`, k, v))
+				}
+				body = fmt.Sprintf(`{"patch":[%s],"stateID":"%032d","checksum":"%s","lastMutationID":%d}`,
+					strings.Join(patch, ","), i, kv.NewMapForTest(db.noms, kvs...).Checksum(), m.lmid)
+				_, err := db.Pull(sp, "", nil)
+				assert.NoError(err, label)
+
+			case n < 7:
+				if m.branchOpen {
+					continue
+				}
+				label = "branch"
+				b, err = db.CreateBranch("model")
+				assert.NoError(err)
+				m.branchOpen = true
+				m.branch = m.local()
+				m.branchOps = nil
+
+			case n < 9:
+				if !m.branchOpen {
+					continue
+				}
+				op := randomOp(m.branch)
+				label = fmt.Sprintf("branch %v", op)
+				if op.value == "" {
+					ok, err := b.Del(op.key)
+					assert.NoError(err)
+					assert.True(ok)
+				} else {
+					assert.NoError(b.Put(op.key, []byte(op.value)))
+				}
+				op.apply(m.branch)
+				m.branchOps = append(m.branchOps, op)
+				assert.Equal(m.branch, scanData(b.Scan(ScanOptions{})), "seed %d: %s", seed, label)
+
+			default:
+				if !m.branchOpen {
+					continue
+				}
+				if rnd.Intn(3) == 0 {
+					label = "discard"
+					assert.NoError(b.Discard())
+				} else {
+					label = "merge"
+					assert.NoError(b.Merge())
+					m.pending = append(m.pending, m.branchOps...)
+				}
+				m.branchOpen = false
+			}
+
+			steps = append(steps, label)
+			if !assert.Equal(m.local(), scanData(db.Scan(ScanOptions{})), "seed %d: %s", seed, strings.Join(steps, "; ")) {
+				break
+			}
+			si, err := db.SyncInfo()
+			assert.NoError(err)
+			assert.Equal(len(m.pending), si.PendingMutations, "seed %d: %s", seed, strings.Join(steps, "; "))
+			assert.Equal(m.lmid, si.LastMutationID)
+		}
+	}
+}
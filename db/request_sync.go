@@ -2,10 +2,12 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -13,7 +15,9 @@ import (
 	servetypes "roci.dev/diff-server/serve/types"
 	"roci.dev/diff-server/util/chk"
 	"roci.dev/diff-server/util/countingreader"
+	"roci.dev/diff-server/util/time"
 
+	"github.com/attic-labs/noms/go/datas"
 	"github.com/attic-labs/noms/go/marshal"
 	"github.com/attic-labs/noms/go/spec"
 	"github.com/attic-labs/noms/go/types"
@@ -25,7 +29,49 @@ type PullAuthError struct {
 	error
 }
 
-type Progress func(bytesReceived, bytesExpected uint64)
+// PullPhase identifies which part of a pull is in progress.
+type PullPhase uint8
+
+const (
+	PullPhaseDownloading PullPhase = iota
+	PullPhaseApplying
+	PullPhaseCommitting
+)
+
+func (p PullPhase) String() string {
+	switch p {
+	case PullPhaseDownloading:
+		return "downloading"
+	case PullPhaseApplying:
+		return "applying"
+	case PullPhaseCommitting:
+		return "committing"
+	}
+	chk.Fail("NOTREACHED")
+	return ""
+}
+
+// PullProgress describes how far along a pull is. Byte counts are only meaningful once
+// downloading has started, and op counts once applying has started.
+type PullProgress struct {
+	Phase         PullPhase
+	BytesReceived uint64
+	BytesExpected uint64
+	OpsApplied    uint64
+	OpsExpected   uint64
+	// HotPrefixes is the progress of applying operations for each prefix set with
+	// SetHotPrefixes.
+	HotPrefixes []PrefixProgress
+	// HotPrefixesApplied is set once an operation for a key outside the hot prefixes has been
+	// applied. Servers that honor hot prefixes send their operations first, so from then on
+	// the data for them is complete, though it is only visible once the pull commits.
+	HotPrefixesApplied bool
+}
+
+type Progress func(p PullProgress)
+
+// applyBatchSize is the number of patch operations applied between progress reports.
+var applyBatchSize = 500
 
 func findGenesis(noms types.ValueReadWriter, c Commit) (Commit, error) {
 	if c.Type() == CommitTypeGenesis {
@@ -53,17 +99,56 @@ func findGenesis(noms types.ValueReadWriter, c Commit) (Commit, error) {
 const sandboxAuthorization = "sandbox"
 
 // Pull pulls new server state from the client side.
+//
+// The patch is downloaded and applied to a detached map without holding the database lock,
+// so local writes are not blocked while a pull is in progress. The lock is only taken to
+// swap in the new head.
 func (db *DB) Pull(remote spec.Spec, clientViewAuth string, progress Progress) (servetypes.ClientViewInfo, error) {
-	genesis, err := findGenesis(db.noms, db.head)
+	return db.PullCtx(context.Background(), remote, clientViewAuth, progress)
+}
+
+// PullCtx is like Pull but is abandoned if ctx is done before the new head is swapped in,
+// in which case the local head is left unchanged.
+func (db *DB) PullCtx(ctx context.Context, remote spec.Spec, clientViewAuth string, progress Progress) (info servetypes.ClientViewInfo, err error) {
+	unlock := db.lock()
+	head := db.head
+	bandwidth := db.bandwidth
+	events := db.events
+	hot := newHotProgress(db.hotPrefixes)
+	unlock()
+
+	events.add(Event{Type: EventPullStart, Remote: remote.String()})
+	defer func() {
+		if err != nil {
+			events.add(Event{Type: EventPullError, Remote: remote.String(), Error: err.Error()})
+		} else {
+			events.add(Event{Type: EventPullFinish, Remote: remote.String()})
+		}
+	}()
+
+	if err := bandwidth.check(time.Now()); err != nil {
+		return servetypes.ClientViewInfo{}, err
+	}
+
+	genesis, err := findGenesis(db.noms, head)
 	if err != nil {
 		return servetypes.ClientViewInfo{}, err
 	}
 	url := fmt.Sprintf("%s/pull", remote.String())
-	pullReq, err := json.Marshal(servetypes.PullRequest{
-		ClientViewAuth: clientViewAuth,
-		ClientID:       db.clientID,
-		BaseStateID:    genesis.Meta.Genesis.ServerStateID,
-		Checksum:       string(genesis.Value.Checksum),
+	resume, canResume := db.loadPullResume(genesis.Meta.Genesis.ServerStateID)
+	var resumeReq *resumeRequest
+	if canResume {
+		resumeReq = &resumeRequest{StateID: resume.StateID, Ops: resume.Ops}
+	}
+	pullReq, err := json.Marshal(pullRequest{
+		PullRequest: servetypes.PullRequest{
+			ClientViewAuth: clientViewAuth,
+			ClientID:       db.clientID,
+			BaseStateID:    genesis.Meta.Genesis.ServerStateID,
+			Checksum:       string(genesis.Value.Checksum),
+		},
+		HotPrefixes: hot.prefixNames(),
+		Resume:      resumeReq,
 	})
 	verbose.Log("Pulling: %s from baseStateID %s", url, genesis.Meta.Genesis.ServerStateID)
 	verbose.Log("Pulling: clientViewAuth: %s", clientViewAuth)
@@ -73,14 +158,55 @@ func (db *DB) Pull(remote spec.Spec, clientViewAuth string, progress Progress) (
 	if err != nil {
 		return servetypes.ClientViewInfo{}, err
 	}
+	req = req.WithContext(ctx)
+	// Servers may stream large patches to clients that accept it.
+	req.Header.Set("Accept", "application/json, "+streamingPatchContentType)
 	req.Header.Add("Authorization", sandboxAuthorization) // TODO expose this in the constructor so clients can set it
-	resp, err := http.DefaultClient.Do(req)
+	// The server state ID is sent as an entity tag so that the server can answer with a cheap
+	// 304 if nothing has changed since the last pull.
+	if id := genesis.Meta.Genesis.ServerStateID; id != "" {
+		req.Header.Set("If-None-Match", fmt.Sprintf(`"%s"`, id))
+	}
+
+	var resp *http.Response
+	var loggedResp *truncatingBuffer
+	if db.wireLog.enabled() {
+		e := db.newWireLogEntry(req, genesis)
+		loggedResp = &truncatingBuffer{max: wireLogMaxBody}
+		defer func() {
+			if resp != nil {
+				e.Status = resp.StatusCode
+				e.ResponseHeaders = redactHeaders(resp.Header)
+				e.ResponseBody = loggedResp.String()
+			}
+			if err != nil {
+				e.Error = err.Error()
+			}
+			db.wireLog.add(e)
+		}()
+	}
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		return servetypes.ClientViewInfo{}, err
 	}
+	// Hints are recorded whatever the status, since servers send Retry-After with errors.
+	db.setPullHints(parsePullHints(resp.Header, time.Now()))
+	received := &byteCounter{r: resp.Body}
+	defer func() {
+		bandwidth.record(remote.String(), uint64(len(pullReq)), received.n, time.Now())
+	}()
+	var respBody io.Reader = received
+	if loggedResp != nil {
+		respBody = io.TeeReader(received, loggedResp)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		verbose.Log("Pull: %s not modified since %s", url, genesis.Meta.Genesis.ServerStateID)
+		return servetypes.ClientViewInfo{}, nil
+	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
+		body, err := ioutil.ReadAll(respBody)
 		var s string
 		if err == nil {
 			s = string(body)
@@ -105,11 +231,17 @@ func (db *DB) Pull(remote spec.Spec, clientViewAuth string, progress Progress) (
 		return 0, nil
 	}
 
-	var pullResp servetypes.PullResponse
-	var r io.Reader = resp.Body
+	var pullResp pullResponse
+	r := respBody
+	var pp PullProgress
+	report := func() {
+		if progress != nil {
+			progress(pp)
+		}
+	}
 	if progress != nil {
 		cr := &countingreader.Reader{
-			R: resp.Body,
+			R: respBody,
 		}
 		expected, err := getExpectedLength()
 		if err != nil {
@@ -123,11 +255,14 @@ func (db *DB) Pull(remote spec.Spec, clientViewAuth string, progress Progress) (
 			} else if rec > exp {
 				rec = exp
 			}
-			progress(rec, exp)
+			pp.BytesReceived = rec
+			pp.BytesExpected = exp
+			report()
 		}
 		r = cr
 	}
-	err = json.NewDecoder(r).Decode(&pullResp)
+	dec := json.NewDecoder(r)
+	err = dec.Decode(&pullResp)
 	if err != nil {
 		return servetypes.ClientViewInfo{}, fmt.Errorf("Response from %s is not valid JSON: %s", url, err.Error())
 	}
@@ -135,9 +270,71 @@ func (db *DB) Pull(remote spec.Spec, clientViewAuth string, progress Progress) (
 	if pullResp.LastMutationID < genesis.Meta.Genesis.LastMutationID {
 		return pullResp.ClientViewInfo, fmt.Errorf("Client view lastMutationID %d is < previous lastMutationID %d; ignoring", pullResp.LastMutationID, genesis.Meta.Genesis.LastMutationID)
 	}
-	patchedMap, err := kv.ApplyPatch(db.Noms(), genesis.Data(db.noms), pullResp.Patch)
-	if err != nil {
-		return pullResp.ClientViewInfo, errors.Wrap(err, "couldnt apply patch")
+
+	// The patch is applied in batches so that progress can be reported for large patches,
+	// which can take longer to apply than to download on slow devices.
+	pp.Phase = PullPhaseApplying
+	var ops patchSource
+	streaming := isStreamingPatch(resp.Header.Get("Content-Type"))
+	if streaming {
+		ops = &streamingPatch{dec: dec}
+	} else {
+		ops = &bufferedPatch{ops: pullResp.Patch}
+		pp.OpsExpected = uint64(len(pullResp.Patch))
+	}
+	report()
+	patchedMap := genesis.Data(db.noms)
+	if pullResp.ResumedFrom > 0 {
+		patchedMap, err = db.resumedData(resume, canResume, pullResp)
+		if err != nil {
+			return pullResp.ClientViewInfo, err
+		}
+	}
+	// applied counts operations from the start of the whole patch, as checkpoints do.
+	applied := pullResp.ResumedFrom
+	for {
+		if err := ctx.Err(); err != nil {
+			return pullResp.ClientViewInfo, err
+		}
+		batch, err := ops.next(applyBatchSize)
+		if err != nil {
+			return pullResp.ClientViewInfo, fmt.Errorf("Response from %s is not valid: %s", url, err.Error())
+		}
+		cp := ops.checkpoint()
+		if len(batch) == 0 && cp == nil {
+			break
+		}
+		if len(batch) > 0 {
+			patchedMap, err = kv.ApplyPatch(db.Noms(), patchedMap, batch)
+			if err != nil {
+				return pullResp.ClientViewInfo, errors.Wrap(err, "couldnt apply patch")
+			}
+			applied += uint64(len(batch))
+			pp.OpsApplied += uint64(len(batch))
+			hot.add(batch)
+			hot.report(&pp)
+			if streaming {
+				// The length of a streaming patch isn't known until it has all arrived.
+				pp.OpsExpected = pp.OpsApplied
+			}
+			report()
+		}
+		if cp != nil {
+			if cp.Ops != applied || cp.Checksum != patchedMap.Checksum() {
+				return pullResp.ClientViewInfo, fmt.Errorf("Checkpoint mismatch! Expected %d ops with checksum %s, got %d ops with checksum %s", cp.Ops, cp.Checksum, applied, patchedMap.Checksum())
+			}
+			// Failing to save progress only loses the progress, so it doesn't fail the pull.
+			err = db.savePullResume(pullResume{
+				BaseStateID: genesis.Meta.Genesis.ServerStateID,
+				StateID:     pullResp.StateID,
+				Ops:         applied,
+				Patched:     db.noms.WriteValue(patchedMap.NomsMap()),
+				Checksum:    patchedMap.Checksum(),
+			})
+			if err != nil {
+				log.Printf("Could not save pull progress: %s", err)
+			}
+		}
 	}
 	expectedChecksum, err := kv.ChecksumFromString(pullResp.Checksum)
 	if err != nil {
@@ -146,8 +343,94 @@ func (db *DB) Pull(remote spec.Spec, clientViewAuth string, progress Progress) (
 	if patchedMap.Checksum() != expectedChecksum.String() {
 		return pullResp.ClientViewInfo, fmt.Errorf("Checksum mismatch! Expected %s, got %s", expectedChecksum, patchedMap.Checksum())
 	}
-	newHead := makeGenesis(db.noms, pullResp.StateID, db.noms.WriteValue(patchedMap.NomsMap()), patchedMap.NomsChecksum(), pullResp.LastMutationID)
-	db.noms.SetHead(db.noms.GetDataset(LOCAL_DATASET), db.noms.WriteValue(marshal.MustMarshal(db.noms, newHead)))
+	pp.Phase = PullPhaseCommitting
+	report()
+
+	defer db.lock()()
+	if err := ctx.Err(); err != nil {
+		return pullResp.ClientViewInfo, err
+	}
+	// The patch is relative to the synced state the pull started from. If another pull, a seed,
+	// or a reset moved the synced state meanwhile, the patched map doesn't follow from it.
+	current, err := findGenesis(db.noms, db.head)
+	if err != nil {
+		return pullResp.ClientViewInfo, err
+	}
+	if current.Original.Hash() != genesis.Original.Hash() {
+		return pullResp.ClientViewInfo, fmt.Errorf("%w: synced state changed during pull", ErrWriteConflict)
+	}
+	newGenesis := makeGenesis(db.noms, pullResp.StateID, db.noms.WriteValue(patchedMap.NomsMap()), patchedMap.NomsChecksum(), pullResp.LastMutationID)
+	_, err = db.rebaseOnto(ctx, newGenesis, RebaseOptions{})
+	if err != nil {
+		return pullResp.ClientViewInfo, err
+	}
+	// The pull has been committed, so a leftover resume point must not fail it. It no longer
+	// matches the base state, so it won't be used.
+	if err := db.clearPullResume(); err != nil {
+		log.Printf("Could not clear pull progress: %s", err)
+	}
+	return pullResp.ClientViewInfo, nil
+}
+
+// rebaseOnto makes newGenesis the synced state and replays the pending local commits on top
+// of it, as described by Rebase, and returns the new head. Local commits made since a pull
+// started are picked up here, since the head is re-read under the lock, which the caller must
+// hold. If the head is moved concurrently, e.g. by another process, the pending commits are
+// re-read and replayed again.
+func (db *DB) rebaseOnto(ctx context.Context, newGenesis Commit, opts RebaseOptions) (Commit, error) {
+	for attempt := 1; ; attempt++ {
+		head, err := db.tryRebaseOnto(ctx, newGenesis, opts)
+		if err != datas.ErrMergeNeeded && err != datas.ErrOptimisticLockFailed {
+			return head, err
+		}
+		if attempt >= db.maxWriteAttempts {
+			return Commit{}, fmt.Errorf("%w: could not rebase after %d attempts", ErrWriteConflict, attempt)
+		}
+		log.Printf("Retrying rebase after concurrent change: %s", err)
+		db.noms.Rebase()
+		if err := db.init(); err != nil {
+			return Commit{}, err
+		}
+	}
+}
 
-	return pullResp.ClientViewInfo, db.init()
+func (db *DB) tryRebaseOnto(ctx context.Context, newGenesis Commit, opts RebaseOptions) (Commit, error) {
+	oldGenesis, pending, err := pendingCommits(db.noms, db.head)
+	if err != nil {
+		return Commit{}, err
+	}
+	onConflict := db.onConflict
+	var conflicts []RebaseConflict
+	if opts.DryRun {
+		onConflict = func(c Commit, err error) error {
+			conflicts = append(conflicts, RebaseConflict{Commit: c, Err: err})
+			return nil
+		}
+	}
+	newHead, err := replay(ctx, db, newGenesis, time.DateTime(), oldGenesis.Meta.Genesis.LastMutationID+1, pending, onConflict, opts.Progress)
+	if err != nil {
+		return Commit{}, err
+	}
+	if opts.DryRun {
+		if len(conflicts) > 0 {
+			return Commit{}, &RebaseConflictError{Conflicts: conflicts}
+		}
+		return newHead, nil
+	}
+	ds := db.noms.GetDataset(LOCAL_DATASET)
+	// Unlike Commit, SetHead doesn't check that the head is still the one the pending commits
+	// were read from.
+	if ds.HeadRef().TargetHash() != db.head.Original.Hash() {
+		return Commit{}, datas.ErrMergeNeeded
+	}
+	_, err = db.noms.SetHead(ds, db.noms.WriteValue(newHead.Original))
+	if err != nil {
+		return Commit{}, err
+	}
+	if err := db.init(); err != nil {
+		return Commit{}, err
+	}
+	db.events.add(Event{Type: EventRebase, Head: db.head.Original.Hash().String(), Pending: len(pending)})
+	db.headChanged()
+	return db.head, nil
 }
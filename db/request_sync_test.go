@@ -1,7 +1,9 @@
 package db
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -245,6 +247,20 @@ func TestPull(t *testing.T) {
 			0,
 			"",
 		},
+		{
+			"not-modified",
+			map[string]string{"foo": `"bar"`},
+			"11111111111111111111111111111111",
+			false,
+			http.StatusNotModified,
+			``,
+			"",
+			map[string]string{"foo": `"bar"`},
+			"11111111111111111111111111111111",
+			1,
+			0,
+			"",
+		},
 		{
 			"refuse-to-travel-backwards-in-time",
 			map[string]string{"foo": `"bar"`},
@@ -288,6 +304,11 @@ func TestPull(t *testing.T) {
 			assert.NoError(err, t.label)
 			assert.Equal(t.initialStateID, reqBody.BaseStateID, t.label)
 			assert.Equal("sandbox", r.Header.Get("Authorization"))
+			if t.initialStateID == "" {
+				assert.Equal("", r.Header.Get("If-None-Match"), t.label)
+			} else {
+				assert.Equal(`"`+t.initialStateID+`"`, r.Header.Get("If-None-Match"), t.label)
+			}
 			assert.NotEqual("", reqBody.ClientID)
 			assert.Equal(clientViewAuth, reqBody.ClientViewAuth)
 			w.WriteHeader(t.respCode)
@@ -378,8 +399,9 @@ func TestProgress(t *testing.T) {
 		reports := []report{}
 		var progress Progress
 		if t.hasProgressHandler {
-			progress = func(bytesReceived, bytesExpected uint64) {
-				reports = append(reports, report{bytesReceived, bytesExpected})
+			progress = func(p PullProgress) {
+				assert.Equal(PullPhaseDownloading, p.Phase, label)
+				reports = append(reports, report{p.BytesReceived, p.BytesExpected})
 			}
 		}
 
@@ -429,3 +451,316 @@ func TestProgress(t *testing.T) {
 		assert.Equal(expected, reports, label)
 	}
 }
+
+func TestProgressPhases(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	defer func(orig int) { applyBatchSize = orig }(applyBatchSize)
+	applyBatchSize = 2
+
+	body := `{"patch":[{"op":"add","path":"/a","value":"a"},{"op":"add","path":"/b","value":"b"},{"op":"add","path":"/c","value":"c"}],"stateID":"11111111111111111111111111111111","checksum":"%s","lastMutationID":1}`
+	m := kv.NewMapForTest(db.noms, "a", `"a"`, "b", `"b"`, "c", `"c"`)
+	body = fmt.Sprintf(body, m.Checksum())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-length", fmt.Sprintf("%d", len(body)))
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	reports := []PullProgress{}
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	_, err = db.Pull(sp, "", func(p PullProgress) {
+		if p.Phase != PullPhaseDownloading {
+			reports = append(reports, p)
+		}
+	})
+	assert.NoError(err)
+
+	l := uint64(len(body))
+	assert.Equal([]PullProgress{
+		{PullPhaseApplying, l, l, 0, 3, nil, false},
+		{PullPhaseApplying, l, l, 2, 3, nil, false},
+		{PullPhaseApplying, l, l, 3, 3, nil, false},
+		{PullPhaseCommitting, l, l, 3, 3, nil, false},
+	}, reports)
+}
+
+func TestPullHotPrefixes(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	defer func(orig int) { applyBatchSize = orig }(applyBatchSize)
+	applyBatchSize = 1
+
+	body := `{"patch":[{"op":"add","path":"/todo~11","value":"b"},{"op":"add","path":"/a","value":"a"},{"op":"add","path":"/c","value":"c"}],"stateID":"11111111111111111111111111111111","checksum":"%s","lastMutationID":1}`
+	m := kv.NewMapForTest(db.noms, "a", `"a"`, "todo/1", `"b"`, "c", `"c"`)
+	body = fmt.Sprintf(body, m.Checksum())
+	var req pullRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(json.NewDecoder(r.Body).Decode(&req))
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	db.SetHotPrefixes([]string{"todo/", "user/"})
+	assert.Equal([]string{"todo/", "user/"}, db.HotPrefixes())
+	type report struct {
+		todo, user uint64
+		applied    bool
+	}
+	reports := []report{}
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	_, err = db.Pull(sp, "", func(p PullProgress) {
+		if p.Phase == PullPhaseApplying && p.OpsApplied > 0 {
+			assert.Equal("todo/", p.HotPrefixes[0].Prefix)
+			reports = append(reports, report{p.HotPrefixes[0].OpsApplied, p.HotPrefixes[1].OpsApplied, p.HotPrefixesApplied})
+		}
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"todo/", "user/"}, req.HotPrefixes)
+	assert.Equal([]report{{1, 0, false}, {1, 0, true}, {1, 0, true}}, reports)
+}
+
+func TestPullStreaming(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(orig int) { applyBatchSize = orig }(applyBatchSize)
+	applyBatchSize = 2
+
+	sdb, _ := LoadTempDB(assert)
+	m := kv.NewMapForTest(sdb.noms, "a", `"a"`, "b", `"b"`, "c", `"c"`)
+	header := fmt.Sprintf(`{"stateID":"11111111111111111111111111111111","checksum":"%s","lastMutationID":1}`, m.Checksum())
+	ops := []string{
+		`{"op":"add","path":"/a","value":"a"}`,
+		`{"op":"add","path":"/b","value":"b"}`,
+		`{"op":"add","path":"/c","value":"c"}`,
+	}
+
+	tc := []struct {
+		label         string
+		lines         []string
+		expectedError string
+	}{
+		{"ok", append([]string{header}, ops...), ""},
+		{"bad-op", []string{header, ops[0], `{"op":`}, "not valid: Invalid patch operation 1"},
+		{"checksum-mismatch", []string{header, ops[0]}, "Checksum mismatch!"},
+	}
+
+	for _, t := range tc {
+		db, _ := LoadTempDB(assert)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Contains(r.Header.Get("Accept"), streamingPatchContentType, t.label)
+			w.Header().Set("Content-Type", streamingPatchContentType+"; charset=utf-8")
+			for _, l := range t.lines {
+				w.Write([]byte(l + "\n"))
+				w.(http.Flusher).Flush()
+			}
+		}))
+		sp, err := spec.ForDatabase(server.URL)
+		assert.NoError(err)
+
+		applied := [][2]uint64{}
+		_, err = db.Pull(sp, "", func(p PullProgress) {
+			// Ops are downloaded while applying, so byte progress is reported in between.
+			a := [2]uint64{p.OpsApplied, p.OpsExpected}
+			if p.Phase == PullPhaseApplying && (len(applied) == 0 || applied[len(applied)-1] != a) {
+				applied = append(applied, a)
+			}
+		})
+		server.Close()
+		if t.expectedError != "" {
+			assert.Error(err, t.label)
+			assert.Contains(err.Error(), t.expectedError, t.label)
+			assert.Equal("", db.head.Meta.Genesis.ServerStateID, t.label)
+			continue
+		}
+		assert.NoError(err, t.label)
+		assert.Equal([][2]uint64{{0, 0}, {2, 2}, {3, 3}}, applied, t.label)
+		assert.Equal("11111111111111111111111111111111", db.head.Meta.Genesis.ServerStateID, t.label)
+		gotChecksum, err := kv.ChecksumFromString(string(db.head.Value.Checksum))
+		assert.NoError(err)
+		assert.Equal(m.Checksum(), gotChecksum.String(), t.label)
+	}
+}
+
+func TestPullResume(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	defer func(orig int) { applyBatchSize = orig }(applyBatchSize)
+	applyBatchSize = 2
+
+	stateID := "11111111111111111111111111111111"
+	m := kv.NewMapForTest(db.noms, "a", `"a"`, "b", `"b"`, "c", `"c"`)
+	cp := kv.NewMapForTest(db.noms, "a", `"a"`)
+	ops := []string{
+		`{"op":"add","path":"/a","value":"a"}`,
+		`{"op":"add","path":"/b","value":"b"}`,
+		`{"op":"add","path":"/c","value":"c"}`,
+	}
+	checkpoint := fmt.Sprintf(`{"checkpoint":{"ops":1,"checksum":"%s"}}`, cp.Checksum())
+
+	var reqs []pullRequest
+	var lines []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req pullRequest
+		assert.NoError(json.NewDecoder(r.Body).Decode(&req))
+		reqs = append(reqs, req)
+		w.Header().Set("Content-Type", streamingPatchContentType)
+		for _, l := range lines {
+			w.Write([]byte(l + "\n"))
+		}
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	// The connection drops after the checkpoint.
+	header := fmt.Sprintf(`{"stateID":"%s","checksum":"%s","lastMutationID":1}`, stateID, m.Checksum())
+	lines = []string{header, ops[0], checkpoint, `{"op":`}
+	_, err = db.Pull(sp, "", nil)
+	assert.Error(err)
+	assert.Nil(reqs[0].Resume)
+	assert.True(db.noms.GetDataset(PULL_RESUME_DATASET).HasHead())
+
+	// A checkpoint that doesn't match the patch fails the pull.
+	lines = []string{header, ops[1], checkpoint}
+	_, err = db.Pull(sp, "", nil)
+	assert.Error(err)
+	assert.Contains(err.Error(), "Checkpoint mismatch!")
+	assert.Equal(&resumeRequest{StateID: stateID, Ops: 1}, reqs[1].Resume)
+
+	header = fmt.Sprintf(`{"stateID":"%s","checksum":"%s","lastMutationID":1,"resumedFrom":1}`, stateID, m.Checksum())
+	lines = []string{header, ops[1], ops[2]}
+	applied := uint64(0)
+	_, err = db.Pull(sp, "", func(p PullProgress) {
+		applied = p.OpsApplied
+	})
+	assert.NoError(err)
+	assert.Equal(&resumeRequest{StateID: stateID, Ops: 1}, reqs[2].Resume)
+	assert.Equal(uint64(2), applied)
+	assert.Equal(stateID, db.head.Meta.Genesis.ServerStateID)
+	gotChecksum, err := kv.ChecksumFromString(string(db.head.Value.Checksum))
+	assert.NoError(err)
+	assert.Equal(m.Checksum(), gotChecksum.String())
+	assert.False(db.noms.GetDataset(PULL_RESUME_DATASET).HasHead())
+
+	// Nothing is resumed once the pull has completed.
+	_, err = db.Pull(sp, "", nil)
+	assert.Error(err)
+	assert.Contains(err.Error(), "no such pull was interrupted")
+	assert.Nil(reqs[3].Resume)
+}
+
+func TestPullDoesNotBlockWrites(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte(`{"patch":[],"stateID":"11111111111111111111111111111111","checksum":"00000000","lastMutationID":0}`))
+	}))
+	defer server.Close()
+
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	pulled := make(chan error)
+	go func() {
+		_, err := db.Pull(sp, "", nil)
+		pulled <- err
+	}()
+
+	<-started
+	put := make(chan error)
+	go func() {
+		put <- db.Put("foo", []byte(`"bar"`))
+	}()
+	select {
+	case err := <-put:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("Put blocked by in-progress pull")
+	}
+
+	close(release)
+	assert.NoError(<-pulled)
+
+	// The write made during the pull is replayed on top of the pulled state.
+	ok, err := db.Has("foo")
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func TestPullReplaysPendingCommits(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	// Mutations 1 and 2. The server has seen the first.
+	assert.NoError(db.Put("foo", []byte(`"foo"`)))
+	assert.NoError(db.Put("bar", []byte(`"bar"`)))
+	putBar := db.Head()
+
+	m := kv.NewMapForTest(db.noms, "baz", `"baz"`, "foo", `"foo"`)
+	body := fmt.Sprintf(`{"patch":[{"op":"add","path":"/baz","value":"baz"},{"op":"add","path":"/foo","value":"foo"}],"stateID":"11111111111111111111111111111111","checksum":"%s","lastMutationID":1}`, m.Checksum())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	_, err = db.Pull(sp, "", nil)
+	assert.NoError(err)
+
+	head := db.Head()
+	assert.Equal(CommitTypeReorder, head.Type())
+	assert.True(putBar.Ref().Equals(head.Target()))
+
+	genesis, pending, err := pendingCommits(db.noms, head)
+	assert.NoError(err)
+	assert.Equal(1, len(pending))
+	assert.Equal("11111111111111111111111111111111", genesis.Meta.Genesis.ServerStateID)
+	assert.Equal(uint64(1), genesis.Meta.Genesis.LastMutationID)
+
+	for _, k := range []string{"foo", "bar", "baz"} {
+		v, err := db.Get(k)
+		assert.NoError(err)
+		assert.Equal(fmt.Sprintf(`"%s"`, k), string(v), k)
+	}
+}
+
+func TestPullFailsIfSyncedStateChanges(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+
+	const otherStateID = "22222222222222222222222222222222"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The synced state moves while the pull is in flight, as if by a concurrent pull.
+		unlock := db.lock()
+		empty := kv.NewMap(db.noms)
+		_, err := db.rebaseOnto(context.Background(), makeGenesis(db.noms, otherStateID, db.noms.WriteValue(empty.NomsMap()), empty.NomsChecksum(), 0), RebaseOptions{})
+		unlock()
+		assert.NoError(err)
+		w.Write([]byte(`{"patch":[],"stateID":"11111111111111111111111111111111","checksum":"00000000","lastMutationID":0}`))
+	}))
+	defer server.Close()
+
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	_, err = db.Pull(sp, "", nil)
+	assert.True(errors.Is(err, ErrWriteConflict))
+	genesis, err := findGenesis(db.noms, db.head)
+	assert.NoError(err)
+	assert.Equal(otherStateID, genesis.Meta.Genesis.ServerStateID)
+	// The pending write was replayed onto the new synced state, not lost.
+	v, err := db.Get("foo")
+	assert.NoError(err)
+	assert.Equal(`"bar"`, string(v))
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"github.com/attic-labs/noms/go/types"
+
+	"roci.dev/diff-server/kv"
+)
+
+// Reset discards all local data and history, including pending mutations, checkpoints,
+// branches, and derived data, leaving an empty database. Unlike deleting the database, the
+// client ID and configuration (see SetConfig) are kept, as is the ID of the last mutation the server is known
+// to have applied, so that the server's per-client mutation tracking remains valid. The next
+// pull fetches the full client view.
+//
+// Pending mutations are discarded without being pushed.
+func (db *DB) Reset() error {
+	defer db.lock()()
+	genesis, _, err := pendingCommits(db.noms, db.head)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	db.noms.Datasets().IterAll(func(k, v types.Value) {
+		id := string(k.(types.String))
+		if id != CONFIG_DATASET && id != APP_CONFIG_DATASET && id != LOCAL_DATASET {
+			ids = append(ids, id)
+		}
+	})
+	for _, id := range ids {
+		_, err = db.noms.Delete(db.noms.GetDataset(id))
+		if err != nil {
+			return err
+		}
+	}
+
+	m := kv.NewMap(db.noms)
+	newHead := makeGenesis(db.noms, "", db.noms.WriteValue(m.NomsMap()), m.NomsChecksum(), genesis.Meta.Genesis.LastMutationID)
+	_, err = db.noms.SetHead(db.noms.GetDataset(LOCAL_DATASET), db.noms.WriteValue(newHead.Original))
+	if err != nil {
+		return err
+	}
+	old := db.head
+	db.head = newHead
+	db.headChanged()
+	db.recordAudit(".reset", old, newHead)
+	return nil
+}
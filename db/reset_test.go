@@ -0,0 +1,57 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+)
+
+func TestReset(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+	clientID := db.clientID
+
+	// Simulate a pull that acknowledged mutations up to 7.
+	m := kv.NewMap(db.noms)
+	g := makeGenesis(db.noms, "s1", db.noms.WriteValue(m.NomsMap()), m.NomsChecksum(), 7)
+	_, err = db.noms.SetHead(db.noms.GetDataset(LOCAL_DATASET), db.noms.WriteValue(g.Original))
+	assert.NoError(err)
+	db, err = New(db.noms)
+	assert.NoError(err)
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	assert.NoError(db.Checkpoint("cp"))
+	_, err = db.CreateBranch("draft")
+	assert.NoError(err)
+
+	assert.NoError(db.Reset())
+	ok, err := db.Has("foo")
+	assert.NoError(err)
+	assert.False(ok)
+	assert.EqualError(db.Restore("cp"), "No such checkpoint: cp")
+	_, err = db.Branch("draft")
+	assert.EqualError(err, "No such branch: draft")
+
+	si, err := db.SyncInfo()
+	assert.NoError(err)
+	assert.Equal(SyncInfo{
+		ClientID:         clientID,
+		ServerStateID:    "",
+		LastMutationID:   7,
+		PendingMutations: 0,
+	}, si)
+
+	// The reset is durable and the client ID is kept.
+	db, err = New(db.noms)
+	assert.NoError(err)
+	assert.Equal(clientID, db.clientID)
+	ok, err = db.Has("foo")
+	assert.NoError(err)
+	assert.False(ok)
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/attic-labs/noms/go/types"
@@ -10,14 +13,22 @@ import (
 )
 
 const (
-	defaultScanLimit = 50
+	// DefaultScanLimit is the maximum number of items returned by Scan if Limit is zero.
+	DefaultScanLimit = 50
+	// subtreeEnd follows a common prefix to make a key that sorts after all the keys that
+	// start with the prefix, and before all later keys, since UTF-8 never contains 0xff.
+	subtreeEnd = "\xff"
 )
 
+// ScanID bounds a scan by key. An empty Value is no bound, and cannot be Exclusive.
 type ScanID struct {
 	Value     string `json:"value,omitempty"`
 	Exclusive bool   `json:"exclusive,omitempty"`
 }
 
+// ScanBound is where a scan starts. A scan starts at the last of the bounds that are set: the
+// first key with ScanOptions.Prefix, ID, and Index, which is the position in the whole
+// database, not among the keys with Prefix.
 type ScanBound struct {
 	ID    *ScanID `json:"id,omitempty"`
 	Index *uint64 `json:"index,omitempty"`
@@ -27,20 +38,136 @@ type ScanOptions struct {
 	Prefix string     `json:"prefix,omitempty"`
 	Start  *ScanBound `json:"start,omitempty"`
 	Limit  int        `json:"limit,omitempty"`
+	// Filter restricts results to values matching it. Limit applies to the matching values.
+	Filter *ScanFilter `json:"filter,omitempty"`
+	// IncludeMeta causes each item's KeyMeta to be returned alongside it.
+	IncludeMeta bool `json:"includeMeta,omitempty"`
+	// Fields, if non-empty, are JSON Pointers to the fields of each value to return, e.g.
+	// ["/title", "/done"]. Values are replaced by objects containing just those fields.
+	// Filter applies to the whole value.
+	Fields []string `json:"fields,omitempty"`
+	// KeysOnly causes only the keys of matching items to be returned, which avoids decoding
+	// values that aren't needed.
+	KeysOnly bool `json:"keysOnly,omitempty"`
+	// IncludeSize causes the size of each item's value, in bytes of JSON, to be returned.
+	IncludeSize bool `json:"includeSize,omitempty"`
+	// Delimiter, if set, scans one level of a hierarchy of keys, like listing a directory.
+	// Keys that contain Delimiter after Prefix are not returned. Instead, each distinct
+	// prefix of them up to and including the first such Delimiter is returned once, as an
+	// item with CommonPrefix set, in key order among the other items. Their subtrees are
+	// skipped rather than scanned. Delimiter cannot be used with Filter.
+	Delimiter string `json:"delimiter,omitempty"`
 	// Future: EndAtID, EndBeforeID
 }
 
 type ScanItem struct {
-	ID    string       `json:"id"`
-	Value jsnoms.Value `json:"value"`
+	// ID is the key of the item, or the common prefix if CommonPrefix is set.
+	ID string `json:"id"`
+	// CommonPrefix is set for the items that stand for the keys with a common prefix when
+	// ScanOptions.Delimiter is set. They have no value, size, or meta.
+	CommonPrefix bool `json:"commonPrefix,omitempty"`
+	// Value is nil if ScanOptions.KeysOnly was specified.
+	Value *jsnoms.Value `json:"value,omitempty"`
+	Size  *uint64       `json:"size,omitempty"`
+	Meta  *KeyMeta      `json:"meta,omitempty"`
 }
 
+// Scan returns the items selected by opts in the byte-wise order of their keys. The order
+// depends only on the keys, not on the history of the database or the version of Replicache,
+// so paging by key, as ScanBound allows, is stable.
 func (db *DB) Scan(opts ScanOptions) ([]ScanItem, error) {
-	// TODO fritz clean up
-	return scan(db.head.Data(db.noms).NomsMap(), opts)
+	return db.ScanCtx(context.Background(), opts)
 }
 
-func scan(data types.Map, opts ScanOptions) ([]ScanItem, error) {
+// ScanCtx is like Scan but fails early if ctx is done.
+func (db *DB) ScanCtx(ctx context.Context, opts ScanOptions) ([]ScanItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Views rewrite the options before calling scan, so they are validated up front.
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if isViewKey(opts.Prefix) {
+		return db.scanView(opts)
+	}
+	if isLocalOnlyKey(opts.Prefix) {
+		return db.scanLocalOnly(opts)
+	}
+	if !opts.IncludeMeta {
+		// TODO fritz clean up
+		return scan(db.noms, db.snapshot().Data(db.noms).NomsMap(), opts)
+	}
+
+	head, idx, err := db.keyMeta()
+	if err != nil {
+		return nil, err
+	}
+	items, err := scan(db.noms, head.Data(db.noms).NomsMap(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		if items[i].CommonPrefix {
+			continue
+		}
+		km, ok, err := idx.get(items[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			items[i].Meta = &km
+		}
+	}
+	return items, nil
+}
+
+// validate returns an error if opts are contradictory. In particular, Start.ID must be within
+// Prefix, since a start before the prefix is meaningless and one after it would silently
+// return nothing.
+func (opts ScanOptions) validate() error {
+	if opts.Limit < 0 {
+		return fmt.Errorf("%w: limit must not be negative: %d", ErrInvalidArgument, opts.Limit)
+	}
+	if opts.Delimiter != "" && opts.Filter != nil {
+		return fmt.Errorf("%w: filter cannot be used with delimiter", ErrInvalidArgument)
+	}
+	if opts.Start == nil || opts.Start.ID == nil {
+		return nil
+	}
+	id := opts.Start.ID
+	if id.Value == "" {
+		if id.Exclusive {
+			return fmt.Errorf("%w: start.id.exclusive requires start.id.value", ErrInvalidArgument)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(id.Value, opts.Prefix) {
+		return fmt.Errorf("%w: start.id '%s' is not within prefix '%s'", ErrInvalidArgument, id.Value, opts.Prefix)
+	}
+	return nil
+}
+
+func scan(noms types.ValueReadWriter, data types.Map, opts ScanOptions) ([]ScanItem, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.KeysOnly && len(opts.Fields) > 0 {
+		return nil, errors.New("fields cannot be used with keysOnly")
+	}
+	proj, err := compileProjection(opts.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter *compiledFilter
+	if opts.Filter != nil {
+		filter, err = compileFilter(*opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var it *types.MapIterator
 
 	updateIter := func(cand *types.MapIterator) {
@@ -82,24 +209,91 @@ func scan(data types.Map, opts ScanOptions) ([]ScanItem, error) {
 
 	lim := opts.Limit
 	if lim == 0 {
-		lim = 50
+		lim = DefaultScanLimit
 	}
 
 	res := []ScanItem{}
-	for ; it.Valid(); it.Next() {
+	for it.Valid() {
 		k, v := it.Entry()
+		it.Next()
 		chk.True(k.Kind() == types.StringKind, "Only keys with string kinds are supported, Noms schema check should have caught this")
 		ks := string(k.(types.String))
 		if opts.Prefix != "" && !strings.HasPrefix(ks, opts.Prefix) {
 			break
 		}
-		res = append(res, ScanItem{
-			ID:    ks,
-			Value: jsnoms.Make(nil, v),
-		})
+		if cp, ok := commonPrefix(ks, opts.Prefix, opts.Delimiter); ok {
+			it = data.IteratorFrom(types.String(cp + subtreeEnd))
+			// A scan that continues after a common prefix, as paging does, starts within it.
+			if opts.Start != nil && opts.Start.ID != nil && opts.Start.ID.Exclusive && opts.Start.ID.Value == cp {
+				continue
+			}
+			res = append(res, ScanItem{ID: cp, CommonPrefix: true})
+			if len(res) == lim {
+				break
+			}
+			continue
+		}
+		if filter != nil {
+			ok, err := filter.matches(v)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		item := ScanItem{ID: ks}
+		if opts.IncludeSize {
+			size, err := jsonSize(v)
+			if err != nil {
+				return nil, err
+			}
+			item.Size = &size
+		}
+		if !opts.KeysOnly {
+			if proj != nil {
+				v, err = proj.apply(noms, v)
+				if err != nil {
+					return nil, err
+				}
+			}
+			jv := jsnoms.Make(nil, v)
+			item.Value = &jv
+		}
+		res = append(res, item)
 		if len(res) == lim {
 			break
 		}
 	}
 	return res, nil
 }
+
+// commonPrefix returns the prefix of key up to and including the first delimiter after
+// prefix, if delimiter is set and key contains one.
+func commonPrefix(key, prefix, delimiter string) (string, bool) {
+	if delimiter == "" {
+		return "", false
+	}
+	i := strings.Index(key[len(prefix):], delimiter)
+	if i < 0 {
+		return "", false
+	}
+	return key[:len(prefix)+i+len(delimiter)], true
+}
+
+// jsonSize returns the length of the JSON encoding of v.
+func jsonSize(v types.Value) (uint64, error) {
+	var w countingWriter
+	err := jsnoms.ToJSON(v, &w)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrValueCorrupt, err)
+	}
+	return uint64(w), nil
+}
+
+type countingWriter uint64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
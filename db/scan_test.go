@@ -2,7 +2,9 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/attic-labs/noms/go/spec"
@@ -34,64 +36,86 @@ func TestScan(t *testing.T) {
 	tc := []struct {
 		opts          ScanOptions
 		expected      []string
-		expectedError error
+		expectedError string
 	}{
 		// no options
-		{ScanOptions{}, []string{"0", "a", "ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{}}, []string{"0", "a", "ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{}}}, []string{"0", "a", "ba", "bb"}, nil},
+		{ScanOptions{}, []string{"0", "a", "ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{}}, []string{"0", "a", "ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{}}}, []string{"0", "a", "ba", "bb"}, ""},
 
 		// prefix alone
-		{ScanOptions{Prefix: "a"}, []string{"a"}, nil},
-		{ScanOptions{Prefix: "b"}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Prefix: "b", Limit: 1}, []string{"ba"}, nil},
-		{ScanOptions{Prefix: "b", Limit: 100}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Prefix: "c"}, []string{}, nil},
+		{ScanOptions{Prefix: "a"}, []string{"a"}, ""},
+		{ScanOptions{Prefix: "b"}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Prefix: "b", Limit: 1}, []string{"ba"}, ""},
+		{ScanOptions{Prefix: "b", Limit: 100}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Prefix: "c"}, []string{}, ""},
 
 		// start.id alone
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "a"}}}, []string{"a", "ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "a", Exclusive: true}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "aa"}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "aa", Exclusive: true}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "a"}}, Limit: 2}, []string{"a", "ba"}, nil},
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "bb"}}}, []string{"bb"}, nil},
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "bb", Exclusive: true}}}, []string{}, nil},
-		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "c"}}}, []string{}, nil},
-
-		// start.id and prefix together
-		{ScanOptions{Prefix: "a", Start: &ScanBound{ID: &ScanID{Value: "a"}}}, []string{"a"}, nil},
-		{ScanOptions{Prefix: "a", Start: &ScanBound{ID: &ScanID{Value: "b"}}}, []string{}, nil},
-		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Value: "a"}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Prefix: "c", Start: &ScanBound{ID: &ScanID{Value: "a"}}}, []string{}, nil},
-		{ScanOptions{Prefix: "a", Start: &ScanBound{ID: &ScanID{Value: "c"}}}, []string{}, nil},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "a"}}}, []string{"a", "ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "a", Exclusive: true}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "aa"}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "aa", Exclusive: true}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "a"}}, Limit: 2}, []string{"a", "ba"}, ""},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "bb"}}}, []string{"bb"}, ""},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "bb", Exclusive: true}}}, []string{}, ""},
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Value: "c"}}}, []string{}, ""},
+
+		// start.id and prefix together: the start must be within the prefix
+		{ScanOptions{Prefix: "a", Start: &ScanBound{ID: &ScanID{Value: "a"}}}, []string{"a"}, ""},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{ID: &ScanID{Value: "a", Exclusive: true}}}, []string{}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Value: "b"}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Value: "b", Exclusive: true}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Value: "ba"}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Value: "ba", Exclusive: true}}}, []string{"bb"}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Value: "bb", Exclusive: true}}}, []string{}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Value: "bc"}}}, []string{}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{ID: &ScanID{Value: "b"}}}, nil, "invalid argument: start.id 'b' is not within prefix 'a'"},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Value: "a"}}}, nil, "invalid argument: start.id 'a' is not within prefix 'b'"},
+		{ScanOptions{Prefix: "ba", Start: &ScanBound{ID: &ScanID{Value: "b"}}}, nil, "invalid argument: start.id 'b' is not within prefix 'ba'"},
+		{ScanOptions{Prefix: "c", Start: &ScanBound{ID: &ScanID{Value: "a"}}}, nil, "invalid argument: start.id 'a' is not within prefix 'c'"},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{ID: &ScanID{Value: "c"}}}, nil, "invalid argument: start.id 'c' is not within prefix 'a'"},
+
+		// start.index and prefix together: the index is into the whole database
+		{ScanOptions{Prefix: "b", Start: &ScanBound{Index: index(0)}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{Index: index(3)}}, []string{"bb"}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{Index: index(4)}}, []string{}, ""},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(2)}}, []string{}, ""},
+
+		// invalid bounds and limits
+		{ScanOptions{Start: &ScanBound{ID: &ScanID{Exclusive: true}}}, nil, "invalid argument: start.id.exclusive requires start.id.value"},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{ID: &ScanID{Exclusive: true}}}, nil, "invalid argument: start.id.exclusive requires start.id.value"},
+		{ScanOptions{Limit: -1}, nil, "invalid argument: limit must not be negative: -1"},
 
 		// start.index alone
-		{ScanOptions{Start: &ScanBound{Index: index(0)}}, []string{"0", "a", "ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(1)}}, []string{"a", "ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(1)}, Limit: 2}, []string{"a", "ba"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(4)}}, []string{}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(100)}}, []string{}, nil},
+		{ScanOptions{Start: &ScanBound{Index: index(0)}}, []string{"0", "a", "ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(1)}}, []string{"a", "ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(1)}, Limit: 2}, []string{"a", "ba"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(4)}}, []string{}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(100)}}, []string{}, ""},
 
 		// start.index and start.id together
-		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "b"}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "b", Exclusive: true}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "ba"}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "ba", Exclusive: true}}}, []string{"bb"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(2), ID: &ScanID{Value: "a"}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(2), ID: &ScanID{Value: "a", Exclusive: true}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(4), ID: &ScanID{Value: "a"}}}, []string{}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "bb", Exclusive: true}}}, []string{}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "c"}}}, []string{}, nil},
-		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "z"}}}, []string{}, nil},
+		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "b"}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "b", Exclusive: true}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "ba"}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "ba", Exclusive: true}}}, []string{"bb"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(2), ID: &ScanID{Value: "a"}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(2), ID: &ScanID{Value: "a", Exclusive: true}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(4), ID: &ScanID{Value: "a"}}}, []string{}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "bb", Exclusive: true}}}, []string{}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "c"}}}, []string{}, ""},
+		{ScanOptions{Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "z"}}}, []string{}, ""},
 
 		// prefix, start.index, and start.id together
-		{ScanOptions{Prefix: "b", Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "b"}}}, []string{"ba", "bb"}, nil},
-		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "b"}}}, []string{}, nil},
-		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(0), ID: &ScanID{Value: "b"}}}, []string{}, nil},
-		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(0), ID: &ScanID{Value: "a"}}}, []string{"a"}, nil},
-		{ScanOptions{Prefix: "c", Start: &ScanBound{Index: index(0), ID: &ScanID{Value: "a"}}}, []string{}, nil},
-		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(100), ID: &ScanID{Value: "a"}}}, []string{}, nil},
-		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(0), ID: &ScanID{Value: "z"}}}, []string{}, nil},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "b"}}}, []string{"ba", "bb"}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{Index: index(3), ID: &ScanID{Value: "ba"}}}, []string{"bb"}, ""},
+		{ScanOptions{Prefix: "b", Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "bb"}}}, []string{"bb"}, ""},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(0), ID: &ScanID{Value: "a"}}}, []string{"a"}, ""},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(100), ID: &ScanID{Value: "a"}}}, []string{}, ""},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(1), ID: &ScanID{Value: "b"}}}, nil, "invalid argument: start.id 'b' is not within prefix 'a'"},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(0), ID: &ScanID{Value: "b"}}}, nil, "invalid argument: start.id 'b' is not within prefix 'a'"},
+		{ScanOptions{Prefix: "c", Start: &ScanBound{Index: index(0), ID: &ScanID{Value: "a"}}}, nil, "invalid argument: start.id 'a' is not within prefix 'c'"},
+		{ScanOptions{Prefix: "a", Start: &ScanBound{Index: index(0), ID: &ScanID{Value: "z"}}}, nil, "invalid argument: start.id 'z' is not within prefix 'a'"},
 	}
 
 	for i, t := range tc {
@@ -99,8 +123,9 @@ func TestScan(t *testing.T) {
 		assert.NoError(err)
 		msg := fmt.Sprintf("case %d: %s", i, js)
 		res, err := d.Scan(t.opts)
-		if t.expectedError != nil {
-			assert.Error(t.expectedError, err, msg)
+		if t.expectedError != "" {
+			assert.EqualError(err, t.expectedError, msg)
+			assert.True(errors.Is(err, ErrInvalidArgument), msg)
 			assert.Nil(res, msg)
 			continue
 		}
@@ -112,3 +137,248 @@ func TestScan(t *testing.T) {
 		assert.Equal(t.expected, act, msg)
 	}
 }
+
+func TestScanFilter(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	d, err := Load(sp)
+	assert.NoError(err)
+
+	for k, v := range map[string]string{
+		"t/1": `{"done":false,"pri":1,"title":"a","tags":["x"]}`,
+		"t/2": `{"done":true,"pri":2,"title":"b","tags":["y"]}`,
+		"t/3": `{"done":false,"pri":3,"title":"c","a/b":true}`,
+		"u/1": `{"done":false}`,
+		"u/2": `"scalar"`,
+	} {
+		assert.NoError(d.Put(k, []byte(v)))
+	}
+
+	f := func(path, op, value string) *ScanFilter {
+		return &ScanFilter{Path: path, Op: op, Value: json.RawMessage(value)}
+	}
+
+	tc := []struct {
+		opts          ScanOptions
+		expected      []string
+		expectedError string
+	}{
+		{ScanOptions{Filter: f("/done", "eq", `false`)}, []string{"t/1", "t/3", "u/1"}, ""},
+		{ScanOptions{Filter: f("/done", "ne", `false`)}, []string{"t/2"}, ""},
+		{ScanOptions{Filter: f("/done", "eq", `false`), Prefix: "t/"}, []string{"t/1", "t/3"}, ""},
+		{ScanOptions{Filter: f("/done", "eq", `false`), Limit: 2}, []string{"t/1", "t/3"}, ""},
+		{ScanOptions{Filter: f("/pri", "gt", `1`)}, []string{"t/2", "t/3"}, ""},
+		{ScanOptions{Filter: f("/pri", "gte", `2`)}, []string{"t/2", "t/3"}, ""},
+		{ScanOptions{Filter: f("/pri", "lt", `2`)}, []string{"t/1"}, ""},
+		{ScanOptions{Filter: f("/pri", "lte", `2`)}, []string{"t/1", "t/2"}, ""},
+		{ScanOptions{Filter: f("/pri", "lt", `"2"`)}, []string{}, ""},
+		{ScanOptions{Filter: f("/title", "gt", `"a"`)}, []string{"t/2", "t/3"}, ""},
+		{ScanOptions{Filter: f("/tags/0", "eq", `"y"`)}, []string{"t/2"}, ""},
+		{ScanOptions{Filter: f("/tags", "eq", `["x"]`)}, []string{"t/1"}, ""},
+		{ScanOptions{Filter: f("/a~1b", "eq", `true`)}, []string{"t/3"}, ""},
+		{ScanOptions{Filter: f("", "eq", `"scalar"`)}, []string{"u/2"}, ""},
+		{ScanOptions{Filter: f("/done", "like", `false`)}, nil, "Invalid filter op: 'like'"},
+		{ScanOptions{Filter: f("done", "eq", `false`)}, nil, "Invalid path: 'done' - must be empty or start with '/'"},
+		{ScanOptions{Filter: f("/done", "eq", ``)}, nil, "Filter value is required"},
+	}
+
+	for i, t := range tc {
+		msg := fmt.Sprintf("case %d", i)
+		res, err := d.Scan(t.opts)
+		if t.expectedError != "" {
+			assert.EqualError(err, t.expectedError, msg)
+			assert.Nil(res, msg)
+			continue
+		}
+		assert.NoError(err, msg)
+		act := []string{}
+		for _, it := range res {
+			act = append(act, it.ID)
+		}
+		assert.Equal(t.expected, act, msg)
+	}
+}
+
+func TestScanProjection(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	d, err := Load(sp)
+	assert.NoError(err)
+
+	for k, v := range map[string]string{
+		"t/1": `{"done":false,"title":"a","tags":["x","y"],"owner":{"id":1,"name":"n"}}`,
+		"t/2": `{"done":true,"title":"b"}`,
+		"u/1": `"scalar"`,
+	} {
+		assert.NoError(d.Put(k, []byte(v)))
+	}
+
+	tc := []struct {
+		opts          ScanOptions
+		expected      []string
+		expectedError string
+	}{
+		{ScanOptions{Prefix: "t/", Fields: []string{"/title", "/done"}},
+			[]string{`{"done":false,"title":"a"}`, `{"done":true,"title":"b"}`}, ""},
+		{ScanOptions{Prefix: "t/", Fields: []string{"/owner/name"}},
+			[]string{`{"owner":{"name":"n"}}`, `{}`}, ""},
+		{ScanOptions{Prefix: "t/", Fields: []string{"/owner", "/owner/name"}},
+			[]string{`{"owner":{"id":1,"name":"n"}}`, `{}`}, ""},
+		{ScanOptions{Prefix: "t/", Fields: []string{"/tags/1"}},
+			[]string{`{"tags":{"1":"y"}}`, `{}`}, ""},
+		{ScanOptions{Prefix: "u/", Fields: []string{"/title"}}, []string{`{}`}, ""},
+		{ScanOptions{Prefix: "u/", Fields: []string{""}}, []string{`"scalar"`}, ""},
+		{ScanOptions{Prefix: "t/", Fields: []string{"/title"}, Filter: &ScanFilter{Path: "/done", Op: "eq", Value: json.RawMessage(`true`)}},
+			[]string{`{"title":"b"}`}, ""},
+		{ScanOptions{Fields: []string{"title"}}, nil, "Invalid path: 'title' - must be empty or start with '/'"},
+	}
+
+	for i, t := range tc {
+		msg := fmt.Sprintf("case %d", i)
+		res, err := d.Scan(t.opts)
+		if t.expectedError != "" {
+			assert.EqualError(err, t.expectedError, msg)
+			assert.Nil(res, msg)
+			continue
+		}
+		assert.NoError(err, msg)
+		act := []string{}
+		for _, it := range res {
+			b, err := json.Marshal(it.Value)
+			assert.NoError(err, msg)
+			act = append(act, string(b))
+		}
+		assert.Equal(t.expected, act, msg)
+	}
+}
+
+func TestScanKeysOnly(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	d, err := Load(sp)
+	assert.NoError(err)
+
+	assert.NoError(d.Put("a", []byte(`"foo"`)))
+	assert.NoError(d.Put("b", []byte(`{"x":[1,2]}`)))
+
+	size := func(n uint64) *uint64 {
+		return &n
+	}
+
+	res, err := d.Scan(ScanOptions{KeysOnly: true})
+	assert.NoError(err)
+	assert.Equal([]ScanItem{{ID: "a"}, {ID: "b"}}, res)
+
+	res, err = d.Scan(ScanOptions{KeysOnly: true, IncludeSize: true})
+	assert.NoError(err)
+	assert.Equal([]ScanItem{{ID: "a", Size: size(5)}, {ID: "b", Size: size(11)}}, res)
+
+	b, err := json.Marshal(res)
+	assert.NoError(err)
+	assert.Equal(`[{"id":"a","size":5},{"id":"b","size":11}]`, string(b))
+
+	res, err = d.Scan(ScanOptions{IncludeSize: true, Prefix: "b"})
+	assert.NoError(err)
+	assert.Equal(1, len(res))
+	assert.NotNil(res[0].Value)
+	assert.Equal(size(11), res[0].Size)
+
+	res, err = d.Scan(ScanOptions{KeysOnly: true, Fields: []string{"/x"}})
+	assert.EqualError(err, "fields cannot be used with keysOnly")
+	assert.Nil(res)
+}
+
+func TestScanDelimiter(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	d, err := Load(sp)
+	assert.NoError(err)
+
+	for _, k := range []string{"a", "b/", "b/1", "b/2/x", "c/1", "c/2", "d", "e//f"} {
+		assert.NoError(d.Put(k, []byte(`"v"`)))
+	}
+	ids := func(items []ScanItem) []string {
+		r := []string{}
+		for _, it := range items {
+			if it.CommonPrefix {
+				assert.Nil(it.Value, it.ID)
+				r = append(r, it.ID+"*")
+			} else {
+				assert.NotNil(it.Value, it.ID)
+				r = append(r, it.ID)
+			}
+		}
+		return r
+	}
+
+	tc := []struct {
+		opts          ScanOptions
+		expected      []string
+		expectedError string
+	}{
+		{ScanOptions{Delimiter: "/"}, []string{"a", "b/*", "c/*", "d", "e/*"}, ""},
+		{ScanOptions{Delimiter: "/", Prefix: "b/"}, []string{"b/", "b/1", "b/2/*"}, ""},
+		{ScanOptions{Delimiter: "/", Prefix: "b"}, []string{"b/*"}, ""},
+		{ScanOptions{Delimiter: "/", Prefix: "e/"}, []string{"e//*"}, ""},
+		{ScanOptions{Delimiter: "2/"}, []string{"a", "b/", "b/1", "b/2/*", "c/1", "c/2", "d", "e//f"}, ""},
+		{ScanOptions{Delimiter: "/", Limit: 2}, []string{"a", "b/*"}, ""},
+		// Paging after a common prefix continues after its subtree.
+		{ScanOptions{Delimiter: "/", Start: &ScanBound{ID: &ScanID{Value: "b/", Exclusive: true}}}, []string{"c/*", "d", "e/*"}, ""},
+		{ScanOptions{Delimiter: "/", Start: &ScanBound{ID: &ScanID{Value: "b/1"}}}, []string{"b/*", "c/*", "d", "e/*"}, ""},
+		{ScanOptions{Delimiter: "/", Filter: &ScanFilter{Path: "", Op: "eq", Value: json.RawMessage(`"v"`)}}, nil, "invalid argument: filter cannot be used with delimiter"},
+	}
+	for i, t := range tc {
+		res, err := d.Scan(t.opts)
+		if t.expectedError != "" {
+			assert.EqualError(err, t.expectedError, "case %d", i)
+			continue
+		}
+		assert.NoError(err, "case %d", i)
+		assert.Equal(t.expected, ids(res), "case %d", i)
+	}
+
+	res, err := d.Scan(ScanOptions{Delimiter: "/", KeysOnly: true, IncludeMeta: true})
+	assert.NoError(err)
+	assert.Equal(ScanItem{ID: "b/", CommonPrefix: true}, res[1])
+	assert.NotNil(res[0].Meta)
+}
+
+func TestScanOptionsJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	// Every field is set so that fields added later must be added here too.
+	index := uint64(3)
+	opts := ScanOptions{
+		Prefix:      "p",
+		Start:       &ScanBound{ID: &ScanID{Value: "a", Exclusive: true}, Index: &index},
+		Limit:       7,
+		Filter:      &ScanFilter{Path: "/done", Op: "eq", Value: json.RawMessage(`true`)},
+		IncludeMeta: true,
+		Fields:      []string{"/title"},
+		KeysOnly:    true,
+		IncludeSize: true,
+		Delimiter:   "/",
+	}
+	v := reflect.ValueOf(opts)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		assert.False(v.Field(i).IsZero(), "field %s is not set", f.Name)
+		assert.NotEqual("", f.Tag.Get("json"), "field %s has no json tag", f.Name)
+	}
+
+	buf, err := json.Marshal(opts)
+	assert.NoError(err)
+	assert.Equal(`{"prefix":"p","start":{"id":{"value":"a","exclusive":true},"index":3},"limit":7,"filter":{"path":"/done","op":"eq","value":true},"includeMeta":true,"fields":["/title"],"keysOnly":true,"includeSize":true,"delimiter":"/"}`, string(buf))
+	var got ScanOptions
+	assert.NoError(json.Unmarshal(buf, &got))
+	assert.Equal(opts, got)
+
+	// The zero value is the empty object.
+	buf, err = json.Marshal(ScanOptions{})
+	assert.NoError(err)
+	assert.Equal(`{}`, string(buf))
+}
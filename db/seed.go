@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+	"roci.dev/diff-server/util/time"
+)
+
+// Seed installs the snapshot at url as the synced state if the database is empty, i.e., it has
+// never been pulled into or written to. The snapshot is a pull response whose patch builds the
+// state from empty, such as those published by package publish. This lets new clients with
+// large datasets download their initial state from a CDN rather than the diff-server, whose
+// first pull is then a patch from the snapshot's state.
+//
+// Seed returns whether the snapshot was installed. Nothing is downloaded if the database isn't
+// empty.
+func (db *DB) Seed(ctx context.Context, url string) (bool, error) {
+	unlock := db.lock()
+	empty := db.isEmpty()
+	bandwidth := db.bandwidth
+	unlock()
+	if !empty {
+		return false, nil
+	}
+	if err := bandwidth.check(time.Now()); err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	received := &byteCounter{r: resp.Body}
+	defer func() {
+		bandwidth.record(url, 0, received.n, time.Now())
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(received)
+		var s string
+		if err == nil {
+			s = string(body)
+		} else {
+			s = err.Error()
+		}
+		return false, fmt.Errorf("%s: %s", resp.Status, s)
+	}
+	var snapshot servetypes.PullResponse
+	if err := json.NewDecoder(received).Decode(&snapshot); err != nil {
+		return false, fmt.Errorf("Response from %s is not valid JSON: %s", url, err.Error())
+	}
+	data, err := db.applySnapshot(snapshot)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.lock()()
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	// The database may have been written to while the snapshot was downloading.
+	if !db.isEmpty() {
+		return false, nil
+	}
+	// The snapshot's lastMutationID is not this client's, which has made no mutations yet.
+	newGenesis := makeGenesis(db.noms, snapshot.StateID, db.noms.WriteValue(data.NomsMap()), data.NomsChecksum(), 0)
+	if _, err := db.rebaseOnto(ctx, newGenesis, RebaseOptions{}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// isEmpty returns whether the head is the initial genesis commit. Callers must hold the lock.
+func (db *DB) isEmpty() bool {
+	return db.head.Type() == CommitTypeGenesis && db.head.Meta.Genesis.ServerStateID == "" && db.head.Data(db.noms).NomsMap().Empty()
+}
+
+// applySnapshot returns the data of snapshot, a pull response whose patch builds the state from
+// empty, after checking it against the snapshot's checksum.
+func (db *DB) applySnapshot(snapshot servetypes.PullResponse) (kv.Map, error) {
+	data, err := kv.ApplyPatch(db.noms, kv.NewMap(db.noms), snapshot.Patch)
+	if err != nil {
+		return kv.Map{}, errors.Wrapf(err, "couldnt apply patch of %s", snapshot.StateID)
+	}
+	expectedChecksum, err := kv.ChecksumFromString(snapshot.Checksum)
+	if err != nil {
+		return kv.Map{}, errors.Wrapf(err, "checksum of %s malformed: %s", snapshot.StateID, snapshot.Checksum)
+	}
+	if data.Checksum() != expectedChecksum.String() {
+		return kv.Map{}, fmt.Errorf("Checksum mismatch! Expected %s, got %s", expectedChecksum, data.Checksum())
+	}
+	return data, nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+)
+
+func TestSeed(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	data := kv.NewMapForTest(db.noms, "foo", `"bar"`)
+	patch, err := SnapshotPatch(data.NomsMap())
+	assert.NoError(err)
+	snapshot := servetypes.PullResponse{Patch: patch, StateID: "s1", Checksum: data.Checksum(), LastMutationID: 7}
+	requests := 0
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		assert.NoError(json.NewEncoder(w).Encode(snapshot))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	status = http.StatusNotFound
+	ok, err := db.Seed(ctx, server.URL)
+	assert.Regexp(`^404 Not Found: `, err.Error())
+	assert.False(ok)
+
+	status = http.StatusOK
+	snapshot.Checksum = "00000000"
+	ok, err = db.Seed(ctx, server.URL)
+	assert.EqualError(err, "Checksum mismatch! Expected 00000000, got "+data.Checksum())
+	assert.False(ok)
+	assert.True(db.isEmpty())
+
+	snapshot.Checksum = data.Checksum()
+	ok, err = db.Seed(ctx, server.URL)
+	assert.NoError(err)
+	assert.True(ok)
+	v, err := db.Get("foo")
+	assert.NoError(err)
+	assert.Equal(`"bar"`, string(v))
+	genesis, err := findGenesis(db.noms, db.head)
+	assert.NoError(err)
+	assert.Equal("s1", genesis.Meta.Genesis.ServerStateID)
+	assert.Equal(uint64(0), genesis.Meta.Genesis.LastMutationID)
+	assert.Equal(3, requests)
+
+	// Once the database isn't empty, nothing is downloaded.
+	ok, err = db.Seed(ctx, server.URL)
+	assert.NoError(err)
+	assert.False(ok)
+	assert.Equal(3, requests)
+
+	other, _ := LoadTempDB(assert)
+	assert.NoError(other.Put("local", []byte(`1`)))
+	ok, err = other.Seed(ctx, server.URL)
+	assert.NoError(err)
+	assert.False(ok)
+	assert.Equal(3, requests)
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/attic-labs/noms/go/hash"
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+
+	"roci.dev/diff-server/kv"
+)
+
+// NewGenesis writes a genesis commit for the synced state data, as of serverStateID and
+// lastMutationID, to noms. It is for use with SetHead.
+func NewGenesis(noms types.ValueReadWriter, serverStateID string, data kv.Map, lastMutationID uint64) Commit {
+	return makeGenesis(noms, serverStateID, noms.WriteValue(data.NomsMap()), data.NomsChecksum(), lastMutationID)
+}
+
+// SetHead makes newGenesis the synced state of the database, as a pull does, and replays the
+// pending local commits on top of it. It is for embedders that sync over transports other than
+// the diff-server, e.g. peer-to-peer or file drop.
+//
+// newGenesis must be a genesis commit that has been written to the database, such as one
+// returned by NewGenesis with db.Noms(). Its data must have string keys and match its checksum,
+// and its lastMutationID must not be less than the current one. Otherwise an
+// ErrInvalidArgument error is returned and the database is unchanged.
+func (db *DB) SetHead(newGenesis Commit) error {
+	_, err := db.Rebase(newGenesis.Original.Hash(), RebaseOptions{})
+	return err
+}
+
+// RebaseProgress describes how far along a rebase is.
+type RebaseProgress struct {
+	// Replayed is the number of pending commits processed so far, including those dropped
+	// because the server already applied them, out of Total.
+	Replayed int
+	Total    int
+}
+
+// RebaseOptions configures Rebase.
+type RebaseOptions struct {
+	// Progress, if set, is called before each pending commit is replayed and once when all of
+	// them have been.
+	Progress func(p RebaseProgress)
+	// DryRun replays the pending commits without changing the head. The conflict handler is
+	// not consulted: if any commits can't be replayed, a *RebaseConflictError listing all of
+	// them is returned.
+	DryRun bool
+}
+
+// RebaseConflict is a pending commit that could not be replayed.
+type RebaseConflict struct {
+	Commit Commit
+	Err    error
+}
+
+// RebaseConflictError is returned by a dry run of Rebase that finds conflicts.
+type RebaseConflictError struct {
+	Conflicts []RebaseConflict
+}
+
+func (e *RebaseConflictError) Error() string {
+	msgs := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", c.Commit.Original.Hash(), c.Err))
+	}
+	return fmt.Sprintf("%d commits could not be replayed: %s", len(e.Conflicts), strings.Join(msgs, "; "))
+}
+
+// Rebase makes the genesis commit onto the synced state of the database and replays the
+// pending local commits on top of it, returning the new head. See SetHead for the
+// requirements on onto.
+//
+// Commits that fail to replay are passed to the conflict handler, as in a pull, unless
+// opts.DryRun is set. A dry run returns the head the rebase would produce without changing the
+// database.
+func (db *DB) Rebase(onto hash.Hash, opts RebaseOptions) (Commit, error) {
+	return db.RebaseCtx(context.Background(), onto, opts)
+}
+
+// RebaseCtx is Rebase, stopping early with ctx's error if ctx is done. The head is unchanged if
+// it stops early.
+func (db *DB) RebaseCtx(ctx context.Context, onto hash.Hash, opts RebaseOptions) (Commit, error) {
+	c, err := db.validateGenesis(onto)
+	if err != nil {
+		return Commit{}, err
+	}
+	defer db.lock()()
+	current, err := findGenesis(db.noms, db.head)
+	if err != nil {
+		return Commit{}, err
+	}
+	if c.Meta.Genesis.LastMutationID < current.Meta.Genesis.LastMutationID {
+		return Commit{}, fmt.Errorf("%w: lastMutationID %d is < current lastMutationID %d", ErrInvalidArgument, c.Meta.Genesis.LastMutationID, current.Meta.Genesis.LastMutationID)
+	}
+	return db.rebaseOnto(ctx, c, opts)
+}
+
+// validateGenesis checks the commit h as described by SetHead and returns it.
+func (db *DB) validateGenesis(h hash.Hash) (Commit, error) {
+	invalid := func(format string, args ...interface{}) (Commit, error) {
+		return Commit{}, fmt.Errorf("%w: %s", ErrInvalidArgument, fmt.Sprintf(format, args...))
+	}
+	v := db.noms.ReadValue(h)
+	if v == nil {
+		return invalid("commit %s has not been written to the database", h)
+	}
+	var c Commit
+	if err := marshal.Unmarshal(v, &c); err != nil {
+		return invalid("%s is not a commit: %s", h, err)
+	}
+	if c.Type() != CommitTypeGenesis {
+		return invalid("commit %s is not a genesis commit: %s", h, c.Type())
+	}
+	m, ok := db.noms.ReadValue(c.Value.Data.TargetHash()).(types.Map)
+	if !ok {
+		return invalid("data of commit %s is missing or not a map", h)
+	}
+	var badKey types.Value
+	m.IterAll(func(k, v types.Value) {
+		if badKey == nil && k.Kind() != types.StringKind {
+			badKey = k
+		}
+	})
+	if badKey != nil {
+		return invalid("data of commit %s has a non-string key: %s", h, types.EncodedValue(badKey))
+	}
+	if actual := kv.ComputeChecksum(m).String(); actual != string(c.Value.Checksum) {
+		return invalid("checksum mismatch: commit %s has %s, its data has %s", h, c.Value.Checksum, actual)
+	}
+	return c, nil
+}
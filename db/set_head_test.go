@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+)
+
+func TestSetHead(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	get := func(id string) string {
+		v, err := db.Get(id)
+		assert.NoError(err)
+		return string(v)
+	}
+	pending := func() int {
+		_, p, err := pendingCommits(db.noms, db.head)
+		assert.NoError(err)
+		return len(p)
+	}
+
+	assert.NoError(db.Put("local", []byte(`"1"`)))
+
+	// Pending commits are replayed on top of the new state.
+	g := NewGenesis(db.Noms(), "s1", kv.NewMapForTest(db.noms, "remote", `"a"`), 0)
+	assert.NoError(db.SetHead(g))
+	assert.Equal(`"a"`, get("remote"))
+	assert.Equal(`"1"`, get("local"))
+	assert.Equal(1, pending())
+	genesis, err := findGenesis(db.noms, db.head)
+	assert.NoError(err)
+	assert.Equal("s1", genesis.Meta.Genesis.ServerStateID)
+
+	// Acknowledged commits are not.
+	g = NewGenesis(db.Noms(), "s2", kv.NewMapForTest(db.noms, "remote", `"b"`, "local", `"1"`), 1)
+	assert.NoError(db.SetHead(g))
+	assert.Equal(`"b"`, get("remote"))
+	assert.Equal(`"1"`, get("local"))
+	assert.Equal(0, pending())
+
+	lowLMID := NewGenesis(db.Noms(), "s3", kv.NewMapForTest(db.noms), 0)
+
+	assert.NoError(db.Put("other", []byte(`"2"`)))
+	tx := db.Head()
+
+	unwritten := Commit{}
+	unwritten.Meta.Genesis.ServerStateID = "unwritten"
+	unwritten.Original = marshal.MustMarshal(db.noms, unwritten).(types.Struct)
+
+	m := kv.NewMapForTest(db.noms, "remote", `"c"`)
+	badChecksum := makeGenesis(db.noms, "s4", db.noms.WriteValue(m.NomsMap()), types.String("00000000"), 2)
+
+	nm := types.NewMap(db.noms, types.Number(1), types.String("x"))
+	badKey := makeGenesis(db.noms, "s5", db.noms.WriteValue(nm), types.String("00000000"), 2)
+
+	invalid := []struct {
+		c             Commit
+		expectedError string
+	}{
+		{lowLMID, "invalid argument: lastMutationID 0 is < current lastMutationID 1"},
+		{tx, "invalid argument: commit " + tx.Original.Hash().String() + " is not a genesis commit: CommitTypeTx"},
+		{unwritten, "invalid argument: commit " + unwritten.Original.Hash().String() + " has not been written to the database"},
+		{badChecksum, "invalid argument: checksum mismatch: commit " + badChecksum.Original.Hash().String() + " has 00000000, its data has " + m.Checksum()},
+		{badKey, "invalid argument: data of commit " + badKey.Original.Hash().String() + " has a non-string key: 1"},
+	}
+	head := db.Hash()
+	for i, t := range invalid {
+		err := db.SetHead(t.c)
+		assert.EqualError(err, t.expectedError, "case %d", i)
+		assert.True(errors.Is(err, ErrInvalidArgument), "case %d", i)
+		assert.Equal(head, db.Hash(), "case %d", i)
+	}
+}
+
+func TestRebaseOptions(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	assert.NoError(db.Put("a", []byte(`"1"`)))
+	assert.NoError(db.Put("b", []byte(`"2"`)))
+	assert.NoError(db.Put("c", []byte(`"3"`)))
+	g := NewGenesis(db.Noms(), "s1", kv.NewMapForTest(db.noms, "remote", `"x"`, "a", `"1"`), 1)
+
+	// A dry run reports progress and returns the head without changing the database.
+	head := db.Hash()
+	var progress []RebaseProgress
+	dry, err := db.Rebase(g.Original.Hash(), RebaseOptions{
+		DryRun: true,
+		Progress: func(p RebaseProgress) {
+			progress = append(progress, p)
+		},
+	})
+	assert.NoError(err)
+	assert.Equal([]RebaseProgress{{0, 3}, {1, 3}, {2, 3}, {3, 3}}, progress)
+	assert.Equal(head, db.Hash())
+
+	// A cancelled rebase leaves the head unchanged.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.RebaseCtx(ctx, g.Original.Hash(), RebaseOptions{})
+	assert.Equal(context.Canceled, err)
+	assert.Equal(head, db.Hash())
+
+	c, err := db.Rebase(g.Original.Hash(), RebaseOptions{})
+	assert.NoError(err)
+	assert.Equal(c.Original.Hash(), db.Hash())
+	assert.True(dry.Value.Data.Equals(c.Value.Data))
+	v, err := db.Get("remote")
+	assert.NoError(err)
+	assert.Equal(`"x"`, string(v))
+	_, pending, err := pendingCommits(db.noms, db.head)
+	assert.NoError(err)
+	assert.Equal(2, len(pending))
+
+	err = &RebaseConflictError{Conflicts: []RebaseConflict{{Commit: c, Err: errors.New("boom")}}}
+	assert.EqualError(err, "1 commits could not be replayed: "+c.Original.Hash().String()+": boom")
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// squashFunction is the name of the transaction that SquashPending replaces pending commits
+// with. Its args are refs to the commits it replaces, oldest first.
+const squashFunction = ".squash"
+
+// SquashPending replaces the pending commits with a single commit on top of the genesis
+// commit, with the same data, and returns the number of commits replaced. This keeps the
+// local history short after long offline editing sessions. The replaced commits are kept,
+// referred to by the new commit, for debugging.
+//
+// Each replaced commit is still one mutation: the server acknowledges mutations by count, so
+// pulls count, and replay if needed, the replaced commits individually.
+func (db *DB) SquashPending() (int, error) {
+	defer db.lock()()
+	genesis, pending, err := pendingCommits(db.noms, db.head)
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) < 2 {
+		return 0, nil
+	}
+	refs := make([]types.Value, 0, len(pending))
+	for _, c := range pending {
+		refs = append(refs, c.Ref())
+	}
+	old := db.head
+	squash := makeTx(db.noms, genesis.Ref(), time.DateTime(), squashFunction, types.NewList(db.noms, refs...), nil, old.Value.Data, old.Value.Checksum)
+	_, err = db.noms.SetHead(db.noms.GetDataset(LOCAL_DATASET), db.noms.WriteValue(squash.Original))
+	if err != nil {
+		return 0, err
+	}
+	db.head = squash
+	db.headChanged()
+	db.recordAudit(squashFunction, old, squash)
+	return len(pending), nil
+}
+
+// isSquash returns whether c is a commit written by SquashPending.
+func isSquash(c Commit) bool {
+	return c.Type() == CommitTypeTx && c.Meta.Tx.Name == squashFunction
+}
+
+// squashedCommits returns the commits replaced by a squash commit with args, oldest first.
+func squashedCommits(noms types.ValueReader, args types.List) ([]Commit, error) {
+	r := make([]Commit, 0, args.Len())
+	for i := uint64(0); i < args.Len(); i++ {
+		ref, ok := args.Get(i).(types.Ref)
+		if !ok {
+			return nil, fmt.Errorf("squash arg %d is not a ref: %s", i, types.EncodedValue(args.Get(i)))
+		}
+		v := ref.TargetValue(noms)
+		if v == nil {
+			return nil, fmt.Errorf("squashed commit %s is missing", ref.TargetHash())
+		}
+		var s Commit
+		if err := marshal.Unmarshal(v, &s); err != nil {
+			return nil, err
+		}
+		r = append(r, s)
+	}
+	return r, nil
+}
+
+// execSquash re-executes the transactions of the commits squashed by a squash commit with
+// args on top of basis, as execImpl does for a single transaction.
+func (db *DB) execSquash(noms types.ValueReadWriter, basis Commit, args types.List) (newDataRef types.Ref, newDataChecksum types.String, err error) {
+	squashed, err := squashedCommits(noms, args)
+	if err != nil {
+		return types.Ref{}, "", err
+	}
+	c := basis
+	for _, s := range squashed {
+		initial, err := s.InitalCommit(noms)
+		if err != nil {
+			return types.Ref{}, "", err
+		}
+		c.Value.Data, c.Value.Checksum, _, _, err = db.execOn(noms, c, initial.Meta.Tx.Name, initial.Meta.Tx.Args)
+		if err != nil {
+			return types.Ref{}, "", err
+		}
+	}
+	return c.Value.Data, c.Value.Checksum, nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+)
+
+func TestSquashPending(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	get := func(id string) string {
+		v, err := db.Get(id)
+		assert.NoError(err)
+		return string(v)
+	}
+
+	n, err := db.SquashPending()
+	assert.NoError(err)
+	assert.Equal(0, n)
+
+	assert.NoError(db.Put("a", []byte(`"1"`)))
+	assert.NoError(db.Put("b", []byte(`"2"`)))
+	_, err = db.Del("a")
+	assert.NoError(err)
+	genesis, pending, err := pendingCommits(db.noms, db.head)
+	assert.NoError(err)
+	before := db.head
+
+	n, err = db.SquashPending()
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.True(isSquash(db.head))
+	assert.True(genesis.Original.Equals(db.head.BasisValue(db.noms)))
+	assert.True(before.Value.Data.Equals(db.head.Value.Data))
+	assert.Equal("", get("a"))
+	assert.Equal(`"2"`, get("b"))
+
+	// The squashed commits still count as pending mutations.
+	_, squashed, err := pendingCommits(db.noms, db.head)
+	assert.NoError(err)
+	assert.Equal(len(pending), len(squashed))
+	for i := range pending {
+		assert.True(pending[i].Original.Equals(squashed[i].Original))
+	}
+
+	// Re-executing the squash commit applies all of its transactions.
+	data, checksum, _, isWrite, err := db.execImpl(db.noms, genesis.Ref(), squashFunction, db.head.Meta.Tx.Args)
+	assert.NoError(err)
+	assert.True(isWrite)
+	assert.True(data.Equals(db.head.Value.Data))
+	assert.Equal(db.head.Value.Checksum, checksum)
+
+	// Pulls drop the acknowledged mutations and replay the rest individually.
+	g := NewGenesis(db.Noms(), "s1", kv.NewMapForTest(db.noms, "a", `"1"`, "c", `"3"`), 1)
+	assert.NoError(db.SetHead(g))
+	assert.Equal("", get("a"))
+	assert.Equal(`"2"`, get("b"))
+	assert.Equal(`"3"`, get("c"))
+	_, pending, err = pendingCommits(db.noms, db.head)
+	assert.NoError(err)
+	assert.Equal(2, len(pending))
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/attic-labs/noms/go/hash"
+	"github.com/attic-labs/noms/go/types"
+)
+
+// Stats summarizes the size of a DB.
+type Stats struct {
+	Keys int `json:"keys"`
+	// Bytes is the approximate size of the data: the total length of the keys and the JSON
+	// encodings of their values.
+	Bytes          int64 `json:"bytes"`
+	LocalOnlyKeys  int   `json:"localOnlyKeys"`
+	LocalOnlyBytes int64 `json:"localOnlyBytes"`
+	// PendingCommits is the number of local commits since the last pull.
+	PendingCommits int `json:"pendingCommits"`
+}
+
+// PrefixStats is the number and approximate size, as in Stats, of the keys with a prefix.
+type PrefixStats struct {
+	Prefix string `json:"prefix"`
+	Keys   int    `json:"keys"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Stats returns the size of the database.
+func (db *DB) Stats() (Stats, error) {
+	defer db.rlock()()
+	var r Stats
+	var err error
+	r.Keys, r.Bytes, err = mapSize(db.head.Data(db.noms).NomsMap(), nil)
+	if err != nil {
+		return Stats{}, err
+	}
+	r.LocalOnlyKeys, r.LocalOnlyBytes, err = mapSize(db.localOnlyData(), nil)
+	if err != nil {
+		return Stats{}, err
+	}
+	_, pending, err := pendingCommits(db.noms, db.head)
+	if err != nil {
+		return Stats{}, err
+	}
+	r.PendingCommits = len(pending)
+	return r, nil
+}
+
+// StatsByPrefix returns the size of the data grouped by key prefix, in prefix order. The prefix
+// of a key is the key up to and including the first delimiter, or the whole key if it doesn't
+// contain the delimiter.
+func (db *DB) StatsByPrefix(delimiter string) ([]PrefixStats, error) {
+	defer db.rlock()()
+	byPrefix := map[string]*PrefixStats{}
+	_, _, err := mapSize(db.head.Data(db.noms).NomsMap(), func(id string, size int64) {
+		prefix := keyPrefix(id, delimiter)
+		ps := byPrefix[prefix]
+		if ps == nil {
+			ps = &PrefixStats{Prefix: prefix}
+			byPrefix[prefix] = ps
+		}
+		ps.Keys++
+		ps.Bytes += size
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := make([]PrefixStats, 0, len(byPrefix))
+	for _, ps := range byPrefix {
+		r = append(r, *ps)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Prefix < r[j].Prefix })
+	return r, nil
+}
+
+// SharingStats describes how many of the values of a set of keys are equal. Noms stores values
+// by content, so equal values that are large enough to be stored apart from the map are only
+// stored once. Prefixes with many keys but few distinct values may be better modeled with one
+// key per distinct value.
+type SharingStats struct {
+	Prefix string `json:"prefix"`
+	Keys   int    `json:"keys"`
+	// DistinctValues is the number of distinct values of the keys.
+	DistinctValues int `json:"distinctValues"`
+	// ValueBytes is the approximate size of the values, as in Stats, counting every key.
+	ValueBytes int64 `json:"valueBytes"`
+	// DistinctValueBytes is the approximate size of the distinct values, counting each once.
+	DistinctValueBytes int64 `json:"distinctValueBytes"`
+}
+
+// SharedBytes returns the bytes of values that equal the value of another key.
+func (s SharingStats) SharedBytes() int64 {
+	return s.ValueBytes - s.DistinctValueBytes
+}
+
+// Sharing returns the sharing of values across all keys, and within each key prefix, in prefix
+// order. Prefixes are as in StatsByPrefix.
+func (db *DB) Sharing(delimiter string) (SharingStats, []SharingStats, error) {
+	defer db.rlock()()
+	type sharing struct {
+		SharingStats
+		seen map[hash.Hash]bool
+	}
+	add := func(s *sharing, h hash.Hash, size int64) {
+		s.Keys++
+		s.ValueBytes += size
+		if !s.seen[h] {
+			s.seen[h] = true
+			s.DistinctValues++
+			s.DistinctValueBytes += size
+		}
+	}
+	total := &sharing{seen: map[hash.Hash]bool{}}
+	byPrefix := map[string]*sharing{}
+	var err error
+	db.head.Data(db.noms).NomsMap().IterAll(func(k, v types.Value) {
+		if err != nil {
+			return
+		}
+		var n uint64
+		if n, err = jsonSize(v); err != nil {
+			return
+		}
+		prefix := keyPrefix(string(k.(types.String)), delimiter)
+		s := byPrefix[prefix]
+		if s == nil {
+			s = &sharing{SharingStats: SharingStats{Prefix: prefix}, seen: map[hash.Hash]bool{}}
+			byPrefix[prefix] = s
+		}
+		add(total, v.Hash(), int64(n))
+		add(s, v.Hash(), int64(n))
+	})
+	if err != nil {
+		return SharingStats{}, nil, err
+	}
+	r := make([]SharingStats, 0, len(byPrefix))
+	for _, s := range byPrefix {
+		r = append(r, s.SharingStats)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Prefix < r[j].Prefix })
+	return total.SharingStats, r, nil
+}
+
+// keyPrefix returns the prefix of id as described by StatsByPrefix.
+func keyPrefix(id, delimiter string) string {
+	if i := strings.Index(id, delimiter); delimiter != "" && i >= 0 {
+		return id[:i+len(delimiter)]
+	}
+	return id
+}
+
+// mapSize returns the number of entries in m and their approximate size, calling f, if
+// non-nil, with the size of each.
+func mapSize(m types.Map, f func(id string, size int64)) (keys int, bytes int64, err error) {
+	m.IterAll(func(k, v types.Value) {
+		if err != nil {
+			return
+		}
+		var n uint64
+		if n, err = jsonSize(v); err != nil {
+			return
+		}
+		id := string(k.(types.String))
+		size := int64(len(id)) + int64(n)
+		keys++
+		bytes += size
+		if f != nil {
+			f(id, size)
+		}
+	})
+	return keys, bytes, err
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	s, err := db.Stats()
+	assert.NoError(err)
+	assert.Equal(Stats{}, s)
+
+	assert.NoError(db.Put("user/1", []byte(`"abby"`)))
+	assert.NoError(db.Put("user/2", []byte(`{"name":"aaron"}`)))
+	assert.NoError(db.Put("todo/1", []byte(`true`)))
+	assert.NoError(db.Put("solo", []byte(`1`)))
+	assert.NoError(db.Put("_local/draft", []byte(`"hi"`)))
+
+	s, err = db.Stats()
+	assert.NoError(err)
+	assert.Equal(Stats{
+		Keys:           4,
+		Bytes:          6 + 6 + 6 + 16 + 6 + 4 + 4 + 1,
+		LocalOnlyKeys:  1,
+		LocalOnlyBytes: 12 + 4,
+		PendingCommits: 4,
+	}, s)
+
+	ps, err := db.StatsByPrefix("/")
+	assert.NoError(err)
+	assert.Equal([]PrefixStats{
+		{Prefix: "solo", Keys: 1, Bytes: 5},
+		{Prefix: "todo/", Keys: 1, Bytes: 10},
+		{Prefix: "user/", Keys: 2, Bytes: 34},
+	}, ps)
+
+	ps, err = db.StatsByPrefix("")
+	assert.NoError(err)
+	assert.Equal(4, len(ps))
+	assert.Equal(PrefixStats{Prefix: "solo", Keys: 1, Bytes: 5}, ps[0])
+}
+
+func TestSharing(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	assert.NoError(db.Put("user/1", []byte(`{"role":"admin"}`)))
+	assert.NoError(db.Put("user/2", []byte(`{"role":"admin"}`)))
+	assert.NoError(db.Put("user/3", []byte(`{"role":"guest"}`)))
+	assert.NoError(db.Put("todo/1", []byte(`{"role":"admin"}`)))
+
+	total, ps, err := db.Sharing("/")
+	assert.NoError(err)
+	assert.Equal(SharingStats{Keys: 4, DistinctValues: 2, ValueBytes: 4 * 16, DistinctValueBytes: 2 * 16}, total)
+	assert.Equal(int64(2*16), total.SharedBytes())
+	assert.Equal([]SharingStats{
+		{Prefix: "todo/", Keys: 1, DistinctValues: 1, ValueBytes: 16, DistinctValueBytes: 16},
+		{Prefix: "user/", Keys: 3, DistinctValues: 2, ValueBytes: 3 * 16, DistinctValueBytes: 2 * 16},
+	}, ps)
+}
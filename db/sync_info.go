@@ -0,0 +1,57 @@
+package db
+
+import (
+	gtime "time"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// SyncInfo describes the state of the local database relative to the server.
+type SyncInfo struct {
+	ClientID string `json:"clientID"`
+	// ServerStateID identifies the server state last pulled. It is empty if the database has
+	// never been pulled.
+	ServerStateID string `json:"serverStateID"`
+	// LastMutationID is the ID of the last local mutation the server had applied as of the last
+	// pull.
+	LastMutationID uint64 `json:"lastMutationID"`
+	// PendingMutations is the number of local mutations made since the last pull.
+	PendingMutations int `json:"pendingMutations"`
+	// PendingLimit is the limit on PendingMutations set with SetPendingLimit, if any. Past it
+	// each local commit warns.
+	PendingLimit int `json:"pendingLimit,omitempty"`
+	// RetryAfterMs is how much longer the server asked the client to wait before pulling again,
+	// via Retry-After on the last pull response. It is zero if there was no such request or
+	// the time has passed.
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+	// PollIntervalMs is how often the server asked the client to pull, via the
+	// X-Replicache-Poll-Interval header on the last pull response. It is zero if the server
+	// gave no hint.
+	PollIntervalMs int64 `json:"pollIntervalMs,omitempty"`
+}
+
+// SyncInfo returns sync-related information about the current head, which is helpful when
+// debugging clients that don't appear to be making progress.
+func (db *DB) SyncInfo() (SyncInfo, error) {
+	defer db.rlock()()
+	return db.syncInfo()
+}
+
+func (db *DB) syncInfo() (SyncInfo, error) {
+	genesis, pending, err := pendingCommits(db.noms, db.head)
+	if err != nil {
+		return SyncInfo{}, err
+	}
+	si := SyncInfo{
+		ClientID:         db.clientID,
+		ServerStateID:    genesis.Meta.Genesis.ServerStateID,
+		LastMutationID:   genesis.Meta.Genesis.LastMutationID,
+		PendingMutations: len(pending),
+		PendingLimit:     db.pendingLimit.max,
+		PollIntervalMs:   int64(db.pullHints.pollInterval / gtime.Millisecond),
+	}
+	if wait := db.pullHints.retryAt.Sub(time.Now()); wait > 0 {
+		si.RetryAfterMs = int64(wait / gtime.Millisecond)
+	}
+	return si, nil
+}
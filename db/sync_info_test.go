@@ -0,0 +1,104 @@
+package db
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/time"
+)
+
+func TestSyncInfo(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	si, err := db.SyncInfo()
+	assert.NoError(err)
+	assert.Equal(SyncInfo{ClientID: db.clientID}, si)
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	_, err = db.Del("foo")
+	assert.NoError(err)
+	si, err = db.SyncInfo()
+	assert.NoError(err)
+	assert.Equal(SyncInfo{ClientID: db.clientID, PendingMutations: 2}, si)
+
+	// Pulled state is reported from the genesis commit the head is based on.
+	g := makeGenesis(db.noms, "ssid1", db.head.Value.Data, db.head.Value.Checksum, 1)
+	_, err = db.noms.SetHead(db.noms.GetDataset(LOCAL_DATASET), db.noms.WriteValue(g.Original))
+	assert.NoError(err)
+	assert.NoError(db.Reload())
+	assert.NoError(db.Put("foo", []byte(`"baz"`)))
+	si, err = db.SyncInfo()
+	assert.NoError(err)
+	assert.Equal(SyncInfo{ClientID: db.clientID, ServerStateID: "ssid1", LastMutationID: 1, PendingMutations: 1}, si)
+}
+
+func TestSyncInfoPullHints(t *testing.T) {
+	assert := assert.New(t)
+	defer time.SetFake()()
+	db, _ := LoadTempDB(assert)
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	header = http.Header{"Retry-After": {"120"}, pollIntervalHeader: {"30"}}
+	_, err = db.Pull(sp, "", nil)
+	assert.Error(err)
+	si, err := db.SyncInfo()
+	assert.NoError(err)
+	assert.Equal(int64(120000), si.RetryAfterMs)
+	assert.Equal(int64(30000), si.PollIntervalMs)
+
+	// Hints are replaced by those of the next response, even if it has none.
+	header = http.Header{}
+	_, err = db.Pull(sp, "", nil)
+	assert.Error(err)
+	si, err = db.SyncInfo()
+	assert.NoError(err)
+	assert.Equal(int64(0), si.RetryAfterMs)
+	assert.Equal(int64(0), si.PollIntervalMs)
+}
+
+func TestParsePullHints(t *testing.T) {
+	assert := assert.New(t)
+	now := gtime.Date(2020, 1, 1, 0, 0, 0, 0, gtime.UTC)
+	tc := []struct {
+		retryAfter   string
+		pollInterval string
+		exp          pullHints
+	}{
+		{"", "", pullHints{}},
+		{"0", "0", pullHints{retryAt: now}},
+		{"5", "60", pullHints{retryAt: now.Add(5 * gtime.Second), pollInterval: gtime.Minute}},
+		{"Wed, 01 Jan 2020 00:01:00 GMT", "", pullHints{retryAt: now.Add(gtime.Minute)}},
+		{"-1", "soon", pullHints{}},
+		{"tomorrow", "1.5", pullHints{}},
+	}
+	for i, t := range tc {
+		h := http.Header{}
+		if t.retryAfter != "" {
+			h.Set("Retry-After", t.retryAfter)
+		}
+		if t.pollInterval != "" {
+			h.Set(pollIntervalHeader, t.pollInterval)
+		}
+		ph := parsePullHints(h, now)
+		assert.True(t.exp.retryAt.Equal(ph.retryAt), "case %d", i)
+		assert.Equal(t.exp.pollInterval, ph.pollInterval, "case %d", i)
+	}
+}
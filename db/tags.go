@@ -0,0 +1,46 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/attic-labs/noms/go/types"
+
+	nomsjson "roci.dev/diff-server/util/noms/json"
+)
+
+// MaxTagsSize is the maximum size in bytes of the JSON passed to WithTags.
+const MaxTagsSize = 1 << 12
+
+type tagsKey struct{}
+
+// WithTags returns a context that causes transactions committed with it to record tags in
+// their commit metadata. Tags are arbitrary, small, app-supplied JSON (e.g., the UI surface
+// that made the write) that is shown in history for debugging. Tags do not affect the
+// transaction itself.
+func WithTags(ctx context.Context, JSON []byte) context.Context {
+	if len(JSON) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, tagsKey{}, JSON)
+}
+
+func (db *DB) tagsFromContext(ctx context.Context) (types.Value, error) {
+	JSON, ok := ctx.Value(tagsKey{}).([]byte)
+	if !ok {
+		return nil, nil
+	}
+	if len(JSON) > MaxTagsSize {
+		return nil, fmt.Errorf("tags must be at most %d bytes, got %d", MaxTagsSize, len(JSON))
+	}
+	canonicalJSON, err := nomsjson.Canonicalize(JSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tags '%s': %w", JSON, err)
+	}
+	v, err := nomsjson.FromJSON(bytes.NewReader(canonicalJSON), db.noms)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tags '%s': %w", JSON, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	nomsjson "roci.dev/diff-server/util/noms/json"
+)
+
+func TestTags(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	assert.Nil(db.Head().Meta.Tx.Tags)
+
+	ctx := WithTags(context.Background(), []byte(`{"surface":"compose"}`))
+	assert.NoError(db.PutCtx(ctx, "foo", []byte(`"baz"`)))
+	var buf bytes.Buffer
+	assert.NoError(nomsjson.ToJSON(db.Head().Meta.Tx.Tags, &buf))
+	assert.Equal(`{"surface":"compose"}`, buf.String())
+
+	ok, err := db.DelCtx(ctx, "foo")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.NotNil(db.Head().Meta.Tx.Tags)
+
+	h := db.Hash()
+	err = db.PutCtx(WithTags(context.Background(), []byte(`{`)), "foo", []byte(`"bar"`))
+	assert.Error(err)
+	assert.True(strings.HasPrefix(err.Error(), "invalid tags '{'"), err.Error())
+
+	big := []byte(`"` + strings.Repeat("a", MaxTagsSize) + `"`)
+	err = db.PutCtx(WithTags(context.Background(), big), "foo", []byte(`"bar"`))
+	assert.EqualError(err, fmt.Sprintf("tags must be at most %d bytes, got %d", MaxTagsSize, len(big)))
+	assert.Equal(h, db.Hash())
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"strings"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+	"github.com/attic-labs/noms/go/util/datetime"
+
+	"roci.dev/diff-server/util/chk"
+	"roci.dev/diff-server/util/time"
+)
+
+// Tombstone records the deletion of a key.
+type Tombstone struct {
+	ID string `json:"id"`
+	// Commit is the hash of the local commit that deleted the key.
+	Commit string            `json:"commit"`
+	Date   datetime.DateTime `json:"date"`
+}
+
+type ScanDeletedOptions struct {
+	Prefix string `json:"prefix,omitempty"`
+	// Since limits results to keys deleted at or after this time.
+	Since *datetime.DateTime `json:"since,omitempty"`
+	Limit int                `json:"limit,omitempty"`
+}
+
+// ScanDeleted returns keys that have been deleted within the tombstone retention window and not
+// since re-added, in key order.
+func (db *DB) ScanDeleted(opts ScanDeletedOptions) ([]Tombstone, error) {
+	return db.ScanDeletedCtx(context.Background(), opts)
+}
+
+// ScanDeletedCtx is like ScanDeleted but fails early if ctx is done.
+func (db *DB) ScanDeletedCtx(ctx context.Context, opts ScanDeletedOptions) ([]Tombstone, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	unlock := db.rlock()
+	cutoff := time.Now().Add(-db.tombstoneRetention)
+	unlock()
+	idx, err := db.pruneTombstones(cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	lim := opts.Limit
+	if lim == 0 {
+		lim = DefaultScanLimit
+	}
+
+	res := []Tombstone{}
+	var it *types.MapIterator
+	if opts.Prefix != "" {
+		it = idx.Tombstones.IteratorFrom(types.String(opts.Prefix))
+	} else {
+		it = idx.Tombstones.Iterator()
+	}
+	for ; it.Valid() && len(res) < lim; it.Next() {
+		k, v := it.Entry()
+		chk.True(k.Kind() == types.StringKind, "Only keys with string kinds are supported")
+		ks := string(k.(types.String))
+		if opts.Prefix != "" && !strings.HasPrefix(ks, opts.Prefix) {
+			break
+		}
+		var km KeyMeta
+		err := marshal.Unmarshal(v, &km)
+		if err != nil {
+			return nil, err
+		}
+		if km.Date.Before(cutoff) || opts.Since != nil && km.Date.Before(opts.Since.Time) {
+			continue
+		}
+		res = append(res, Tombstone{
+			ID:     ks,
+			Commit: km.Commit,
+			Date:   km.Date,
+		})
+	}
+	return res, nil
+}
+
+// pruneTombstones returns the key metadata, brought up to date with the local head. If they
+// haven't been within tombstonePruneInterval, tombstones older than cutoff are first removed
+// from it. This visits every tombstone, so it is done here rather than whenever the metadata
+// is updated.
+func (db *DB) pruneTombstones(cutoff gtime.Time) (keyMetaIndex, error) {
+	db.keyMetaMu.Lock()
+	defer db.keyMetaMu.Unlock()
+	idx, err := db.updateKeyMeta(db.snapshot())
+	if err != nil {
+		return keyMetaIndex{}, err
+	}
+	now := time.Now()
+	if now.Sub(db.tombstonesPruned) < tombstonePruneInterval {
+		return idx, nil
+	}
+	db.tombstonesPruned = now
+
+	ed := idx.Tombstones.Edit()
+	pruned := 0
+	idx.Tombstones.IterAll(func(k, v types.Value) {
+		var km KeyMeta
+		if marshal.Unmarshal(v, &km) != nil || km.Date.Before(cutoff) {
+			ed.Remove(k)
+			pruned++
+		}
+	})
+	if pruned == 0 {
+		return idx, nil
+	}
+	idx.Tombstones = ed.Map()
+	db.storeKeyMeta(idx)
+	return idx, nil
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/time"
+)
+
+func TestScanDeleted(t *testing.T) {
+	assert := assert.New(t)
+	defer time.SetFake()()
+
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	for _, k := range []string{"a/1", "a/2", "b/1"} {
+		assert.NoError(db.Put(k, []byte(`true`)))
+	}
+
+	items, err := db.ScanDeleted(ScanDeletedOptions{})
+	assert.NoError(err)
+	assert.Equal([]Tombstone{}, items)
+
+	_, err = db.Del("a/1")
+	assert.NoError(err)
+	a1Hash := db.Hash().String()
+	_, err = db.Del("b/1")
+	assert.NoError(err)
+	b1Hash := db.Hash().String()
+
+	items, err = db.ScanDeleted(ScanDeletedOptions{})
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+	assert.Equal("a/1", items[0].ID)
+	assert.Equal(a1Hash, items[0].Commit)
+	assert.True(time.DateTime().Equal(items[0].Date.Time))
+	assert.Equal("b/1", items[1].ID)
+	assert.Equal(b1Hash, items[1].Commit)
+
+	items, err = db.ScanDeleted(ScanDeletedOptions{Prefix: "b/"})
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+	assert.Equal("b/1", items[0].ID)
+
+	items, err = db.ScanDeleted(ScanDeletedOptions{Limit: 1})
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+	assert.Equal("a/1", items[0].ID)
+
+	// Re-adding a key clears its tombstone.
+	assert.NoError(db.Put("a/1", []byte(`false`)))
+	items, err = db.ScanDeleted(ScanDeletedOptions{})
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+	assert.Equal("b/1", items[0].ID)
+
+	// Tombstones older than the retention window are no longer reported, and are pruned by
+	// the next ScanDeleted.
+	db.SetTombstoneRetention(-1)
+	items, err = db.ScanDeleted(ScanDeletedOptions{})
+	assert.NoError(err)
+	assert.Equal([]Tombstone{}, items)
+	_, idx, err := db.keyMeta()
+	assert.NoError(err)
+	assert.True(idx.Tombstones.Empty())
+
+	db.SetTombstoneRetention(defaultTombstoneRetention)
+	_, err = db.Del("a/2")
+	assert.NoError(err)
+	items, err = db.ScanDeleted(ScanDeletedOptions{})
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+	assert.Equal("a/2", items[0].ID)
+
+	// Pruning happens at most once per tombstonePruneInterval, but expired tombstones are
+	// never reported.
+	db.tombstoneRetention = -1
+	items, err = db.ScanDeleted(ScanDeletedOptions{})
+	assert.NoError(err)
+	assert.Equal([]Tombstone{}, items)
+	_, idx, err = db.keyMeta()
+	assert.NoError(err)
+	assert.Equal(uint64(1), idx.Tombstones.Len())
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/attic-labs/noms/go/util/verbose"
+
+	"roci.dev/diff-server/kv"
+	"roci.dev/diff-server/util/time"
+)
+
+// StateVerification is the result of VerifyState.
+type StateVerification struct {
+	// StateID is the server state the local head is based on.
+	StateID string `json:"stateID"`
+	// Checksum is the local checksum of the state, and ServerChecksum the server's. Drifted is
+	// set if they differ, which means that the local data is not what the server sent.
+	Checksum       string `json:"checksum"`
+	ServerChecksum string `json:"serverChecksum"`
+	Drifted        bool   `json:"drifted"`
+}
+
+type verifyStateRequest struct {
+	ClientViewAuth string `json:"clientViewAuth"`
+	ClientID       string `json:"clientID"`
+	StateID        string `json:"stateID"`
+	Checksum       string `json:"checksum"`
+}
+
+type verifyStateResponse struct {
+	Checksum string `json:"checksum"`
+}
+
+// VerifyState asks remote for its checksum of the server state the local head is based on,
+// and reports whether the local data has drifted from it. Unlike a pull, no patch is
+// transferred and nothing changes locally, so it is cheap enough to run as a periodic
+// consistency probe.
+func (db *DB) VerifyState(ctx context.Context, remote spec.Spec, clientViewAuth string) (StateVerification, error) {
+	unlock := db.lock()
+	head := db.head
+	bandwidth := db.bandwidth
+	unlock()
+
+	if err := bandwidth.check(time.Now()); err != nil {
+		return StateVerification{}, err
+	}
+	genesis, err := findGenesis(db.noms, head)
+	if err != nil {
+		return StateVerification{}, err
+	}
+	res := StateVerification{
+		StateID:  genesis.Meta.Genesis.ServerStateID,
+		Checksum: genesis.Data(db.noms).Checksum(),
+	}
+
+	url := fmt.Sprintf("%s/verifyState", remote.String())
+	body, err := json.Marshal(verifyStateRequest{
+		ClientViewAuth: clientViewAuth,
+		ClientID:       db.clientID,
+		StateID:        res.StateID,
+		Checksum:       res.Checksum,
+	})
+	if err != nil {
+		return StateVerification{}, err
+	}
+	verbose.Log("Verifying state %s with %s", res.StateID, url)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return StateVerification{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Add("Authorization", sandboxAuthorization)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return StateVerification{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	bandwidth.record(remote.String(), uint64(len(body)), uint64(len(respBody)), time.Now())
+	if err != nil {
+		return StateVerification{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return StateVerification{}, fmt.Errorf("%s: %s", resp.Status, string(respBody))
+	}
+
+	var verifyResp verifyStateResponse
+	if err := json.Unmarshal(respBody, &verifyResp); err != nil {
+		return StateVerification{}, fmt.Errorf("Response from %s is not valid JSON: %s", url, err.Error())
+	}
+	serverChecksum, err := kv.ChecksumFromString(verifyResp.Checksum)
+	if err != nil {
+		return StateVerification{}, fmt.Errorf("Response from %s has malformed checksum: %s", url, verifyResp.Checksum)
+	}
+	res.ServerChecksum = serverChecksum.String()
+	res.Drifted = res.ServerChecksum != res.Checksum
+	return res, nil
+}
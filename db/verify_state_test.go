@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+)
+
+func TestVerifyState(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+	stateID := "11111111111111111111111111111111"
+	m := kv.NewMapForTest(db.noms, "a", `"a"`)
+	empty := kv.NewMapForTest(db.noms)
+
+	var req verifyStateRequest
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/verifyState", r.URL.Path)
+		assert.NoError(json.NewDecoder(r.Body).Decode(&req))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	// Pull so that the local head is based on a server state.
+	status = http.StatusOK
+	body = fmt.Sprintf(`{"patch":[{"op":"add","path":"/a","value":"a"}],"stateID":"%s","checksum":"%s","lastMutationID":1}`, stateID, m.Checksum())
+	_, err = db.Pull(sp, "", nil)
+	assert.NoError(err)
+	// Pending local writes don't count as drift.
+	assert.NoError(db.Put("b", []byte(`"b"`)))
+
+	tc := []struct {
+		label         string
+		status        int
+		body          string
+		expected      StateVerification
+		expectedError string
+	}{
+		{"match", http.StatusOK, fmt.Sprintf(`{"checksum":"%s"}`, m.Checksum()), StateVerification{stateID, m.Checksum(), m.Checksum(), false}, ""},
+		{"drift", http.StatusOK, fmt.Sprintf(`{"checksum":"%s"}`, empty.Checksum()), StateVerification{stateID, m.Checksum(), empty.Checksum(), true}, ""},
+		{"bad-checksum", http.StatusOK, `{"checksum":"x"}`, StateVerification{}, "malformed checksum"},
+		{"bad-json", http.StatusOK, `{`, StateVerification{}, "not valid JSON"},
+		{"status", http.StatusNotFound, "unknown state", StateVerification{}, "404 Not Found: unknown state"},
+	}
+	for _, t := range tc {
+		status, body = t.status, t.body
+		req = verifyStateRequest{}
+		res, err := db.VerifyState(context.Background(), sp, "auth")
+		assert.Equal(verifyStateRequest{"auth", db.clientID, stateID, m.Checksum()}, req, t.label)
+		if t.expectedError != "" {
+			assert.Error(err, t.label)
+			assert.Contains(err.Error(), t.expectedError, t.label)
+			continue
+		}
+		assert.NoError(err, t.label)
+		assert.Equal(t.expected, res, t.label)
+	}
+}
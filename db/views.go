@@ -0,0 +1,221 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/types"
+)
+
+// VIEWS_DATASET holds the contents of derived views. Like KEYMETA_DATASET it is derived from
+// the local dataset and kept outside of commits so that it does not affect sync.
+const VIEWS_DATASET = "views"
+
+// ViewPrefix is the reserved key prefix under which derived views can be read with Has, Get,
+// and Scan. The entry k of view v has the key ViewPrefix + v + "/" + k.
+const ViewPrefix = "_view/"
+
+// KeyChange describes a change to the value of a key.
+type KeyChange struct {
+	ID string
+	// Old and New are the values before and after the change. Old is nil if the key was
+	// added, and New is nil if it was removed.
+	Old, New types.Value
+}
+
+// ViewFunc incrementally maintains a derived view. It is called with the keys that changed
+// since the view was last updated, in key order, and must apply the corresponding changes to
+// the view's contents via view. Keys of the view must be strings.
+//
+// When a view is first registered, or registered with a new version, it is called with every
+// key in the database as added.
+type ViewFunc func(noms types.ValueReader, changes []KeyChange, view *types.MapEditor) error
+
+type registeredView struct {
+	version string
+	f       ViewFunc
+}
+
+// viewState is the stored state of a single view.
+type viewState struct {
+	Version string
+	// Basis is the local commit that Data is up to date with.
+	Basis types.Ref
+	Data  types.Map
+}
+
+// RegisterView registers a derived view called name that is maintained by f as the local head
+// changes. Views are not persisted across processes, so they must be registered each time the
+// database is loaded. The contents computed by a previous process are reused if version
+// matches the version they were computed with, otherwise the view is rebuilt.
+func (db *DB) RegisterView(name, version string, f ViewFunc) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("Invalid view name: '%s' - must be non-empty and not contain '/'", name)
+	}
+	defer db.lock()()
+	if db.views == nil {
+		db.views = map[string]registeredView{}
+	}
+	db.views[name] = registeredView{version, f}
+	return db.updateViews(db.head)
+}
+
+// updateViews brings the registered views up to date with head. Callers must hold the lock.
+func (db *DB) updateViews(head Commit) error {
+	if len(db.views) == 0 {
+		return nil
+	}
+	ds := db.noms.GetDataset(VIEWS_DATASET)
+	views := types.NewMap(db.noms)
+	if ds.HasHead() {
+		views = ds.HeadValue().(types.Map)
+	}
+
+	data := head.Data(db.noms).NomsMap()
+	ed := views.Edit()
+	changed := false
+	for name, rv := range db.views {
+		vs := viewState{Version: rv.version, Data: types.NewMap(db.noms)}
+		last := types.NewMap(db.noms)
+		if v, ok := views.MaybeGet(types.String(name)); ok {
+			var stored viewState
+			if marshal.Unmarshal(v, &stored) == nil && stored.Version == rv.version {
+				if stored.Basis.TargetHash() == head.Original.Hash() {
+					continue
+				}
+				var basis Commit
+				err := marshal.Unmarshal(stored.Basis.TargetValue(db.noms), &basis)
+				if err != nil {
+					return err
+				}
+				vs = stored
+				last = basis.Data(db.noms).NomsMap()
+			}
+		}
+
+		ved := vs.Data.Edit()
+		err := rv.f(db.noms, diffKeys(last, data), ved)
+		if err != nil {
+			return fmt.Errorf("could not update view %s: %w", name, err)
+		}
+		vs.Basis = head.Ref()
+		vs.Data = ved.Map()
+		ed.Set(types.String(name), marshal.MustMarshal(db.noms, vs))
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	_, err := db.noms.CommitValue(ds, ed.Map())
+	return err
+}
+
+// diffKeys returns the changes that turn from into to.
+func diffKeys(from, to types.Map) []KeyChange {
+	changes := make(chan types.ValueChanged)
+	go func() {
+		to.Diff(from, changes, nil)
+		close(changes)
+	}()
+	r := []KeyChange{}
+	for c := range changes {
+		kc := KeyChange{ID: string(c.Key.(types.String))}
+		if c.ChangeType != types.DiffChangeAdded {
+			kc.Old = from.Get(c.Key)
+		}
+		if c.ChangeType != types.DiffChangeRemoved {
+			kc.New = to.Get(c.Key)
+		}
+		r = append(r, kc)
+	}
+	return r
+}
+
+// isViewKey returns whether id is in the reserved view key space.
+func isViewKey(id string) bool {
+	return strings.HasPrefix(id, ViewPrefix)
+}
+
+// parseViewKey splits a key in the reserved view key space into the view name and the key
+// within the view.
+func parseViewKey(id string) (name, key string, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(id, ViewPrefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("Invalid view key: '%s' - must be of the form %s<view>/<key>", id, ViewPrefix)
+	}
+	return parts[0], parts[1], nil
+}
+
+// viewData returns the current contents of the named view. Callers must hold the lock.
+func (db *DB) viewData(name string) (types.Map, error) {
+	if _, ok := db.views[name]; !ok {
+		return types.Map{}, notFound("No such view: %s", name)
+	}
+	err := db.updateViews(db.head)
+	if err != nil {
+		return types.Map{}, err
+	}
+	v, ok := db.noms.GetDataset(VIEWS_DATASET).HeadValue().(types.Map).MaybeGet(types.String(name))
+	if !ok {
+		return types.Map{}, errors.New("UNEXPECTED STATE: view was not stored")
+	}
+	var vs viewState
+	err = marshal.Unmarshal(v, &vs)
+	if err != nil {
+		return types.Map{}, err
+	}
+	return vs.Data, nil
+}
+
+// getView returns the value of the view key id, or nil if it isn't present.
+func (db *DB) getView(id string) (types.Value, error) {
+	name, key, err := parseViewKey(id)
+	if err != nil {
+		return nil, err
+	}
+	defer db.lock()()
+	m, err := db.viewData(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.Get(types.String(key)), nil
+}
+
+// scanView scans a view. opts.Prefix must start with ViewPrefix and name a view. Returned IDs
+// are view keys, including the view prefix.
+func (db *DB) scanView(opts ScanOptions) ([]ScanItem, error) {
+	name, prefix, err := parseViewKey(opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if opts.IncludeMeta {
+		return nil, errors.New("includeMeta is not supported for views")
+	}
+	keyPrefix := ViewPrefix + name + "/"
+	opts.Prefix = prefix
+	if opts.Start != nil && opts.Start.ID != nil {
+		// ScanCtx has checked that a non-empty start ID is within the view.
+		start := *opts.Start
+		id := *start.ID
+		id.Value = strings.TrimPrefix(id.Value, keyPrefix)
+		start.ID = &id
+		opts.Start = &start
+	}
+
+	unlock := db.lock()
+	m, err := db.viewData(name)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	items, err := scan(db.noms, m, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].ID = keyPrefix + items[i].ID
+	}
+	return items, nil
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/attic-labs/noms/go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViews(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	// counts maintains the number of keys in each list, where the list is the part of the
+	// key before the first "/".
+	var calls [][]KeyChange
+	counts := func(noms types.ValueReader, changes []KeyChange, view *types.MapEditor) error {
+		calls = append(calls, changes)
+		for _, c := range changes {
+			list := types.String(strings.SplitN(c.ID, "/", 2)[0])
+			n := types.Number(0)
+			if v := view.Get(list); v != nil {
+				n = v.Value().(types.Number)
+			}
+			if c.Old == nil {
+				n++
+			}
+			if c.New == nil {
+				n--
+			}
+			if n == 0 {
+				view.Remove(list)
+			} else {
+				view.Set(list, n)
+			}
+		}
+		return nil
+	}
+
+	assert.NoError(db.Put("todo/1", []byte(`"a"`)))
+	assert.NoError(db.Put("todo/2", []byte(`"b"`)))
+	assert.NoError(db.Put("done/1", []byte(`"c"`)))
+	assert.EqualError(db.RegisterView("a/b", "1", counts), "Invalid view name: 'a/b' - must be non-empty and not contain '/'")
+	assert.NoError(db.RegisterView("counts", "1", counts))
+	assert.Equal(1, len(calls))
+	assert.Equal(3, len(calls[0]))
+
+	get := func(id string) string {
+		v, err := db.Get(id)
+		assert.NoError(err)
+		return string(v)
+	}
+	assert.Equal("2", get("_view/counts/todo"))
+	assert.Equal("1", get("_view/counts/done"))
+
+	_, err = db.Del("todo/1")
+	assert.NoError(err)
+	assert.NoError(db.Put("todo/2", []byte(`"bb"`)))
+	assert.NoError(db.Put("todo/3", []byte(`"d"`)))
+	assert.Equal(4, len(calls))
+	assert.Equal([]KeyChange{{ID: "todo/3", New: types.String("d")}}, calls[3])
+	assert.Equal("2", get("_view/counts/todo"))
+
+	items, err := db.Scan(ScanOptions{Prefix: "_view/counts/"})
+	assert.NoError(err)
+	assert.Equal(2, len(items))
+	assert.Equal("_view/counts/done", items[0].ID)
+	assert.Equal("_view/counts/todo", items[1].ID)
+	items, err = db.Scan(ScanOptions{Prefix: "_view/counts/", Start: &ScanBound{ID: &ScanID{Value: "_view/counts/done", Exclusive: true}}})
+	assert.NoError(err)
+	assert.Equal(1, len(items))
+	assert.Equal("_view/counts/todo", items[0].ID)
+
+	ok, err := db.Has("_view/counts/nope")
+	assert.NoError(err)
+	assert.False(ok)
+	_, err = db.Get("_view/nope/x")
+	assert.EqualError(err, "No such view: nope")
+	_, err = db.Get("_view/counts")
+	assert.EqualError(err, "Invalid view key: '_view/counts' - must be of the form _view/<view>/<key>")
+	assert.EqualError(db.Put("_view/counts/x", []byte(`1`)), "could not Put '_view/counts/x': keys starting with '_view/' are reserved for views")
+
+	// Views computed by a previous process are reused if the version matches.
+	db, err = New(db.noms)
+	assert.NoError(err)
+	assert.NoError(db.RegisterView("counts", "1", counts))
+	assert.Equal(4, len(calls))
+	assert.Equal("2", get("_view/counts/todo"))
+
+	// Otherwise they are rebuilt.
+	assert.NoError(db.RegisterView("counts", "2", counts))
+	assert.Equal(5, len(calls))
+	assert.Equal(3, len(calls[4]))
+	assert.Equal("2", get("_view/counts/todo"))
+}
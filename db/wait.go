@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/hash"
+)
+
+// headChangedCh returns a channel that is closed the next time the local head changes.
+// Callers must hold the lock.
+func (db *DB) headChangedCh() chan struct{} {
+	if db.headWaiters == nil {
+		db.headWaiters = make(chan struct{})
+	}
+	return db.headWaiters
+}
+
+// notifyHeadWaiters wakes callers of WaitForChange. Callers must hold the lock.
+func (db *DB) notifyHeadWaiters() {
+	if db.headWaiters != nil {
+		close(db.headWaiters)
+		db.headWaiters = nil
+	}
+}
+
+// WaitForChange blocks until the hash of the local head differs from from, timeout elapses,
+// or ctx is done, and returns the hash of the head. A timeout of zero waits indefinitely.
+// Timing out is not an error: the unchanged hash is returned.
+func (db *DB) WaitForChange(ctx context.Context, from hash.Hash, timeout gtime.Duration) (hash.Hash, error) {
+	var expired <-chan gtime.Time
+	if timeout > 0 {
+		t := gtime.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	for {
+		unlock := db.lock()
+		h := db.head.Original.Hash()
+		ch := db.headChangedCh()
+		unlock()
+		if h != from {
+			return h, nil
+		}
+		select {
+		case <-ch:
+		case <-expired:
+			return h, nil
+		case <-ctx.Done():
+			return h, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForChange(t *testing.T) {
+	assert := assert.New(t)
+	sp, err := spec.ForDatabase("mem")
+	assert.NoError(err)
+	db, err := Load(sp)
+	assert.NoError(err)
+
+	// Returns immediately if the head already differs.
+	h0 := db.Hash()
+	assert.NoError(db.Put("foo", []byte(`"bar"`)))
+	h1 := db.Hash()
+	h, err := db.WaitForChange(context.Background(), h0, 0)
+	assert.NoError(err)
+	assert.Equal(h1, h)
+
+	// Times out if the head doesn't change.
+	h, err = db.WaitForChange(context.Background(), h1, 10*time.Millisecond)
+	assert.NoError(err)
+	assert.Equal(h1, h)
+
+	// Wakes when the head changes.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		db.Put("foo", []byte(`"baz"`))
+	}()
+	h, err = db.WaitForChange(context.Background(), h1, 10*time.Second)
+	assert.NoError(err)
+	assert.NotEqual(h1, h)
+	assert.Equal(db.Hash(), h)
+
+	// Gives up when ctx is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.WaitForChange(ctx, db.Hash(), 0)
+	assert.Equal(context.Canceled, err)
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	gtime "time"
+
+	servetypes "roci.dev/diff-server/serve/types"
+	"roci.dev/diff-server/util/chk"
+	"roci.dev/diff-server/util/time"
+)
+
+// wireLogMaxBody is the number of bytes of each request and response body kept in the wire log.
+const wireLogMaxBody = 4 << 10
+
+// redacted replaces sensitive values in the wire log.
+const redacted = "REDACTED"
+
+// WireLogEntry records a single pull request and its response. Credentials are redacted and
+// bodies are truncated.
+type WireLogEntry struct {
+	Date            gtime.Time        `json:"date"`
+	URL             string            `json:"url"`
+	RequestHeaders  map[string]string `json:"requestHeaders"`
+	RequestBody     string            `json:"requestBody"`
+	Status          int               `json:"status,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	ResponseBody    string            `json:"responseBody,omitempty"`
+	// Error is the error the pull failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// wireLog is a ring buffer of the most recent pull exchanges. It has its own lock because
+// pulls do most of their work without holding the database lock.
+type wireLog struct {
+	mu      sync.Mutex
+	size    int
+	entries []WireLogEntry
+}
+
+// SetWireLogSize sets how many recent pull exchanges are kept for WireLog. Zero, the default,
+// disables the wire log.
+func (db *DB) SetWireLogSize(n int) {
+	db.wireLog.mu.Lock()
+	defer db.wireLog.mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	db.wireLog.size = n
+	if len(db.wireLog.entries) > n {
+		db.wireLog.entries = append([]WireLogEntry{}, db.wireLog.entries[len(db.wireLog.entries)-n:]...)
+	}
+}
+
+// WireLog returns the recent pull exchanges, oldest first.
+func (db *DB) WireLog() []WireLogEntry {
+	db.wireLog.mu.Lock()
+	defer db.wireLog.mu.Unlock()
+	return append([]WireLogEntry{}, db.wireLog.entries...)
+}
+
+func (wl *wireLog) getSize() int {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	return wl.size
+}
+
+func (wl *wireLog) enabled() bool {
+	return wl.getSize() > 0
+}
+
+func (wl *wireLog) add(e WireLogEntry) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	if wl.size == 0 {
+		return
+	}
+	if len(wl.entries) == wl.size {
+		wl.entries = wl.entries[1:]
+	}
+	wl.entries = append(wl.entries, e)
+}
+
+// newWireLogEntry returns an entry for the pull request req from the client at genesis.
+func (db *DB) newWireLogEntry(req *http.Request, genesis Commit) WireLogEntry {
+	// The request body is re-encoded rather than captured so that clientViewAuth is redacted.
+	body, err := json.Marshal(servetypes.PullRequest{
+		ClientViewAuth: redacted,
+		ClientID:       db.clientID,
+		BaseStateID:    genesis.Meta.Genesis.ServerStateID,
+		Checksum:       string(genesis.Value.Checksum),
+	})
+	chk.NoError(err)
+	return WireLogEntry{
+		Date:           time.Now(),
+		URL:            req.URL.String(),
+		RequestHeaders: redactHeaders(req.Header),
+		RequestBody:    string(body),
+	}
+}
+
+// redactHeaders flattens h, replacing the values of headers that carry credentials.
+func redactHeaders(h http.Header) map[string]string {
+	r := map[string]string{}
+	for k, vs := range h {
+		switch http.CanonicalHeaderKey(k) {
+		case "Authorization", "Cookie", "Set-Cookie":
+			r[k] = redacted
+		default:
+			r[k] = strings.Join(vs, ", ")
+		}
+	}
+	return r
+}
+
+// truncatingBuffer keeps the first max bytes written to it and discards the rest.
+type truncatingBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *truncatingBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if rem := b.max - b.buf.Len(); n > rem {
+		p = p[:rem]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *truncatingBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "...(truncated)"
+	}
+	return b.buf.String()
+}
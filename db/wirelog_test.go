@@ -0,0 +1,57 @@
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWireLog(t *testing.T) {
+	assert := assert.New(t)
+	db, _ := LoadTempDB(assert)
+
+	body := strings.Repeat("x", wireLogMaxBody+1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Request-Id", "42")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(body))
+	}))
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	// Disabled by default.
+	_, err = db.Pull(sp, "secret-auth", nil)
+	assert.Error(err)
+	assert.Equal([]WireLogEntry{}, db.WireLog())
+
+	db.SetWireLogSize(2)
+	for i := 0; i < 3; i++ {
+		_, err = db.Pull(sp, fmt.Sprintf("secret-auth-%d", i), nil)
+		assert.Error(err)
+	}
+	entries := db.WireLog()
+	assert.Equal(2, len(entries))
+	e := entries[1]
+	assert.Equal(server.URL+"/pull", e.URL)
+	assert.Equal(redacted, e.RequestHeaders["Authorization"])
+	assert.NotContains(e.RequestBody, "secret-auth")
+	assert.Contains(e.RequestBody, db.clientID)
+	assert.Equal(http.StatusBadRequest, e.Status)
+	assert.Equal(redacted, e.ResponseHeaders["Set-Cookie"])
+	assert.Equal("42", e.ResponseHeaders["X-Request-Id"])
+	assert.Equal(body[:wireLogMaxBody]+"...(truncated)", e.ResponseBody)
+	assert.Equal(err.Error(), e.Error)
+	// Logging the response doesn't hide it from bandwidth accounting.
+	assert.Equal(uint64(4*len(body)), db.Bandwidth().Stats()[0].BytesReceived)
+
+	db.SetWireLogSize(1)
+	assert.Equal(entries[1:], db.WireLog())
+	db.SetWireLogSize(0)
+	assert.Equal([]WireLogEntry{}, db.WireLog())
+}
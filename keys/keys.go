@@ -0,0 +1,138 @@
+// Package keys implements order-preserving encodings of numbers, timestamps, and tuples as
+// Replicache keys.
+//
+// Keys are compared as strings, so naively formatted numbers sort incorrectly ("10" < "9") and
+// naively joined composite keys can collide or interleave ("a/b" + "c" vs "a" + "b/c"). The
+// encodings here sort the same way as the values they encode:
+//
+//	EncodeInt(-1) < EncodeInt(0) < EncodeInt(9) < EncodeInt(10)
+//	EncodeTuple("a", "b") < EncodeTuple("a", "b", "c") < EncodeTuple("a\x00", "b")
+//
+// Use TuplePrefix to scan all tuples that start with some components.
+package keys
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// tupleSeparator separates tuple components. It sorts before any other content so that
+	// shorter tuples sort before longer ones with the same leading components.
+	tupleSeparator = "\x00\x00"
+
+	// escapedZero replaces NUL bytes within tuple components.
+	escapedZero = "\x00\x01"
+
+	timeFormat = "2006-01-02T15:04:05.000000000Z"
+)
+
+// EncodeInt encodes i as 16 hex digits that sort in numeric order.
+func EncodeInt(i int64) string {
+	return encodeUint(uint64(i) ^ (1 << 63))
+}
+
+func DecodeInt(s string) (int64, error) {
+	u, err := decodeUint(s)
+	if err != nil {
+		return 0, err
+	}
+	return int64(u ^ (1 << 63)), nil
+}
+
+// EncodeFloat encodes f as 16 hex digits that sort in numeric order. NaN sorts after +Inf.
+func EncodeFloat(f float64) string {
+	u := math.Float64bits(f)
+	if u&(1<<63) != 0 {
+		u = ^u
+	} else {
+		u |= 1 << 63
+	}
+	return encodeUint(u)
+}
+
+func DecodeFloat(s string) (float64, error) {
+	u, err := decodeUint(s)
+	if err != nil {
+		return 0, err
+	}
+	if u&(1<<63) != 0 {
+		u &^= 1 << 63
+	} else {
+		u = ^u
+	}
+	return math.Float64frombits(u), nil
+}
+
+// EncodeTime encodes t as a fixed-width UTC timestamp with nanosecond precision, which sorts
+// chronologically for years 0 through 9999.
+func EncodeTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func DecodeTime(s string) (time.Time, error) {
+	return time.Parse(timeFormat, s)
+}
+
+// EncodeTuple combines parts, each typically itself the output of one of the Encode functions
+// or a plain string, into a single key that sorts by each part in turn.
+func EncodeTuple(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = strings.ReplaceAll(p, "\x00", escapedZero)
+	}
+	return strings.Join(escaped, tupleSeparator)
+}
+
+// DecodeTuple is the inverse of EncodeTuple.
+func DecodeTuple(s string) ([]string, error) {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != 0 {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return nil, fmt.Errorf("Invalid tuple: unexpected end after NUL at %d", i)
+		}
+		i++
+		switch s[i] {
+		case 0:
+			parts = append(parts, b.String())
+			b.Reset()
+		case 1:
+			b.WriteByte(0)
+		default:
+			return nil, fmt.Errorf("Invalid tuple: unexpected byte %#x after NUL at %d", s[i], i-1)
+		}
+	}
+	return append(parts, b.String()), nil
+}
+
+// TuplePrefix returns the prefix shared by all tuples whose leading parts are parts, for use
+// as a scan prefix.
+func TuplePrefix(parts ...string) string {
+	return EncodeTuple(parts...) + tupleSeparator
+}
+
+func encodeUint(u uint64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], u)
+	return hex.EncodeToString(b[:])
+}
+
+func decodeUint(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("Invalid encoded number: '%s' - must be 16 hex digits", s)
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid encoded number: '%s' - %s", s, err)
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
@@ -0,0 +1,115 @@
+package keys
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertSorted(assert *assert.Assertions, encoded []string) {
+	assert.True(sort.StringsAreSorted(encoded), "%q", encoded)
+}
+
+func TestInt(t *testing.T) {
+	assert := assert.New(t)
+	vals := []int64{math.MinInt64, -1000, -1, 0, 1, 9, 10, 1000, math.MaxInt64}
+	encoded := []string{}
+	for _, v := range vals {
+		e := EncodeInt(v)
+		assert.Equal(16, len(e))
+		d, err := DecodeInt(e)
+		assert.NoError(err)
+		assert.Equal(v, d)
+		encoded = append(encoded, e)
+	}
+	assertSorted(assert, encoded)
+	assert.Equal("8000000000000000", EncodeInt(0))
+
+	_, err := DecodeInt("123")
+	assert.EqualError(err, "Invalid encoded number: '123' - must be 16 hex digits")
+	_, err = DecodeInt("zz00000000000000")
+	assert.EqualError(err, "Invalid encoded number: 'zz00000000000000' - encoding/hex: invalid byte: U+007A 'z'")
+}
+
+func TestFloat(t *testing.T) {
+	assert := assert.New(t)
+	vals := []float64{math.Inf(-1), -math.MaxFloat64, -10, -1.5, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 1, 1.5, 10, math.MaxFloat64, math.Inf(1)}
+	encoded := []string{}
+	for _, v := range vals {
+		e := EncodeFloat(v)
+		d, err := DecodeFloat(e)
+		assert.NoError(err)
+		assert.Equal(v, d)
+		encoded = append(encoded, e)
+	}
+	assertSorted(assert, encoded)
+
+	d, err := DecodeFloat(EncodeFloat(math.NaN()))
+	assert.NoError(err)
+	assert.True(math.IsNaN(d))
+}
+
+func TestTime(t *testing.T) {
+	assert := assert.New(t)
+	base := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	vals := []time.Time{
+		time.Date(999, 1, 1, 0, 0, 0, 0, time.UTC),
+		base,
+		base.Add(time.Nanosecond),
+		base.Add(time.Second),
+		time.Date(2020, 1, 2, 12, 0, 0, 0, time.FixedZone("x", 8*60*60)), // 04:00 UTC
+	}
+	encoded := []string{}
+	for _, v := range vals {
+		e := EncodeTime(v)
+		d, err := DecodeTime(e)
+		assert.NoError(err)
+		assert.True(v.Equal(d))
+		encoded = append(encoded, e)
+	}
+	assertSorted(assert, encoded)
+	assert.Equal("2020-01-02T03:04:05.000000000Z", EncodeTime(base))
+}
+
+func TestTuple(t *testing.T) {
+	assert := assert.New(t)
+	vals := [][]string{
+		{""},
+		{"a"},
+		{"a", ""},
+		{"a", "b"},
+		{"a", "b", "c"},
+		{"a", "b\x00"},
+		{"a\x00", "b"},
+		{"a\x00\x00"},
+		{"a\x01"},
+		{"a/b"},
+		{"ab"},
+	}
+	encoded := []string{}
+	for _, v := range vals {
+		e := EncodeTuple(v...)
+		d, err := DecodeTuple(e)
+		assert.NoError(err)
+		assert.Equal(v, d)
+		encoded = append(encoded, e)
+	}
+	assertSorted(assert, encoded)
+
+	p := TuplePrefix("a")
+	for _, v := range [][]string{{"a", ""}, {"a", "b"}, {"a", "b", "c"}} {
+		assert.True(len(EncodeTuple(v...)) >= len(p) && EncodeTuple(v...)[:len(p)] == p, "%q", v)
+	}
+	for _, v := range [][]string{{"a"}, {"ab"}, {"a\x00", "b"}} {
+		e := EncodeTuple(v...)
+		assert.False(len(e) >= len(p) && e[:len(p)] == p, "%q", v)
+	}
+
+	_, err := DecodeTuple("a\x00")
+	assert.EqualError(err, "Invalid tuple: unexpected end after NUL at 1")
+	_, err = DecodeTuple("a\x00\x02")
+	assert.EqualError(err, "Invalid tuple: unexpected byte 0x2 after NUL at 1")
+}
@@ -0,0 +1,74 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// GCSStore writes objects to a Google Cloud Storage bucket using the JSON API.
+type GCSStore struct {
+	Bucket string
+	// Token returns an OAuth 2.0 access token with write access to the bucket, e.g. from the
+	// metadata server or `gcloud auth print-access-token`.
+	Token func(ctx context.Context) (string, error)
+	// Endpoint is the URL of the service. It defaults to https://storage.googleapis.com.
+	Endpoint string
+	// Client is used to send requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (s *GCSStore) Put(ctx context.Context, o Object) error {
+	token, err := s.Token(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+
+	// A multipart upload is used so that the object's metadata, which includes Cache-Control,
+	// is set along with its data.
+	meta, err := json.Marshal(struct {
+		Name         string `json:"name"`
+		ContentType  string `json:"contentType"`
+		CacheControl string `json:"cacheControl,omitempty"`
+	}{o.Name, o.ContentType, o.CacheControl})
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		data        []byte
+	}{{"application/json; charset=UTF-8", meta}, {o.ContentType, o.Data}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(part.data); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=multipart", strings.TrimSuffix(endpoint, "/"), url.PathEscape(s.Bucket))
+	req, err := http.NewRequest("POST", u, &body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return send(s.Client, req)
+}
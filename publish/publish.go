@@ -0,0 +1,171 @@
+// Package publish exports a replica's data to object storage, such as S3 or GCS, for backup
+// and so that new clients can be seeded from a CDN rather than a diff-server.
+//
+// Published objects are pull responses, in the format the diff-server returns, named relative
+// to Options.Prefix:
+//
+//	latest.json              a Latest, describing the current state and snapshot
+//	snapshots/<state>.json   the whole data as of state, as for a pull with no base state
+//	patches/<state>.json     the changes from state to the next published state
+//
+// A client seeds itself by applying the latest snapshot, e.g. with DB.Seed or the seedURL open
+// option, then follows patches until it reaches the latest state. State IDs are hashes of the data, so publishing unchanged data
+// writes nothing.
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/types"
+
+	servetypes "roci.dev/diff-server/serve/types"
+
+	"roci.dev/replicache-client/db"
+)
+
+const (
+	contentTypeJSON = "application/json"
+	// Snapshots and patches never change once written, but latest.json does.
+	cacheImmutable = "public, max-age=31536000, immutable"
+	cacheNone      = "no-cache"
+)
+
+// Object is an object to write to a Store.
+type Object struct {
+	Name         string
+	Data         []byte
+	ContentType  string
+	CacheControl string
+}
+
+// Store is where published objects are written. S3Store and GCSStore are provided.
+type Store interface {
+	Put(ctx context.Context, o Object) error
+}
+
+// Latest describes the most recently published state.
+type Latest struct {
+	StateID  string `json:"stateID"`
+	Checksum string `json:"checksum"`
+	// Snapshot is the name of the most recent snapshot, relative to the prefix. Patches lead
+	// from it to StateID.
+	Snapshot string `json:"snapshot"`
+}
+
+type Options struct {
+	// Prefix is prepended to the names of published objects, e.g. "backups/mydb/".
+	Prefix string
+	// SnapshotEvery is the number of publishes between full snapshots. The first publish is
+	// always a snapshot. Zero means every publish is a snapshot.
+	SnapshotEvery int
+}
+
+// Publisher publishes the data of a replica to a Store.
+type Publisher struct {
+	db    *db.DB
+	store Store
+	opts  Options
+
+	last          types.Map
+	lastID        string
+	lastSnapshot  string
+	sinceSnapshot int
+}
+
+// New returns a Publisher that publishes d to store.
+func New(d *db.DB, store Store, opts Options) *Publisher {
+	return &Publisher{db: d, store: store, opts: opts}
+}
+
+// Publish publishes the current data of the replica: a patch from the previously published
+// state, and a snapshot if one is due. It does nothing if the data hasn't changed.
+func (p *Publisher) Publish(ctx context.Context) error {
+	data := p.db.Head().Data(p.db.Noms())
+	m := data.NomsMap()
+	id := m.Hash().String()
+	if id == p.lastID {
+		return nil
+	}
+
+	if p.lastID != "" {
+		patch, err := db.DiffPatch(p.last, m)
+		if err != nil {
+			return err
+		}
+		err = p.put(ctx, "patches/"+p.lastID+".json", servetypes.PullResponse{
+			Patch:    patch,
+			StateID:  id,
+			Checksum: data.Checksum(),
+		}, cacheImmutable)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshot := p.lastSnapshot
+	if p.lastID == "" || p.sinceSnapshot >= p.opts.SnapshotEvery {
+		patch, err := db.SnapshotPatch(m)
+		if err != nil {
+			return err
+		}
+		snapshot = "snapshots/" + id + ".json"
+		err = p.put(ctx, snapshot, servetypes.PullResponse{
+			Patch:    patch,
+			StateID:  id,
+			Checksum: data.Checksum(),
+		}, cacheImmutable)
+		if err != nil {
+			return err
+		}
+	}
+
+	// latest.json is written last, so that everything it refers to exists.
+	err := p.put(ctx, "latest.json", Latest{StateID: id, Checksum: data.Checksum(), Snapshot: snapshot}, cacheNone)
+	if err != nil {
+		return err
+	}
+
+	if snapshot != p.lastSnapshot {
+		p.sinceSnapshot = 0
+	} else {
+		p.sinceSnapshot++
+	}
+	p.last, p.lastID, p.lastSnapshot = m, id, snapshot
+	return nil
+}
+
+// Run publishes every interval until ctx is done, and returns the error that stopped it.
+func (p *Publisher) Run(ctx context.Context, interval gtime.Duration) error {
+	t := gtime.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := p.Publish(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (p *Publisher) put(ctx context.Context, name string, v interface{}, cacheControl string) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = p.store.Put(ctx, Object{
+		Name:         p.opts.Prefix + name,
+		Data:         b,
+		ContentType:  contentTypeJSON,
+		CacheControl: cacheControl,
+	})
+	if err != nil {
+		return fmt.Errorf("could not publish %s: %w", name, err)
+	}
+	return nil
+}
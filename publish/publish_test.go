@@ -0,0 +1,110 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	servetypes "roci.dev/diff-server/serve/types"
+
+	"roci.dev/replicache-client/db"
+)
+
+type memStore struct {
+	objects map[string]Object
+	err     error
+}
+
+func (s *memStore) Put(ctx context.Context, o Object) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.objects[o.Name] = o
+	return nil
+}
+
+func (s *memStore) names() []string {
+	r := []string{}
+	for n := range s.objects {
+		r = append(r, n)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func TestPublish(t *testing.T) {
+	assert := assert.New(t)
+	d, _ := db.LoadTempDB(assert)
+	store := &memStore{objects: map[string]Object{}}
+	p := New(d, store, Options{Prefix: "mydb/", SnapshotEvery: 1})
+	ctx := context.Background()
+
+	latest := func() Latest {
+		var l Latest
+		o := store.objects["mydb/latest.json"]
+		assert.Equal(cacheNone, o.CacheControl)
+		assert.NoError(json.Unmarshal(o.Data, &l))
+		return l
+	}
+	response := func(name string) servetypes.PullResponse {
+		var r servetypes.PullResponse
+		o, ok := store.objects["mydb/"+name]
+		assert.True(ok, name)
+		assert.Equal(contentTypeJSON, o.ContentType)
+		assert.Equal(cacheImmutable, o.CacheControl)
+		assert.NoError(json.Unmarshal(o.Data, &r))
+		return r
+	}
+
+	// The first publish is a snapshot.
+	assert.NoError(d.Put("foo", []byte(`"bar"`)))
+	assert.NoError(p.Publish(ctx))
+	s1 := latest()
+	assert.Equal("snapshots/"+s1.StateID+".json", s1.Snapshot)
+	assert.Equal([]string{"mydb/latest.json", "mydb/" + s1.Snapshot}, store.names())
+	r := response(s1.Snapshot)
+	assert.Equal(s1.StateID, r.StateID)
+	assert.Equal(s1.Checksum, r.Checksum)
+	assert.Equal(2, len(r.Patch))
+
+	// Unchanged data isn't published again.
+	assert.NoError(p.Publish(ctx))
+	assert.Equal(2, len(store.names()))
+
+	// Then patches, until a snapshot is due.
+	assert.NoError(d.Put("foo", []byte(`"baz"`)))
+	assert.NoError(p.Publish(ctx))
+	s2 := latest()
+	assert.NotEqual(s1.StateID, s2.StateID)
+	assert.Equal(s1.Snapshot, s2.Snapshot)
+	r = response("patches/" + s1.StateID + ".json")
+	assert.Equal(s2.StateID, r.StateID)
+	assert.Equal(1, len(r.Patch))
+	assert.Equal("replace", r.Patch[0].Op)
+
+	assert.NoError(d.Put("qux", []byte(`1`)))
+	assert.NoError(p.Publish(ctx))
+	s3 := latest()
+	assert.Equal("snapshots/"+s3.StateID+".json", s3.Snapshot)
+	response("patches/" + s2.StateID + ".json")
+	response(s3.Snapshot)
+	assert.Equal(6, len(store.names()))
+
+	// Failed writes are retried by the next publish.
+	store.err = errors.New("bonk")
+	assert.NoError(d.Put("qux", []byte(`2`)))
+	assert.EqualError(p.Publish(ctx), "could not publish patches/"+s3.StateID+".json: bonk")
+	store.err = nil
+	assert.NoError(p.Publish(ctx))
+	assert.NotEqual(s3.StateID, latest().StateID)
+	response("patches/" + s3.StateID + ".json")
+
+	// Run publishes until its context is done.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(context.Canceled, p.Run(ctx, 1))
+}
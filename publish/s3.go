@@ -0,0 +1,149 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	gtime "time"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// S3Store writes objects to an Amazon S3 bucket, or to a bucket of an S3-compatible service,
+// signing requests with AWS Signature Version 4.
+type S3Store struct {
+	Bucket string
+	Region string
+	// Endpoint is the URL of the bucket. It defaults to
+	// https://<Bucket>.s3.<Region>.amazonaws.com, and for S3-compatible services is typically
+	// of the form https://host/<Bucket>.
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is required for temporary credentials.
+	SessionToken string
+	// Client is used to send requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (s *S3Store) Put(ctx context.Context, o Object) error {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.Bucket, s.Region)
+	}
+	req, err := http.NewRequest("PUT", strings.TrimSuffix(endpoint, "/")+"/"+awsEscape(o.Name), bytes.NewReader(o.Data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", o.ContentType)
+	if o.CacheControl != "" {
+		req.Header.Set("Cache-Control", o.CacheControl)
+	}
+	s.sign(req, o.Data, time.Now())
+	return send(s.Client, req)
+}
+
+// sign adds the AWS Signature Version 4 headers for the request to req. See
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html.
+func (s *S3Store) sign(req *http.Request, body []byte, now gtime.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	dateTime := now.Format("20060102T150405Z")
+	payloadHash := hexSHA256(body)
+	req.Header.Set("X-Amz-Date", dateTime)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	// All the headers set so far are signed, along with the host.
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", dateTime, scope, hexSHA256([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, k := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, k)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// awsEscape URI-encodes name as AWS requires: every byte except unreserved characters and
+// slashes is percent-encoded.
+func awsEscape(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// send sends req and returns an error if it fails or the response isn't a success.
+func send(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, err := ioutil.ReadAll(resp.Body)
+		var s string
+		if err == nil {
+			s = string(body)
+		} else {
+			s = err.Error()
+		}
+		return fmt.Errorf("%s: %s", resp.Status, s)
+	}
+	return nil
+}
@@ -1,50 +1,196 @@
 package repm
 
 import (
+	"archive/zip"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/attic-labs/noms/go/hash"
+
+	servetypes "roci.dev/diff-server/serve/types"
 	"roci.dev/diff-server/util/chk"
 	jsnoms "roci.dev/diff-server/util/noms/json"
+	"roci.dev/diff-server/util/version"
 	"roci.dev/replicache-client/db"
+	"roci.dev/replicache-client/keys"
 )
 
+// defaultScanMaxBytes is the default bound on the size of scan responses. See
+// ScanRequest.MaxBytes.
+const defaultScanMaxBytes = 4 << 20
+
 type connection struct {
+	name    string
+	account string
 	dir     string
-	db      *db.DB
-	sp      pullProgress
-	pulling int32
+	scratch scratch
+	// schemaVersion is the Dispatch schema version spoken by the SDK that opened the
+	// database. Requests and responses are translated to and from it.
+	schemaVersion int
+	db            *db.DB
+	// sp holds the pullProgress of the latest pull, which the pull stores while the
+	// pullProgress rpc reads it.
+	sp       atomic.Value
+	pulling  int32
+	lastUsed time.Time
+	recent   idempotencyCache
+	// active is the number of rpcs in flight on the connection, which close waits for with
+	// inFlight. Connections with rpcs in flight are not unloaded for being idle.
+	active   int32
+	inFlight sync.WaitGroup
+	// mu guards the settings below, which are reapplied if the database is unloaded for
+	// being idle.
+	mu      sync.Mutex
+	audit   db.AuditOptions
+	wireLog int
+	// pendingLimit is OpenRequest.PendingLimit.
+	pendingLimit int
+	// hotPrefixes are the prefixes set with setHotPrefixes.
+	hotPrefixes []string
+	// bandwidth is the bandwidth accounting of the database, kept while it is unloaded.
+	bandwidth *db.Bandwidth
+	// events is the event log of the database, kept while it is unloaded.
+	events *db.EventLog
+	// loading is non-nil while the database opened by OpenAsync has not been picked up by
+	// ensureLoaded, or if it failed to load.
+	loading *asyncLoad
+	// loadMu serializes ensureLoaded, so that concurrent rpcs load the database only once.
+	loadMu sync.Mutex
 }
 
 type pullProgress struct {
-	bytesReceived uint64
-	bytesExpected uint64
+	phase              db.PullPhase
+	bytesReceived      uint64
+	bytesExpected      uint64
+	opsApplied         uint64
+	opsExpected        uint64
+	hotPrefixes        []db.PrefixProgress
+	hotPrefixesApplied bool
+	// started is when the pull started, and updated when it last reported progress.
+	started time.Time
+	updated time.Time
+}
+
+// bytesPerSecond returns the average rate at which the pull has received bytes.
+func (p pullProgress) bytesPerSecond() float64 {
+	d := p.updated.Sub(p.started).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(p.bytesReceived) / d
+}
+
+// eta returns the estimated time until the pull has received all its bytes, or zero if it
+// can't be estimated.
+func (p pullProgress) eta() time.Duration {
+	rate := p.bytesPerSecond()
+	if rate == 0 || p.bytesExpected <= p.bytesReceived {
+		return 0
+	}
+	return time.Duration(float64(p.bytesExpected-p.bytesReceived) / rate * float64(time.Second))
+}
+
+func dispatchEncodeKey(reqBytes []byte) ([]byte, error) {
+	var req EncodeKeyRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Parts) == 0 {
+		return nil, errors.New("at least one key part is required")
+	}
+	parts := make([]string, 0, len(req.Parts))
+	for i, p := range req.Parts {
+		var n int
+		var s string
+		if p.String != nil {
+			n++
+			s = *p.String
+		}
+		if p.Int != nil {
+			n++
+			s = keys.EncodeInt(*p.Int)
+		}
+		if p.Number != nil {
+			n++
+			s = keys.EncodeFloat(*p.Number)
+		}
+		if p.Time != nil {
+			n++
+			s = keys.EncodeTime(*p.Time)
+		}
+		if n != 1 {
+			return nil, fmt.Errorf("key part %d must have exactly one of string, int, number, or time", i)
+		}
+		parts = append(parts, s)
+	}
+	return mustMarshal(EncodeKeyResponse{Key: keys.EncodeTuple(parts...)}), nil
 }
 
-func (conn *connection) dispatchGetRoot(reqBytes []byte) ([]byte, error) {
+func (conn *connection) dispatchGetRoot(ctx context.Context, reqBytes []byte) ([]byte, error) {
 	var req GetRootRequest
 	err := json.Unmarshal(reqBytes, &req)
 	if err != nil {
 		return nil, err
 	}
 
+	root := conn.db.Hash()
+	if req.WaitForChangeFrom != "" {
+		from, ok := hash.MaybeParse(req.WaitForChangeFrom)
+		if !ok {
+			return nil, fmt.Errorf("Invalid waitForChangeFrom: '%s'", req.WaitForChangeFrom)
+		}
+		root, err = conn.db.WaitForChange(ctx, from, time.Duration(req.TimeoutMs)*time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+	}
 	res := GetRootResponse{
 		Root: jsnoms.Hash{
-			Hash: conn.db.Hash(),
+			Hash: root,
 		},
 	}
+	if req.IncludeSyncInfo {
+		si, err := conn.db.SyncInfo()
+		if err != nil {
+			return nil, err
+		}
+		throttlePollInterval(&si)
+		res.SyncInfo = &si
+	}
 	return mustMarshal(res), nil
 }
 
-func (conn *connection) dispatchHas(reqBytes []byte) ([]byte, error) {
+func (conn *connection) dispatchSyncInfo(reqBytes []byte) ([]byte, error) {
+	var req SyncInfoRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	si, err := conn.db.SyncInfo()
+	if err != nil {
+		return nil, err
+	}
+	throttlePollInterval(&si)
+	return mustMarshal(SyncInfoResponse(si)), nil
+}
+
+func (conn *connection) dispatchHas(ctx context.Context, reqBytes []byte) ([]byte, error) {
 	var req HasRequest
 	err := json.Unmarshal(reqBytes, &req)
 	if err != nil {
 		return nil, err
 	}
-	ok, err := conn.db.Has(req.ID)
+	ok, err := conn.db.HasCtx(ctx, req.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -54,13 +200,13 @@ func (conn *connection) dispatchHas(reqBytes []byte) ([]byte, error) {
 	return mustMarshal(res), nil
 }
 
-func (conn *connection) dispatchGet(reqBytes []byte) ([]byte, error) {
+func (conn *connection) dispatchGet(ctx context.Context, reqBytes []byte) ([]byte, error) {
 	var req GetRequest
 	err := json.Unmarshal(reqBytes, &req)
 	if err != nil {
 		return nil, err
 	}
-	v, err := conn.db.Get(req.ID)
+	v, err := conn.db.GetCtx(ctx, req.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -74,20 +220,157 @@ func (conn *connection) dispatchGet(reqBytes []byte) ([]byte, error) {
 	return mustMarshal(res), nil
 }
 
-func (conn *connection) dispatchScan(reqBytes []byte) ([]byte, error) {
+func (conn *connection) dispatchGetMeta(reqBytes []byte) ([]byte, error) {
+	var req GetMetaRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok, err := conn.db.GetMeta(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	res := GetMetaResponse{
+		Has: ok,
+	}
+	if ok {
+		res.Meta = &meta
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchScan(ctx context.Context, reqBytes []byte) ([]byte, error) {
 	var req ScanRequest
 	err := json.Unmarshal(reqBytes, &req)
 	if err != nil {
 		return nil, err
 	}
-	items, err := conn.db.Scan(db.ScanOptions(req))
+	if conn.schemaVersion < 2 {
+		items, err := conn.db.ScanCtx(ctx, req.ScanOptions)
+		if err != nil {
+			return nil, err
+		}
+		return mustMarshal(items), nil
+	}
+
+	maxBytes := req.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultScanMaxBytes
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = db.DefaultScanLimit
+	}
+	opts := req.ScanOptions
+	if limit > 0 {
+		// One more item than requested is scanned to find out whether the scan is done. A
+		// negative limit is left for ScanCtx to reject.
+		opts.Limit = limit + 1
+	}
+	items, err := conn.db.ScanCtx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	res := ScanResponse{Values: []db.ScanItem{}, Done: true}
+	size := 0
+	for i, it := range items {
+		n := len(mustMarshal(it))
+		if i == limit || (i > 0 && size+n > maxBytes) {
+			res.Done = false
+			res.Next = &db.ScanBound{ID: &db.ScanID{Value: items[i-1].ID, Exclusive: true}}
+			break
+		}
+		size += n
+		res.Values = append(res.Values, it)
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchScanDeleted(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	var req ScanDeletedRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	items, err := conn.db.ScanDeletedCtx(ctx, db.ScanDeletedOptions(req))
 	if err != nil {
 		return nil, err
 	}
 	return mustMarshal(items), nil
 }
 
-func (conn *connection) dispatchPut(reqBytes []byte) ([]byte, error) {
+func (conn *connection) dispatchAggregate(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	var req AggregateRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.db.Aggregate(ctx, db.AggregateOptions(req))
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(AggregateResponse(res)), nil
+}
+
+func (conn *connection) dispatchCollectionScan(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	var req CollectionScanRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	c, err := conn.db.Collection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+	items, err := c.Scan(ctx, req.ScanOptions)
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(items), nil
+}
+
+func (conn *connection) dispatchCollectionCount(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	var req CollectionCountRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	c, err := conn.db.Collection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+	n, err := c.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(CollectionCountResponse{Count: n}), nil
+}
+
+func (conn *connection) dispatchCollectionClear(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	var req CollectionClearRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	c, err := conn.db.Collection(req.Collection)
+	if err != nil {
+		return nil, err
+	}
+	ctx = db.WithTags(ctx, req.Tags)
+	n, err := c.Clear(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := CollectionClearResponse{
+		Count: n,
+		Root: jsnoms.Hash{
+			Hash: conn.db.Hash(),
+		},
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchPut(ctx context.Context, reqBytes []byte) ([]byte, error) {
 	var req PutRequest
 	err := json.Unmarshal(reqBytes, &req)
 	if err != nil {
@@ -96,7 +379,8 @@ func (conn *connection) dispatchPut(reqBytes []byte) ([]byte, error) {
 	if len(req.Value) == 0 {
 		return nil, errors.New("value field is required")
 	}
-	err = conn.db.Put(req.ID, req.Value)
+	ctx = db.WithTags(ctx, req.Tags)
+	err = conn.db.PutCtx(ctx, req.ID, req.Value)
 	if err != nil {
 		return nil, err
 	}
@@ -108,13 +392,14 @@ func (conn *connection) dispatchPut(reqBytes []byte) ([]byte, error) {
 	return mustMarshal(res), nil
 }
 
-func (conn *connection) dispatchDel(reqBytes []byte) ([]byte, error) {
+func (conn *connection) dispatchDel(ctx context.Context, reqBytes []byte) ([]byte, error) {
 	req := DelRequest{}
 	err := json.Unmarshal(reqBytes, &req)
 	if err != nil {
 		return nil, err
 	}
-	ok, err := conn.db.Del(req.ID)
+	ctx = db.WithTags(ctx, req.Tags)
+	ok, err := conn.db.DelCtx(ctx, req.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -127,28 +412,382 @@ func (conn *connection) dispatchDel(reqBytes []byte) ([]byte, error) {
 	return mustMarshal(res), nil
 }
 
-func (conn *connection) dispatchPull(reqBytes []byte) ([]byte, error) {
-	var req PullRequest
+func (conn *connection) dispatchClear(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	var req ClearRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	ctx = db.WithTags(ctx, req.Tags)
+	n, err := conn.db.ClearCtx(ctx, req.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	res := ClearResponse{
+		Count: n,
+		Root: jsnoms.Hash{
+			Hash: conn.db.Hash(),
+		},
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchCheckpoint(reqBytes []byte) ([]byte, error) {
+	var req CheckpointRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.db.Checkpoint(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	res := CheckpointResponse{
+		Root: jsnoms.Hash{
+			Hash: conn.db.Hash(),
+		},
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchRestore(reqBytes []byte) ([]byte, error) {
+	var req RestoreRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.db.Restore(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	res := RestoreResponse{
+		Root: jsnoms.Hash{
+			Hash: conn.db.Hash(),
+		},
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchReset() ([]byte, error) {
+	err := conn.db.Reset()
+	if err != nil {
+		return nil, err
+	}
+	res := ResetResponse{
+		Root: jsnoms.Hash{
+			Hash: conn.db.Hash(),
+		},
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchSquashPending(reqBytes []byte) ([]byte, error) {
+	var req SquashPendingRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	n, err := conn.db.SquashPending()
+	if err != nil {
+		return nil, err
+	}
+	res := SquashPendingResponse{
+		Root: jsnoms.Hash{
+			Hash: conn.db.Hash(),
+		},
+		Squashed: n,
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchFingerprint() ([]byte, error) {
+	res := FingerprintResponse{
+		Fingerprint: jsnoms.Hash{
+			Hash: conn.db.Fingerprint(),
+		},
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchPreviewPatch(reqBytes []byte) ([]byte, error) {
+	var req PreviewPatchRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.db.PreviewPatch(servetypes.PullResponse(req))
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(PreviewPatchResponse(res)), nil
+}
+
+func (conn *connection) dispatchVerifyState(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	var req VerifyStateRequest
 	err := json.Unmarshal(reqBytes, &req)
 	if err != nil {
 		return nil, err
 	}
+	res, err := conn.db.VerifyState(ctx, req.Remote.Spec, req.ClientViewAuth)
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(VerifyStateResponse(res)), nil
+}
+
+func (conn *connection) dispatchSetConfig(reqBytes []byte) ([]byte, error) {
+	var req SetConfigRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.db.SetConfig(req.Key, req.Value)
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(SetConfigResponse{}), nil
+}
+
+func (conn *connection) dispatchGetConfig(reqBytes []byte) ([]byte, error) {
+	var req GetConfigRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	v, err := conn.db.GetConfig(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	res := GetConfigResponse{
+		Has:   v != nil,
+		Value: v,
+	}
+	return mustMarshal(res), nil
+}
+
+func (conn *connection) dispatchSetAudit(reqBytes []byte) ([]byte, error) {
+	var req SetAuditRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	// Remembered so that it can be reapplied if the database is unloaded for being idle.
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.audit = db.AuditOptions(req)
+	conn.db.SetAudit(conn.audit)
+	return mustMarshal(SetAuditResponse{}), nil
+}
+
+func (conn *connection) dispatchExportAudit(reqBytes []byte) ([]byte, error) {
+	var req ExportAuditRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := conn.db.ExportAudit(db.ExportAuditOptions(req))
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(entries), nil
+}
+
+func (conn *connection) dispatchSetWireLogSize(reqBytes []byte) ([]byte, error) {
+	var req SetWireLogSizeRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.wireLog = req.Size
+	conn.db.SetWireLogSize(req.Size)
+	return mustMarshal(SetWireLogSizeResponse{}), nil
+}
+
+func (conn *connection) dispatchSetHotPrefixes(reqBytes []byte) ([]byte, error) {
+	var req SetHotPrefixesRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.hotPrefixes = req.Prefixes
+	conn.db.SetHotPrefixes(req.Prefixes)
+	return mustMarshal(SetHotPrefixesResponse{}), nil
+}
+
+func (conn *connection) dispatchSetBandwidthQuota(reqBytes []byte) ([]byte, error) {
+	var req SetBandwidthQuotaRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	conn.db.Bandwidth().SetQuota(db.BandwidthQuota(req))
+	return mustMarshal(SetBandwidthQuotaResponse{}), nil
+}
+
+func (conn *connection) dispatchBandwidthStats(reqBytes []byte) ([]byte, error) {
+	var req BandwidthStatsRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(BandwidthStatsResponse{Remotes: conn.db.Bandwidth().Stats()}), nil
+}
+
+func (conn *connection) dispatchPollEvents(reqBytes []byte) ([]byte, error) {
+	var req PollEventsRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	events, dropped := conn.db.EventLog().Since(req.SinceSeq)
+	return mustMarshal(PollEventsResponse{Events: events, Dropped: dropped}), nil
+}
+
+func (conn *connection) dispatchDebugDump(reqBytes []byte) ([]byte, error) {
+	var req DebugDumpRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	zipPath := ""
+	if req.ZipPath != "" {
+		// Only the scratch directory may be written to, since the caller may be remote (see
+		// httprpc).
+		if strings.ContainsAny(req.ZipPath, `/\`) || req.ZipPath == "." || req.ZipPath == ".." {
+			return nil, fmt.Errorf("%w: zipPath must be a file name, not a path: %s", db.ErrInvalidArgument, req.ZipPath)
+		}
+		zipPath = path.Join(string(conn.scratch), req.ZipPath)
+	}
+	info, err := conn.db.DebugInfo()
+	if err != nil {
+		return nil, err
+	}
+	usage, err := conn.scratch.usage()
+	if err != nil {
+		return nil, err
+	}
+	res := DebugDumpResponse{
+		Version: version.Version(),
+		DB:      info,
+		Config: DebugConfig{
+			IdleTimeoutMs: int64(idleTimeout / time.Millisecond),
+			ScratchLimit:  scratchLimit,
+		},
+		ScratchUsage: usage,
+		Logs:         recentLogs.recent(),
+		ZipPath:      zipPath,
+	}
+	buf := mustMarshal(res)
+	if zipPath != "" {
+		err = writeZip(zipPath, "debugdump.json", buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// writeZip writes a zip archive to path containing a single file name with contents data.
+func writeZip(path, name string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (conn *connection) dispatchPull(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	res, err := conn.pull(ctx, "pull", reqBytes)
+	if err != nil {
+		return nil, err
+	}
+	return mustMarshal(res), nil
+}
+
+// pull performs the pull described by reqBytes, a PullRequest, on behalf of rpc.
+func (conn *connection) pull(ctx context.Context, rpc string, reqBytes []byte) (PullResponse, error) {
+	// File remotes aren't Noms database specs, so they are recognized before req is decoded.
+	var fileReq struct {
+		Remote      string `json:"remote"`
+		Speculative bool   `json:"speculative"`
+	}
+	var req PullRequest
+	var remote, dir string
+	var isFile bool
+	if json.Unmarshal(reqBytes, &fileReq) == nil {
+		dir, isFile = db.FileRemoteDir(fileReq.Remote)
+	}
+	if isFile {
+		remote = fileReq.Remote
+	} else {
+		err := json.Unmarshal(reqBytes, &req)
+		if err != nil {
+			return PullResponse{}, err
+		}
+		remote = req.Remote.Spec.String()
+	}
 
 	if !atomic.CompareAndSwapInt32(&conn.pulling, 0, 1) {
-		return nil, errors.New("There is already a pull in progress")
+		return PullResponse{}, errors.New("There is already a pull in progress")
 	}
 
-	defer chk.True(atomic.CompareAndSwapInt32(&conn.pulling, 1, 0), "UNEXPECTED STATE: Overlapping pulls somehow!")
+	defer func() {
+		chk.True(atomic.CompareAndSwapInt32(&conn.pulling, 1, 0), "UNEXPECTED STATE: Overlapping pulls somehow!")
+	}()
 
 	res := PullResponse{}
-	clientViewInfo, err := conn.db.Pull(req.Remote.Spec, req.ClientViewAuth, func(received, expected uint64) {
-		conn.sp = pullProgress{
-			bytesReceived: received,
-			bytesExpected: expected,
+	speculative := req.Speculative || fileReq.Speculative || rpc == "runBackgroundSync"
+	if (speculative && !allowSpeculativeSync()) || !allowSync(conn.db, conn.name, rpc, remote) {
+		res.Deferred = true
+		res.Root = jsnoms.Hash{
+			Hash: conn.db.Hash(),
+		}
+		return res, nil
+	}
+	if isFile {
+		if err := conn.db.SyncFile(ctx, dir); err != nil {
+			return PullResponse{}, err
+		}
+		res.Root = jsnoms.Hash{
+			Hash: conn.db.Hash(),
 		}
+		return res, nil
+	}
+	started := time.Now()
+	conn.sp.Store(pullProgress{started: started, updated: started})
+	clientViewInfo, err := conn.db.PullCtx(ctx, req.Remote.Spec, req.ClientViewAuth, func(p db.PullProgress) {
+		conn.sp.Store(pullProgress{
+			phase:              p.Phase,
+			bytesReceived:      p.BytesReceived,
+			bytesExpected:      p.BytesExpected,
+			opsApplied:         p.OpsApplied,
+			opsExpected:        p.OpsExpected,
+			hotPrefixes:        p.HotPrefixes,
+			hotPrefixesApplied: p.HotPrefixesApplied,
+			started:            started,
+			updated:            time.Now(),
+		})
 	})
 	if err != nil {
-		return nil, err
+		return PullResponse{}, err
 	}
 	res.Root = jsnoms.Hash{
 		Hash: conn.db.Hash(),
@@ -160,7 +799,7 @@ func (conn *connection) dispatchPull(reqBytes []byte) ([]byte, error) {
 		}
 	}
 
-	return mustMarshal(res), nil
+	return res, nil
 }
 
 func (conn *connection) dispatchPullProgress(reqBytes []byte) ([]byte, error) {
@@ -169,9 +808,21 @@ func (conn *connection) dispatchPullProgress(reqBytes []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	sp, _ := conn.sp.Load().(pullProgress)
 	res := PullProgressResponse{
-		BytesReceived: conn.sp.bytesReceived,
-		BytesExpected: conn.sp.bytesExpected,
+		Phase:              sp.phase.String(),
+		BytesReceived:      sp.bytesReceived,
+		BytesExpected:      sp.bytesExpected,
+		OpsApplied:         sp.opsApplied,
+		OpsExpected:        sp.opsExpected,
+		HotPrefixes:        sp.hotPrefixes,
+		HotPrefixesApplied: sp.hotPrefixesApplied,
+		BytesPerSecond:     uint64(sp.bytesPerSecond()),
+		EtaMs:              int64(sp.eta() / time.Millisecond),
+	}
+	if !sp.started.IsZero() {
+		res.StartedAt = &sp.started
+		res.UpdatedAt = &sp.updated
 	}
 	return mustMarshal(res), nil
 }
@@ -14,6 +14,8 @@ import (
 
 	jsnoms "roci.dev/diff-server/util/noms/json"
 	"roci.dev/diff-server/util/time"
+
+	"roci.dev/replicache-client/db"
 )
 
 func TestBasics(t *testing.T) {
@@ -42,12 +44,24 @@ func TestBasics(t *testing.T) {
 		// attempt to write non-json with put()
 		// attempt to read non-json with get()
 
+		// encodeKey
+		{"encodeKey", invalidRequest, ``, invalidRequestError},
+		{"encodeKey", `{"parts":[]}`, ``, "at least one key part is required"},
+		{"encodeKey", `{"parts":[{"string":"a","int":1}]}`, ``, "key part 0 must have exactly one of string, int, number, or time"},
+		{"encodeKey", `{"parts":[{"string":"todo"},{"int":0}]}`, `{"key":"todo\u0000\u00008000000000000000"}`, ""},
+		{"encodeKey", `{"parts":[{"time":"2020-01-02T03:04:05Z"}]}`, `{"key":"2020-01-02T03:04:05.000000000Z"}`, ""},
+
 		// getRoot on empty db
 		{"getRoot", `{}`, `{"root":"4p3l8m7gjkkd8g3g0glothm038s61123"}`, ""},
 
+		// syncInfo
+		{"syncInfo", invalidRequest, ``, invalidRequestError},
+
 		// put
 		{"put", invalidRequest, ``, invalidRequestError},
 		{"getRoot", `{}`, `{"root":"4p3l8m7gjkkd8g3g0glothm038s61123"}`, ""}, // getRoot when db didn't change
+		{"getRoot", `{"waitForChangeFrom": "4p3l8m7gjkkd8g3g0glothm038s61123", "timeoutMs": 1}`, `{"root":"4p3l8m7gjkkd8g3g0glothm038s61123"}`, ""},
+		{"getRoot", `{"waitForChangeFrom": "nope"}`, ``, "Invalid waitForChangeFrom: 'nope'"},
 		{"put", `{"id": "foo", "value": "bar"}`, `{"root":"0msppp2die542he6b4udelpe165gh1i2"}`, ""},
 		{"put", `{"id": "foo"}`, ``, "value field is required"},
 		{"put", `{"id": "foo", "value": null}`, `{"root":"jsi6q9qc1fhcg91skdgpirfgkotcuu0o"}`, ""},
@@ -62,13 +76,79 @@ func TestBasics(t *testing.T) {
 		{"get", invalidRequest, ``, invalidRequestError},
 		{"get", `{"id": "foo"}`, `{"has":true,"value":"bar"}`, ""},
 
+		// getMeta
+		{"getMeta", invalidRequest, ``, invalidRequestError},
+		{"getMeta", `{"id": "bar"}`, `{"has":false}`, ""},
+
 		// scan
 		{"put", `{"id": "foopa", "value": "doopa"}`, `{"root":"i3p2c676665as6vhcv5032bhtguci02s"}`, ""},
 		{"scan", `{"prefix": "foo"}`, `[{"id":"foo","value":"bar"},{"id":"foopa","value":"doopa"}]`, ""},
 		{"scan", `{"start": {"id": {"value": "foo"}}}`, `[{"id":"foo","value":"bar"},{"id":"foopa","value":"doopa"}]`, ""},
 		{"scan", `{"start": {"id": {"value": "foo", "exclusive": true}}}`, `[{"id":"foopa","value":"doopa"}]`, ""},
+		{"scan", `{"prefix": "foop", "start": {"id": {"value": "foo"}}}`, ``, "InvalidArgument: invalid argument: start.id 'foo' is not within prefix 'foop'"},
+		{"scan", `{"start": {"id": {"exclusive": true}}}`, ``, "InvalidArgument: invalid argument: start.id.exclusive requires start.id.value"},
 
 		// TODO: other scan operators
+
+		// aggregate
+		{"aggregate", invalidRequest, ``, invalidRequestError},
+		{"aggregate", `{"prefix": "foo"}`, `{"count":2,"numericCount":0,"sum":0}`, ""},
+		{"aggregate", `{"path": "x"}`, ``, "Invalid path: 'x' - must be empty or start with '/'"},
+
+		// collections
+		{"collectionCount", invalidRequest, ``, invalidRequestError},
+		{"collectionCount", `{"collection": ""}`, ``, "collection name must not be empty"},
+		{"collectionCount", `{"collection": "todos"}`, `{"count":0}`, ""},
+		{"collectionScan", `{"collection": "todos"}`, `[]`, ""},
+
+		// clear
+		{"clear", invalidRequest, ``, invalidRequestError},
+
+		// checkpoints
+		{"checkpoint", invalidRequest, ``, invalidRequestError},
+		{"restore", invalidRequest, ``, invalidRequestError},
+		{"restore", `{"name": "cp"}`, ``, "NotFound: No such checkpoint: cp"},
+		{"checkpoint", `{"name": "cp"}`, `{"root":"i3p2c676665as6vhcv5032bhtguci02s"}`, ""},
+		{"restore", `{"name": "cp"}`, `{"root":"i3p2c676665as6vhcv5032bhtguci02s"}`, ""},
+
+		// scanDeleted
+		{"scanDeleted", invalidRequest, ``, invalidRequestError},
+		{"scanDeleted", `{}`, `[]`, ""},
+
+		// audit
+		{"setAudit", invalidRequest, ``, invalidRequestError},
+		{"setAudit", `{"enabled": false}`, `{}`, ""},
+		{"exportAudit", invalidRequest, ``, invalidRequestError},
+		{"exportAudit", `{}`, `[]`, ""},
+
+		// debugging
+		{"setWireLogSize", invalidRequest, ``, invalidRequestError},
+		{"setWireLogSize", `{"size": 0}`, `{}`, ""},
+		{"setBandwidthQuota", invalidRequest, ``, invalidRequestError},
+		{"setBandwidthQuota", `{"bytes": 1000000, "windowMs": 3600000}`, `{}`, ""},
+		{"bandwidthStats", invalidRequest, ``, invalidRequestError},
+		{"bandwidthStats", `{}`, `{"remotes":[]}`, ""},
+		{"debugDump", invalidRequest, ``, invalidRequestError},
+
+		// config
+		{"setConfig", invalidRequest, ``, invalidRequestError},
+		{"setConfig", `{"key": ""}`, ``, "config key must be non-empty"},
+		{"setConfig", `{"key": "remote", "value": {"url": "https://example.com"}}`, `{}`, ""},
+		{"getConfig", `{"key": "remote"}`, `{"has":true,"value":{"url":"https://example.com"}}`, ""},
+		{"getConfig", `{"key": "interval"}`, `{"has":false}`, ""},
+
+		// reset
+		{"reset", `{}`, `{"root":"4p3l8m7gjkkd8g3g0glothm038s61123"}`, ""},
+		{"has", `{"id": "foo"}`, `{"has":false}`, ""},
+		{"restore", `{"name": "cp"}`, ``, "NotFound: No such checkpoint: cp"},
+		{"getConfig", `{"key": "remote"}`, `{"has":true,"value":{"url":"https://example.com"}}`, ""},
+		{"setConfig", `{"key": "remote"}`, `{}`, ""},
+		{"getConfig", `{"key": "remote"}`, `{"has":false}`, ""},
+
+		// previewPatch
+		{"previewPatch", invalidRequest, ``, invalidRequestError},
+		{"previewPatch", `{"patch":[{"op":"add","path":"/foo","value":"bar"}],"checksum":"c4e7090d"}`, `{"checksum":"c4e7090d","checksumMatches":true,"changes":[{"id":"foo","op":"add"}]}`, ""},
+		{"has", `{"id": "foo"}`, `{"has":false}`, ""},
 	}
 
 	for _, t := range tc {
@@ -94,12 +174,15 @@ func TestProgress(t *testing.T) {
 
 	twoChunks := [][]byte{[]byte(`"foo`), []byte(`bar"`)}
 
+	var last PullProgressResponse
 	getProgress := func() (received, expected uint64) {
 		buf, err := Dispatch("db1", "pullProgress", mustMarshal(PullProgressRequest{}))
 		assert.NoError(err)
 		var resp PullProgressResponse
 		err = json.Unmarshal(buf, &resp)
 		assert.NoError(err)
+		assert.Equal("downloading", resp.Phase)
+		last = resp
 		return resp.BytesReceived, resp.BytesExpected
 	}
 
@@ -110,7 +193,12 @@ func TestProgress(t *testing.T) {
 		rec, exp := getProgress()
 		assert.Equal(uint64(0), rec)
 		assert.Equal(uint64(0), exp)
-		for _, c := range twoChunks {
+		assert.NotNil(last.StartedAt)
+		assert.Equal(uint64(0), last.BytesPerSecond)
+		assert.Equal(int64(0), last.EtaMs)
+		// Slow enough that the ETA after the first chunk is at least a millisecond.
+		gtime.Sleep(10 * gtime.Millisecond)
+		for i, c := range twoChunks {
 			seen += uint64(len(c))
 			_, err := w.Write(c)
 			assert.NoError(err)
@@ -119,6 +207,13 @@ func TestProgress(t *testing.T) {
 			rec, exp := getProgress()
 			assert.Equal(seen, rec)
 			assert.Equal(totalLength, exp)
+			assert.True(last.UpdatedAt.After(*last.StartedAt))
+			assert.True(last.BytesPerSecond > 0)
+			if i == 0 {
+				assert.True(last.EtaMs > 0)
+			} else {
+				assert.Equal(int64(0), last.EtaMs)
+			}
 		}
 	}))
 
@@ -131,3 +226,105 @@ func TestProgress(t *testing.T) {
 	_, err = Dispatch("db1", "pull", mustMarshal(req))
 	assert.Regexp(`is not valid JSON`, err.Error())
 }
+
+func TestPullFileRemote(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	shared, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	for _, name := range []string{"db1", "db2"} {
+		_, err = Dispatch(name, "open", nil)
+		assert.NoError(err)
+	}
+
+	req := []byte(fmt.Sprintf(`{"remote":"file://%s"}`, shared))
+	_, err = Dispatch("db1", "put", mm(assert, PutRequest{ID: "foo", Value: []byte(`"bar"`)}))
+	assert.NoError(err)
+	_, err = Dispatch("db1", "pull", req)
+	assert.NoError(err)
+	buf, err := Dispatch("db2", "pull", req)
+	assert.NoError(err)
+	var res PullResponse
+	assert.NoError(json.Unmarshal(buf, &res))
+	assert.False(res.Deferred)
+
+	buf, err = Dispatch("db2", "get", mm(assert, GetRequest{ID: "foo"}))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":"bar"}`, string(buf))
+}
+
+func TestScanPaging(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", mm(assert, OpenRequest{SchemaVersion: 2}))
+	assert.NoError(err)
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		_, err = Dispatch("db1", "put", mm(assert, PutRequest{ID: id, Value: []byte(`"0123456789"`)}))
+		assert.NoError(err)
+	}
+
+	// Each item is {"id":"a","value":"0123456789"}, which is 31 bytes.
+	tc := []struct {
+		req      string
+		expected string
+	}{
+		{`{}`, `{"values":[{"id":"a","value":"0123456789"},{"id":"b","value":"0123456789"},{"id":"c","value":"0123456789"},{"id":"d","value":"0123456789"}],"done":true}`},
+		{`{"limit":4}`, `{"values":[{"id":"a","value":"0123456789"},{"id":"b","value":"0123456789"},{"id":"c","value":"0123456789"},{"id":"d","value":"0123456789"}],"done":true}`},
+		{`{"limit":2}`, `{"values":[{"id":"a","value":"0123456789"},{"id":"b","value":"0123456789"}],"done":false,"next":{"id":{"value":"b","exclusive":true}}}`},
+		{`{"maxBytes":70}`, `{"values":[{"id":"a","value":"0123456789"},{"id":"b","value":"0123456789"}],"done":false,"next":{"id":{"value":"b","exclusive":true}}}`},
+		{`{"maxBytes":62,"start":{"id":{"value":"b","exclusive":true}}}`, `{"values":[{"id":"c","value":"0123456789"},{"id":"d","value":"0123456789"}],"done":true}`},
+		{`{"maxBytes":1}`, `{"values":[{"id":"a","value":"0123456789"}],"done":false,"next":{"id":{"value":"a","exclusive":true}}}`},
+		{`{"prefix":"z"}`, `{"values":[],"done":true}`},
+	}
+	for i, t := range tc {
+		res, err := Dispatch("db1", "scan", []byte(t.req))
+		assert.NoError(err, "test case %d", i)
+		assert.Equal(t.expected, string(res), "test case %d", i)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	fingerprints := []string{}
+	for _, name := range []string{"db1", "db2"} {
+		_, err = Dispatch(name, "open", nil)
+		assert.NoError(err)
+		if name == "db2" {
+			_, err = Dispatch(name, "put", []byte(`{"id": "foo", "value": "baz"}`))
+			assert.NoError(err)
+		}
+		_, err = Dispatch(name, "put", []byte(`{"id": "foo", "value": "bar"}`))
+		assert.NoError(err)
+		res, err := Dispatch(name, "fingerprint", []byte(`{}`))
+		assert.NoError(err)
+		assert.Regexp(`^{"fingerprint":"[0-9a-v]{32}"}$`, string(res))
+		fingerprints = append(fingerprints, string(res))
+	}
+	assert.Equal(fingerprints[0], fingerprints[1])
+}
+
+func TestScanRequestJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	// Requests that embed db.ScanOptions accept its fields at the top level.
+	var sr ScanRequest
+	assert.NoError(json.Unmarshal([]byte(`{"prefix":"p","limit":2,"keysOnly":true,"maxBytes":10}`), &sr))
+	assert.Equal(ScanRequest{ScanOptions: db.ScanOptions{Prefix: "p", Limit: 2, KeysOnly: true}, MaxBytes: 10}, sr)
+	assert.Equal(`{"prefix":"p","limit":2,"keysOnly":true,"maxBytes":10}`, string(mustMarshal(sr)))
+
+	var csr CollectionScanRequest
+	assert.NoError(json.Unmarshal([]byte(`{"collection":"todos","prefix":"p","includeMeta":true}`), &csr))
+	assert.Equal(CollectionScanRequest{Collection: "todos", ScanOptions: db.ScanOptions{Prefix: "p", IncludeMeta: true}}, csr)
+}
@@ -0,0 +1,134 @@
+package repm
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"roci.dev/replicache-client/db"
+)
+
+// ReadyHandler is notified when a database opened with OpenAsync has finished loading.
+type ReadyHandler interface {
+	// OnReady is called on a background goroutine. errMsg is empty if the database loaded
+	// successfully.
+	OnReady(dbName string, errMsg string)
+}
+
+// asyncLoad is the result of loading a database in the background. db and err must not be
+// read until wait returns or finished is true.
+type asyncLoad struct {
+	wg  sync.WaitGroup
+	fin int32
+	db  *db.DB
+	err error
+}
+
+func (l *asyncLoad) wait() {
+	l.wg.Wait()
+}
+
+func (l *asyncLoad) finished() bool {
+	return atomic.LoadInt32(&l.fin) != 0
+}
+
+// finish records the result of the load and wakes those waiting for it.
+func (l *asyncLoad) finish(d *db.DB, err error) {
+	l.db, l.err = d, err
+	atomic.StoreInt32(&l.fin, 1)
+	l.wg.Done()
+}
+
+// beginOpen registers a connection for dbName whose database is loading, so that rpcs to it
+// wait for the load rather than failing. The connection is nil if the database is already
+// open. The caller must hold connectionsMu, and must finish the returned load.
+func beginOpen(dbName string, data []byte) (*connection, *asyncLoad, OpenRequest, error) {
+	conn, req, err := newConnection(dbName, data)
+	if conn == nil || err != nil {
+		return nil, nil, req, err
+	}
+	l := &asyncLoad{}
+	l.wg.Add(1)
+	conn.loading = l
+	connections[dbName] = conn
+	return conn, l, req, nil
+}
+
+// OpenAsync is like the open rpc, but loads the database in the background so that app
+// startup is not blocked by it. Requests dispatched to the database before it is ready wait
+// for it to finish loading. Whether the database is ready can be polled with the status rpc,
+// and h, if non-nil, is notified when loading completes. An error is returned only if the
+// request is invalid.
+func OpenAsync(dbName string, data []byte, h ReadyHandler) error {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	conn, l, req, err := beginOpen(dbName, data)
+	if conn == nil || err != nil {
+		return err
+	}
+
+	go func() {
+		d, err := loadAsync(dbName, conn.dir, req)
+		if err == nil {
+			conn.recordOpened()
+		}
+		l.finish(d, err)
+		if h != nil {
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			h.OnReady(dbName, msg)
+		}
+	}()
+	return nil
+}
+
+func loadAsync(dbName, dir string, req OpenRequest) (d *db.DB, err error) {
+	// There's no Dispatch on the stack to recover panics while loading in the background.
+	var ret []byte
+	defer recoverPanic(dbName, "open", &ret, &err)
+	d, err = loadDB(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = applyStorageOptions(dir, req)
+	if err == nil {
+		err = scratchPath(dir).reset()
+	}
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	seed(d, dbName, req)
+	return d, nil
+}
+
+type StatusResponse struct {
+	// Status is one of "closed", "opening", "ready", or "failed".
+	Status string `json:"status"`
+	// Error describes why the database failed to load.
+	Error string `json:"error,omitempty"`
+}
+
+// loadFailed returns true if the database opened by OpenAsync failed to load. The caller
+// must hold connectionsMu.
+func (conn *connection) loadFailed() bool {
+	return conn.loading != nil && conn.loading.finished() && conn.loading.err != nil
+}
+
+func status(dbName string) ([]byte, error) {
+	res := StatusResponse{Status: "ready"}
+	conn := connections[dbName]
+	if conn == nil {
+		res.Status = "closed"
+	} else if conn.loading != nil {
+		if !conn.loading.finished() {
+			res.Status = "opening"
+		} else if conn.loading.err != nil {
+			res.Status = "failed"
+			res.Error = conn.loading.err.Error()
+		}
+	}
+	return json.Marshal(res)
+}
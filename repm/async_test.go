@@ -0,0 +1,149 @@
+package repm
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	gtime "time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type readyChan chan string
+
+func (c readyChan) OnReady(dbName, errMsg string) {
+	c <- errMsg
+}
+
+func TestOpenAsync(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	res, err := Dispatch("db1", "status", nil)
+	assert.NoError(err)
+	assert.Equal(`{"status":"closed"}`, string(res))
+
+	ready := make(readyChan, 1)
+	assert.NoError(OpenAsync("db1", nil, ready))
+	assert.Equal("", <-ready)
+	res, err = Dispatch("db1", "status", nil)
+	assert.NoError(err)
+	assert.Equal(`{"status":"ready"}`, string(res))
+	res, err = Dispatch("db1", "put", []byte(`{"id": "foo", "value": "bar"}`))
+	assert.NoError(err)
+
+	// Opening an already open database is a no-op.
+	assert.NoError(OpenAsync("db1", nil, nil))
+	res, err = Dispatch("db1", "get", []byte(`{"id": "foo"}`))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":"bar"}`, string(res))
+
+	// A database that can't be loaded.
+	assert.NoError(ioutil.WriteFile(dbPath(dir, "db2"), nil, 0644))
+	assert.NoError(OpenAsync("db2", nil, ready))
+	msg := <-ready
+	assert.NotEqual("", msg)
+	res, err = Dispatch("db2", "status", nil)
+	assert.NoError(err)
+	assert.Equal(mustMarshal(StatusResponse{Status: "failed", Error: msg}), res)
+	_, err = Dispatch("db2", "getRoot", []byte(`{}`))
+	assert.EqualError(err, msg)
+	_, err = Dispatch("db2", "close", nil)
+	assert.NoError(err)
+	res, err = Dispatch("db2", "status", nil)
+	assert.NoError(err)
+	assert.Equal(`{"status":"closed"}`, string(res))
+
+	assert.EqualError(OpenAsync("", nil, nil), "dbName must be non-empty")
+}
+
+func TestWaitingForLoadDoesNotBlockOthers(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	// A database whose load is still in progress.
+	l := &asyncLoad{}
+	l.wg.Add(1)
+	conn := &connection{name: "db2", dir: dbPath(dir, "db2"), loading: l}
+	connectionsMu.Lock()
+	connections["db2"] = conn
+	connectionsMu.Unlock()
+
+	done := make(chan error)
+	go func() {
+		_, err := Dispatch("db2", "get", []byte(`{"id": "foo"}`))
+		done <- err
+	}()
+	for atomic.LoadInt32(&conn.active) == 0 {
+		gtime.Sleep(gtime.Millisecond)
+	}
+
+	res, err := Dispatch("db2", "status", nil)
+	assert.NoError(err)
+	assert.Equal(`{"status":"opening"}`, string(res))
+	_, err = Dispatch("db1", "put", []byte(`{"id": "foo", "value": "bar"}`))
+	assert.NoError(err)
+	_, err = Dispatch("db1", "list", nil)
+	assert.NoError(err)
+
+	l.err = errors.New("boom")
+	atomic.StoreInt32(&l.fin, 1)
+	l.wg.Done()
+	assert.EqualError(<-done, "boom")
+}
+
+func TestOpenDoesNotBlockOthers(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	// A seed server that stalls until released holds up the open of db2.
+	var stall sync.WaitGroup
+	stall.Add(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stall.Wait()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	done := make(chan error)
+	go func() {
+		_, err := Dispatch("db2", "open", mustMarshal(OpenRequest{SeedURL: server.URL}))
+		done <- err
+	}()
+	for {
+		res, err := Dispatch("db2", "status", nil)
+		assert.NoError(err)
+		if string(res) == `{"status":"opening"}` {
+			break
+		}
+		gtime.Sleep(gtime.Millisecond)
+	}
+
+	_, err = Dispatch("db1", "put", []byte(`{"id": "foo", "value": "bar"}`))
+	assert.NoError(err)
+	_, err = Dispatch("db1", "list", nil)
+	assert.NoError(err)
+
+	stall.Done()
+	// Seed failures are logged, not returned.
+	assert.NoError(<-done)
+	res, err := Dispatch("db2", "status", nil)
+	assert.NoError(err)
+	assert.Equal(`{"status":"ready"}`, string(res))
+}
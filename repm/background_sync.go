@@ -0,0 +1,76 @@
+package repm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	gtime "time"
+
+	jsnoms "roci.dev/diff-server/util/noms/json"
+)
+
+// BackgroundSyncRequest asks for one sync cycle bounded by a deadline, as OS background
+// execution models such as Android's WorkManager and iOS's BGTaskScheduler require. The other
+// fields are as in PullRequest.
+type BackgroundSyncRequest struct {
+	PullRequest
+	// DeadlineMs is how long the cycle may take. It should leave the host time to return from
+	// its background task, since the cycle is abandoned, not completed, at the deadline.
+	DeadlineMs int64 `json:"deadlineMs"`
+}
+
+type BackgroundSyncResponse struct {
+	Root jsnoms.Hash `json:"root"`
+	// Changed is true if the cycle changed the local head.
+	Changed bool `json:"changed"`
+	// Bytes is the number of bytes sent and received by the cycle.
+	Bytes uint64 `json:"bytes"`
+	// NeedsMore is true if the cycle ran out of time, so the host should schedule another.
+	NeedsMore bool `json:"needsMore"`
+	// Deferred and Error are as in PullResponse.
+	Deferred bool               `json:"deferred,omitempty"`
+	Error    *PullResponseError `json:"error,omitempty"`
+}
+
+// dispatchRunBackgroundSync performs at most one pull, abandoning it at the deadline. Running
+// out of time is not an error: the response says that more work is needed.
+func (conn *connection) dispatchRunBackgroundSync(ctx context.Context, reqBytes []byte) ([]byte, error) {
+	var req BackgroundSyncRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	if req.DeadlineMs <= 0 {
+		return nil, errors.New("deadlineMs must be positive")
+	}
+
+	head := conn.db.Hash()
+	bytes := conn.bandwidthUsed()
+	cycleCtx, cancel := context.WithTimeout(ctx, gtime.Duration(req.DeadlineMs)*gtime.Millisecond)
+	defer cancel()
+	pullRes, err := conn.pull(cycleCtx, "runBackgroundSync", reqBytes)
+	res := BackgroundSyncResponse{}
+	if err != nil {
+		if ctx.Err() != nil || cycleCtx.Err() != context.DeadlineExceeded {
+			return nil, err
+		}
+		res.NeedsMore = true
+	}
+	res.Root = jsnoms.Hash{
+		Hash: conn.db.Hash(),
+	}
+	res.Changed = res.Root.Hash != head
+	res.Bytes = conn.bandwidthUsed() - bytes
+	res.Deferred = pullRes.Deferred
+	res.Error = pullRes.Error
+	return mustMarshal(res), nil
+}
+
+// bandwidthUsed returns the total bytes sent to and received from all remotes.
+func (conn *connection) bandwidthUsed() uint64 {
+	var n uint64
+	for _, s := range conn.db.Bandwidth().Stats() {
+		n += s.BytesSent + s.BytesReceived
+	}
+	return n
+}
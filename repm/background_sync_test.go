@@ -0,0 +1,70 @@
+package repm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+	jsnoms "roci.dev/diff-server/util/noms/json"
+
+	"roci.dev/replicache-client/db"
+)
+
+func TestRunBackgroundSync(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	tmp, _ := db.LoadTempDB(assert)
+	data := kv.NewMapForTest(tmp.Noms(), "foo", `"bar"`)
+	patch, err := db.SnapshotPatch(data.NomsMap())
+	assert.NoError(err)
+	slow := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow {
+			<-r.Context().Done()
+			return
+		}
+		w.Write(mm(assert, servetypes.PullResponse{Patch: patch, StateID: "s1", Checksum: data.Checksum()}))
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+
+	sync := func(deadlineMs int64) BackgroundSyncResponse {
+		req := BackgroundSyncRequest{PullRequest: PullRequest{Remote: jsnoms.Spec{Spec: sp}}, DeadlineMs: deadlineMs}
+		buf, err := Dispatch("db1", "runBackgroundSync", mm(assert, req))
+		assert.NoError(err)
+		var res BackgroundSyncResponse
+		assert.NoError(json.Unmarshal(buf, &res))
+		return res
+	}
+
+	_, err = Dispatch("db1", "runBackgroundSync", mm(assert, BackgroundSyncRequest{}))
+	assert.EqualError(err, "deadlineMs must be positive")
+
+	res := sync(10000)
+	assert.True(res.Changed)
+	assert.False(res.NeedsMore)
+	assert.True(res.Bytes > 0)
+	buf, err := Dispatch("db1", "get", mm(assert, GetRequest{ID: "foo"}))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":"bar"}`, string(buf))
+
+	// A cycle that runs out of time asks for another.
+	slow = true
+	res = sync(50)
+	assert.False(res.Changed)
+	assert.True(res.NeedsMore)
+}
@@ -0,0 +1,136 @@
+package repm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"roci.dev/diff-server/util/time"
+
+	"roci.dev/replicache-client/db"
+)
+
+// DispatchBinary is an alternative to Dispatch for the hot get, put, and scan paths that
+// avoids JSON-encoding the request and response envelopes, which is expensive for large
+// values crossing the Gomobile bridge.
+//
+// Requests and responses are sequences of segments, each a 4-byte big-endian length
+// followed by that many bytes. Values are still JSON, but are passed through as-is.
+//
+//	get:  request [id]                 response [has (1 byte, 0 or 1)] [value, if has]
+//	put:  request [id] [value]         response [root hash]
+//	scan: request [ScanRequest JSON]   response [id] [value] [id] [value] ...
+//
+// If the scan request specifies keysOnly, the scan response is just [id] [id] ...
+func DispatchBinary(dbName, rpc string, data []byte) (ret []byte, err error) {
+	defer recoverPanic(dbName, rpc, &ret, &err)
+	if !hasRPC(binaryRPCs, rpc) {
+		return nil, withCode(unknownRPC(rpc, binaryRPCs))
+	}
+
+	conn, err := getConnection(dbName, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+	segs, err := readSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = withCode(err)
+	}()
+	switch rpc {
+	case "get":
+		return conn.dispatchGetBinary(segs)
+	case "put":
+		return conn.dispatchPutBinary(segs)
+	case "scan":
+		return conn.dispatchScanBinary(segs)
+	}
+	return nil, unknownRPC(rpc, binaryRPCs)
+}
+
+func (conn *connection) dispatchGetBinary(segs [][]byte) ([]byte, error) {
+	if len(segs) != 1 {
+		return nil, fmt.Errorf("get expects 1 segment, got %d", len(segs))
+	}
+	v, err := conn.db.Get(string(segs[0]))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return appendSegment(nil, []byte{0}), nil
+	}
+	return appendSegment(appendSegment(nil, []byte{1}), v), nil
+}
+
+func (conn *connection) dispatchPutBinary(segs [][]byte) ([]byte, error) {
+	if len(segs) != 2 {
+		return nil, fmt.Errorf("put expects 2 segments, got %d", len(segs))
+	}
+	if len(segs[1]) == 0 {
+		return nil, errors.New("value field is required")
+	}
+	err := conn.db.Put(string(segs[0]), segs[1])
+	if err != nil {
+		return nil, err
+	}
+	return appendSegment(nil, []byte(conn.db.Hash().String())), nil
+}
+
+func (conn *connection) dispatchScanBinary(segs [][]byte) ([]byte, error) {
+	if len(segs) != 1 {
+		return nil, fmt.Errorf("scan expects 1 segment, got %d", len(segs))
+	}
+	var req ScanRequest
+	err := json.Unmarshal(segs[0], &req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Delimiter != "" {
+		// Common prefixes couldn't be told apart from keys in the binary encoding.
+		return nil, fmt.Errorf("%w: delimiter is not supported by binary scan", db.ErrInvalidArgument)
+	}
+	items, err := conn.db.Scan(req.ScanOptions)
+	if err != nil {
+		return nil, err
+	}
+	var ret []byte
+	for _, it := range items {
+		if it.Value == nil {
+			ret = appendSegment(ret, []byte(it.ID))
+			continue
+		}
+		v, err := json.Marshal(it.Value)
+		if err != nil {
+			return nil, err
+		}
+		ret = appendSegment(appendSegment(ret, []byte(it.ID)), v)
+	}
+	return ret, nil
+}
+
+func appendSegment(buf, seg []byte) []byte {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(seg)))
+	return append(append(buf, l[:]...), seg...)
+}
+
+func readSegments(data []byte) ([][]byte, error) {
+	segs := [][]byte{}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("truncated segment length")
+		}
+		l := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(l) {
+			return nil, errors.New("truncated segment")
+		}
+		segs = append(segs, data[:l])
+		data = data[l:]
+	}
+	return segs, nil
+}
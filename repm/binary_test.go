@@ -0,0 +1,66 @@
+package repm
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/time"
+)
+
+func segs(s ...string) []byte {
+	var r []byte
+	for _, v := range s {
+		r = appendSegment(r, []byte(v))
+	}
+	return r
+}
+
+func TestDispatchBinary(t *testing.T) {
+	defer deinit()
+	defer time.SetFake()()
+
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	tc := []struct {
+		rpc              string
+		req              []byte
+		expectedResponse []byte
+		expectedError    string
+	}{
+		{"get", segs("foo"), segs("\x00"), ""},
+		{"put", segs("foo", `"bar"`), segs("0msppp2die542he6b4udelpe165gh1i2"), ""},
+		{"put", segs("foo"), nil, "put expects 2 segments, got 1"},
+		{"put", segs("foo", ""), nil, "value field is required"},
+		{"get", segs("foo"), segs("\x01", `"bar"`), ""},
+		{"get", []byte{0, 0, 0, 5, 'f'}, nil, "truncated segment"},
+		{"get", []byte{0, 0}, nil, "truncated segment length"},
+		{"put", segs("foopa", `{"a":1}`), nil, ""},
+		{"scan", segs(`{"prefix":"foo"}`), segs("foo", `"bar"`, "foopa", `{"a":1}`), ""},
+		{"scan", segs(`{"prefix":"z"}`), nil, ""},
+		{"scan", segs(`{"prefix":"foo","keysOnly":true}`), segs("foo", "foopa"), ""},
+		{"del", segs("foo"), nil, "UnknownRPC: unknown rpc: del - supported rpcs are: get, put, scan"},
+	}
+
+	for i, t := range tc {
+		res, err := DispatchBinary("db1", t.rpc, t.req)
+		if t.expectedError != "" {
+			assert.Nil(res, "test case %d", i)
+			assert.EqualError(err, t.expectedError, "test case %d", i)
+			continue
+		}
+		assert.NoError(err, "test case %d", i)
+		if t.expectedResponse != nil {
+			assert.Equal(t.expectedResponse, res, "test case %d", i)
+		}
+	}
+
+	_, err = DispatchBinary("db2", "get", segs("foo"))
+	assert.EqualError(err, "specified database is not open")
+}
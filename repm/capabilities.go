@@ -0,0 +1,106 @@
+package repm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"roci.dev/diff-server/util/version"
+)
+
+const (
+	// pullProtocolVersion is the version of the pull request and response format spoken to
+	// the diff-server. It must be incremented when the format changes incompatibly.
+	pullProtocolVersion = 1
+	// binaryProtocolVersion is the version of the DispatchBinary encoding.
+	binaryProtocolVersion = 1
+)
+
+// topLevelRPCs are the rpcs that don't require an open database.
+var topLevelRPCs = []string{
+	"list", "listForAccount", "open", "close", "drop", "dropAccount", "version", "capabilities",
+	"encodeKey", "status", "profile", "lastPanic", "doctor", "setPowerState",
+}
+
+// connectionRPCs are the rpcs dispatched to an open database.
+var connectionRPCs = []string{
+	"getRoot", "syncInfo", "has", "get", "getMeta", "scan", "scanDeleted", "aggregate",
+	"collectionScan", "collectionCount", "collectionClear", "put", "del", "clear",
+	"checkpoint", "restore", "reset", "squashPending", "fingerprint", "previewPatch", "setConfig",
+	"getConfig", "setAudit", "exportAudit", "setWireLogSize", "setHotPrefixes", "setBandwidthQuota",
+	"bandwidthStats", "pollEvents", "debugDump", "pull", "pullProgress", "runBackgroundSync",
+	"verifyState",
+}
+
+// binaryRPCs are the rpcs supported by DispatchBinary.
+var binaryRPCs = []string{"get", "put", "scan"}
+
+// ErrUnknownRPC is returned by Dispatch and DispatchBinary for rpcs this build doesn't support,
+// typically because the SDK is newer than it. SDKs can use the capabilities rpc to check for
+// support up front.
+var ErrUnknownRPC = errors.New("unknown rpc")
+
+// unknownRPC returns an ErrUnknownRPC error for rpc, listing the supported rpcs.
+func unknownRPC(rpc string, supported ...[]string) error {
+	all := []string{}
+	for _, s := range supported {
+		all = append(all, s...)
+	}
+	return fmt.Errorf("%w: %s - supported rpcs are: %s", ErrUnknownRPC, rpc, strings.Join(all, ", "))
+}
+
+// hasRPC returns whether rpcs contains rpc.
+func hasRPC(rpcs []string, rpc string) bool {
+	for _, r := range rpcs {
+		if r == rpc {
+			return true
+		}
+	}
+	return false
+}
+
+// CapabilitiesResponse describes what this build of Replicache supports, so that SDKs can
+// detect features rather than depending on exact versions.
+type CapabilitiesResponse struct {
+	Version string `json:"version"`
+	// SchemaVersion and MinSchemaVersion are the range of Dispatch schema versions that may
+	// be passed to open.
+	SchemaVersion    int      `json:"schemaVersion"`
+	MinSchemaVersion int      `json:"minSchemaVersion"`
+	RPCs             []string `json:"rpcs"`
+	BinaryRPCs       []string `json:"binaryRPCs"`
+	// Protocols maps the name of each wire protocol to the version spoken.
+	Protocols      map[string]int `json:"protocols"`
+	StorageBackend string         `json:"storageBackend"`
+	BuildTags      []string       `json:"buildTags"`
+	GOOS           string         `json:"goos"`
+	GOARCH         string         `json:"goarch"`
+	GoVersion      string         `json:"goVersion"`
+}
+
+func capabilities() ([]byte, error) {
+	res := CapabilitiesResponse{
+		Version:          version.Version(),
+		SchemaVersion:    SchemaVersion,
+		MinSchemaVersion: minSchemaVersion,
+		RPCs:             append(append([]string{}, topLevelRPCs...), connectionRPCs...),
+		BinaryRPCs:       binaryRPCs,
+		Protocols: map[string]int{
+			"pull":   pullProtocolVersion,
+			"binary": binaryProtocolVersion,
+		},
+		// Databases are always opened from a local directory, which Noms backs with its
+		// block store.
+		StorageBackend: "nbs",
+		BuildTags:      []string{},
+		GOOS:           runtime.GOOS,
+		GOARCH:         runtime.GOARCH,
+		GoVersion:      runtime.Version(),
+	}
+	if iosBuild {
+		res.BuildTags = append(res.BuildTags, "ios")
+	}
+	return json.Marshal(res)
+}
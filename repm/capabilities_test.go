@@ -0,0 +1,102 @@
+package repm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/version"
+)
+
+func TestCapabilities(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	buf, err := Dispatch("", "capabilities", nil)
+	assert.NoError(err)
+	var res CapabilitiesResponse
+	assert.NoError(json.Unmarshal(buf, &res))
+	assert.Equal(version.Version(), res.Version)
+	assert.Contains(res.RPCs, "capabilities")
+	assert.Contains(res.RPCs, "put")
+	assert.Equal(1, res.Protocols["pull"])
+
+	// Every advertised rpc is actually dispatched.
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+	for _, rpc := range connectionRPCs {
+		_, err := Dispatch("db1", rpc, []byte(""))
+		assert.EqualError(err, "unexpected end of JSON input", rpc)
+	}
+	for _, rpc := range binaryRPCs {
+		_, err := DispatchBinary("db1", rpc, []byte{0})
+		assert.EqualError(err, "truncated segment length", rpc)
+	}
+	_, err = Dispatch("db1", "bogus", []byte(""))
+	assert.Regexp("^UnknownRPC: unknown rpc: bogus - supported rpcs are: list, .*, open, .*, put, .*, pullProgress, runBackgroundSync, verifyState$", err)
+	assert.True(errors.Is(err, ErrUnknownRPC))
+}
+
+// TestSDKCompatibility checks that SDKs older and newer than this build fail gracefully: older
+// SDKs use schema versions and rpcs that are still supported, and newer ones get errors they
+// can recognize for anything that isn't, without crashing the host app.
+func TestSDKCompatibility(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	for v := minSchemaVersion; v <= SchemaVersion+1; v++ {
+		name := fmt.Sprintf("db%d", v)
+		_, err := Dispatch(name, "open", mm(assert, OpenRequest{SchemaVersion: v}))
+		if v > SchemaVersion {
+			assert.EqualError(err, fmt.Sprintf("Unsupported schemaVersion %d - must be between %d and %d", v, minSchemaVersion, SchemaVersion))
+			continue
+		}
+		assert.NoError(err, "schema %d", v)
+
+		for _, t := range []struct {
+			rpc           string
+			req           string
+			expectedError string
+		}{
+			{"put", `{"id":"foo","value":"bar"}`, ""},
+			{"frobnicate", `{"id":"foo"}`, "UnknownRPC: unknown rpc: frobnicate - "},
+			{"get", `{"id":"foo"}`, ""},
+			{"", `{}`, "UnknownRPC: unknown rpc:  - "},
+			{"Get", `{"id":"foo"}`, "UnknownRPC: unknown rpc: Get - "},
+			{"has", `{"id":"foo"}`, ""},
+		} {
+			msg := fmt.Sprintf("schema %d, rpc %s", v, t.rpc)
+			res, err := Dispatch(name, t.rpc, []byte(t.req))
+			if t.expectedError != "" {
+				assert.Nil(res, msg)
+				if assert.Error(err, msg) {
+					assert.True(strings.HasPrefix(err.Error(), t.expectedError), msg)
+				}
+				var ce codedError
+				assert.True(errors.As(err, &ce), msg)
+				assert.Equal(ErrorCodeUnknownRPC, ce.Code(), msg)
+				continue
+			}
+			assert.NoError(err, msg)
+		}
+		_, err = Dispatch(name, "close", nil)
+		assert.NoError(err)
+	}
+
+	// Unknown rpcs are reported the same way whether or not the database is open.
+	_, err = Dispatch("closed", "frobnicate", nil)
+	assert.True(errors.Is(err, ErrUnknownRPC))
+	_, err = DispatchBinary("closed", "del", nil)
+	assert.EqualError(err, "UnknownRPC: unknown rpc: del - supported rpcs are: get, put, scan")
+}
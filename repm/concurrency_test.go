@@ -0,0 +1,145 @@
+package repm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+	jsnoms "roci.dev/diff-server/util/noms/json"
+
+	"roci.dev/replicache-client/db"
+)
+
+// TestConcurrentDispatch is most useful with the race detector: go test -race.
+func TestConcurrentDispatch(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	tmp, _ := db.LoadTempDB(assert)
+	data := kv.NewMapForTest(tmp.Noms(), "server", `"data"`)
+	patch, err := db.SnapshotPatch(data.NomsMap())
+	assert.NoError(err)
+	// Pulls are held up by the server until one has been refused for overlapping, so that
+	// overlap is certain.
+	var refused int32
+	var block sync.WaitGroup
+	block.Add(1)
+	go func() {
+		for atomic.LoadInt32(&refused) == 0 {
+			gtime.Sleep(gtime.Millisecond)
+		}
+		block.Done()
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		block.Wait()
+		w.Write(mm(assert, servetypes.PullResponse{Patch: patch, StateID: "s1", Checksum: data.Checksum()}))
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	pullReq := mm(assert, PullRequest{Remote: jsnoms.Spec{Spec: sp}})
+
+	const n = 20
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				f(i)
+			}(i)
+		}
+	}
+	run(func(i int) {
+		_, err := Dispatch("db1", "put", []byte(fmt.Sprintf(`{"id":"k%d","value":%d}`, i, i)))
+		assert.NoError(err)
+	})
+	run(func(i int) {
+		_, err := Dispatch("db1", "get", []byte(fmt.Sprintf(`{"id":"k%d"}`, i)))
+		assert.NoError(err)
+	})
+	run(func(i int) {
+		for _, rpc := range []string{"scan", "getRoot", "syncInfo", "pullProgress", "bandwidthStats"} {
+			_, err := Dispatch("db1", rpc, []byte(`{}`))
+			assert.NoError(err, rpc)
+		}
+	})
+	run(func(i int) {
+		_, err := Dispatch("db1", "setHotPrefixes", []byte(`{"prefixes":["k"]}`))
+		assert.NoError(err)
+		_, err = Dispatch("db1", "setPowerState", []byte(`{"state":"normal"}`))
+		assert.NoError(err)
+	})
+	run(func(i int) {
+		// Overlapping pulls are refused, but must not corrupt anything.
+		_, err := Dispatch("db1", "pull", pullReq)
+		if err != nil {
+			assert.Equal("There is already a pull in progress", err.Error())
+			atomic.AddInt32(&refused, 1)
+		}
+	})
+	wg.Wait()
+	assert.True(atomic.LoadInt32(&refused) > 0)
+
+	_, err = Dispatch("db1", "pull", pullReq)
+	assert.NoError(err)
+	for i := 0; i < n; i++ {
+		res, err := Dispatch("db1", "get", []byte(fmt.Sprintf(`{"id":"k%d"}`, i)))
+		assert.NoError(err)
+		assert.Equal(fmt.Sprintf(`{"has":true,"value":%d}`, i), string(res))
+	}
+	res, err := Dispatch("db1", "get", []byte(`{"id":"server"}`))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":"data"}`, string(res))
+}
+
+func TestCloseWaitsForInFlight(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+	root := connections["db1"].db.Hash()
+
+	done := make(chan error)
+	go func() {
+		_, err := Dispatch("db1", "getRoot", []byte(fmt.Sprintf(`{"waitForChangeFrom":"%s","timeoutMs":100}`, root)))
+		done <- err
+	}()
+	conn := connections["db1"]
+	for atomic.LoadInt32(&conn.active) == 0 {
+		gtime.Sleep(gtime.Millisecond)
+	}
+
+	closed := make(chan error)
+	go func() {
+		_, err := Dispatch("db1", "close", nil)
+		closed <- err
+	}()
+	select {
+	case <-closed:
+		assert.Fail("close did not wait for getRoot")
+	case err := <-done:
+		assert.NoError(err)
+	}
+	assert.NoError(<-closed)
+	_, err = Dispatch("db1", "get", []byte(`{"id":"foo"}`))
+	assert.EqualError(err, "specified database is not open")
+}
@@ -0,0 +1,95 @@
+package repm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	gtime "time"
+
+	"github.com/attic-labs/noms/go/hash"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// dbInfoFile is the file in each database directory recording the metadata reported by list,
+// so that list doesn't need to load every database.
+const dbInfoFile = ".info.json"
+
+// dbInfo is the content of dbInfoFile.
+type dbInfo struct {
+	// Account is the account the database was first opened with, if any.
+	Account string `json:"account,omitempty"`
+	// Head is the hash of the head commit as of when the database was last closed.
+	Head            string     `json:"head,omitempty"`
+	LastOpened      gtime.Time `json:"lastOpened"`
+	SchemaVersion   int        `json:"schemaVersion"`
+	ProtocolVersion int        `json:"protocolVersion"`
+}
+
+// readDBInfo returns the recorded metadata of the database in dir. Databases created by older
+// builds have none, in which case the zero dbInfo is returned.
+func readDBInfo(dir string) dbInfo {
+	var info dbInfo
+	b, err := ioutil.ReadFile(path.Join(dir, dbInfoFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Could not read database info in %s: %s", dir, err)
+		}
+		return info
+	}
+	if err := json.Unmarshal(b, &info); err != nil {
+		log.Printf("Could not decode database info in %s: %s", dir, err)
+	}
+	return info
+}
+
+// updateDBInfo applies f to the recorded metadata of the database in dir. Failures are logged
+// rather than returned since the metadata is informational.
+func updateDBInfo(dir string, f func(info *dbInfo)) {
+	info := readDBInfo(dir)
+	f(&info)
+	b, err := json.Marshal(info)
+	if err == nil {
+		err = ioutil.WriteFile(path.Join(dir, dbInfoFile), b, 0644)
+	}
+	if err != nil {
+		log.Printf("Could not write database info in %s: %s", dir, err)
+	}
+}
+
+// recordOpened records that the connection's database was opened now.
+func (conn *connection) recordOpened() {
+	updateDBInfo(conn.dir, func(info *dbInfo) {
+		if conn.account != "" {
+			info.Account = conn.account
+		}
+		info.LastOpened = time.Now()
+		info.SchemaVersion = conn.schemaVersion
+		info.ProtocolVersion = pullProtocolVersion
+	})
+}
+
+// recordHead records h as the head of the connection's database.
+func (conn *connection) recordHead(h hash.Hash) {
+	updateDBInfo(conn.dir, func(info *dbInfo) {
+		info.Head = h.String()
+	})
+}
+
+// dirSize returns the total size of the files in dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,66 @@
+package repm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/attic-labs/noms/go/spec"
+
+	"roci.dev/replicache-client/db"
+)
+
+type DoctorRequest struct {
+	// Repair fixes the problems found, rather than only reporting them.
+	Repair bool `json:"repair,omitempty"`
+}
+
+type DoctorResponse struct {
+	Problems []db.Problem `json:"problems"`
+	// Repaired is true if the problems were repaired.
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+// doctor diagnoses, and optionally repairs, problems that prevent dbName from loading. The
+// database must not be open, though it may be one whose open failed, in which case it must be
+// closed and opened again after it is repaired.
+func doctor(dbName string, data []byte) ([]byte, error) {
+	var req DoctorRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, err
+		}
+	}
+	if repDir == "" {
+		return nil, errors.New("Replicache is uninitialized - must call init first")
+	}
+	if dbName == "" {
+		return nil, errors.New("dbName must be non-empty")
+	}
+	if conn := connections[dbName]; conn != nil && !conn.loadFailed() {
+		return nil, fmt.Errorf("Database '%s' is open - must close it first", dbName)
+	}
+	p := dbPath(repDir, dbName)
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: no such database: %s", db.ErrNotFound, dbName)
+	}
+
+	sp, err := spec.ForDatabase(p)
+	if err != nil {
+		return nil, err
+	}
+	defer sp.Close()
+	noms := sp.GetDatabase()
+	res := DoctorResponse{}
+	if req.Repair {
+		res.Problems, err = db.Repair(noms)
+		if err != nil {
+			return nil, err
+		}
+		res.Repaired = true
+	} else {
+		res.Problems = db.Diagnose(noms)
+	}
+	return json.Marshal(res)
+}
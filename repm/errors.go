@@ -0,0 +1,64 @@
+package repm
+
+import (
+	"errors"
+
+	"roci.dev/replicache-client/db"
+)
+
+// Error codes identify classes of failure so that bindings need not match on error messages.
+// Errors with a code are returned from Dispatch with the message "<code>: <message>".
+const (
+	ErrorCodeNotFound        = "NotFound"
+	ErrorCodeValueCorrupt    = "ValueCorrupt"
+	ErrorCodeWriteConflict   = "WriteConflict"
+	ErrorCodeQuotaExceeded   = "QuotaExceeded"
+	ErrorCodeInvalidArgument = "InvalidArgument"
+	ErrorCodeUnknownRPC      = "UnknownRPC"
+	ErrorCodeInternal        = "Internal"
+)
+
+// codedError is an error with an error code.
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e codedError) Error() string {
+	return e.code + ": " + e.err.Error()
+}
+
+// Code returns the error code. httprpc uses it to populate error responses.
+func (e codedError) Code() string {
+	return e.code
+}
+
+func (e codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode adds the matching error code to err, if there is one.
+func withCode(err error) error {
+	var code string
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, db.ErrNotFound):
+		code = ErrorCodeNotFound
+	case errors.Is(err, db.ErrValueCorrupt):
+		code = ErrorCodeValueCorrupt
+	case errors.Is(err, db.ErrWriteConflict):
+		code = ErrorCodeWriteConflict
+	case errors.Is(err, db.ErrQuotaExceeded):
+		code = ErrorCodeQuotaExceeded
+	case errors.Is(err, db.ErrInvalidArgument):
+		code = ErrorCodeInvalidArgument
+	case errors.Is(err, ErrUnknownRPC):
+		code = ErrorCodeUnknownRPC
+	case errors.Is(err, ErrInternal):
+		code = ErrorCodeInternal
+	default:
+		return err
+	}
+	return codedError{code, err}
+}
@@ -0,0 +1,42 @@
+package repm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/replicache-client/db"
+)
+
+func TestWithCode(t *testing.T) {
+	assert := assert.New(t)
+
+	tc := []struct {
+		err          error
+		expectedCode string
+	}{
+		{fmt.Errorf("%w: could not commit put", db.ErrWriteConflict), ErrorCodeWriteConflict},
+		{fmt.Errorf("%w: could not decode 'foo'", db.ErrValueCorrupt), ErrorCodeValueCorrupt},
+		{fmt.Errorf("restore: %w", db.ErrNotFound), ErrorCodeNotFound},
+		{fmt.Errorf("%w: 10 bytes used in the last 1m0s, quota is 10", db.ErrQuotaExceeded), ErrorCodeQuotaExceeded},
+		{fmt.Errorf("%w: limit must not be negative: -1", db.ErrInvalidArgument), ErrorCodeInvalidArgument},
+		{errors.New("boom"), ""},
+	}
+
+	for i, t := range tc {
+		msg := fmt.Sprintf("case %d", i)
+		err := withCode(t.err)
+		if t.expectedCode == "" {
+			assert.Equal(t.err, err, msg)
+			continue
+		}
+		var ce codedError
+		assert.True(errors.As(err, &ce), msg)
+		assert.Equal(t.expectedCode, ce.Code(), msg)
+		assert.Equal(t.expectedCode+": "+t.err.Error(), err.Error(), msg)
+		assert.True(errors.Is(err, t.err), msg)
+	}
+	assert.Nil(withCode(nil))
+}
@@ -0,0 +1,152 @@
+// Package httprpc exposes the Replicache client API over HTTP, so that Go programs can mount
+// it on their own mux, e.g.:
+//
+//	mux.Handle("/replicache/", http.StripPrefix("/replicache", httprpc.NewHandler(repm.DispatchCtx, httprpc.Options{})))
+//
+// Only the rpcs in Options.RPCs are exposed, by default those in DataRPCs. Others respond 403.
+//
+// Each RPC is a POST to /<rpc>?db=<dbName> with the JSON request as the body. Successful calls
+// respond 200 with the RPC's response as the body. Failures respond with a JSON error envelope
+// of the form {"error":"<message>"}, which also includes "code" if the error has one. The
+// status of failures follows from the code: 404 for NotFound and UnknownRPC, 400 for
+// InvalidArgument and requests that are not valid JSON, 409 for WriteConflict, 429 for
+// QuotaExceeded, and 500 otherwise.
+package httprpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxRequestBytes bounds the size of a request body.
+const maxRequestBytes = 32 << 20
+
+// Dispatcher is the signature of repm.DispatchCtx.
+type Dispatcher func(ctx context.Context, dbName, rpc string, data []byte) ([]byte, error)
+
+// ErrorResponse is the body of unsuccessful responses.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Code is the error code of the failure, if it has one. See the repm.ErrorCode constants.
+	Code string `json:"code,omitempty"`
+}
+
+// coder is implemented by errors that have an error code.
+type coder interface {
+	Code() string
+}
+
+// DataRPCs are the rpcs that read and write the data of open databases. They are the rpcs
+// exposed by default.
+var DataRPCs = []string{
+	"getRoot", "has", "get", "getMeta", "scan", "scanDeleted", "aggregate", "collectionScan",
+	"collectionCount", "collectionClear", "put", "del", "clear",
+}
+
+// Options configures the handler returned by NewHandler.
+type Options struct {
+	// RPCs are the rpcs to expose. If nil, DataRPCs are exposed. Rpcs that manage databases
+	// (e.g., drop), touch the host (e.g., debugDump, profile), or sync should only be exposed
+	// to trusted callers.
+	RPCs []string
+}
+
+type handler struct {
+	dispatch Dispatcher
+	rpcs     map[string]bool
+}
+
+// NewHandler returns an http.Handler that forwards requests for the rpcs in opts to d.
+func NewHandler(d Dispatcher, opts Options) http.Handler {
+	rpcs := opts.RPCs
+	if rpcs == nil {
+		rpcs = DataRPCs
+	}
+	h := handler{dispatch: d, rpcs: map[string]bool{}}
+	for _, rpc := range rpcs {
+		h.rpcs[rpc] = true
+	}
+	return h
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "Unsupported method: "+r.Method)
+		return
+	}
+	rpc := strings.Trim(r.URL.Path, "/")
+	if rpc == "" || strings.Contains(rpc, "/") {
+		writeError(w, http.StatusNotFound, "Invalid rpc path: "+r.URL.Path)
+		return
+	}
+	if !h.rpcs[rpc] {
+		writeError(w, http.StatusForbidden, "RPC is not exposed over HTTP: "+rpc)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		writeError(w, http.StatusUnsupportedMediaType, "Unsupported content type: "+ct)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res, err := h.dispatch(r.Context(), r.URL.Query().Get("db"), rpc, body)
+	if err != nil {
+		var c coder
+		code := ""
+		if errors.As(err, &c) {
+			code = c.Code()
+		}
+		writeErrorCode(w, statusForError(err, code), err.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// statusForError returns the status of the response to a request that failed with err, which
+// has the error code code.
+func statusForError(err error, code string) int {
+	switch code {
+	case "NotFound", "UnknownRPC":
+		return http.StatusNotFound
+	case "InvalidArgument":
+		return http.StatusBadRequest
+	case "WriteConflict":
+		return http.StatusConflict
+	case "QuotaExceeded":
+		return http.StatusTooManyRequests
+	}
+	// Requests that aren't valid JSON fail to unmarshal before they get far enough to have a
+	// code.
+	var se *json.SyntaxError
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &se) || errors.As(err, &te) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeErrorCode(w, status, msg, "")
+}
+
+func writeErrorCode(w http.ResponseWriter, status int, msg, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(ErrorResponse{Error: msg, Code: code})
+	if err != nil {
+		log.Printf("Could not write error response: %s", err)
+	}
+}
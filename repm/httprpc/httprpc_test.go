@@ -0,0 +1,112 @@
+package httprpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var gotDB, gotRPC, gotData string
+	d := func(ctx context.Context, dbName, rpc string, data []byte) ([]byte, error) {
+		gotDB, gotRPC, gotData = dbName, rpc, string(data)
+		switch rpc {
+		case "fail":
+			return nil, errors.New("boom")
+		case "badjson":
+			var v interface{}
+			return nil, json.Unmarshal(data, &v)
+		case "badtype":
+			var v struct{ ID string }
+			return nil, json.Unmarshal(data, &v)
+		case "coded":
+			return nil, fmt.Errorf("wrapped: %w", codedError(gotData))
+		}
+		return []byte(`{"ok":true}`), nil
+	}
+	h := NewHandler(d, Options{RPCs: []string{"get", "list", "fail", "badjson", "badtype", "coded"}})
+
+	tc := []struct {
+		method       string
+		path         string
+		contentType  string
+		body         string
+		expectedCode int
+		expectedBody string
+		expectedRPC  string
+		expectedDB   string
+	}{
+		{"POST", "/get?db=db1", "application/json", `{"id":"foo"}`, 200, `{"ok":true}`, "get", "db1"},
+		{"POST", "/list", "", ``, 200, `{"ok":true}`, "list", ""},
+		{"GET", "/get?db=db1", "", ``, 405, `{"error":"Unsupported method: GET"}` + "\n", "", ""},
+		{"POST", "/", "", ``, 404, `{"error":"Invalid rpc path: /"}` + "\n", "", ""},
+		{"POST", "/a/b", "", ``, 404, `{"error":"Invalid rpc path: /a/b"}` + "\n", "", ""},
+		{"POST", "/drop?db=db1", "", ``, 403, `{"error":"RPC is not exposed over HTTP: drop"}` + "\n", "", ""},
+		{"POST", "/get", "text/plain", ``, 415, `{"error":"Unsupported content type: text/plain"}` + "\n", "", ""},
+		{"POST", "/fail?db=db1", "", `{}`, 500, `{"error":"boom"}` + "\n", "fail", "db1"},
+		{"POST", "/badjson?db=db1", "", ``, 400, `{"error":"unexpected end of JSON input"}` + "\n", "badjson", "db1"},
+		{"POST", "/badtype?db=db1", "", `{"ID":1}`, 400, `{"error":"json: cannot unmarshal number into Go struct field .ID of type string"}` + "\n", "badtype", "db1"},
+		{"POST", "/coded?db=db1", "", `NotFound`, 404, `{"error":"wrapped: NotFound: nope","code":"NotFound"}` + "\n", "coded", "db1"},
+		{"POST", "/coded?db=db1", "", `UnknownRPC`, 404, `{"error":"wrapped: UnknownRPC: nope","code":"UnknownRPC"}` + "\n", "coded", "db1"},
+		{"POST", "/coded?db=db1", "", `InvalidArgument`, 400, `{"error":"wrapped: InvalidArgument: nope","code":"InvalidArgument"}` + "\n", "coded", "db1"},
+		{"POST", "/coded?db=db1", "", `WriteConflict`, 409, `{"error":"wrapped: WriteConflict: nope","code":"WriteConflict"}` + "\n", "coded", "db1"},
+		{"POST", "/coded?db=db1", "", `QuotaExceeded`, 429, `{"error":"wrapped: QuotaExceeded: nope","code":"QuotaExceeded"}` + "\n", "coded", "db1"},
+		{"POST", "/coded?db=db1", "", `ValueCorrupt`, 500, `{"error":"wrapped: ValueCorrupt: nope","code":"ValueCorrupt"}` + "\n", "coded", "db1"},
+		{"POST", "/coded?db=db1", "", `Internal`, 500, `{"error":"wrapped: Internal: nope","code":"Internal"}` + "\n", "coded", "db1"},
+	}
+
+	for i, t := range tc {
+		gotDB, gotRPC, gotData = "", "", ""
+		msg := fmt.Sprintf("test case %d: %s %s", i, t.method, t.path)
+		req := httptest.NewRequest(t.method, t.path, strings.NewReader(t.body))
+		if t.contentType != "" {
+			req.Header.Set("Content-Type", t.contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t.expectedCode, w.Code, msg)
+		assert.Equal("application/json", w.Header().Get("Content-Type"), msg)
+		assert.Equal(t.expectedBody, w.Body.String(), msg)
+		assert.Equal(t.expectedRPC, gotRPC, msg)
+		assert.Equal(t.expectedDB, gotDB, msg)
+		if t.expectedRPC != "" {
+			assert.Equal(t.body, gotData, msg)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/get", nil))
+	assert.Equal(http.MethodPost, w.Header().Get("Allow"))
+
+	// By default only DataRPCs are exposed.
+	h = NewHandler(d, Options{})
+	for _, rpc := range []string{"put", "drop", "debugDump"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/"+rpc+"?db=db1", strings.NewReader(`{}`)))
+		if rpc == "put" {
+			assert.Equal(http.StatusOK, w.Code, rpc)
+		} else {
+			assert.Equal(http.StatusForbidden, w.Code, rpc)
+		}
+	}
+}
+
+// codedError is an error whose code is its value.
+type codedError string
+
+func (e codedError) Error() string {
+	return string(e) + ": nope"
+}
+
+func (e codedError) Code() string {
+	return string(e)
+}
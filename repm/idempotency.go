@@ -0,0 +1,121 @@
+package repm
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// idempotencyCacheSize is the number of responses remembered per connection.
+const idempotencyCacheSize = 1000
+
+// committingRPCs are the RPCs that accept an idempotencyKey. Every connection rpc that
+// commits must be listed.
+var committingRPCs = map[string]bool{
+	"put":             true,
+	"del":             true,
+	"clear":           true,
+	"collectionClear": true,
+	"checkpoint":      true,
+	"restore":         true,
+	"reset":           true,
+	"squashPending":   true,
+	"setConfig":       true,
+}
+
+// idempotencyKey returns the key under which the response to the request should be
+// remembered, or "" if it should not be. Hosts may retry requests that timed out on their
+// side of the bridge but in fact succeeded. Passing the same idempotencyKey on the retry
+// returns the original response rather than committing a second time.
+func idempotencyKey(rpc string, data []byte) string {
+	if !committingRPCs[rpc] {
+		return ""
+	}
+	var req struct {
+		IdempotencyKey string `json:"idempotencyKey"`
+	}
+	// Invalid requests are reported by the rpc itself.
+	if json.Unmarshal(data, &req) != nil || req.IdempotencyKey == "" {
+		return ""
+	}
+	return rpc + "/" + req.IdempotencyKey
+}
+
+// idempotencyCache remembers the responses to the most recent requests that had an
+// idempotency key. It belongs to the connection, so it is kept while the database is unloaded
+// for being idle, but is lost when the database is closed.
+type idempotencyCache struct {
+	mu        sync.Mutex
+	responses map[string][]byte
+	// order holds the keys of responses, oldest first.
+	order []string
+	// inFlight holds the keys of requests being dispatched, which are done when the request
+	// finishes.
+	inFlight map[string]*sync.WaitGroup
+}
+
+// begin reserves key for a request about to be dispatched. If a request with the same key is
+// in flight, such as the original of a retry, begin waits for it to finish. If there is a
+// response for key, begin returns it and ok is true. Otherwise the caller must dispatch the
+// request and then call end.
+func (c *idempotencyCache) begin(key string) (res []byte, ok bool) {
+	if key == "" {
+		return nil, false
+	}
+	c.mu.Lock()
+	for {
+		if res, ok := c.responses[key]; ok {
+			c.mu.Unlock()
+			return res, true
+		}
+		wg, ok := c.inFlight[key]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+		wg.Wait()
+		c.mu.Lock()
+	}
+	if c.inFlight == nil {
+		c.inFlight = map[string]*sync.WaitGroup{}
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	c.inFlight[key] = wg
+	c.mu.Unlock()
+	return nil, false
+}
+
+// end releases key reserved by begin and, if the request succeeded, remembers its response.
+// If it failed, a request waiting in begin dispatches again.
+func (c *idempotencyCache) end(key string, res []byte, succeeded bool) {
+	if key == "" {
+		return
+	}
+	if succeeded {
+		c.add(key, res)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight[key].Done()
+	delete(c.inFlight, key)
+}
+
+func (c *idempotencyCache) add(key string, res []byte) {
+	if key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.responses == nil {
+		c.responses = map[string][]byte{}
+	}
+	if _, ok := c.responses[key]; ok {
+		return
+	}
+	if len(c.order) == idempotencyCacheSize {
+		delete(c.responses, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.responses[key] = res
+	c.order = append(c.order, key)
+}
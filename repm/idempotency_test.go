@@ -0,0 +1,132 @@
+package repm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	defer deinit()
+	defer time.SetFake()()
+
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	getRoot := func() string {
+		res, err := Dispatch("db1", "getRoot", []byte(`{}`))
+		assert.NoError(err)
+		return string(res)
+	}
+
+	res1, err := Dispatch("db1", "put", []byte(`{"id":"foo","value":1,"idempotencyKey":"k1"}`))
+	assert.NoError(err)
+	root := getRoot()
+
+	// A retry returns the original response without committing again.
+	res2, err := Dispatch("db1", "put", []byte(`{"id":"foo","value":1,"idempotencyKey":"k1"}`))
+	assert.NoError(err)
+	assert.Equal(string(res1), string(res2))
+	assert.Equal(root, getRoot())
+
+	// Keys are scoped to the rpc.
+	_, err = Dispatch("db1", "del", []byte(`{"id":"foo","idempotencyKey":"k1"}`))
+	assert.NoError(err)
+	assert.NotEqual(root, getRoot())
+	root = getRoot()
+
+	// Requests without a key are always executed.
+	_, err = Dispatch("db1", "put", []byte(`{"id":"foo","value":1}`))
+	assert.NoError(err)
+	assert.NotEqual(root, getRoot())
+	root = getRoot()
+	_, err = Dispatch("db1", "put", []byte(`{"id":"foo","value":1}`))
+	assert.NoError(err)
+	assert.NotEqual(root, getRoot())
+
+	// Failed requests are not remembered.
+	_, err = Dispatch("db1", "put", []byte(`{"id":"foo","idempotencyKey":"k2"}`))
+	assert.EqualError(err, "value field is required")
+	_, err = Dispatch("db1", "put", []byte(`{"id":"foo","value":2,"idempotencyKey":"k2"}`))
+	assert.NoError(err)
+
+	// Other committing rpcs accept keys too.
+	for _, rpc := range []string{"reset", "squashPending", "setConfig"} {
+		assert.True(committingRPCs[rpc], rpc)
+	}
+	_, err = Dispatch("db1", "setConfig", []byte(`{"key":"k","value":1,"idempotencyKey":"k3"}`))
+	assert.NoError(err)
+	_, err = Dispatch("db1", "setConfig", []byte(`{"key":"k","value":2}`))
+	assert.NoError(err)
+	_, err = Dispatch("db1", "setConfig", []byte(`{"key":"k","value":1,"idempotencyKey":"k3"}`))
+	assert.NoError(err)
+	res, err := Dispatch("db1", "getConfig", []byte(`{"key":"k"}`))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":2}`, string(res))
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	assert := assert.New(t)
+	var c idempotencyCache
+
+	c.add("", []byte("ignored"))
+	assert.Equal(0, len(c.order))
+
+	for i := 0; i < idempotencyCacheSize+1; i++ {
+		c.add(fmt.Sprintf("k%d", i), []byte(fmt.Sprintf("v%d", i)))
+	}
+	_, ok := c.responses["k0"]
+	assert.False(ok)
+	res, ok := c.responses["k1"]
+	assert.True(ok)
+	assert.Equal("v1", string(res))
+	res, ok = c.responses[fmt.Sprintf("k%d", idempotencyCacheSize)]
+	assert.True(ok)
+	assert.Equal(fmt.Sprintf("v%d", idempotencyCacheSize), string(res))
+	assert.Equal(idempotencyCacheSize, len(c.order))
+}
+
+func TestIdempotencyCacheInFlight(t *testing.T) {
+	assert := assert.New(t)
+	var c idempotencyCache
+
+	_, ok := c.begin("")
+	assert.False(ok)
+	c.end("", nil, true)
+
+	// A retry that arrives while the original is in flight gets the original's response.
+	_, ok = c.begin("k1")
+	assert.False(ok)
+	retried := make(chan string)
+	go func() {
+		res, ok := c.begin("k1")
+		assert.True(ok)
+		retried <- string(res)
+	}()
+	c.end("k1", []byte("v1"), true)
+	assert.Equal("v1", <-retried)
+
+	// If the original fails, the retry is dispatched.
+	_, ok = c.begin("k2")
+	assert.False(ok)
+	go func() {
+		_, ok := c.begin("k2")
+		assert.False(ok)
+		c.end("k2", []byte("v2"), true)
+		retried <- ""
+	}()
+	c.end("k2", nil, false)
+	<-retried
+	res, ok := c.begin("k2")
+	assert.True(ok)
+	assert.Equal("v2", string(res))
+	assert.Equal(0, len(c.inFlight))
+}
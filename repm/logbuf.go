@@ -0,0 +1,45 @@
+package repm
+
+import (
+	"bytes"
+	"sync"
+)
+
+// recentLogLines is the number of log lines kept for debugDump.
+const recentLogLines = 500
+
+// logBuffer keeps the most recent lines written to it. Unlike the rest of repm it is
+// thread-safe, since logging happens from background goroutines.
+type logBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+var recentLogs = &logBuffer{}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	for {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			b.partial = append(b.partial, p...)
+			return n, nil
+		}
+		b.lines = append(b.lines, string(append(b.partial, p[:i]...)))
+		b.partial = b.partial[:0]
+		p = p[i+1:]
+		if len(b.lines) > recentLogLines {
+			b.lines = b.lines[len(b.lines)-recentLogLines:]
+		}
+	}
+}
+
+// recent returns the most recent complete lines, oldest first.
+func (b *logBuffer) recent() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, b.lines...)
+}
@@ -0,0 +1,27 @@
+package repm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogBuffer(t *testing.T) {
+	assert := assert.New(t)
+	b := &logBuffer{}
+	assert.Equal([]string{}, b.recent())
+
+	b.Write([]byte("one\ntw"))
+	assert.Equal([]string{"one"}, b.recent())
+	b.Write([]byte("o\n\nthree"))
+	assert.Equal([]string{"one", "two", ""}, b.recent())
+
+	for i := 0; i < recentLogLines; i++ {
+		fmt.Fprintf(b, "%d\n", i)
+	}
+	lines := b.recent()
+	assert.Equal(recentLogLines, len(lines))
+	assert.Equal("three0", lines[0])
+	assert.Equal(fmt.Sprintf("%d", recentLogLines-1), lines[len(lines)-1])
+}
@@ -0,0 +1,36 @@
+package repm
+
+import "roci.dev/replicache-client/db"
+
+// NetworkPolicy lets hosts decide whether a sync may use the network now, e.g. to defer large
+// syncs on cellular or metered connections until the device is on Wi-Fi.
+type NetworkPolicy interface {
+	// AllowSync is called before each pull, and before seeding a database being opened, for
+	// which rpc is "open". bytesEstimate is the estimated number of bytes
+	// the sync will receive, based on the previous sync with the same remote, or -1 if there
+	// is no estimate. Returning false defers the sync.
+	AllowSync(dbName, rpc string, bytesEstimate int64) bool
+}
+
+var networkPolicy NetworkPolicy
+
+// SetNetworkPolicy registers p to be consulted before each sync. A nil policy, the default,
+// allows every sync. It must not be called concurrently with Dispatch.
+func SetNetworkPolicy(p NetworkPolicy) {
+	networkPolicy = p
+}
+
+// allowSync asks the network policy, if any, whether rpc may sync d, the database named
+// dbName, with remote.
+func allowSync(d *db.DB, dbName, rpc, remote string) bool {
+	if networkPolicy == nil {
+		return true
+	}
+	estimate := int64(-1)
+	for _, s := range d.Bandwidth().Stats() {
+		if s.Remote == remote {
+			estimate = int64(s.LastBytesReceived)
+		}
+	}
+	return networkPolicy.AllowSync(dbName, rpc, estimate)
+}
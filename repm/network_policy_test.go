@@ -0,0 +1,66 @@
+package repm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	jsnoms "roci.dev/diff-server/util/noms/json"
+)
+
+type fakeNetworkPolicy struct {
+	allow     bool
+	estimates []int64
+}
+
+func (p *fakeNetworkPolicy) AllowSync(dbName, rpc string, bytesEstimate int64) bool {
+	p.estimates = append(p.estimates, bytesEstimate)
+	return p.allow
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	body := "nope"
+	pulls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pulls++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(body))
+	}))
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	req := mustMarshal(PullRequest{Remote: jsnoms.Spec{Spec: sp}})
+
+	p := &fakeNetworkPolicy{allow: true}
+	SetNetworkPolicy(p)
+	_, err = Dispatch("db1", "pull", req)
+	assert.Error(err)
+	assert.Equal(1, pulls)
+
+	// The estimate is the size of the previous response from the remote.
+	p.allow = false
+	buf, err := Dispatch("db1", "pull", req)
+	assert.NoError(err)
+	var res PullResponse
+	assert.NoError(json.Unmarshal(buf, &res))
+	assert.True(res.Deferred)
+	assert.Equal(1, pulls)
+	assert.Equal([]int64{-1, int64(len(body))}, p.estimates)
+
+	SetNetworkPolicy(nil)
+	_, err = Dispatch("db1", "pull", req)
+	assert.Error(err)
+	assert.Equal(2, pulls)
+}
@@ -0,0 +1,68 @@
+package repm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	gtime "time"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// ErrInternal is returned when Replicache panics, e.g. on a failed Noms assertion. The panic is
+// contained so that it doesn't take down the host app, and reported by the lastPanic rpc.
+var ErrInternal = errors.New("internal error")
+
+// PanicInfo describes a panic recovered by Dispatch.
+type PanicInfo struct {
+	Message string `json:"message"`
+	Stack   string `json:"stack"`
+	// DBName and RPC are the Dispatch call that panicked. RPC is "open" for panics while
+	// opening a database in the background.
+	DBName string     `json:"dbName"`
+	RPC    string     `json:"rpc"`
+	Time   gtime.Time `json:"time"`
+}
+
+// LastPanicResponse is the response to lastPanic. Panic is nil if nothing has panicked since
+// Init.
+type LastPanicResponse struct {
+	Panic *PanicInfo `json:"panic,omitempty"`
+}
+
+var (
+	// panicMu guards lastPanic, since databases opened with OpenAsync load in the background.
+	panicMu   sync.Mutex
+	lastPanic *PanicInfo
+)
+
+// recoverPanic converts a panic in the calling Dispatch function for rpc on dbName into an
+// ErrInternal error, and records it for lastPanic. It must be called directly via defer.
+func recoverPanic(dbName, rpc string, ret *[]byte, err *error) {
+	if r := recover(); r != nil {
+		var msg string
+		if e, ok := r.(error); ok {
+			msg = e.Error()
+		} else {
+			msg = fmt.Sprintf("%v", r)
+		}
+		stack := string(debug.Stack())
+		log.Printf("Replicache panicked with: %s\n%s\n", msg, stack)
+
+		panicMu.Lock()
+		lastPanic = &PanicInfo{Message: msg, Stack: stack, DBName: dbName, RPC: rpc, Time: time.Now()}
+		panicMu.Unlock()
+
+		*ret = nil
+		*err = withCode(fmt.Errorf("%w: Replicache panicked with: %s - see lastPanic for more", ErrInternal, msg))
+	}
+}
+
+func dispatchLastPanic() ([]byte, error) {
+	panicMu.Lock()
+	defer panicMu.Unlock()
+	return json.Marshal(LastPanicResponse{Panic: lastPanic})
+}
@@ -0,0 +1,62 @@
+package repm
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	jsnoms "roci.dev/diff-server/util/noms/json"
+	"roci.dev/diff-server/util/time"
+)
+
+type panickingNetworkPolicy struct{}
+
+func (panickingNetworkPolicy) AllowSync(dbName, rpc string, bytesEstimate int64) bool {
+	panic("boom")
+}
+
+func TestPanicIsolation(t *testing.T) {
+	defer deinit()
+	defer time.SetFake()()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	lastPanic := func() LastPanicResponse {
+		buf, err := Dispatch("", "lastPanic", nil)
+		assert.NoError(err)
+		var res LastPanicResponse
+		assert.NoError(json.Unmarshal(buf, &res))
+		return res
+	}
+	assert.Nil(lastPanic().Panic)
+
+	sp, err := spec.ForDatabase("http://localhost:1")
+	assert.NoError(err)
+	SetNetworkPolicy(panickingNetworkPolicy{})
+	res, err := Dispatch("db1", "pull", mustMarshal(PullRequest{Remote: jsnoms.Spec{Spec: sp}}))
+	assert.Nil(res)
+	assert.EqualError(err, "Internal: internal error: Replicache panicked with: boom - see lastPanic for more")
+	assert.True(errors.Is(err, ErrInternal))
+
+	p := lastPanic().Panic
+	if assert.NotNil(p) {
+		assert.Equal("boom", p.Message)
+		assert.Equal("db1", p.DBName)
+		assert.Equal("pull", p.RPC)
+		assert.True(time.Now().Equal(p.Time))
+		assert.Contains(p.Stack, "AllowSync")
+	}
+
+	// The database is still usable.
+	SetNetworkPolicy(nil)
+	_, err = Dispatch("db1", "put", []byte(`{"id": "foo", "value": "bar"}`))
+	assert.NoError(err)
+}
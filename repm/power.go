@@ -0,0 +1,111 @@
+package repm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+
+	"roci.dev/replicache-client/db"
+)
+
+// Power states accepted by setPowerState.
+const (
+	PowerStateNormal   = "normal"
+	PowerStateLow      = "low"
+	PowerStateCharging = "charging"
+)
+
+// PowerConfig configures how syncing is throttled in each power state. Zero fields take the
+// defaults from defaultPowerConfig.
+type PowerConfig struct {
+	// LowIntervalFactor multiplies the poll interval reported by syncInfo while the battery is
+	// low, so that sync loops that follow it pull less often.
+	LowIntervalFactor float64 `json:"lowIntervalFactor,omitempty"`
+	// LowMinIntervalMs is the least poll interval reported while the battery is low, including
+	// when the server gave no poll interval.
+	LowMinIntervalMs int64 `json:"lowMinIntervalMs,omitempty"`
+	// ChargingIntervalFactor multiplies the poll interval while charging. Values below one
+	// pull more often than the server asked.
+	ChargingIntervalFactor float64 `json:"chargingIntervalFactor,omitempty"`
+	// AllowSpeculativeOnLowPower allows speculative pulls and background syncs while the
+	// battery is low. By default they are deferred.
+	AllowSpeculativeOnLowPower bool `json:"allowSpeculativeOnLowPower,omitempty"`
+}
+
+var defaultPowerConfig = PowerConfig{
+	LowIntervalFactor:      4,
+	LowMinIntervalMs:       5 * 60 * 1000,
+	ChargingIntervalFactor: 1,
+}
+
+// SetPowerStateRequest reports the device's power state, which the host should send whenever
+// it changes. If Config is set it replaces the current configuration.
+type SetPowerStateRequest struct {
+	// State is one of "normal", "low", or "charging".
+	State  string       `json:"state"`
+	Config *PowerConfig `json:"config,omitempty"`
+}
+
+var (
+	// powerMu guards powerState and powerConfig, which pulls read concurrently.
+	powerMu     sync.Mutex
+	powerState  = PowerStateNormal
+	powerConfig = defaultPowerConfig
+)
+
+func setPowerState(data []byte) error {
+	var req SetPowerStateRequest
+	err := json.Unmarshal(data, &req)
+	if err != nil {
+		return err
+	}
+	switch req.State {
+	case PowerStateNormal, PowerStateLow, PowerStateCharging:
+	default:
+		return fmt.Errorf("%w: unknown power state: %s", db.ErrInvalidArgument, req.State)
+	}
+	powerMu.Lock()
+	defer powerMu.Unlock()
+	if c := req.Config; c != nil {
+		if c.LowIntervalFactor < 0 || c.ChargingIntervalFactor < 0 || c.LowMinIntervalMs < 0 {
+			return fmt.Errorf("%w: power config values must not be negative", db.ErrInvalidArgument)
+		}
+		powerConfig = *c
+		if powerConfig.LowIntervalFactor == 0 {
+			powerConfig.LowIntervalFactor = defaultPowerConfig.LowIntervalFactor
+		}
+		if powerConfig.LowMinIntervalMs == 0 {
+			powerConfig.LowMinIntervalMs = defaultPowerConfig.LowMinIntervalMs
+		}
+		if powerConfig.ChargingIntervalFactor == 0 {
+			powerConfig.ChargingIntervalFactor = defaultPowerConfig.ChargingIntervalFactor
+		}
+	}
+	powerState = req.State
+	return nil
+}
+
+// allowSpeculativeSync returns whether a speculative pull, one the user didn't ask for, may
+// run in the current power state.
+func allowSpeculativeSync() bool {
+	powerMu.Lock()
+	defer powerMu.Unlock()
+	return powerState != PowerStateLow || powerConfig.AllowSpeculativeOnLowPower
+}
+
+// throttlePollInterval adjusts the poll interval of si for the current power state.
+func throttlePollInterval(si *db.SyncInfo) {
+	powerMu.Lock()
+	defer powerMu.Unlock()
+	switch powerState {
+	case PowerStateLow:
+		ms := int64(math.Round(float64(si.PollIntervalMs) * powerConfig.LowIntervalFactor))
+		if ms < powerConfig.LowMinIntervalMs {
+			ms = powerConfig.LowMinIntervalMs
+		}
+		si.PollIntervalMs = ms
+	case PowerStateCharging:
+		si.PollIntervalMs = int64(math.Round(float64(si.PollIntervalMs) * powerConfig.ChargingIntervalFactor))
+	}
+}
@@ -0,0 +1,105 @@
+package repm
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/stretchr/testify/assert"
+
+	jsnoms "roci.dev/diff-server/util/noms/json"
+
+	"roci.dev/replicache-client/db"
+)
+
+func TestPowerState(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+
+	pulls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pulls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	sp, err := spec.ForDatabase(server.URL)
+	assert.NoError(err)
+	pull := func(speculative bool) (PullResponse, error) {
+		buf, err := Dispatch("db1", "pull", mm(assert, PullRequest{Remote: jsnoms.Spec{Spec: sp}, Speculative: speculative}))
+		var res PullResponse
+		if err == nil {
+			assert.NoError(json.Unmarshal(buf, &res))
+		}
+		return res, err
+	}
+	pollInterval := func() int64 {
+		buf, err := Dispatch("db1", "syncInfo", mm(assert, SyncInfoRequest{}))
+		assert.NoError(err)
+		var si SyncInfoResponse
+		assert.NoError(json.Unmarshal(buf, &si))
+		return si.PollIntervalMs
+	}
+
+	_, err = Dispatch("", "setPowerState", mm(assert, SetPowerStateRequest{State: "empty"}))
+	assert.True(errors.Is(err, db.ErrInvalidArgument))
+	_, err = pull(true)
+	assert.Error(err)
+	assert.Equal(1, pulls)
+	assert.Equal(int64(0), pollInterval())
+
+	// Speculative pulls are deferred on low battery, and the poll interval is stretched.
+	_, err = Dispatch("", "setPowerState", mm(assert, SetPowerStateRequest{State: PowerStateLow}))
+	assert.NoError(err)
+	res, err := pull(true)
+	assert.NoError(err)
+	assert.True(res.Deferred)
+	assert.Equal(1, pulls)
+	_, err = pull(false)
+	assert.Error(err)
+	assert.Equal(2, pulls)
+	assert.Equal(int64(5*60*1000), pollInterval())
+
+	_, err = Dispatch("", "setPowerState", mm(assert, SetPowerStateRequest{State: PowerStateLow, Config: &PowerConfig{LowMinIntervalMs: 1000, AllowSpeculativeOnLowPower: true}}))
+	assert.NoError(err)
+	_, err = pull(true)
+	assert.Error(err)
+	assert.Equal(3, pulls)
+	assert.Equal(int64(1000), pollInterval())
+
+	_, err = Dispatch("", "setPowerState", mm(assert, SetPowerStateRequest{State: PowerStateNormal}))
+	assert.NoError(err)
+	assert.Equal(int64(0), pollInterval())
+}
+
+func TestThrottlePollInterval(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	tc := []struct {
+		state  string
+		config PowerConfig
+		in     int64
+		out    int64
+	}{
+		{PowerStateNormal, defaultPowerConfig, 60000, 60000},
+		{PowerStateLow, defaultPowerConfig, 60000, 300000},
+		{PowerStateLow, defaultPowerConfig, 600000, 2400000},
+		{PowerStateLow, PowerConfig{LowIntervalFactor: 2, LowMinIntervalMs: 1}, 60000, 120000},
+		{PowerStateCharging, defaultPowerConfig, 60000, 60000},
+		{PowerStateCharging, PowerConfig{ChargingIntervalFactor: 0.5}, 60000, 30000},
+	}
+	for i, t := range tc {
+		powerState, powerConfig = t.state, t.config
+		si := db.SyncInfo{PollIntervalMs: t.in}
+		throttlePollInterval(&si)
+		assert.Equal(t.out, si.PollIntervalMs, "case %d", i)
+	}
+}
@@ -1,8 +1,14 @@
 // Package repm implements an Android and iOS interface to Replicache via [Gomobile](https://github.com/golang/go/wiki/Mobile).
-// repm is not thread-safe. Callers must guarantee that it is not called concurrently on different threads/goroutines.
+//
+// Dispatch may be called concurrently. Rpcs to an open database run concurrently: reads see a
+// snapshot of the database, writes are serialized, and a pull only excludes writes while it
+// swaps in the new head. Databases are loaded, whether by open or after idle eviction, without
+// holding up rpcs to other databases; rpcs to a database that is loading wait for it. Closing
+// a database waits for the rpcs in flight on it.
 package repm
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -15,11 +21,12 @@ import (
 	"os"
 	"path"
 	"runtime"
-	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	gtime "time"
 
 	"github.com/attic-labs/noms/go/spec"
 
-	"roci.dev/diff-server/util/chk"
 	rlog "roci.dev/diff-server/util/log"
 	"roci.dev/diff-server/util/time"
 	"roci.dev/diff-server/util/version"
@@ -28,9 +35,18 @@ import (
 
 var (
 	connections = map[string]*connection{}
-	repDir      string
+	// connectionsMu guards connections, and the db and loading fields of each connection.
+	// It is not held while databases load, so that a slow load only stalls the rpcs that need
+	// that database.
+	connectionsMu sync.Mutex
+	repDir        string
+	idleTimeout   gtime.Duration
+	// seedTimeout bounds how long open waits for the snapshot named by OpenRequest.SeedURL.
+	seedTimeout = defaultSeedTimeout
 )
 
+const defaultSeedTimeout = gtime.Minute
+
 // Logger allows client to optionally provide a place to send repm's log messages.
 type Logger interface {
 	io.Writer
@@ -43,7 +59,8 @@ func Init(storageDir, tempDir string, logger Logger) {
 	if logger == nil {
 		logger = os.Stderr
 	}
-	rlog.Init(logger, rlog.Options{Prefix: true})
+	// Recent logs are kept for debugDump.
+	rlog.Init(io.MultiWriter(logger, recentLogs), rlog.Options{Prefix: true})
 
 	if storageDir == "" {
 		log.Print("storageDir must be non-empty")
@@ -56,104 +73,312 @@ func Init(storageDir, tempDir string, logger Logger) {
 	repDir = storageDir
 }
 
+// SetIdleTimeout configures repm to release the underlying storage of open databases that
+// have not been used for the specified number of milliseconds. Such databases are reopened
+// transparently on their next use. Zero (the default) disables eviction.
+func SetIdleTimeout(ms int64) {
+	idleTimeout = gtime.Duration(ms) * gtime.Millisecond
+}
+
 // for testing
 func deinit() {
 	connections = map[string]*connection{}
 	repDir = ""
+	idleTimeout = 0
+	seedTimeout = defaultSeedTimeout
+	scratchLimit = defaultScratchLimit
+	networkPolicy = nil
+	powerState = PowerStateNormal
+	powerConfig = defaultPowerConfig
+	lastPanic = nil
 }
 
 // Dispatch send an API request to Replicache, JSON-serialized parameters, and returns the response.
 func Dispatch(dbName, rpc string, data []byte) (ret []byte, err error) {
+	return DispatchCtx(context.Background(), dbName, rpc, data)
+}
+
+// DispatchCtx is like Dispatch but abandons the request if ctx is done before it completes.
+// It is not available via Gomobile.
+func DispatchCtx(ctx context.Context, dbName, rpc string, data []byte) (ret []byte, err error) {
 	t0 := time.Now()
 	defer func() {
 		t1 := time.Now()
 		ds := string(data)
 		log.Printf("Dispatch %v :: %v %v took %v - returned %v", dbName, rpc, ds, t1.Sub(t0), len(ret))
-		if r := recover(); r != nil {
-			var msg string
-			if e, ok := r.(error); ok {
-				msg = e.Error()
-			} else {
-				msg = fmt.Sprintf("%v", r)
-			}
-			log.Printf("Replicache panicked with: %s\n%s\n", msg, string(debug.Stack()))
-			ret = nil
-			err = fmt.Errorf("Replicache panicked with: %s - see stderr for more", msg)
-		}
 	}()
+	defer recoverPanic(dbName, rpc, &ret, &err)
 
 	switch rpc {
 	case "list":
 		return list()
+	case "listForAccount":
+		return listForAccount(data)
+	case "dropAccount":
+		return nil, dropAccount(data)
 	case "open":
-		return nil, open(dbName)
+		return nil, open(dbName, data)
 	case "close":
 		return nil, close(dbName)
 	case "drop":
 		return nil, drop(dbName)
 	case "version":
 		return []byte(version.Version()), nil
+	case "capabilities":
+		return capabilities()
+	case "encodeKey":
+		return dispatchEncodeKey(data)
+	case "status":
+		connectionsMu.Lock()
+		defer connectionsMu.Unlock()
+		return status(dbName)
 	case "profile":
 		profile()
 		return nil, nil
+	case "lastPanic":
+		return dispatchLastPanic()
+	case "doctor":
+		connectionsMu.Lock()
+		defer connectionsMu.Unlock()
+		return doctor(dbName, data)
+	case "setPowerState":
+		return nil, setPowerState(data)
+	}
+	if !hasRPC(connectionRPCs, rpc) {
+		// Checked before the database so that SDKs newer than this build get the same error
+		// whether or not it is open.
+		return nil, withCode(unknownRPC(rpc, topLevelRPCs, connectionRPCs))
 	}
 
-	conn := connections[dbName]
-	if conn == nil {
-		return nil, errors.New("specified database is not open")
+	conn, err := getConnection(dbName, t0)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.release()
+	key := idempotencyKey(rpc, data)
+	if res, ok := conn.recent.begin(key); ok {
+		return res, nil
 	}
+	succeeded := false
+	// Deferred so that the key is released if the rpc panics.
+	defer func() { conn.recent.end(key, ret, succeeded) }()
+	ret, err = conn.dispatch(ctx, rpc, data)
+	if err != nil {
+		return nil, withCode(err)
+	}
+	succeeded = true
+	return ret, nil
+}
+
+func (conn *connection) dispatch(ctx context.Context, rpc string, data []byte) ([]byte, error) {
 	switch rpc {
 	case "getRoot":
-		return conn.dispatchGetRoot(data)
+		return conn.dispatchGetRoot(ctx, data)
+	case "syncInfo":
+		return conn.dispatchSyncInfo(data)
 	case "has":
-		return conn.dispatchHas(data)
+		return conn.dispatchHas(ctx, data)
 	case "get":
-		return conn.dispatchGet(data)
+		return conn.dispatchGet(ctx, data)
+	case "getMeta":
+		return conn.dispatchGetMeta(data)
 	case "scan":
-		return conn.dispatchScan(data)
+		return conn.dispatchScan(ctx, data)
+	case "scanDeleted":
+		return conn.dispatchScanDeleted(ctx, data)
+	case "aggregate":
+		return conn.dispatchAggregate(ctx, data)
+	case "collectionScan":
+		return conn.dispatchCollectionScan(ctx, data)
+	case "collectionCount":
+		return conn.dispatchCollectionCount(ctx, data)
+	case "collectionClear":
+		return conn.dispatchCollectionClear(ctx, data)
 	case "put":
-		return conn.dispatchPut(data)
+		return conn.dispatchPut(ctx, data)
 	case "del":
-		return conn.dispatchDel(data)
+		return conn.dispatchDel(ctx, data)
+	case "clear":
+		return conn.dispatchClear(ctx, data)
+	case "checkpoint":
+		return conn.dispatchCheckpoint(data)
+	case "restore":
+		return conn.dispatchRestore(data)
+	case "reset":
+		return conn.dispatchReset()
+	case "squashPending":
+		return conn.dispatchSquashPending(data)
+	case "fingerprint":
+		return conn.dispatchFingerprint()
+	case "previewPatch":
+		return conn.dispatchPreviewPatch(data)
+	case "setConfig":
+		return conn.dispatchSetConfig(data)
+	case "getConfig":
+		return conn.dispatchGetConfig(data)
+	case "setAudit":
+		return conn.dispatchSetAudit(data)
+	case "exportAudit":
+		return conn.dispatchExportAudit(data)
+	case "setWireLogSize":
+		return conn.dispatchSetWireLogSize(data)
+	case "setHotPrefixes":
+		return conn.dispatchSetHotPrefixes(data)
+	case "setBandwidthQuota":
+		return conn.dispatchSetBandwidthQuota(data)
+	case "bandwidthStats":
+		return conn.dispatchBandwidthStats(data)
+	case "pollEvents":
+		return conn.dispatchPollEvents(data)
+	case "debugDump":
+		return conn.dispatchDebugDump(data)
 	case "pull":
-		return conn.dispatchPull(data)
+		return conn.dispatchPull(ctx, data)
 	case "pullProgress":
 		return conn.dispatchPullProgress(data)
+	case "runBackgroundSync":
+		return conn.dispatchRunBackgroundSync(ctx, data)
+	case "verifyState":
+		return conn.dispatchVerifyState(ctx, data)
 	}
-	chk.Fail("Unsupported rpc name: %s", rpc)
-	return nil, nil
+	return nil, unknownRPC(rpc, topLevelRPCs, connectionRPCs)
+}
+
+// getConnection returns the open connection for dbName, loading its database if necessary.
+// The connection is in use, so it can't be unloaded or closed, until it is released.
+func getConnection(dbName string, now gtime.Time) (*connection, error) {
+	connectionsMu.Lock()
+	conn := connections[dbName]
+	if conn == nil {
+		connectionsMu.Unlock()
+		return nil, errors.New("specified database is not open")
+	}
+	evictIdle(now)
+	conn.lastUsed = now
+	atomic.AddInt32(&conn.active, 1)
+	conn.inFlight.Add(1)
+	connectionsMu.Unlock()
+
+	if err := conn.ensureLoaded(); err != nil {
+		conn.release()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// release ends a use of the connection started by getConnection.
+func (conn *connection) release() {
+	atomic.AddInt32(&conn.active, -1)
+	conn.inFlight.Done()
 }
 
 type DatabaseInfo struct {
 	Name string `json:"name"`
+	// Account is the account the database was opened with, if any.
+	Account string `json:"account,omitempty"`
+	// SizeBytes is the size of the database on disk.
+	SizeBytes int64 `json:"sizeBytes"`
+	// Head is the hash of the head commit. For databases that aren't open it is the head as of
+	// when the database was last closed.
+	Head string `json:"head,omitempty"`
+	// LastOpened, SchemaVersion, and ProtocolVersion describe the most recent open of the
+	// database, and are omitted for databases last opened by builds that didn't record them.
+	LastOpened      *gtime.Time `json:"lastOpened,omitempty"`
+	SchemaVersion   int         `json:"schemaVersion,omitempty"`
+	ProtocolVersion int         `json:"protocolVersion,omitempty"`
 }
 
 type ListResponse struct {
 	Databases []DatabaseInfo `json:"databases"`
 }
 
+type ListForAccountRequest struct {
+	Account string `json:"account"`
+}
+
+type DropAccountRequest struct {
+	Account string `json:"account"`
+}
+
 func list() (resBytes []byte, err error) {
-	if repDir == "" {
-		return nil, errors.New("must call init first")
+	resp, err := listDatabases()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(resp)
+}
+
+// listForAccount is like list but only returns the databases opened with the given account.
+func listForAccount(reqBytes []byte) ([]byte, error) {
+	var req ListForAccountRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return nil, err
+	}
+	dbs, err := accountDatabases(req.Account)
+	if err != nil {
+		return nil, err
 	}
+	return json.Marshal(ListResponse{Databases: dbs})
+}
 
+// dropAccount drops every database opened with the given account.
+func dropAccount(reqBytes []byte) error {
+	var req DropAccountRequest
+	err := json.Unmarshal(reqBytes, &req)
+	if err != nil {
+		return err
+	}
+	dbs, err := accountDatabases(req.Account)
+	if err != nil {
+		return err
+	}
+	for _, d := range dbs {
+		if err := drop(d.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func accountDatabases(account string) ([]DatabaseInfo, error) {
+	if account == "" {
+		return nil, errors.New("account must be non-empty")
+	}
+	resp, err := listDatabases()
+	if err != nil {
+		return nil, err
+	}
+	r := []DatabaseInfo{}
+	for _, d := range resp.Databases {
+		if d.Account == account {
+			r = append(r, d)
+		}
+	}
+	return r, nil
+}
+
+func listDatabases() (ListResponse, error) {
 	resp := ListResponse{
 		Databases: []DatabaseInfo{},
 	}
+	if repDir == "" {
+		return resp, errors.New("must call init first")
+	}
 
 	fi, err := os.Stat(repDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return json.Marshal(resp)
+			return resp, nil
 		}
-		return nil, err
+		return resp, err
 	}
 	if !fi.IsDir() {
-		return nil, errors.New("Specified path is not a directory")
+		return resp, errors.New("Specified path is not a directory")
 	}
 	entries, err := ioutil.ReadDir(repDir)
 	if err != nil {
-		return nil, err
+		return resp, err
 	}
 	for _, entry := range entries {
 		if entry.IsDir() {
@@ -162,54 +387,241 @@ func list() (resBytes []byte, err error) {
 				log.Printf("Could not decode directory name: %s, skipping", entry.Name())
 				continue
 			}
-			resp.Databases = append(resp.Databases, DatabaseInfo{
-				Name: string(b),
-			})
+			resp.Databases = append(resp.Databases, listDatabase(string(b), path.Join(repDir, entry.Name())))
 		}
 	}
-	return json.Marshal(resp)
+	return resp, nil
 }
 
-// Open a Replicache database. If the named database doesn't exist it is created.
-func open(dbName string) error {
+// listDatabase returns the list entry for the database name in dir.
+func listDatabase(name, dir string) DatabaseInfo {
+	info := readDBInfo(dir)
+	di := DatabaseInfo{
+		Name:            name,
+		Account:         info.Account,
+		Head:            info.Head,
+		SchemaVersion:   info.SchemaVersion,
+		ProtocolVersion: info.ProtocolVersion,
+	}
+	if !info.LastOpened.IsZero() {
+		di.LastOpened = &info.LastOpened
+	}
+	connectionsMu.Lock()
+	if conn := connections[name]; conn != nil && conn.db != nil {
+		di.Head = conn.db.Hash().String()
+	}
+	connectionsMu.Unlock()
+	size, err := dirSize(dir)
+	if err != nil {
+		log.Printf("Could not determine size of database '%s': %s", name, err)
+	}
+	di.SizeBytes = size
+	return di
+}
+
+// Open a Replicache database. If the named database doesn't exist it is created. reqBytes
+// is an optional OpenRequest. The database is loaded without holding connectionsMu, so that
+// rpcs to other databases aren't held up, and rpcs to this one wait for it to load. If it is
+// already open, open waits for it to finish loading.
+func open(dbName string, reqBytes []byte) error {
+	connectionsMu.Lock()
+	conn, l, req, err := beginOpen(dbName, reqBytes)
+	if conn == nil && err == nil {
+		conn = connections[dbName]
+	}
+	connectionsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		return conn.ensureLoaded()
+	}
+
+	d, err := loadAsync(dbName, conn.dir, req)
+	if err == nil {
+		conn.recordOpened()
+	}
+	l.finish(d, err)
+	if err := conn.ensureLoaded(); err != nil {
+		// Unlike OpenAsync, a failed open leaves the database closed.
+		connectionsMu.Lock()
+		if connections[dbName] == conn {
+			delete(connections, dbName)
+		}
+		connectionsMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// newConnection validates an open request and returns an unloaded connection for dbName. If
+// the database is already open the returned connection is nil.
+func newConnection(dbName string, reqBytes []byte) (*connection, OpenRequest, error) {
+	var req OpenRequest
+	if len(reqBytes) > 0 {
+		err := json.Unmarshal(reqBytes, &req)
+		if err != nil {
+			return nil, req, err
+		}
+	}
 	if repDir == "" {
-		return errors.New("Replicache is uninitialized - must call init first")
+		return nil, req, errors.New("Replicache is uninitialized - must call init first")
 	}
 	if dbName == "" {
-		return errors.New("dbName must be non-empty")
+		return nil, req, errors.New("dbName must be non-empty")
+	}
+	sv, err := checkSchemaVersion(req.SchemaVersion)
+	if err != nil {
+		return nil, req, err
 	}
 
 	if _, ok := connections[dbName]; ok {
-		return nil
+		return nil, req, nil
 	}
 
 	p := dbPath(repDir, dbName)
+	if a := readDBInfo(p).Account; req.Account != "" && a != "" && a != req.Account {
+		return nil, req, fmt.Errorf("Database '%s' belongs to a different account", dbName)
+	}
 	log.Printf("Opening Replicache database '%s' at '%s'", dbName, p)
 	log.Printf("Using tempdir: %s", os.TempDir())
-	sp, err := spec.ForDatabase(p)
+	return &connection{name: dbName, account: req.Account, dir: p, scratch: scratchPath(p), schemaVersion: sv, pendingLimit: req.PendingLimit, lastUsed: time.Now()}, req, nil
+}
+
+// ensureLoaded loads the connection's database if it isn't already loaded, either because
+// the connection is new or because it was evicted for being idle. If the database is being
+// loaded in the background, ensureLoaded waits for it. The caller must not hold
+// connectionsMu.
+func (conn *connection) ensureLoaded() error {
+	conn.loadMu.Lock()
+	defer conn.loadMu.Unlock()
+	connectionsMu.Lock()
+	d, l := conn.db, conn.loading
+	connectionsMu.Unlock()
+	if d != nil {
+		return nil
+	}
+
+	if l != nil {
+		l.wait()
+		if l.err != nil {
+			return l.err
+		}
+		d = l.db
+		d.SetPendingLimit(conn.pendingLimit, nil)
+	} else {
+		var err error
+		d, err = conn.load()
+		if err != nil {
+			return err
+		}
+	}
+	connectionsMu.Lock()
+	conn.db = d
+	conn.loading = nil
+	connectionsMu.Unlock()
+	return nil
+}
+
+// load loads the connection's database and applies the connection's settings to it.
+func (conn *connection) load() (*db.DB, error) {
+	d, err := loadDB(conn.dir)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	conn.mu.Lock()
+	d.SetAudit(conn.audit)
+	d.SetWireLogSize(conn.wireLog)
+	d.SetPendingLimit(conn.pendingLimit, nil)
+	d.SetHotPrefixes(conn.hotPrefixes)
+	conn.mu.Unlock()
+	if conn.bandwidth != nil {
+		d.SetBandwidth(conn.bandwidth)
 	}
-	db, err := db.Load(sp)
+	if conn.events != nil {
+		d.SetEventLog(conn.events)
+	}
+	return d, nil
+}
+
+func loadDB(dir string) (*db.DB, error) {
+	sp, err := spec.ForDatabase(dir)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return db.Load(sp)
+}
 
-	connections[dbName] = &connection{db: db, dir: p}
-	return nil
+// seed installs the snapshot at req.SeedURL, if any, in d. Failures are logged rather than
+// returned, since the first pull fetches the same data from the diff-server.
+// seed installs the snapshot at req.SeedURL into d, the database named dbName, unless the
+// power state or network policy would defer a sync. It gives up after seedTimeout.
+func seed(d *db.DB, dbName string, req OpenRequest) {
+	if req.SeedURL == "" {
+		return
+	}
+	if !allowSpeculativeSync() || !allowSync(d, dbName, "open", req.SeedURL) {
+		log.Printf("Not seeding from %s: sync deferred", req.SeedURL)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), seedTimeout)
+	defer cancel()
+	if _, err := d.Seed(ctx, req.SeedURL); err != nil {
+		log.Printf("Could not seed from %s: %s", req.SeedURL, err)
+	}
+}
+
+// unload releases the connection's database, if loaded.
+func (conn *connection) unload() error {
+	if conn.db == nil {
+		return nil
+	}
+	// Bandwidth accounting is kept so that quotas still apply once the database is reloaded.
+	conn.bandwidth = conn.db.Bandwidth()
+	// Likewise events are kept so that pollEvents sequence numbers carry on.
+	conn.events = conn.db.EventLog()
+	conn.recordHead(conn.db.Hash())
+	err := conn.db.Close()
+	conn.db = nil
+	return err
 }
 
-// Close releases the resources held by the specified open database.
+// evictIdle unloads databases that are not in use and have not been used within
+// idleTimeout. The caller must hold connectionsMu.
+func evictIdle(now gtime.Time) {
+	if idleTimeout <= 0 {
+		return
+	}
+	for name, conn := range connections {
+		if conn.db == nil || atomic.LoadInt32(&conn.active) != 0 || now.Sub(conn.lastUsed) < idleTimeout {
+			continue
+		}
+		log.Printf("Unloading idle database '%s'", name)
+		if err := conn.unload(); err != nil {
+			log.Printf("Could not close idle database '%s': %s", name, err)
+		}
+	}
+}
+
+// Close releases the resources held by the specified open database, once the rpcs in flight
+// on it have finished.
 func close(dbName string) error {
 	if dbName == "" {
 		return errors.New("dbName must be non-empty")
 	}
+	connectionsMu.Lock()
 	conn := connections[dbName]
+	delete(connections, dbName)
+	connectionsMu.Unlock()
 	if conn == nil {
 		return nil
 	}
-	delete(connections, dbName)
-	return nil
+	conn.inFlight.Wait()
+	if conn.loading != nil && conn.ensureLoaded() != nil {
+		// The database failed to load, so there is nothing to release.
+		return nil
+	}
+	return conn.unload()
 }
 
 // Drop closes and deletes the specified local database. Remote replicas in the group are not affected.
@@ -221,7 +633,9 @@ func drop(dbName string) error {
 		return errors.New("dbName must be non-empty")
 	}
 
+	connectionsMu.Lock()
 	conn := connections[dbName]
+	connectionsMu.Unlock()
 	p := dbPath(repDir, dbName)
 	if conn != nil {
 		if conn.dir != p {
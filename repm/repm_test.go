@@ -1,18 +1,27 @@
 package repm
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"testing"
+	gtime "time"
 
 	"github.com/stretchr/testify/assert"
 
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
 	"roci.dev/diff-server/util/time"
 	"roci.dev/diff-server/util/version"
+
+	"roci.dev/replicache-client/db"
 )
 
 func mm(assert *assert.Assertions, in interface{}) []byte {
@@ -83,6 +92,47 @@ func TestBasic(t *testing.T) {
 	assert.Equal(version.Version(), string(resp))
 }
 
+func TestIdleEviction(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+	SetIdleTimeout(1)
+
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+	_, err = Dispatch("db1", "put", []byte(`{"id": "foo", "value": "bar", "idempotencyKey": "k1"}`))
+	assert.NoError(err)
+	_, err = Dispatch("db2", "open", nil)
+	assert.NoError(err)
+
+	gtime.Sleep(10 * gtime.Millisecond)
+	_, err = Dispatch("db2", "getRoot", []byte(`{}`))
+	assert.NoError(err)
+	assert.Nil(connections["db1"].db)
+	assert.NotNil(connections["db2"].db)
+
+	// db1 is transparently reloaded on next use.
+	resp, err := Dispatch("db1", "get", []byte(`{"id": "foo"}`))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":"bar"}`, string(resp))
+	assert.NotNil(connections["db1"].db)
+
+	// Its idempotency keys are remembered, so a retry doesn't commit again.
+	_, err = Dispatch("db1", "put", []byte(`{"id": "foo", "value": "bar", "idempotencyKey": "k1"}`))
+	assert.NoError(err)
+
+	// So are its events.
+	resp, err = Dispatch("db1", "pollEvents", []byte(`{}`))
+	assert.NoError(err)
+	var events PollEventsResponse
+	assert.NoError(json.Unmarshal(resp, &events))
+	assert.Equal(1, len(events.Events))
+	assert.Equal(db.EventCommit, events.Events[0].Type)
+	assert.False(events.Dropped)
+}
+
 func TestList(t *testing.T) {
 	defer deinit()
 	assert := assert.New(t)
@@ -102,70 +152,313 @@ func TestList(t *testing.T) {
 	assert.NoError(err)
 
 	Init(dir, "", nil)
-	rb, err = Dispatch("", "list", nil)
-	assert.NoError(err)
-	assert.Equal(`{"databases":[]}`, string(rb))
+	assert.Equal([]string{}, listNames(assert))
 
 	rb, err = Dispatch("db1", "open", nil)
 	assert.Nil(rb)
 	assert.NoError(err)
 
-	rb, err = Dispatch("", "list", nil)
-	assert.NoError(err)
-	assert.Equal(`{"databases":[{"name":"db1"}]}`, string(rb))
+	assert.Equal([]string{"db1"}, listNames(assert))
 
 	rb, err = Dispatch("db1", "open", nil)
 	assert.Nil(rb)
 	assert.NoError(err)
 
-	rb, err = Dispatch("", "list", nil)
-	assert.NoError(err)
-	assert.Equal(`{"databases":[{"name":"db1"}]}`, string(rb))
+	assert.Equal([]string{"db1"}, listNames(assert))
 
 	rb, err = Dispatch("db2", "open", nil)
 	assert.Nil(rb)
 	assert.NoError(err)
 
-	rb, err = Dispatch("", "list", nil)
-	assert.NoError(err)
-	assert.Equal(`{"databases":[{"name":"db1"},{"name":"db2"}]}`, string(rb))
+	assert.Equal([]string{"db1", "db2"}, listNames(assert))
 
 	rb, err = Dispatch("db1", "drop", nil)
 	assert.Nil(rb)
 	assert.NoError(err)
 
-	rb, err = Dispatch("", "list", nil)
-	assert.NoError(err)
-	assert.Equal(`{"databases":[{"name":"db2"}]}`, string(rb))
+	assert.Equal([]string{"db2"}, listNames(assert))
 
 	rb, err = Dispatch("db2", "drop", nil)
 	assert.Nil(rb)
 	assert.NoError(err)
 
-	rb, err = Dispatch("", "list", nil)
-	assert.NoError(err)
-	assert.Equal(`{"databases":[]}`, string(rb))
+	assert.Equal([]string{}, listNames(assert))
 
 	err = ioutil.WriteFile(path.Join(dir, "file.txt"), []byte("foo"), 0644)
 	assert.NoError(err)
 
-	rb, err = Dispatch("", "list", nil)
-	assert.NoError(err)
-	assert.Equal(`{"databases":[]}`, string(rb))
+	assert.Equal([]string{}, listNames(assert))
 
 	err = os.Mkdir(path.Join(dir, "-not-valid-base64"), 0755)
 	assert.NoError(err)
 
-	rb, err = Dispatch("", "list", nil)
-	assert.NoError(err)
-	assert.Equal(`{"databases":[]}`, string(rb))
+	assert.Equal([]string{}, listNames(assert))
 
 	rb, err = Dispatch("db1", "open", nil)
 	assert.Nil(rb)
 	assert.NoError(err)
 
 	// Should still return valid databases, skipping over other garbage directory entries.
-	rb, err = Dispatch("", "list", nil)
+	assert.Equal([]string{"db1"}, listNames(assert))
+}
+
+// listNames returns the names of the databases returned by list.
+func listNames(assert *assert.Assertions) []string {
+	rb, err := Dispatch("", "list", nil)
+	assert.NoError(err)
+	var res ListResponse
+	assert.NoError(json.Unmarshal(rb, &res))
+	names := []string{}
+	for _, d := range res.Databases {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+func TestListMetadata(t *testing.T) {
+	defer deinit()
+	defer time.SetFake()()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	list := func() DatabaseInfo {
+		rb, err := Dispatch("", "list", nil)
+		assert.NoError(err)
+		var res ListResponse
+		assert.NoError(json.Unmarshal(rb, &res))
+		assert.Equal(1, len(res.Databases))
+		return res.Databases[0]
+	}
+
+	_, err = Dispatch("db1", "open", mm(assert, OpenRequest{SchemaVersion: 2}))
+	assert.NoError(err)
+	_, err = Dispatch("db1", "put", []byte(`{"id": "foo", "value": "bar"}`))
+	assert.NoError(err)
+	head := connections["db1"].db.Hash().String()
+
+	// Open databases report their current head.
+	di := list()
+	assert.Equal("db1", di.Name)
+	assert.Equal(head, di.Head)
+	assert.True(di.SizeBytes > 0)
+	assert.True(time.Now().Equal(*di.LastOpened))
+	assert.Equal(2, di.SchemaVersion)
+	assert.Equal(pullProtocolVersion, di.ProtocolVersion)
+
+	// Closed databases report their head as of when they were closed.
+	_, err = Dispatch("db1", "close", nil)
+	assert.NoError(err)
+	di = list()
+	assert.Equal(head, di.Head)
+	assert.Equal(2, di.SchemaVersion)
+
+	// Databases last opened by older builds have no metadata.
+	assert.NoError(os.Remove(path.Join(dbPath(dir, "db1"), dbInfoFile)))
+	di = list()
+	assert.Equal("db1", di.Name)
+	assert.Equal("", di.Head)
+	assert.Nil(di.LastOpened)
+	assert.Equal(0, di.SchemaVersion)
+}
+
+func TestAccounts(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	listFor := func(account string) []string {
+		rb, err := Dispatch("", "listForAccount", mm(assert, ListForAccountRequest{Account: account}))
+		assert.NoError(err)
+		var res ListResponse
+		assert.NoError(json.Unmarshal(rb, &res))
+		names := []string{}
+		for _, d := range res.Databases {
+			names = append(names, d.Name)
+		}
+		return names
+	}
+
+	for _, o := range []struct {
+		name    string
+		account string
+	}{{"a1", "alice"}, {"a2", "alice"}, {"b1", "bob"}, {"none", ""}} {
+		_, err = Dispatch(o.name, "open", mm(assert, OpenRequest{Account: o.account}))
+		assert.NoError(err)
+	}
+	assert.Equal([]string{"a1", "a2"}, listFor("alice"))
+	assert.Equal([]string{"b1"}, listFor("bob"))
+	assert.Equal([]string{}, listFor("carol"))
+	_, err = Dispatch("", "listForAccount", mm(assert, ListForAccountRequest{}))
+	assert.EqualError(err, "account must be non-empty")
+
+	// Databases keep their account when reopened, and can't be opened by another account.
+	_, err = Dispatch("b1", "close", nil)
+	assert.NoError(err)
+	_, err = Dispatch("b1", "open", mm(assert, OpenRequest{Account: "alice"}))
+	assert.EqualError(err, "Database 'b1' belongs to a different account")
+	_, err = Dispatch("b1", "open", nil)
+	assert.NoError(err)
+	assert.Equal([]string{"b1"}, listFor("bob"))
+
+	_, err = Dispatch("", "dropAccount", mm(assert, DropAccountRequest{Account: "alice"}))
+	assert.NoError(err)
+	assert.Equal([]string{}, listFor("alice"))
+	assert.Equal([]string{"b1", "none"}, listNames(assert))
+	assert.Nil(connections["a1"])
+}
+
+func TestSeedURL(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	tmp, _ := db.LoadTempDB(assert)
+	data := kv.NewMapForTest(tmp.Noms(), "foo", `"bar"`)
+	patch, err := db.SnapshotPatch(data.NomsMap())
+	assert.NoError(err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/snapshot.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(mm(assert, servetypes.PullResponse{Patch: patch, StateID: "s1", Checksum: data.Checksum()}))
+	}))
+	defer server.Close()
+
+	get := func(dbName string) string {
+		rb, err := Dispatch(dbName, "get", mm(assert, GetRequest{ID: "foo"}))
+		assert.NoError(err)
+		return string(rb)
+	}
+
+	_, err = Dispatch("db1", "open", mm(assert, OpenRequest{SeedURL: server.URL + "/snapshot.json"}))
+	assert.NoError(err)
+	assert.Equal(`{"has":true,"value":"bar"}`, get("db1"))
+
+	// Failing to seed doesn't fail the open.
+	_, err = Dispatch("db2", "open", mm(assert, OpenRequest{SeedURL: server.URL + "/missing.json"}))
+	assert.NoError(err)
+	assert.Equal(`{"has":false}`, get("db2"))
+
+	// Seeding is deferred like other syncs.
+	SetNetworkPolicy(&fakeNetworkPolicy{allow: false})
+	_, err = Dispatch("db3", "open", mm(assert, OpenRequest{SeedURL: server.URL + "/snapshot.json"}))
+	assert.NoError(err)
+	assert.Equal(`{"has":false}`, get("db3"))
+	SetNetworkPolicy(nil)
+	_, err = Dispatch("", "setPowerState", mm(assert, SetPowerStateRequest{State: PowerStateLow}))
+	assert.NoError(err)
+	_, err = Dispatch("db4", "open", mm(assert, OpenRequest{SeedURL: server.URL + "/snapshot.json"}))
+	assert.NoError(err)
+	assert.Equal(`{"has":false}`, get("db4"))
+	_, err = Dispatch("", "setPowerState", mm(assert, SetPowerStateRequest{State: PowerStateNormal}))
+	assert.NoError(err)
+
+	// A server that doesn't answer doesn't hold up the open for long.
+	seedTimeout = 10 * gtime.Millisecond
+	var stall sync.WaitGroup
+	stall.Add(1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stall.Wait()
+	}))
+	defer slow.Close()
+	defer stall.Done()
+	_, err = Dispatch("db5", "open", mm(assert, OpenRequest{SeedURL: slow.URL}))
+	assert.NoError(err)
+	assert.Equal(`{"has":false}`, get("db5"))
+}
+
+func TestPendingLimit(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	_, err = Dispatch("db1", "open", mm(assert, OpenRequest{PendingLimit: 1}))
+	assert.NoError(err)
+	for _, id := range []string{"a", "b"} {
+		_, err = Dispatch("db1", "put", mm(assert, PutRequest{ID: id, Value: []byte(`"x"`)}))
+		assert.NoError(err)
+	}
+	resp, err := Dispatch("db1", "syncInfo", mm(assert, SyncInfoRequest{}))
+	assert.NoError(err)
+	var si SyncInfoResponse
+	assert.NoError(json.Unmarshal(resp, &si))
+	assert.Equal(2, si.PendingMutations)
+	assert.Equal(1, si.PendingLimit)
+}
+
+func TestDoctor(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	_, err = Dispatch("db1", "doctor", nil)
+	assert.EqualError(err, "not found: no such database: db1")
+
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+	_, err = Dispatch("db1", "doctor", nil)
+	assert.EqualError(err, "Database 'db1' is open - must close it first")
+
+	_, err = Dispatch("db1", "close", nil)
+	assert.NoError(err)
+	for _, req := range []DoctorRequest{{}, {Repair: true}} {
+		rb, err := Dispatch("db1", "doctor", mm(assert, req))
+		assert.NoError(err)
+		var res DoctorResponse
+		assert.NoError(json.Unmarshal(rb, &res))
+		assert.Equal([]db.Problem{}, res.Problems)
+		assert.Equal(req.Repair, res.Repaired)
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+	_, err = Dispatch("db1", "put", []byte(`{"id": "foo", "value": "bar"}`))
+	assert.NoError(err)
+
+	for _, p := range []string{path.Join(dir, "dump.zip"), "../dump.zip", "..", `a\b.zip`} {
+		_, err = Dispatch("db1", "debugDump", mm(assert, DebugDumpRequest{ZipPath: p}))
+		assert.EqualError(err, "InvalidArgument: invalid argument: zipPath must be a file name, not a path: "+p)
+	}
+
+	buf, err := Dispatch("db1", "debugDump", mm(assert, DebugDumpRequest{ZipPath: "dump.zip"}))
+	assert.NoError(err)
+	var res DebugDumpResponse
+	assert.NoError(json.Unmarshal(buf, &res))
+	zipPath := path.Join(string(connections["db1"].scratch), "dump.zip")
+	assert.Equal(zipPath, res.ZipPath)
+	assert.Equal(version.Version(), res.Version)
+	assert.Equal(".putValue", res.DB.Head.Name)
+	assert.Equal(1, len(res.DB.Pending))
+	assert.Equal(int64(defaultScratchLimit), res.Config.ScratchLimit)
+	assert.NotEmpty(res.Logs)
+
+	zr, err := zip.OpenReader(zipPath)
+	assert.NoError(err)
+	defer zr.Close()
+	assert.Equal(1, len(zr.File))
+	assert.Equal("debugdump.json", zr.File[0].Name)
+	r, err := zr.File[0].Open()
+	assert.NoError(err)
+	zipped, err := ioutil.ReadAll(r)
 	assert.NoError(err)
-	assert.Equal(`{"databases":[{"name":"db1"}]}`, string(rb))
+	assert.Equal(buf, zipped)
 }
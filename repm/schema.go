@@ -0,0 +1,31 @@
+package repm
+
+import (
+	"fmt"
+)
+
+const (
+	// SchemaVersion is the version of the Dispatch request and response schema implemented
+	// by this build. It must be incremented whenever a change to a request or response type
+	// would break existing SDKs, and the previous version must continue to be accepted by
+	// translating to and from it.
+	//
+	// Version 2: scan responds with ScanResponse rather than an array of items, and bounds
+	// the size of its response.
+	SchemaVersion = 2
+	// minSchemaVersion is the oldest schema version still supported.
+	minSchemaVersion = 1
+)
+
+// checkSchemaVersion returns an error if SDKs speaking schema version v are not supported.
+// Zero is treated as the oldest version, since it is what SDKs that predate schema
+// versioning send.
+func checkSchemaVersion(v int) (int, error) {
+	if v == 0 {
+		v = minSchemaVersion
+	}
+	if v < minSchemaVersion || v > SchemaVersion {
+		return 0, fmt.Errorf("Unsupported schemaVersion %d - must be between %d and %d", v, minSchemaVersion, SchemaVersion)
+	}
+	return v, nil
+}
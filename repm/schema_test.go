@@ -0,0 +1,75 @@
+package repm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/util/time"
+)
+
+// TestSchemaCompatibility replays the exchanges recorded for each supported schema version
+// in testdata/schema. A failure means that a change would break SDKs speaking that version.
+func TestSchemaCompatibility(t *testing.T) {
+	assert := assert.New(t)
+	files, err := filepath.Glob("testdata/schema/v*.json")
+	assert.NoError(err)
+	assert.Equal(SchemaVersion-minSchemaVersion+1, len(files))
+
+	for _, f := range files {
+		var v int
+		_, err := fmt.Sscanf(filepath.Base(f), "v%d.json", &v)
+		assert.NoError(err, f)
+		buf, err := ioutil.ReadFile(f)
+		assert.NoError(err, f)
+		var exchanges []struct {
+			RPC      string          `json:"rpc"`
+			Request  json.RawMessage `json:"request"`
+			Response json.RawMessage `json:"response"`
+			Error    string          `json:"error"`
+		}
+		assert.NoError(json.Unmarshal(buf, &exchanges), f)
+
+		func() {
+			defer deinit()
+			defer time.SetFake()()
+			dir, err := ioutil.TempDir("", "")
+			assert.NoError(err)
+			Init(dir, "", nil)
+			_, err = Dispatch("db1", "open", mm(assert, OpenRequest{SchemaVersion: v}))
+			assert.NoError(err, f)
+
+			for i, ex := range exchanges {
+				label := fmt.Sprintf("%s exchange %d: %s %s", f, i, ex.RPC, ex.Request)
+				res, err := Dispatch("db1", ex.RPC, ex.Request)
+				if ex.Error != "" {
+					assert.EqualError(err, ex.Error, label)
+					continue
+				}
+				assert.NoError(err, label)
+				var expected bytes.Buffer
+				assert.NoError(json.Compact(&expected, ex.Response), label)
+				assert.Equal(expected.String(), string(res), label)
+			}
+		}()
+	}
+}
+
+func TestSchemaVersionCheck(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	_, err = Dispatch("db1", "open", mm(assert, OpenRequest{SchemaVersion: SchemaVersion + 1}))
+	assert.EqualError(err, fmt.Sprintf("Unsupported schemaVersion %d - must be between %d and %d", SchemaVersion+1, minSchemaVersion, SchemaVersion))
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+	assert.Equal(minSchemaVersion, connections["db1"].schemaVersion)
+}
@@ -0,0 +1,65 @@
+package repm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const defaultScratchLimit = 64 << 20
+
+var scratchLimit int64 = defaultScratchLimit
+
+// SetScratchLimit sets the maximum number of bytes of temporary files each open database
+// may keep in its scratch space. Zero or less restores the default of 64MB.
+func SetScratchLimit(n int64) {
+	if n <= 0 {
+		n = defaultScratchLimit
+	}
+	scratchLimit = n
+}
+
+// scratch is a per-database directory for temporary files. It is cleared whenever the
+// database is opened so that files left behind by a crash don't accumulate.
+type scratch string
+
+func scratchPath(dbDir string) scratch {
+	return scratch(path.Join(dbDir, ".scratch"))
+}
+
+// reset removes all files from the scratch directory, creating it if necessary.
+func (s scratch) reset() error {
+	err := os.RemoveAll(string(s))
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(string(s), 0700)
+}
+
+// create creates a new file in the scratch directory, as ioutil.TempFile does. It fails if
+// the existing files already use up the scratch limit. Callers must remove the file when
+// they are done with it.
+func (s scratch) create(pattern string) (*os.File, error) {
+	used, err := s.usage()
+	if err != nil {
+		return nil, err
+	}
+	if used >= scratchLimit {
+		return nil, fmt.Errorf("Scratch space is full: %d of %d bytes used", used, scratchLimit)
+	}
+	return ioutil.TempFile(string(s), pattern)
+}
+
+// usage returns the total size of the files in the scratch directory.
+func (s scratch) usage() (int64, error) {
+	entries, err := ioutil.ReadDir(string(s))
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, e := range entries {
+		n += e.Size()
+	}
+	return n, nil
+}
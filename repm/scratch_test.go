@@ -0,0 +1,43 @@
+package repm
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScratch(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	// Files left over from a previous run are removed on open.
+	s := scratchPath(dbPath(dir, "db1"))
+	assert.NoError(os.MkdirAll(string(s), 0700))
+	assert.NoError(ioutil.WriteFile(path.Join(string(s), "leftover"), []byte("x"), 0600))
+	_, err = Dispatch("db1", "open", nil)
+	assert.NoError(err)
+	assert.Equal(s, connections["db1"].scratch)
+	n, err := s.usage()
+	assert.NoError(err)
+	assert.Equal(int64(0), n)
+
+	SetScratchLimit(4)
+	f, err := s.create("test")
+	assert.NoError(err)
+	_, err = f.Write([]byte("abcd"))
+	assert.NoError(err)
+	assert.NoError(f.Close())
+	_, err = s.create("test")
+	assert.EqualError(err, "Scratch space is full: 4 of 4 bytes used")
+
+	assert.NoError(os.Remove(f.Name()))
+	f, err = s.create("test")
+	assert.NoError(err)
+	assert.NoError(f.Close())
+}
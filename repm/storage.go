@@ -0,0 +1,31 @@
+package repm
+
+import (
+	"fmt"
+)
+
+// fileProtectionClasses maps the fileProtection values accepted by open to the iOS
+// NSFileProtectionType they select.
+var fileProtectionClasses = map[string]string{
+	"complete":                             "NSFileProtectionComplete",
+	"completeUnlessOpen":                   "NSFileProtectionCompleteUnlessOpen",
+	"completeUntilFirstUserAuthentication": "NSFileProtectionCompleteUntilFirstUserAuthentication",
+	"none":                                 "NSFileProtectionNone",
+}
+
+// applyStorageOptions sets the platform storage attributes requested by opts on the database
+// directory dir.
+func applyStorageOptions(dir string, opts OpenRequest) error {
+	if opts.FileProtection == "" && !opts.ExcludeFromBackup {
+		return nil
+	}
+	class := ""
+	if opts.FileProtection != "" {
+		var ok bool
+		class, ok = fileProtectionClasses[opts.FileProtection]
+		if !ok {
+			return fmt.Errorf("Invalid fileProtection: '%s'", opts.FileProtection)
+		}
+	}
+	return setStorageAttributes(dir, class, opts.ExcludeFromBackup)
+}
@@ -0,0 +1,70 @@
+//go:build ios
+// +build ios
+
+package repm
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework Foundation
+#import <Foundation/Foundation.h>
+#include <stdlib.h>
+#include <string.h>
+
+// setStorageAttributes returns NULL on success, otherwise a description of the error that
+// the caller must free.
+static char* setStorageAttributes(const char* dir, const char* protectionClass, int excludeFromBackup) {
+	@autoreleasepool {
+		NSString* path = [NSString stringWithUTF8String:dir];
+		NSFileManager* fm = [NSFileManager defaultManager];
+		NSError* err = nil;
+		if (protectionClass != NULL) {
+			// The class of a directory only applies to files created in it afterward, so
+			// existing files are updated too.
+			NSDictionary* attrs = @{NSFileProtectionKey: [NSString stringWithUTF8String:protectionClass]};
+			if (![fm setAttributes:attrs ofItemAtPath:path error:&err]) {
+				return strdup(err.localizedDescription.UTF8String);
+			}
+			for (NSString* sub in [fm enumeratorAtPath:path]) {
+				if (![fm setAttributes:attrs ofItemAtPath:[path stringByAppendingPathComponent:sub] error:&err]) {
+					return strdup(err.localizedDescription.UTF8String);
+				}
+			}
+		}
+		if (excludeFromBackup) {
+			NSURL* url = [NSURL fileURLWithPath:path isDirectory:YES];
+			if (![url setResourceValue:@YES forKey:NSURLIsExcludedFromBackupKey error:&err]) {
+				return strdup(err.localizedDescription.UTF8String);
+			}
+		}
+		return NULL;
+	}
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+const iosBuild = true
+
+func setStorageAttributes(dir, protectionClass string, excludeFromBackup bool) error {
+	cdir := C.CString(dir)
+	defer C.free(unsafe.Pointer(cdir))
+	var cclass *C.char
+	if protectionClass != "" {
+		cclass = C.CString(protectionClass)
+		defer C.free(unsafe.Pointer(cclass))
+	}
+	var exclude C.int
+	if excludeFromBackup {
+		exclude = 1
+	}
+	cerr := C.setStorageAttributes(cdir, cclass, exclude)
+	if cerr != nil {
+		defer C.free(unsafe.Pointer(cerr))
+		return fmt.Errorf("Could not set storage attributes on '%s': %s", dir, C.GoString(cerr))
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !ios
+// +build !ios
+
+package repm
+
+import (
+	"errors"
+)
+
+const iosBuild = false
+
+func setStorageAttributes(dir, protectionClass string, excludeFromBackup bool) error {
+	if protectionClass != "" {
+		return errors.New("fileProtection is only supported on iOS")
+	}
+	// Android has no per-file backup attribute. Files under Context.getNoBackupFilesDir() are
+	// never backed up, so hosts should place the storage directory there instead.
+	return errors.New("excludeFromBackup is only supported on iOS - on Android, use a storage directory under Context.getNoBackupFilesDir()")
+}
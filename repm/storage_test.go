@@ -0,0 +1,33 @@
+//go:build !ios
+// +build !ios
+
+package repm
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenStorageOptions(t *testing.T) {
+	defer deinit()
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	Init(dir, "", nil)
+
+	_, err = Dispatch("db1", "open", []byte(`{"fileProtection": "bogus"}`))
+	assert.EqualError(err, "Invalid fileProtection: 'bogus'")
+	_, err = Dispatch("db1", "open", []byte(`{"fileProtection": "complete"}`))
+	assert.EqualError(err, "fileProtection is only supported on iOS")
+	_, err = Dispatch("db1", "open", []byte(`{"excludeFromBackup": true}`))
+	assert.Error(err)
+
+	// Failing to apply the options leaves the database closed.
+	_, err = Dispatch("db1", "getRoot", []byte(`{}`))
+	assert.EqualError(err, "specified database is not open")
+
+	_, err = Dispatch("db1", "open", []byte(`{}`))
+	assert.NoError(err)
+}
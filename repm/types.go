@@ -5,19 +5,78 @@ package repm
 
 import (
 	"encoding/json"
+	"time"
 
 	"roci.dev/replicache-client/db"
 
+	servetypes "roci.dev/diff-server/serve/types"
 	jsnoms "roci.dev/diff-server/util/noms/json"
 )
 
+// OpenRequest is the optional request of the open RPC. The storage options are applied to the
+// database directory when the database is opened, and are only supported on iOS.
+type OpenRequest struct {
+	// SchemaVersion is the version of the Dispatch schema the caller speaks. See
+	// SchemaVersion.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	// FileProtection is the iOS data protection class of the database files. It is one of
+	// "complete", "completeUnlessOpen", "completeUntilFirstUserAuthentication", or "none".
+	// If empty the files are left with the app's default class.
+	FileProtection string `json:"fileProtection,omitempty"`
+	// ExcludeFromBackup excludes the database from device backups.
+	ExcludeFromBackup bool `json:"excludeFromBackup,omitempty"`
+	// Account groups the database with others belonging to the same user or account, so that
+	// they can be listed with listForAccount and removed together with dropAccount. A database
+	// keeps the account it was first opened with, and cannot be opened with a different one.
+	Account string `json:"account,omitempty"`
+	// SeedURL is the URL of a snapshot, such as one published by package publish, to install
+	// as the synced state if the database is empty, so that large datasets don't have to be
+	// pulled from the diff-server on first run. Open doesn't fail if seeding does. Seeding is
+	// skipped if the power state or network policy would defer a sync, and gives up after a minute.
+	SeedURL string `json:"seedURL,omitempty"`
+	// PendingLimit is the number of pending mutations past which each mutation logs a warning,
+	// since every pending mutation is replayed by each pull. Zero means no limit. The limit is
+	// reported by getRoot's syncInfo.
+	PendingLimit int `json:"pendingLimit,omitempty"`
+}
+
+// KeyPart is one component of a key to encode. Exactly one field must be set.
+type KeyPart struct {
+	String *string    `json:"string,omitempty"`
+	Int    *int64     `json:"int,omitempty"`
+	Number *float64   `json:"number,omitempty"`
+	Time   *time.Time `json:"time,omitempty"`
+}
+
+type EncodeKeyRequest struct {
+	Parts []KeyPart `json:"parts"`
+}
+
+type EncodeKeyResponse struct {
+	Key string `json:"key"`
+}
+
 type GetRootRequest struct {
+	IncludeSyncInfo bool `json:"includeSyncInfo,omitempty"`
+	// WaitForChangeFrom, if set, causes getRoot to block until the root differs from it or
+	// TimeoutMs elapses, whichever is first. This lets hosts that can't receive callbacks
+	// detect changes, e.g. made by a pull or by writes dispatched concurrently, without
+	// polling.
+	WaitForChangeFrom string `json:"waitForChangeFrom,omitempty"`
+	// TimeoutMs bounds how long getRoot waits for a change. Zero waits indefinitely.
+	TimeoutMs int `json:"timeoutMs,omitempty"`
 }
 
 type GetRootResponse struct {
-	Root jsnoms.Hash `json:"root"`
+	Root     jsnoms.Hash  `json:"root"`
+	SyncInfo *db.SyncInfo `json:"syncInfo,omitempty"`
+}
+
+type SyncInfoRequest struct {
 }
 
+type SyncInfoResponse db.SyncInfo
+
 type HasRequest struct {
 	ID string `json:"id"`
 }
@@ -35,21 +94,73 @@ type GetResponse struct {
 	Value json.RawMessage `json:"value,omitempty"`
 }
 
-type ScanRequest db.ScanOptions
+type GetMetaRequest struct {
+	ID string `json:"id"`
+}
+
+type GetMetaResponse struct {
+	Has  bool        `json:"has"`
+	Meta *db.KeyMeta `json:"meta,omitempty"`
+}
 
-type ScanItem struct {
-	ID    string       `json:"id"`
-	Value jsnoms.Value `json:"value"`
+type ScanRequest struct {
+	db.ScanOptions
+	// MaxBytes bounds the size of the response, which keeps large values from exhausting
+	// memory on the far side of the Gomobile bridge. Once it would be exceeded no more items
+	// are returned, even if Limit has not been reached. At least one item is always returned.
+	// Zero means defaultScanMaxBytes. It is only supported from schema version 2.
+	MaxBytes int `json:"maxBytes,omitempty"`
 }
 
+// ScanResponse is the response to scan from schema version 2. Earlier versions respond with
+// just the array of items.
 type ScanResponse struct {
-	Values []ScanItem `json:"values"`
-	Done   bool       `json:"done"`
+	Values []db.ScanItem `json:"values"`
+	// Done is true if there are no more matching items.
+	Done bool `json:"done"`
+	// Next is set if Done is false. Repeating the request with Next as start continues the
+	// scan.
+	Next *db.ScanBound `json:"next,omitempty"`
+}
+
+type ScanDeletedRequest db.ScanDeletedOptions
+
+type AggregateRequest db.AggregateOptions
+
+type AggregateResponse db.AggregateResult
+
+type CollectionScanRequest struct {
+	Collection string `json:"collection"`
+	db.ScanOptions
+}
+
+type CollectionCountRequest struct {
+	Collection string `json:"collection"`
+}
+
+type CollectionCountResponse struct {
+	Count uint64 `json:"count"`
+}
+
+type CollectionClearRequest struct {
+	Collection     string `json:"collection"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Tags is optional JSON metadata recorded with the commit. See db.WithTags.
+	Tags json.RawMessage `json:"tags,omitempty"`
+}
+
+type CollectionClearResponse struct {
+	// Count is the number of members removed.
+	Count uint64      `json:"count"`
+	Root  jsnoms.Hash `json:"root"`
 }
 
 type PutRequest struct {
-	ID    string          `json:"id"`
-	Value json.RawMessage `json:"value"`
+	ID             string          `json:"id"`
+	Value          json.RawMessage `json:"value"`
+	IdempotencyKey string          `json:"idempotencyKey,omitempty"`
+	// Tags is optional JSON metadata recorded with the commit. See db.WithTags.
+	Tags json.RawMessage `json:"tags,omitempty"`
 }
 
 type PutResponse struct {
@@ -57,7 +168,10 @@ type PutResponse struct {
 }
 
 type DelRequest struct {
-	ID string `json:"id"`
+	ID             string `json:"id"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Tags is optional JSON metadata recorded with the commit. See db.WithTags.
+	Tags json.RawMessage `json:"tags,omitempty"`
 }
 
 type DelResponse struct {
@@ -65,9 +179,181 @@ type DelResponse struct {
 	Root jsnoms.Hash `json:"root"`
 }
 
+type ClearRequest struct {
+	// Prefix restricts clear to keys starting with it. If empty, all keys are removed.
+	Prefix         string `json:"prefix,omitempty"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Tags is optional JSON metadata recorded with the commit. See db.WithTags.
+	Tags json.RawMessage `json:"tags,omitempty"`
+}
+
+type ClearResponse struct {
+	// Count is the number of keys removed.
+	Count uint64      `json:"count"`
+	Root  jsnoms.Hash `json:"root"`
+}
+
+type CheckpointRequest struct {
+	Name           string `json:"name"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+type CheckpointResponse struct {
+	Root jsnoms.Hash `json:"root"`
+}
+
+type RestoreRequest struct {
+	Name           string `json:"name"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+type RestoreResponse struct {
+	Root jsnoms.Hash `json:"root"`
+}
+
+// FingerprintResponse is the response to fingerprint. Replicas with the same keys and values
+// have the same fingerprint, regardless of their history. See db.Fingerprint.
+type FingerprintResponse struct {
+	Fingerprint jsnoms.Hash `json:"fingerprint"`
+}
+
+// PreviewPatchRequest is a pull response, as returned by the diff-server. previewPatch returns
+// the changes its patch would make without committing them. See db.PreviewPatch.
+type PreviewPatchRequest servetypes.PullResponse
+
+type PreviewPatchResponse db.PatchPreview
+
+// SetConfigRequest sets a per-database configuration value, such as the remote URL or sync
+// interval. Configuration is persisted, is not synced, and is kept by reset. If Value is
+// omitted the key is removed.
+type SetConfigRequest struct {
+	Key            string          `json:"key"`
+	Value          json.RawMessage `json:"value,omitempty"`
+	IdempotencyKey string          `json:"idempotencyKey,omitempty"`
+}
+
+type SetConfigResponse struct {
+}
+
+type GetConfigRequest struct {
+	Key string `json:"key"`
+}
+
+type GetConfigResponse struct {
+	Has   bool            `json:"has"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type ResetRequest struct {
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+// ResetResponse is the response to reset, which clears all local data and history but keeps
+// the client ID. Unlike drop, the database remains open. See db.Reset.
+type ResetResponse struct {
+	Root jsnoms.Hash `json:"root"`
+}
+
+// SquashPendingRequest replaces the pending commits with a single commit. See
+// db.SquashPending.
+type SquashPendingRequest struct {
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+type SquashPendingResponse struct {
+	Root jsnoms.Hash `json:"root"`
+	// Squashed is the number of commits replaced, or zero if there were fewer than two.
+	Squashed int `json:"squashed"`
+}
+
+// SetAuditRequest configures the audit log. The settings apply for as long as the database
+// is open.
+type SetAuditRequest db.AuditOptions
+
+type SetAuditResponse struct {
+}
+
+type ExportAuditRequest db.ExportAuditOptions
+
+type SetWireLogSizeRequest struct {
+	// Size is the number of recent pulls to keep in the wire log. Zero disables it.
+	Size int `json:"size"`
+}
+
+type SetWireLogSizeResponse struct {
+}
+
+// SetHotPrefixesRequest sets the key prefixes the app most needs, e.g. those shown on the
+// current screen, so that cooperating servers send them first in pulls. See
+// db.SetHotPrefixes.
+type SetHotPrefixesRequest struct {
+	Prefixes []string `json:"prefixes"`
+}
+
+type SetHotPrefixesResponse struct {
+}
+
+// SetBandwidthQuotaRequest sets a soft quota on the bytes exchanged with remotes. Once it is
+// used up, pulls fail with a QuotaExceeded error until enough of the window has passed.
+type SetBandwidthQuotaRequest db.BandwidthQuota
+
+type SetBandwidthQuotaResponse struct {
+}
+
+type BandwidthStatsRequest struct {
+}
+
+type BandwidthStatsResponse struct {
+	// Remotes are the cumulative bytes exchanged with each remote since the database was opened.
+	Remotes []db.BandwidthStats `json:"remotes"`
+}
+
+// PollEventsRequest asks for the events recorded after SinceSeq, which is zero on the first
+// poll and the Seq of the last event received on later ones. Only recent events are kept.
+type PollEventsRequest struct {
+	SinceSeq uint64 `json:"sinceSeq"`
+}
+
+type PollEventsResponse struct {
+	Events []db.Event `json:"events"`
+	// Dropped is true if events after SinceSeq were discarded before they could be returned.
+	Dropped bool `json:"dropped,omitempty"`
+}
+
+type DebugDumpRequest struct {
+	// ZipPath, if set, is the name of a file in the database's scratch directory to also
+	// write the dump to as a zip archive, for attaching to bug reports. It must be a file
+	// name, not a path, and the file is removed the next time the database is opened.
+	ZipPath string `json:"zipPath,omitempty"`
+}
+
+// DebugDumpResponse contains information useful for diagnosing problems in the field.
+type DebugDumpResponse struct {
+	Version string       `json:"version"`
+	DB      db.DebugInfo `json:"db"`
+	Config  DebugConfig  `json:"config"`
+	// ScratchUsage is the number of bytes used by the database's scratch files.
+	ScratchUsage int64 `json:"scratchUsage"`
+	// Logs are the most recent lines logged by Replicache, oldest first.
+	Logs []string `json:"logs"`
+	// ZipPath is the path of the zip archive written for DebugDumpRequest.ZipPath, if any.
+	ZipPath string `json:"zipPath,omitempty"`
+}
+
+// DebugConfig is the repm configuration.
+type DebugConfig struct {
+	IdleTimeoutMs int64 `json:"idleTimeoutMs"`
+	ScratchLimit  int64 `json:"scratchLimit"`
+}
+
 type PullRequest struct {
+	// Remote is the diff-server to pull from. It may instead be a file remote such as
+	// "file:///path/to/shared/folder", to sync through a shared folder; see db.SyncFile.
 	Remote         jsnoms.Spec `json:"remote"`
 	ClientViewAuth string      `json:"clientViewAuth"`
+	// Speculative marks pulls the user didn't ask for, e.g. periodic or prefetching pulls,
+	// which are deferred while the battery is low. See setPowerState.
+	Speculative bool `json:"speculative,omitempty"`
 }
 
 type PullResponseError struct {
@@ -77,12 +363,43 @@ type PullResponseError struct {
 type PullResponse struct {
 	Error *PullResponseError `json:"error,omitempty"`
 	Root  jsnoms.Hash        `json:"root,omitempty"`
+	// Deferred is true if the pull was not attempted because the NetworkPolicy did not allow
+	// it, or because it was speculative and the battery is low. Sync loops should pause until
+	// the host's network or power conditions change.
+	Deferred bool `json:"deferred,omitempty"`
 }
 
 type PullProgressRequest struct {
 }
 
+// VerifyStateRequest asks Remote to confirm the checksum of the server state the local head is
+// based on, without pulling. See db.VerifyState.
+type VerifyStateRequest struct {
+	Remote         jsnoms.Spec `json:"remote"`
+	ClientViewAuth string      `json:"clientViewAuth"`
+}
+
+type VerifyStateResponse db.StateVerification
+
 type PullProgressResponse struct {
+	// Phase is one of "downloading", "applying", or "committing".
+	Phase         string `json:"phase"`
 	BytesReceived uint64 `json:"bytesReceived"`
 	BytesExpected uint64 `json:"bytesExpected"`
+	OpsApplied    uint64 `json:"opsApplied"`
+	OpsExpected   uint64 `json:"opsExpected"`
+	// HotPrefixes and HotPrefixesApplied report the progress of the prefixes set with
+	// setHotPrefixes. See db.PullProgress.
+	HotPrefixes        []db.PrefixProgress `json:"hotPrefixes,omitempty"`
+	HotPrefixesApplied bool                `json:"hotPrefixesApplied,omitempty"`
+	// StartedAt is when the pull started, and UpdatedAt when it last reported progress. They
+	// are omitted if no pull has started since the database was opened.
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// BytesPerSecond is the average download rate of the pull so far, and EtaMs the estimated
+	// time until the download completes. BytesPerSecond is omitted until bytes have been
+	// received, and EtaMs if it can't be estimated, e.g. because the server didn't send the
+	// response's length.
+	BytesPerSecond uint64 `json:"bytesPerSecond,omitempty"`
+	EtaMs          int64  `json:"etaMs,omitempty"`
 }
@@ -0,0 +1,188 @@
+// Package vectors generates golden protocol test vectors: canonical pull requests and
+// responses and Dispatch calls, with the checksums and hashes this implementation produces.
+// Implementations of the client in other languages and the diff server can validate their
+// interoperability against them.
+package vectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/attic-labs/noms/go/types"
+
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+	nomsjson "roci.dev/diff-server/util/noms/json"
+	"roci.dev/diff-server/util/time"
+	"roci.dev/replicache-client/clienttest"
+)
+
+// Vectors is a complete set of test vectors.
+type Vectors struct {
+	Checksums []ChecksumVector `json:"checksums"`
+	Pulls     []PullVector     `json:"pulls"`
+	// Dispatch are made in order against a new database, with the clock fixed at the
+	// diff server's fake time.
+	Dispatch []DispatchVector `json:"dispatch"`
+}
+
+// ChecksumVector is the checksum of a key/value map.
+type ChecksumVector struct {
+	Data     map[string]json.RawMessage `json:"data"`
+	Checksum string                     `json:"checksum"`
+}
+
+// PullVector is a pull by a client whose data is Data.
+type PullVector struct {
+	Name     string                     `json:"name"`
+	Data     map[string]json.RawMessage `json:"data"`
+	Request  servetypes.PullRequest     `json:"request"`
+	Response servetypes.PullResponse    `json:"response"`
+	// ExpectedData is Data after applying the response's patch. The response's checksum is
+	// its checksum.
+	ExpectedData map[string]json.RawMessage `json:"expectedData"`
+}
+
+// DispatchVector is a Dispatch call and its result.
+type DispatchVector struct {
+	RPC      string          `json:"rpc"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Generate generates the test vectors.
+func Generate() (Vectors, error) {
+	var v Vectors
+	var err error
+	noms := types.NewTestValueStore()
+
+	for _, data := range []map[string]json.RawMessage{
+		{},
+		{"foo": json.RawMessage(`"bar"`)},
+		{"foo": json.RawMessage(`"bar"`), "hot": json.RawMessage(`{"dog":true,"n":[1,2.5]}`)},
+		{"a/b": json.RawMessage(`""`), "é": json.RawMessage(`"é"`)},
+	} {
+		m, err := makeMap(noms, data)
+		if err != nil {
+			return v, err
+		}
+		v.Checksums = append(v.Checksums, ChecksumVector{Data: data, Checksum: m.Checksum()})
+	}
+
+	for _, p := range []struct {
+		name        string
+		data        map[string]json.RawMessage
+		baseStateID string
+		stateID     string
+		patch       string
+		lmid        uint64
+	}{
+		{"initial", map[string]json.RawMessage{}, "", "11111111111111111111111111111111",
+			`[{"op":"add","path":"/foo","value":"bar"},{"op":"add","path":"/hot","value":{"dog":true}}]`, 0},
+		{"incremental", map[string]json.RawMessage{"foo": json.RawMessage(`"bar"`), "hot": json.RawMessage(`{"dog":true}`)},
+			"11111111111111111111111111111111", "22222222222222222222222222222222",
+			`[{"op":"replace","path":"/foo","value":"baz"},{"op":"remove","path":"/hot"},{"op":"add","path":"/n","value":42}]`, 3},
+		{"reset", map[string]json.RawMessage{"foo": json.RawMessage(`"bar"`)},
+			"22222222222222222222222222222222", "33333333333333333333333333333333",
+			`[{"op":"remove","path":"/"},{"op":"add","path":"/list","value":[]}]`, 4},
+	} {
+		pv, err := makePullVector(noms, p.name, p.data, p.baseStateID, p.patch, p.lmid)
+		if err != nil {
+			return v, err
+		}
+		pv.Response.StateID = p.stateID
+		v.Pulls = append(v.Pulls, pv)
+	}
+
+	v.Dispatch, err = dispatchVectors()
+	return v, err
+}
+
+func makeMap(noms types.ValueReadWriter, data map[string]json.RawMessage) (kv.Map, error) {
+	ed := kv.NewMap(noms).Edit()
+	for k, raw := range data {
+		val, err := nomsjson.FromJSON(bytes.NewReader(raw), noms)
+		if err != nil {
+			return kv.Map{}, err
+		}
+		if err := ed.Set(types.String(k), val); err != nil {
+			return kv.Map{}, err
+		}
+	}
+	return ed.Build(), nil
+}
+
+func mapData(m kv.Map) (map[string]json.RawMessage, error) {
+	r := map[string]json.RawMessage{}
+	var err error
+	m.NomsMap().IterAll(func(k, v types.Value) {
+		var buf bytes.Buffer
+		if err == nil {
+			err = nomsjson.ToJSON(v, &buf)
+		}
+		r[string(k.(types.String))] = json.RawMessage(bytes.TrimSpace(buf.Bytes()))
+	})
+	return r, err
+}
+
+func makePullVector(noms types.ValueReadWriter, name string, data map[string]json.RawMessage, baseStateID, patch string, lmid uint64) (PullVector, error) {
+	pv := PullVector{Name: name, Data: data}
+	m, err := makeMap(noms, data)
+	if err != nil {
+		return pv, err
+	}
+	pv.Request = servetypes.PullRequest{
+		ClientViewAuth: "auth",
+		ClientID:       "client1",
+		BaseStateID:    baseStateID,
+		Checksum:       m.Checksum(),
+	}
+	if err := json.Unmarshal([]byte(patch), &pv.Response.Patch); err != nil {
+		return pv, fmt.Errorf("invalid patch for %s: %s", name, err)
+	}
+	patched, err := kv.ApplyPatch(noms, m, pv.Response.Patch)
+	if err != nil {
+		return pv, err
+	}
+	pv.Response.LastMutationID = lmid
+	pv.Response.Checksum = patched.Checksum()
+	pv.ExpectedData, err = mapData(patched)
+	return pv, err
+}
+
+func dispatchVectors() ([]DispatchVector, error) {
+	defer time.SetFake()()
+	c, err := clienttest.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	r := []DispatchVector{}
+	for _, call := range []struct {
+		rpc, req string
+	}{
+		{"getRoot", `{}`},
+		{"put", `{"id":"foo","value":"bar"}`},
+		{"get", `{"id":"foo"}`},
+		{"has", `{"id":"hot"}`},
+		{"put", `{"id":"hot","value":{"dog":true}}`},
+		{"scan", `{"prefix":"","limit":10}`},
+		{"getRoot", `{}`},
+		{"del", `{"id":"foo"}`},
+		{"getRoot", `{}`},
+		{"restore", `{"name":"missing"}`},
+	} {
+		dv := DispatchVector{RPC: call.rpc, Request: json.RawMessage(call.req)}
+		res, err := c.Dispatch(call.rpc, []byte(call.req))
+		if err != nil {
+			dv.Error = err.Error()
+		} else if len(res) > 0 {
+			dv.Response = json.RawMessage(res)
+		}
+		r = append(r, dv)
+	}
+	return r, nil
+}
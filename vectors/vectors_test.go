@@ -0,0 +1,40 @@
+package vectors
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	assert := assert.New(t)
+	v, err := Generate()
+	assert.NoError(err)
+
+	// Vectors must be reproducible to be useful as goldens.
+	v2, err := Generate()
+	assert.NoError(err)
+	assert.Equal(v, v2)
+
+	assert.Equal("00000000", v.Checksums[0].Checksum)
+	for _, p := range v.Pulls {
+		assert.Equal(v.Checksums[0].Checksum == p.Request.Checksum, len(p.Data) == 0, p.Name)
+		assert.NotEqual("", p.Response.StateID, p.Name)
+	}
+	assert.Equal(map[string]json.RawMessage{
+		"foo": json.RawMessage(`"baz"`),
+		"n":   json.RawMessage(`42`),
+	}, v.Pulls[1].ExpectedData)
+
+	roots := map[string]bool{}
+	for _, d := range v.Dispatch {
+		if d.RPC == "getRoot" {
+			roots[string(d.Response)] = true
+		}
+	}
+	assert.Equal(3, len(roots))
+	last := v.Dispatch[len(v.Dispatch)-1]
+	assert.Equal("NotFound: No such checkpoint: missing", last.Error)
+	assert.Nil(last.Response)
+}